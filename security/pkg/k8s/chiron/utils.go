@@ -23,15 +23,21 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
+	"go.opencensus.io/trace"
 	certv1 "k8s.io/api/certificates/v1"
 	certv1beta1 "k8s.io/api/certificates/v1beta1"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
 	rand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apimachinery/pkg/watch"
 	clientset "k8s.io/client-go/kubernetes"
 
@@ -44,10 +50,169 @@ const (
 	csrRetriesMax = 3
 	// cert-manager use below annotation on kubernetes CSR to control TTL for the generated cert.
 	RequestLifeTimeAnnotationForCertManager = "experimental.cert-manager.io/request-duration"
+	// MinCSRExpiration is the minimum expirationSeconds of CSRs accepted by the K8s API server. CSRs requesting
+	// shorter lifetimes are created with this one, and are issued certificates outliving the requested lifetime.
+	MinCSRExpiration = 10 * time.Minute
+	// maxCSRWatchReconnects is the number of times a closed watch of a CSR is resumed before polling the CSR.
+	maxCSRWatchReconnects = 5
+	// emptyCertificateRetryInterval is the default interval between the reads of a CSR issued with an empty
+	// certificate.
+	emptyCertificateRetryInterval = 200 * time.Millisecond
 )
 
 type CsrNameGenerator func(string, string) string
 
+// ErrCSRNotIssued is returned by SignCSRK8sWithContext when a CSR was created, but no certificate was
+// issued for it within the ApprovalTimeout.
+var ErrCSRNotIssued = errors.New("CSR was created but not issued")
+
+// ErrCSRDenied is returned by SignCSRK8sWithContext when the CSR was denied. The error includes the reason
+// and message of the denial.
+var ErrCSRDenied = errors.New("CSR was denied")
+
+// ErrCSRFailed is returned by SignCSRK8sWithContext when the signer failed to issue a certificate for the
+// CSR. The error includes the reason and message of the failure.
+var ErrCSRFailed = errors.New("CSR failed")
+
+// errCertificateEmpty is returned when a CSR is issued, but its certificate is still nil or empty.
+var errCertificateEmpty = errors.New("no certificate returned for the CSR")
+
+// SignOptions holds optional settings for SignCSRK8sWithContext. The zero value keeps the
+// behavior of SignCSRK8s.
+type SignOptions struct {
+	// SkipCleanUp keeps the CSR object once signing completes, instead of deleting it.
+	SkipCleanUp bool
+	// CleanUpTimeout bounds the deletion of the CSR. When set, the CSR is deleted in the background
+	// so that the deletion does not delay returning the certificate.
+	CleanUpTimeout time.Duration
+	// OnCleanUpFailure, when set, is called when the CSR could not be deleted. A CSR that is already
+	// gone is not a failure.
+	OnCleanUpFailure func(csrName string, err error)
+	// CleanUpGroup, when set, tracks the background deletions of CSRs, so that callers can wait for them,
+	// e.g. on shutdown.
+	CleanUpGroup *sync.WaitGroup
+	// ApprovalTimeout, when set, bounds the time waiting for the CSR to be approved and issued by the
+	// signer, independently of the deadline of the context.
+	ApprovalTimeout time.Duration
+	// GenCSRName, when set, generates the name of the CSR instead of GenCsrName. It is called again
+	// when the generated name already exists, and the name must be a valid DNS subdomain.
+	GenCSRName func() string
+	// Labels are set on the CSR, e.g. for external approvers. They must be valid K8s labels.
+	Labels map[string]string
+	// Annotations are set on the CSR, e.g. for external approvers. Their keys must be valid K8s annotation keys.
+	Annotations map[string]string
+	// WaitStrategy selects how to wait for the certificate of the CSR. Defaults to WaitWatch.
+	WaitStrategy WaitStrategy
+	// PollInitialDelay, when set, is the interval between the first reads of the CSR when polling it, instead of
+	// a fixed interval. With WaitPoll, the CSR is first read after this delay.
+	PollInitialDelay time.Duration
+	// PollMaxInterval, when greater than the initial interval, is the maximum interval between reads of the CSR
+	// when polling it, the interval being doubled after each read.
+	PollMaxInterval time.Duration
+	// OnWaitStrategy, when set, is called when waiting for the certificate of the CSR starts using strategy,
+	// including when falling back from watching the CSR to polling it.
+	OnWaitStrategy func(strategy WaitStrategy)
+	// EmptyCertificateRetries, when set, is the number of times the CSR is read again when it is issued with a nil or
+	// empty certificate, which API servers briefly report under high churn, before failing.
+	EmptyCertificateRetries int
+	// EmptyCertificateRetryInterval, when set, is the interval between these reads, instead of 200ms.
+	EmptyCertificateRetryInterval time.Duration
+	// SubmitRetries, when set, is the number of attempts to create the CSR when the K8s API returns an error,
+	// instead of 3. Name conflicts are retried with a new name regardless. Callers retrying the signing themselves
+	// set it to 1, so that their attempts and these do not multiply.
+	SubmitRetries int
+}
+
+// submitRetries returns the number of attempts to create the CSR with o.
+func (o *SignOptions) submitRetries() int {
+	if o.SubmitRetries > 0 {
+		return o.SubmitRetries
+	}
+	return csrRetriesMax
+}
+
+// notifyWaitStrategy calls the OnWaitStrategy callback of o, if any.
+func (o *SignOptions) notifyWaitStrategy(strategy WaitStrategy) {
+	if o.OnWaitStrategy != nil {
+		o.OnWaitStrategy(strategy)
+	}
+}
+
+// WaitStrategy selects how SignCSRK8sWithContext waits for the certificate of a CSR.
+type WaitStrategy string
+
+const (
+	// WaitWatch watches the CSR, resuming the watch when it is closed or its resource version expired, and
+	// falls back to polling the CSR when the watch fails or times out.
+	WaitWatch WaitStrategy = "Watch"
+	// WaitPoll polls the CSR.
+	WaitPoll WaitStrategy = "Poll"
+)
+
+// ValidateWaitStrategy checks that strategy is a known WaitStrategy, or unset.
+func ValidateWaitStrategy(strategy WaitStrategy) error {
+	switch strategy {
+	case "", WaitWatch, WaitPoll:
+		return nil
+	}
+	return fmt.Errorf("unknown CSR wait strategy %q", strategy)
+}
+
+// ValidateCSRMetadata checks that labels are valid K8s labels, and the keys of annotations are valid
+// K8s annotation keys.
+func ValidateCSRMetadata(labels, annotations map[string]string) error {
+	for key, value := range labels {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("invalid CSR label key %q: %s", key, strings.Join(errs, ", "))
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return fmt.Errorf("invalid value of CSR label %q: %s", key, strings.Join(errs, ", "))
+		}
+	}
+	for key := range annotations {
+		// Annotation keys are validated case-insensitively, like the API server does.
+		if errs := validation.IsQualifiedName(strings.ToLower(key)); len(errs) > 0 {
+			return fmt.Errorf("invalid CSR annotation key %q: %s", key, strings.Join(errs, ", "))
+		}
+	}
+	return nil
+}
+
+// csrMetadata returns the object metadata of a CSR named csrName.
+func csrMetadata(csrName string, labels, annotations map[string]string, requestedLifetime time.Duration) metav1.ObjectMeta {
+	meta := metav1.ObjectMeta{Name: csrName}
+	if len(labels) > 0 {
+		meta.Labels = map[string]string{}
+		for key, value := range labels {
+			meta.Labels[key] = value
+		}
+	}
+	if len(annotations) > 0 || requestedLifetime != time.Duration(0) {
+		meta.Annotations = map[string]string{}
+		for key, value := range annotations {
+			meta.Annotations[key] = value
+		}
+		if requestedLifetime != time.Duration(0) {
+			meta.Annotations[RequestLifeTimeAnnotationForCertManager] = requestedLifetime.String()
+		}
+	}
+	return meta
+}
+
+// csrExpirationSeconds returns the expirationSeconds of a CSR of requestedLifetime, raised to the
+// MinCSRExpiration. A zero requestedLifetime leaves the lifetime to the signer.
+func csrExpirationSeconds(requestedLifetime time.Duration) *int32 {
+	if requestedLifetime == time.Duration(0) {
+		return nil
+	}
+	if requestedLifetime < MinCSRExpiration {
+		log.Warnf("requested lifetime %s is less than the min CSR expiration %s, requesting the min", requestedLifetime, MinCSRExpiration)
+		requestedLifetime = MinCSRExpiration
+	}
+	expirationSeconds := int32(requestedLifetime / time.Second)
+	return &expirationSeconds
+}
+
 // GenCsrName : Generate CSR Name for Resource. Guarantees returning a resource name that doesn't already exist
 func GenCsrName() string {
 	name := fmt.Sprintf("csr-workload-%s", rand.String(randomLength))
@@ -99,14 +264,37 @@ func SignCSRK8s(client clientset.Interface,
 	usages []certv1.KeyUsage,
 	dnsName, caFilePath string,
 	approveCsr bool, appendCaCert bool, requestedLifetime time.Duration) ([]byte, []byte, error) {
+	return SignCSRK8sWithContext(context.Background(), client, csrData, signerName, requestedDuration,
+		usages, dnsName, caFilePath, approveCsr, appendCaCert, requestedLifetime, nil)
+}
+
+// SignCSRK8sWithContext is similar to SignCSRK8s, but aborts submitting, approving and waiting
+// for the CSR once ctx is done. The returned error wraps ctx.Err() in that case. opts may be nil.
+func SignCSRK8sWithContext(ctx context.Context, client clientset.Interface,
+	csrData []byte, signerName string, requestedDuration *time.Duration,
+	usages []certv1.KeyUsage,
+	dnsName, caFilePath string,
+	approveCsr bool, appendCaCert bool, requestedLifetime time.Duration, opts *SignOptions) ([]byte, []byte, error) {
+	if opts == nil {
+		opts = &SignOptions{}
+	}
 	var err error
 	var v1Req bool = false
 
 	// 1. Submit the CSR
 
-	csrName, v1CsrReq, v1Beta1CsrReq, err := submitCSR(client, csrData, signerName, usages, csrRetriesMax, requestedLifetime)
+	genCSRName := opts.GenCSRName
+	if genCSRName == nil {
+		genCSRName = GenCsrName
+	}
+	submitCtx, span := trace.StartSpan(ctx, "chiron.SubmitCSR")
+	span.AddAttributes(trace.StringAttribute("signer", signerName))
+	csrName, v1CsrReq, v1Beta1CsrReq, err := submitCSR(submitCtx, client, csrData, signerName, usages, genCSRName,
+		opts.Labels, opts.Annotations, opts.submitRetries(), requestedLifetime)
+	span.AddAttributes(trace.StringAttribute("csr_name", csrName))
+	endSpan(span, err)
 	if err != nil {
-		return nil, nil, fmt.Errorf("unable to submit CSR request (%v). Error: %v", csrName, err)
+		return nil, nil, fmt.Errorf("unable to submit CSR request (%v). Error: %w", csrName, err)
 	}
 	log.Debugf("CSR (%v) has been created", csrName)
 	if v1CsrReq != nil {
@@ -114,24 +302,38 @@ func SignCSRK8s(client clientset.Interface,
 	}
 
 	// clean up certificate request after deletion
-	defer func() {
-		_ = cleanUpCertGen(client, v1Req, csrName)
-	}()
+	if !opts.SkipCleanUp {
+		defer cleanUpCSR(client, v1Req, csrName, opts)
+	}
 
 	// 2. Approve the CSR
 	if approveCsr {
 		csrMsg := fmt.Sprintf("CSR (%s) for the certificate (%s) is approved", csrName, dnsName)
-		err = approveCSR(csrName, csrMsg, client, v1CsrReq, v1Beta1CsrReq)
+		approveCtx, span := trace.StartSpan(ctx, "chiron.ApproveCSR")
+		err = approveCSR(approveCtx, csrName, csrMsg, client, v1CsrReq, v1Beta1CsrReq)
+		endSpan(span, err)
 		if err != nil {
-			return nil, nil, fmt.Errorf("unable to approve CSR request. Error: %v", err)
+			return nil, nil, fmt.Errorf("unable to approve CSR request. Error: %w", err)
 		}
 		log.Debugf("CSR (%v) is approved", csrName)
 	}
 
 	// 3. Read the signed certificate
-	certChain, caCert, err := readSignedCertificate(client,
-		csrName, certWatchTimeout, certReadInterval, maxNumCertRead, caFilePath, appendCaCert, v1Req)
+	waitCtx := ctx
+	if opts.ApprovalTimeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, opts.ApprovalTimeout)
+		defer cancel()
+	}
+	waitCtx, span = trace.StartSpan(waitCtx, "chiron.WaitForCertificate")
+	certChain, caCert, err := readSignedCertificate(waitCtx, client,
+		csrName, certWatchTimeout, certReadInterval, maxNumCertRead, caFilePath, appendCaCert, v1Req, opts)
+	endSpan(span, err)
 	if err != nil {
+		if ctx.Err() == nil && waitCtx.Err() != nil {
+			return nil, nil, fmt.Errorf("%w: no certificate was issued for CSR %q within %v, check the approver and signer of the CSR",
+				ErrCSRNotIssued, csrName, opts.ApprovalTimeout)
+		}
 		return nil, nil, err
 	}
 
@@ -139,6 +341,14 @@ func SignCSRK8s(client clientset.Interface,
 	return certChain, caCert, err
 }
 
+// endSpan records the error err of the step traced by span, if any, and ends it.
+func endSpan(span *trace.Span, err error) {
+	if err != nil {
+		span.SetStatus(trace.Status{Code: trace.StatusCodeUnknown, Message: err.Error()})
+	}
+	span.End()
+}
+
 // Read CA certificate and check whether it is a valid certificate.
 func readCACert(caCertPath string) ([]byte, error) {
 	caCert, err := os.ReadFile(caCertPath)
@@ -189,82 +399,90 @@ func reloadCACert(wc *WebhookController) (bool, error) {
 	return certChanged, nil
 }
 
-func submitCSR(clientset clientset.Interface,
+func submitCSR(ctx context.Context, clientset clientset.Interface,
 	csrData []byte, signerName string,
-	usages []certv1.KeyUsage, numRetries int, requestedLifetime time.Duration) (string, *certv1.CertificateSigningRequest,
-	*certv1beta1.CertificateSigningRequest, error) {
+	usages []certv1.KeyUsage, genCSRName func() string, labels, annotations map[string]string, numRetries int,
+	requestedLifetime time.Duration) (string, *certv1.CertificateSigningRequest, *certv1beta1.CertificateSigningRequest, error) {
+	if err := ValidateCSRMetadata(labels, annotations); err != nil {
+		return "", nil, nil, err
+	}
 	var lastErr error
 	var useV1 bool = true
 	var csrName string = ""
-	for i := 0; i < numRetries; i++ {
+	// Name conflicts are retried with a new name without counting against numRetries, up to csrRetriesMax times.
+	var attempts, conflicts int
+	for attempts < numRetries && conflicts < csrRetriesMax {
+		if ctx.Err() != nil {
+			return csrName, nil, nil, ctx.Err()
+		}
 		if csrName == "" {
-			csrName = GenCsrName()
+			csrName = genCSRName()
+			if errs := validation.IsDNS1123Subdomain(csrName); len(errs) > 0 {
+				return "", nil, nil, fmt.Errorf("invalid CSR name %q: %s", csrName, strings.Join(errs, ", "))
+			}
 		}
 		if useV1 && len(usages) > 0 && len(signerName) > 0 && signerName != "kubernetes.io/legacy-unknown" {
-			log.Debugf("trial %v using v1 api to create CSR (%v)", i+1, csrName)
+			log.Debugf("trial %v using v1 api to create CSR (%v)", attempts+conflicts+1, csrName)
 			csr := &certv1.CertificateSigningRequest{
 				// Username, UID, Groups will be injected by API server.
-				TypeMeta: metav1.TypeMeta{Kind: "CertificateSigningRequest"},
-				ObjectMeta: metav1.ObjectMeta{
-					Name: csrName,
-				},
+				TypeMeta:   metav1.TypeMeta{Kind: "CertificateSigningRequest"},
+				ObjectMeta: csrMetadata(csrName, labels, annotations, requestedLifetime),
 				Spec: certv1.CertificateSigningRequestSpec{
-					Request:    csrData,
-					Usages:     usages,
-					SignerName: signerName,
+					Request:           csrData,
+					Usages:            usages,
+					SignerName:        signerName,
+					ExpirationSeconds: csrExpirationSeconds(requestedLifetime),
 				},
 			}
-			if requestedLifetime != time.Duration(0) {
-				csr.ObjectMeta.Annotations = map[string]string{RequestLifeTimeAnnotationForCertManager: requestedLifetime.String()}
-			}
-			v1req, err := clientset.CertificatesV1().CertificateSigningRequests().Create(context.TODO(), csr, metav1.CreateOptions{})
+			v1req, err := clientset.CertificatesV1().CertificateSigningRequests().Create(ctx, csr, metav1.CreateOptions{})
 			if err == nil {
 				return csrName, v1req, nil, nil
 			}
 			lastErr = err
 			if apierrors.IsAlreadyExists(err) {
 				csrName = ""
+				conflicts++
 				continue
 			} else if apierrors.IsNotFound(err) {
 				// don't attempt to use older api unless we get an API error
 				useV1 = false
 			} else {
+				attempts++
 				continue
 			}
 		}
 		// Only exercise v1beta1 logic if v1 api was not found
-		log.Debugf("trial %v using v1beta1 api for csr %v", i+1, csrName)
+		log.Debugf("trial %v using v1beta1 api for csr %v", attempts+conflicts+1, csrName)
 		// convert relevant bits to v1beta1
 		v1beta1csr := &certv1beta1.CertificateSigningRequest{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: csrName,
-			},
+			ObjectMeta: csrMetadata(csrName, labels, annotations, requestedLifetime),
 			Spec: certv1beta1.CertificateSigningRequestSpec{
-				SignerName: &signerName,
-				Request:    csrData,
+				SignerName:        &signerName,
+				Request:           csrData,
+				ExpirationSeconds: csrExpirationSeconds(requestedLifetime),
 			},
 		}
 		for _, usage := range usages {
 			v1beta1csr.Spec.Usages = append(v1beta1csr.Spec.Usages, certv1beta1.KeyUsage(usage))
 		}
-		if requestedLifetime != time.Duration(0) {
-			v1beta1csr.ObjectMeta.Annotations = map[string]string{RequestLifeTimeAnnotationForCertManager: requestedLifetime.String()}
-		}
 		// create v1beta1 certificate request
-		v1beta1req, err := clientset.CertificatesV1beta1().CertificateSigningRequests().Create(context.TODO(), v1beta1csr, metav1.CreateOptions{})
+		v1beta1req, err := clientset.CertificatesV1beta1().CertificateSigningRequests().Create(ctx, v1beta1csr, metav1.CreateOptions{})
 		if err == nil {
 			return csrName, nil, v1beta1req, nil
 		}
 		lastErr = err
 		if apierrors.IsAlreadyExists(err) {
 			csrName = ""
+			conflicts++
+		} else {
+			attempts++
 		}
 	}
 	log.Errorf("retry attempts exceeded when creating csr request %v", csrName)
 	return "", nil, nil, lastErr
 }
 
-func approveCSR(csrName string, csrMsg string, client clientset.Interface,
+func approveCSR(ctx context.Context, csrName string, csrMsg string, client clientset.Interface,
 	v1CsrReq *certv1.CertificateSigningRequest, v1Beta1CsrReq *certv1beta1.CertificateSigningRequest) error {
 	var err error = errors.New("invalid CSR")
 
@@ -274,7 +492,7 @@ func approveCSR(csrName string, csrMsg string, client clientset.Interface,
 			Reason:  csrMsg,
 			Message: csrMsg,
 		})
-		_, err = client.CertificatesV1beta1().CertificateSigningRequests().UpdateApproval(context.TODO(), v1Beta1CsrReq, metav1.UpdateOptions{})
+		_, err = client.CertificatesV1beta1().CertificateSigningRequests().UpdateApproval(ctx, v1Beta1CsrReq, metav1.UpdateOptions{})
 		if err != nil {
 			log.Errorf("failed to approve CSR (%v): %v", csrName, err)
 			return err
@@ -286,7 +504,7 @@ func approveCSR(csrName string, csrMsg string, client clientset.Interface,
 			Message: csrMsg,
 			Status:  corev1.ConditionTrue,
 		})
-		_, err = client.CertificatesV1().CertificateSigningRequests().UpdateApproval(context.TODO(), csrName, v1CsrReq, metav1.UpdateOptions{})
+		_, err = client.CertificatesV1().CertificateSigningRequests().UpdateApproval(ctx, csrName, v1CsrReq, metav1.UpdateOptions{})
 		if err != nil {
 			log.Errorf("failed to approve CSR (%v): %v", csrName, err)
 			return err
@@ -297,14 +515,25 @@ func approveCSR(csrName string, csrMsg string, client clientset.Interface,
 
 // Read the signed certificate
 // verify and append CA certificate to certChain if appendCaCert is true
-func readSignedCertificate(client clientset.Interface, csrName string,
+func readSignedCertificate(ctx context.Context, client clientset.Interface, csrName string,
 	watchTimeout, readInterval time.Duration,
-	maxNumRead int, caCertPath string, appendCaCert bool, usev1 bool) ([]byte, []byte, error) {
-	// First try to read the signed CSR through a watching mechanism
-	certPEM := readSignedCsr(client, csrName, watchTimeout, readInterval, maxNumRead, usev1)
-
+	maxNumRead int, caCertPath string, appendCaCert bool, usev1 bool, opts *SignOptions) ([]byte, []byte, error) {
+	certPEM, err := readSignedCsr(ctx, client, csrName, watchTimeout, readInterval, maxNumRead, usev1, opts)
+	if err == nil && len(certPEM) == 0 && ctx.Err() == nil {
+		// The certificate of the CSR may still be nil rather than empty, read it again all the same.
+		err = fmt.Errorf("%w: %q", errCertificateEmpty, csrName)
+	}
+	if errors.Is(err, errCertificateEmpty) {
+		certPEM, err = readEmptyCertificate(ctx, client, csrName, usev1, opts, err)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if ctx.Err() != nil {
+		return nil, nil, fmt.Errorf("stopped waiting for the certificate of CSR %q: %w", csrName, ctx.Err())
+	}
 	if len(certPEM) == 0 {
-		return []byte{}, []byte{}, fmt.Errorf("no certificate returned for the CSR: %q", csrName)
+		return []byte{}, []byte{}, fmt.Errorf("%w: %q", errCertificateEmpty, csrName)
 	}
 	certsParsed, err := util.ParsePemEncodedCertificateChain(certPEM)
 	if err != nil {
@@ -344,6 +573,39 @@ func readSignedCertificate(client clientset.Interface, csrName string,
 	return certChain, caCert, nil
 }
 
+// readEmptyCertificate reads the CSR csrName, issued with a nil or empty certificate as reported by err, again up to
+// the EmptyCertificateRetries of opts, which may be nil, and returns its certificate once populated. These reads
+// are distinct from the polling of pending CSRs, and bounded regardless of the ApprovalTimeout.
+func readEmptyCertificate(ctx context.Context, client clientset.Interface, csrName string, usev1 bool, opts *SignOptions,
+	err error) ([]byte, error) {
+	if opts == nil {
+		return nil, err
+	}
+	interval := opts.EmptyCertificateRetryInterval
+	if interval <= 0 {
+		interval = emptyCertificateRetryInterval
+	}
+	for i := 1; i <= opts.EmptyCertificateRetries; i++ {
+		if !sleepWithContext(ctx, interval) {
+			return nil, err
+		}
+		r, getErr := getCSR(ctx, client, csrName, usev1)
+		if getErr != nil {
+			log.Debugf("failed to read CSR %v issued with an empty certificate: %v", csrName, getErr)
+			continue
+		}
+		certPEM, _, stateErr := csrState(r)
+		if certPEM != nil {
+			log.Debugf("certificate of CSR %v is populated after %d reads", csrName, i)
+			return certPEM, nil
+		}
+		if stateErr != nil && !errors.Is(stateErr, errCertificateEmpty) {
+			return nil, stateErr
+		}
+	}
+	return nil, err
+}
+
 func checkDuplicateCsr(client clientset.Interface, csrName string) (*certv1.CertificateSigningRequest, *certv1beta1.CertificateSigningRequest) {
 	v1CsrReq, err := client.CertificatesV1().CertificateSigningRequests().Get(context.TODO(), csrName, metav1.GetOptions{})
 	if err == nil {
@@ -356,116 +618,319 @@ func checkDuplicateCsr(client clientset.Interface, csrName string) (*certv1.Cert
 	return nil, nil
 }
 
-func getSignedCsr(client clientset.Interface, csrName string, readInterval time.Duration, maxNumRead int, usev1 bool) []byte {
+// getSignedCsr polls the CSR csrName with backoff, for maxNumRead reads and at least until pollUntil. If no CSR is
+// read, return nil. An error is returned if the CSR is denied or failed.
+func getSignedCsr(ctx context.Context, client clientset.Interface, csrName string, backoff *pollBackoff,
+	pollUntil time.Time, maxNumRead int, usev1 bool) ([]byte, error) {
 	var err error
-	if usev1 {
-		var r *certv1.CertificateSigningRequest
-		for i := 0; i < maxNumRead; i++ {
-			r, err = client.CertificatesV1().CertificateSigningRequests().Get(context.TODO(), csrName, metav1.GetOptions{})
-			if err == nil && r.Status.Certificate != nil {
+	for i := 0; i < maxNumRead || time.Now().Before(pollUntil); i++ {
+		var r runtime.Object
+		r, err = getCSR(ctx, client, csrName, usev1)
+		if err == nil {
+			certPEM, _, condErr := csrState(r)
+			if certPEM != nil {
 				// Certificate is ready
-				return r.Status.Certificate
+				return certPEM, nil
 			}
-			time.Sleep(readInterval)
-		}
-		if err != nil || r.Status.Certificate == nil {
-			if err != nil {
-				log.Errorf("failed to read the CSR (%v): %v", csrName, err)
-			} else if r.Status.Certificate == nil {
-				for _, c := range r.Status.Conditions {
-					if c.Type == certv1.CertificateDenied {
-						log.Errorf("CertificateDenied, name: %v, uid: %v, cond-type: %v, cond: %s",
-							r.Name, r.UID, c.Type, c.String())
-						break
-					}
-				}
+			if condErr != nil {
+				return nil, condErr
 			}
-			return []byte{}
+		}
+		if !sleepWithContext(ctx, backoff.next()) {
+			return []byte{}, nil
+		}
+	}
+	if err != nil {
+		log.Errorf("failed to read the CSR (%v): %v", csrName, err)
+	}
+	return []byte{}, nil
+}
+
+// getCSR reads the CSR csrName.
+func getCSR(ctx context.Context, client clientset.Interface, csrName string, usev1 bool) (runtime.Object, error) {
+	if usev1 {
+		return client.CertificatesV1().CertificateSigningRequests().Get(ctx, csrName, metav1.GetOptions{})
+	}
+	return client.CertificatesV1beta1().CertificateSigningRequests().Get(ctx, csrName, metav1.GetOptions{})
+}
+
+// watchCSR watches the CSR csrName from resourceVersion, or from its current state if resourceVersion is empty.
+func watchCSR(ctx context.Context, client clientset.Interface, csrName, resourceVersion string, usev1 bool) (watch.Interface, error) {
+	listOpts := metav1.ListOptions{
+		FieldSelector:       fields.OneTermEqualSelector("metadata.name", csrName).String(),
+		ResourceVersion:     resourceVersion,
+		AllowWatchBookmarks: true,
+	}
+	if usev1 {
+		return client.CertificatesV1().CertificateSigningRequests().Watch(ctx, listOpts)
+	}
+	return client.CertificatesV1beta1().CertificateSigningRequests().Watch(ctx, listOpts)
+}
+
+// csrState returns the certificate issued for the CSR obj and its resource version. An error wrapping ErrCSRDenied
+// or ErrCSRFailed is returned if the CSR is denied or failed, and one wrapping errCertificateEmpty if it is issued
+// with an empty certificate. A nil certificate leaves the CSR pending, as it is until signed, and is only read again
+// as an empty one once the CSR is no longer waited for. Nothing is returned for objects other than CSRs.
+func csrState(obj runtime.Object) ([]byte, string, error) {
+	switch r := obj.(type) {
+	case *certv1.CertificateSigningRequest:
+		if len(r.Status.Certificate) > 0 {
+			return r.Status.Certificate, r.ResourceVersion, nil
+		}
+		if err := v1ConditionError(r); err != nil || r.Status.Certificate == nil {
+			return nil, r.ResourceVersion, err
+		}
+		return nil, r.ResourceVersion, fmt.Errorf("%w: %q", errCertificateEmpty, r.Name)
+	case *certv1beta1.CertificateSigningRequest:
+		if len(r.Status.Certificate) > 0 {
+			return r.Status.Certificate, r.ResourceVersion, nil
+		}
+		if err := v1beta1ConditionError(r); err != nil || r.Status.Certificate == nil {
+			return nil, r.ResourceVersion, err
+		}
+		return nil, r.ResourceVersion, fmt.Errorf("%w: %q", errCertificateEmpty, r.Name)
+	}
+	return nil, "", nil
+}
+
+// v1ConditionError returns an error wrapping ErrCSRDenied or ErrCSRFailed if r has a Denied or Failed
+// condition, or nil otherwise.
+func v1ConditionError(r *certv1.CertificateSigningRequest) error {
+	for _, c := range r.Status.Conditions {
+		if c.Status == corev1.ConditionFalse {
+			continue
+		}
+		switch c.Type {
+		case certv1.CertificateDenied:
+			return conditionError(ErrCSRDenied, r.Name, c.Reason, c.Message)
+		case certv1.CertificateFailed:
+			return conditionError(ErrCSRFailed, r.Name, c.Reason, c.Message)
+		}
+	}
+	return nil
+}
+
+// v1beta1ConditionError returns an error wrapping ErrCSRDenied or ErrCSRFailed if r has a Denied or Failed
+// condition, or nil otherwise.
+func v1beta1ConditionError(r *certv1beta1.CertificateSigningRequest) error {
+	for _, c := range r.Status.Conditions {
+		if c.Status == corev1.ConditionFalse {
+			continue
+		}
+		switch c.Type {
+		case certv1beta1.CertificateDenied:
+			return conditionError(ErrCSRDenied, r.Name, c.Reason, c.Message)
+		case certv1beta1.CertificateFailed:
+			return conditionError(ErrCSRFailed, r.Name, c.Reason, c.Message)
+		}
+	}
+	return nil
+}
+
+func conditionError(err error, csrName, reason, message string) error {
+	log.Errorf("%v, name: %v, reason: %v, message: %v", err, csrName, reason, message)
+	return fmt.Errorf("%w: CSR %q: reason %q, message %q", err, csrName, reason, message)
+}
+
+// Return signed CSR, watching or polling it as configured in opts, which may be nil. If no CSR is read, return nil.
+// An error is returned if the CSR is denied or failed.
+func readSignedCsr(ctx context.Context, client clientset.Interface, csrName string, watchTimeout time.Duration, readInterval time.Duration,
+	maxNumRead int, usev1 bool, opts *SignOptions) ([]byte, error) {
+	if opts == nil {
+		opts = &SignOptions{}
+	}
+	backoff := newPollBackoff(readInterval, opts)
+	var pollUntil time.Time
+	if opts.WaitStrategy == WaitPoll {
+		opts.notifyWaitStrategy(WaitPoll)
+		// Poll for as long as a watch and its fallback would wait.
+		pollUntil = time.Now().Add(watchTimeout)
+		if !sleepWithContext(ctx, backoff.next()) {
+			return []byte{}, nil
 		}
 	} else {
-		var r *certv1beta1.CertificateSigningRequest
-		for i := 0; i < maxNumRead; i++ {
-			r, err = client.CertificatesV1beta1().CertificateSigningRequests().Get(context.TODO(), csrName, metav1.GetOptions{})
-			if err == nil && r.Status.Certificate != nil {
-				// Certificate is ready
-				return r.Status.Certificate
-			}
-			time.Sleep(readInterval)
+		opts.notifyWaitStrategy(WaitWatch)
+		certPEM, done, err := watchSignedCsr(ctx, client, csrName, watchTimeout, usev1)
+		if done {
+			return certPEM, err
 		}
-		if err != nil || r.Status.Certificate == nil {
+		opts.notifyWaitStrategy(WaitPoll)
+	}
+	return getSignedCsr(ctx, client, csrName, backoff, pollUntil, maxNumRead, usev1)
+}
+
+// watchSignedCsr returns the certificate of the CSR csrName through a watch. done is false when the watch failed or
+// timed out before the CSR was issued, denied or failed, and the CSR should be polled instead. A closed watch is
+// resumed from the last resource version seen, and from the current state of the CSR when that version expired.
+func watchSignedCsr(ctx context.Context, client clientset.Interface, csrName string, watchTimeout time.Duration,
+	usev1 bool) (certPEM []byte, done bool, err error) {
+	timer := time.NewTimer(watchTimeout)
+	defer timer.Stop()
+	resourceVersion := ""
+	for watches := 0; watches <= maxCSRWatchReconnects; watches++ {
+		if resourceVersion == "" {
+			// Read the CSR first, so that it is not missed if it is issued before the watch starts.
+			r, err := getCSR(ctx, client, csrName, usev1)
 			if err != nil {
-				log.Errorf("failed to read the CSR (%v): %v", csrName, err)
-			} else if r.Status.Certificate == nil {
-				for _, c := range r.Status.Conditions {
-					if c.Type == certv1beta1.CertificateDenied {
-						log.Errorf("CertificateDenied, name: %v, uid: %v, cond-type: %v, cond: %s",
-							r.Name, r.UID, c.Type, c.String())
-						break
-					}
-				}
+				log.Debugf("failed to read CSR %v before watching it: %v", csrName, err)
+				return nil, ctx.Err() != nil, nil
+			}
+			certPEM, rv, err := csrState(r)
+			if certPEM != nil || err != nil {
+				return certPEM, true, err
 			}
-			return []byte{}
+			resourceVersion = rv
+		}
+		watcher, err := watchCSR(ctx, client, csrName, resourceVersion, usev1)
+		if apierrors.IsResourceExpired(err) || apierrors.IsGone(err) {
+			log.Debugf("resource version %v of CSR %v expired, reading it again", resourceVersion, csrName)
+			resourceVersion = ""
+			continue
+		}
+		if err != nil {
+			log.Debugf("failed to watch CSR %v: %v", csrName, err)
+			return nil, ctx.Err() != nil, nil
+		}
+		var rewatch bool
+		certPEM, resourceVersion, rewatch, err = consumeCSRWatch(ctx, watcher, csrName, resourceVersion, timer.C)
+		if !rewatch {
+			return certPEM, certPEM != nil || err != nil || ctx.Err() != nil, err
 		}
 	}
-	return []byte{}
+	log.Debugf("watch of CSR %v was closed %d times, falling back to polling", csrName, maxCSRWatchReconnects+1)
+	return nil, false, nil
 }
 
-// Return signed CSR through a watcher. If no CSR is read, return nil.
-func readSignedCsr(client clientset.Interface, csrName string, watchTimeout time.Duration, readInterval time.Duration,
-	maxNumRead int, usev1 bool) []byte {
-	var watcher watch.Interface
-	var err error
-	if usev1 {
-		watcher, err = client.CertificatesV1().CertificateSigningRequests().Watch(context.TODO(), metav1.ListOptions{
-			FieldSelector: fields.OneTermEqualSelector("metadata.name", csrName).String(),
-		})
-	} else {
-		watcher, err = client.CertificatesV1beta1().CertificateSigningRequests().Watch(context.TODO(), metav1.ListOptions{
-			FieldSelector: fields.OneTermEqualSelector("metadata.name", csrName).String(),
-		})
-	}
-	if err == nil {
-		var timeout bool = false
-		// Set a timeout
-		timer := time.After(watchTimeout)
-		for {
-			select {
-			case r := <-watcher.ResultChan():
-				if usev1 {
-					reqSigned := r.Object.(*certv1.CertificateSigningRequest)
-					if reqSigned.Status.Certificate != nil {
-						return reqSigned.Status.Certificate
-					}
-				} else {
-					reqSigned := r.Object.(*certv1beta1.CertificateSigningRequest)
-					if reqSigned.Status.Certificate != nil {
-						return reqSigned.Status.Certificate
-					}
-				}
-			case <-timer:
-				log.Debugf("timeout when watching CSR %v", csrName)
-				timeout = true
+// consumeCSRWatch reads the events of watcher on the CSR csrName, watched from resourceVersion, until the CSR is
+// issued, denied or failed, or the watch ends. It returns the last resource version seen, empty when it expired,
+// and whether the CSR should be watched again.
+func consumeCSRWatch(ctx context.Context, watcher watch.Interface, csrName, resourceVersion string,
+	timeout <-chan time.Time) (certPEM []byte, lastResourceVersion string, rewatch bool, err error) {
+	defer watcher.Stop()
+	for {
+		select {
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				// the watch was closed, e.g. by the API server or when its context is done
+				return nil, resourceVersion, ctx.Err() == nil, nil
 			}
-			if timeout {
-				break
+			if m, err := meta.Accessor(event.Object); err == nil && m.GetName() != "" && m.GetName() != csrName {
+				// an event of another CSR, where the field selector of the watch is not applied
+				continue
+			}
+			switch event.Type {
+			case watch.Added, watch.Modified, watch.Bookmark:
+				certPEM, rv, err := csrState(event.Object)
+				if certPEM != nil || err != nil {
+					return certPEM, rv, false, err
+				}
+				if rv != "" {
+					resourceVersion = rv
+				}
+			case watch.Deleted:
+				return nil, resourceVersion, false, fmt.Errorf("CSR %q was deleted before a certificate was issued", csrName)
+			case watch.Error:
+				statusErr := apierrors.FromObject(event.Object)
+				if apierrors.IsResourceExpired(statusErr) || apierrors.IsGone(statusErr) {
+					log.Debugf("resource version %v of CSR %v expired, reading it again", resourceVersion, csrName)
+					return nil, "", true, nil
+				}
+				log.Debugf("error when watching CSR %v: %v", csrName, statusErr)
+				return nil, resourceVersion, false, nil
 			}
+		case <-timeout:
+			log.Debugf("timeout when watching CSR %v", csrName)
+			return nil, resourceVersion, false, nil
+		case <-ctx.Done():
+			log.Debugf("context done when watching CSR %v: %v", csrName, ctx.Err())
+			return nil, resourceVersion, false, nil
 		}
 	}
+}
+
+// pollBackoff is the interval between reads of a polled CSR, doubled after each read up to maxInterval.
+type pollBackoff struct {
+	interval    time.Duration
+	maxInterval time.Duration
+}
+
+// newPollBackoff returns the backoff configured in opts, starting at readInterval unless opts has a PollInitialDelay.
+func newPollBackoff(readInterval time.Duration, opts *SignOptions) *pollBackoff {
+	b := &pollBackoff{interval: readInterval, maxInterval: opts.PollMaxInterval}
+	if opts.PollInitialDelay > 0 {
+		b.interval = opts.PollInitialDelay
+	}
+	if b.maxInterval < b.interval {
+		b.maxInterval = b.interval
+	}
+	return b
+}
+
+// next returns the interval before the next read.
+func (b *pollBackoff) next() time.Duration {
+	d := b.interval
+	b.interval *= 2
+	if b.interval > b.maxInterval {
+		b.interval = b.maxInterval
+	}
+	return d
+}
+
+// sleepWithContext waits for d to elapse, returning false if ctx is done first.
+func sleepWithContext(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
 
-	return getSignedCsr(client, csrName, readInterval, maxNumRead, usev1)
+// cleanUpCSR deletes the CSR as configured in opts. It does not use the context of the sign request,
+// so that the CSR is still deleted when signing was canceled.
+func cleanUpCSR(client clientset.Interface, usev1 bool, csrName string, opts *SignOptions) {
+	cleanUp := func(ctx context.Context) {
+		err := cleanUpCertGenWithContext(ctx, client, usev1, csrName)
+		if err != nil && !apierrors.IsNotFound(err) && opts.OnCleanUpFailure != nil {
+			opts.OnCleanUpFailure(csrName, err)
+		}
+	}
+	if opts.CleanUpTimeout <= 0 {
+		cleanUp(context.Background())
+		return
+	}
+	if opts.CleanUpGroup != nil {
+		opts.CleanUpGroup.Add(1)
+	}
+	go func() {
+		if opts.CleanUpGroup != nil {
+			defer opts.CleanUpGroup.Done()
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), opts.CleanUpTimeout)
+		defer cancel()
+		cleanUp(ctx)
+	}()
 }
 
 // Clean up the CSR
 func cleanUpCertGen(client clientset.Interface, usev1 bool, csrName string) error {
+	return cleanUpCertGenWithContext(context.TODO(), client, usev1, csrName)
+}
+
+func cleanUpCertGenWithContext(ctx context.Context, client clientset.Interface, usev1 bool, csrName string) error {
 	var err error
 
 	if usev1 {
-		err = client.CertificatesV1().CertificateSigningRequests().Delete(context.TODO(), csrName, metav1.DeleteOptions{})
+		err = client.CertificatesV1().CertificateSigningRequests().Delete(ctx, csrName, metav1.DeleteOptions{})
 	} else {
-		err = client.CertificatesV1beta1().CertificateSigningRequests().Delete(context.TODO(), csrName, metav1.DeleteOptions{})
+		err = client.CertificatesV1beta1().CertificateSigningRequests().Delete(ctx, csrName, metav1.DeleteOptions{})
 	}
 
-	if err != nil {
+	if apierrors.IsNotFound(err) {
+		log.Debugf("CSR %v is already deleted", csrName)
+	} else if err != nil {
 		log.Errorf("failed to delete CSR (%v): %v", csrName, err)
 	} else {
 		log.Debugf("deleted CSR: %v", csrName)
@@ -16,17 +16,24 @@ package chiron
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strconv"
 	"strings"
 	"testing"
 	"time"
 
 	cert "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes/fake"
 	kt "k8s.io/client-go/testing"
 
@@ -322,7 +329,7 @@ func TestCheckDuplicateCSR(t *testing.T) {
 			t.Errorf("test case (%s) failed unexpectedly", tcName)
 		}
 
-		certData := readSignedCsr(client, tc.csrName, 1*time.Second, certReadInterval, 1, true)
+		certData, _ := readSignedCsr(context.Background(), client, tc.csrName, 1*time.Second, certReadInterval, 1, true, nil)
 		if tc.expectFail {
 			if len(certData) != 0 {
 				t.Errorf("test case (%s) should have failed", tcName)
@@ -333,6 +340,230 @@ func TestCheckDuplicateCSR(t *testing.T) {
 	}
 }
 
+func TestReadSignedCsrConditions(t *testing.T) {
+	testCases := map[string]struct {
+		conditions  []cert.CertificateSigningRequestCondition
+		expectedErr error
+	}{
+		"denied": {
+			conditions: []cert.CertificateSigningRequestCondition{
+				{Type: cert.CertificateDenied, Status: corev1.ConditionTrue, Reason: "PolicyViolation", Message: "not allowed"},
+			},
+			expectedErr: ErrCSRDenied,
+		},
+		"failed": {
+			conditions: []cert.CertificateSigningRequestCondition{
+				{Type: cert.CertificateApproved, Status: corev1.ConditionTrue},
+				{Type: cert.CertificateFailed, Status: corev1.ConditionTrue, Reason: "SignerError", Message: "not allowed"},
+			},
+			expectedErr: ErrCSRFailed,
+		},
+		"pending": {
+			conditions: []cert.CertificateSigningRequestCondition{
+				{Type: cert.CertificateDenied, Status: corev1.ConditionFalse, Reason: "PolicyViolation", Message: "not allowed"},
+			},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			client.PrependReactor("get", "certificatesigningrequests", defaultReactionFunc(&cert.CertificateSigningRequest{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-csr"},
+				Status:     cert.CertificateSigningRequestStatus{Conditions: tc.conditions},
+			}))
+			certData, err := readSignedCsr(context.Background(), client, "test-csr", time.Millisecond, time.Millisecond, 3, true, nil)
+			if len(certData) != 0 {
+				t.Fatalf("expected no certificate, got %s", certData)
+			}
+			if tc.expectedErr == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected an error wrapping %v, got: %v", tc.expectedErr, err)
+			}
+			if reason := tc.conditions[len(tc.conditions)-1].Reason; !strings.Contains(err.Error(), reason) ||
+				!strings.Contains(err.Error(), "not allowed") {
+				t.Errorf("expected the error to include the reason and message of the condition, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestReadSignedCsrWatch(t *testing.T) {
+	pending := func(resourceVersion string) *cert.CertificateSigningRequest {
+		return &cert.CertificateSigningRequest{ObjectMeta: metav1.ObjectMeta{Name: "test-csr", ResourceVersion: resourceVersion}}
+	}
+	issued := pending("5")
+	issued.Status.Certificate = []byte(exampleIssuedCert)
+	expired := apierrors.NewResourceExpired("too old resource version")
+
+	client := fake.NewSimpleClientset()
+	gets := 0
+	client.PrependReactor("get", "certificatesigningrequests", func(act kt.Action) (bool, runtime.Object, error) {
+		gets++
+		return true, pending(strconv.Itoa(gets)), nil
+	})
+	var watchedVersions []string
+	client.PrependWatchReactor("certificatesigningrequests", func(act kt.Action) (bool, watch.Interface, error) {
+		watchedVersions = append(watchedVersions, act.(kt.WatchAction).GetWatchRestrictions().ResourceVersion)
+		w := watch.NewFakeWithChanSize(2, false)
+		switch len(watchedVersions) {
+		case 1:
+			// events of other CSRs are ignored, and the watch is closed by the API server
+			w.Modify(&cert.CertificateSigningRequest{ObjectMeta: metav1.ObjectMeta{Name: "other-csr", ResourceVersion: "9"}})
+			w.Modify(pending("3"))
+			w.Stop()
+		case 2:
+			// the resource version expired while resuming the watch
+			w.Error(&expired.ErrStatus)
+		case 3:
+			return true, nil, expired
+		default:
+			w.Modify(issued)
+		}
+		return true, w, nil
+	})
+	var strategies []WaitStrategy
+	opts := &SignOptions{OnWaitStrategy: func(strategy WaitStrategy) {
+		strategies = append(strategies, strategy)
+	}}
+
+	certData, err := readSignedCsr(context.Background(), client, "test-csr", time.Second, time.Millisecond, 1, true, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(certData) != exampleIssuedCert {
+		t.Fatalf("expected the issued certificate, got %q", certData)
+	}
+	if want := []string{"1", "3", "2", "3"}; !reflect.DeepEqual(watchedVersions, want) {
+		t.Errorf("expected the CSR to be watched from resource versions %v, got %v", want, watchedVersions)
+	}
+	if want := []WaitStrategy{WaitWatch}; !reflect.DeepEqual(strategies, want) {
+		t.Errorf("expected the wait strategies %v, got %v", want, strategies)
+	}
+}
+
+func TestReadSignedCsrWaitStrategy(t *testing.T) {
+	testCases := map[string]struct {
+		strategy       WaitStrategy
+		watchErr       error
+		wantStrategies []WaitStrategy
+		wantWatches    int
+	}{
+		"watch": {
+			wantStrategies: []WaitStrategy{WaitWatch},
+			wantWatches:    1,
+		},
+		"watch failing": {
+			watchErr:       errors.New("watch not allowed"),
+			wantStrategies: []WaitStrategy{WaitWatch, WaitPoll},
+			wantWatches:    1,
+		},
+		"poll": {
+			strategy:       WaitPoll,
+			wantStrategies: []WaitStrategy{WaitPoll},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			gets := 0
+			client.PrependReactor("get", "certificatesigningrequests", func(act kt.Action) (bool, runtime.Object, error) {
+				gets++
+				csr := &cert.CertificateSigningRequest{ObjectMeta: metav1.ObjectMeta{Name: "test-csr"}}
+				if gets > 1 {
+					csr.Status.Certificate = []byte(exampleIssuedCert)
+				}
+				return true, csr, nil
+			})
+			watches := 0
+			client.PrependWatchReactor("certificatesigningrequests", func(act kt.Action) (bool, watch.Interface, error) {
+				watches++
+				if tc.watchErr != nil {
+					return true, nil, tc.watchErr
+				}
+				w := watch.NewFakeWithChanSize(1, false)
+				w.Modify(&cert.CertificateSigningRequest{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-csr"},
+					Status:     cert.CertificateSigningRequestStatus{Certificate: []byte(exampleIssuedCert)},
+				})
+				return true, w, nil
+			})
+			var strategies []WaitStrategy
+			opts := &SignOptions{
+				WaitStrategy:     tc.strategy,
+				PollInitialDelay: time.Millisecond,
+				OnWaitStrategy: func(strategy WaitStrategy) {
+					strategies = append(strategies, strategy)
+				},
+			}
+
+			certData, err := readSignedCsr(context.Background(), client, "test-csr", time.Second, certReadInterval, 3, true, opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(certData) != exampleIssuedCert {
+				t.Fatalf("expected the issued certificate, got %q", certData)
+			}
+			if watches != tc.wantWatches {
+				t.Errorf("expected %d watches of the CSR, got %d", tc.wantWatches, watches)
+			}
+			if !reflect.DeepEqual(strategies, tc.wantStrategies) {
+				t.Errorf("expected the wait strategies %v, got %v", tc.wantStrategies, strategies)
+			}
+		})
+	}
+}
+
+func TestPollBackoff(t *testing.T) {
+	testCases := map[string]struct {
+		opts SignOptions
+		want []time.Duration
+	}{
+		"fixed interval by default": {
+			want: []time.Duration{100 * time.Millisecond, 100 * time.Millisecond, 100 * time.Millisecond},
+		},
+		"initial delay": {
+			opts: SignOptions{PollInitialDelay: time.Second},
+			want: []time.Duration{time.Second, time.Second},
+		},
+		"doubled up to the max interval": {
+			opts: SignOptions{PollMaxInterval: 300 * time.Millisecond},
+			want: []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond},
+		},
+		"max interval below the initial delay": {
+			opts: SignOptions{PollInitialDelay: time.Second, PollMaxInterval: 300 * time.Millisecond},
+			want: []time.Duration{time.Second, time.Second},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			backoff := newPollBackoff(100*time.Millisecond, &tc.opts)
+			var got []time.Duration
+			for range tc.want {
+				got = append(got, backoff.next())
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("expected the intervals %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestValidateWaitStrategy(t *testing.T) {
+	for _, strategy := range []WaitStrategy{"", WaitWatch, WaitPoll} {
+		if err := ValidateWaitStrategy(strategy); err != nil {
+			t.Errorf("unexpected error for the wait strategy %q: %v", strategy, err)
+		}
+	}
+	if err := ValidateWaitStrategy("Stream"); err == nil {
+		t.Error("expected an unknown wait strategy to be rejected")
+	}
+}
+
 func TestSubmitCSR(t *testing.T) {
 	testCases := map[string]struct {
 		gracePeriodRatio  float32
@@ -344,6 +575,9 @@ func TestSubmitCSR(t *testing.T) {
 
 		secretName      string
 		secretNameSpace string
+		genCSRName      func() string
+		labels          map[string]string
+		annotations     map[string]string
 		expectFail      bool
 	}{
 		"submitting a CSR without duplicate should succeed": {
@@ -356,6 +590,62 @@ func TestSubmitCSR(t *testing.T) {
 			secretNameSpace:   "mock-secret-namespace",
 			expectFail:        false,
 		},
+		"submitting a CSR with an invalid name should fail": {
+			gracePeriodRatio:  0.6,
+			k8sCaCertFile:     "./test-data/example-ca-cert.pem",
+			dnsNames:          []string{"foo"},
+			secretNames:       []string{"istio.webhook.foo"},
+			serviceNamespaces: []string{"foo.ns"},
+			secretName:        "mock-secret",
+			secretNameSpace:   "mock-secret-namespace",
+			genCSRName:        func() string { return "Invalid_CSR_Name" },
+			expectFail:        true,
+		},
+		"submitting a CSR with labels and annotations should succeed": {
+			gracePeriodRatio:  0.6,
+			k8sCaCertFile:     "./test-data/example-ca-cert.pem",
+			dnsNames:          []string{"foo"},
+			secretNames:       []string{"istio.webhook.foo"},
+			serviceNamespaces: []string{"foo.ns"},
+			secretName:        "mock-secret",
+			secretNameSpace:   "mock-secret-namespace",
+			labels:            map[string]string{"example.com/tenant": "foo"},
+			annotations:       map[string]string{"example.com/workload": "spiffe://cluster.local/ns/foo/sa/bar"},
+			expectFail:        false,
+		},
+		"submitting a CSR with an invalid label key should fail": {
+			gracePeriodRatio:  0.6,
+			k8sCaCertFile:     "./test-data/example-ca-cert.pem",
+			dnsNames:          []string{"foo"},
+			secretNames:       []string{"istio.webhook.foo"},
+			serviceNamespaces: []string{"foo.ns"},
+			secretName:        "mock-secret",
+			secretNameSpace:   "mock-secret-namespace",
+			labels:            map[string]string{"example.com/tenant/": "foo"},
+			expectFail:        true,
+		},
+		"submitting a CSR with an invalid label value should fail": {
+			gracePeriodRatio:  0.6,
+			k8sCaCertFile:     "./test-data/example-ca-cert.pem",
+			dnsNames:          []string{"foo"},
+			secretNames:       []string{"istio.webhook.foo"},
+			serviceNamespaces: []string{"foo.ns"},
+			secretName:        "mock-secret",
+			secretNameSpace:   "mock-secret-namespace",
+			labels:            map[string]string{"example.com/tenant": "spiffe://cluster.local"},
+			expectFail:        true,
+		},
+		"submitting a CSR with an invalid annotation key should fail": {
+			gracePeriodRatio:  0.6,
+			k8sCaCertFile:     "./test-data/example-ca-cert.pem",
+			dnsNames:          []string{"foo"},
+			secretNames:       []string{"istio.webhook.foo"},
+			serviceNamespaces: []string{"foo.ns"},
+			secretName:        "mock-secret",
+			secretNameSpace:   "mock-secret-namespace",
+			annotations:       map[string]string{"example.com/work load": "foo"},
+			expectFail:        true,
+		},
 	}
 
 	for tcName, tc := range testCases {
@@ -387,18 +677,128 @@ func TestSubmitCSR(t *testing.T) {
 			cert.UsageClientAuth,
 		}
 
-		_, r, _, err := submitCSR(wc.clientset, []byte("test-pem"), "test-signer",
-			usages, numRetries, DefaulCertTTL)
+		genCSRName := tc.genCSRName
+		if genCSRName == nil {
+			genCSRName = GenCsrName
+		}
+		_, r, _, err := submitCSR(context.Background(), wc.clientset, []byte("test-pem"), "test-signer",
+			usages, genCSRName, tc.labels, tc.annotations, numRetries, DefaulCertTTL)
 		if tc.expectFail {
 			if err == nil {
 				t.Errorf("test case (%s) should have failed", tcName)
 			}
+			if actions := client.Actions(); len(actions) != 0 {
+				t.Errorf("test case (%s) should not have created a CSR, got %v", tcName, actions)
+			}
 		} else if err != nil || r == nil {
 			t.Errorf("test case (%s) failed unexpectedly: %v", tcName, err)
+		} else {
+			for key, value := range tc.labels {
+				if r.Labels[key] != value {
+					t.Errorf("test case (%s): got CSR label %s=%q, want %q", tcName, key, r.Labels[key], value)
+				}
+			}
+			for key, value := range tc.annotations {
+				if r.Annotations[key] != value {
+					t.Errorf("test case (%s): got CSR annotation %s=%q, want %q", tcName, key, r.Annotations[key], value)
+				}
+			}
+			if got := r.Annotations[RequestLifeTimeAnnotationForCertManager]; got != DefaulCertTTL.String() {
+				t.Errorf("test case (%s): got requested lifetime annotation %q, want %q", tcName, got, DefaulCertTTL.String())
+			}
+			if got := r.Spec.ExpirationSeconds; got == nil || time.Duration(*got)*time.Second != DefaulCertTTL {
+				t.Errorf("test case (%s): got expiration seconds %v, want %v", tcName, got, DefaulCertTTL)
+			}
 		}
 	}
 }
 
+func TestSubmitCSRRetries(t *testing.T) {
+	csrResource := schema.GroupResource{Group: "certificates.k8s.io", Resource: "certificatesigningrequests"}
+	testCases := map[string]struct {
+		errs          []error
+		numRetries    int
+		expectFail    bool
+		expectedCalls int
+	}{
+		"errors retried": {
+			errs:          []error{apierrors.NewServiceUnavailable("down"), apierrors.NewServiceUnavailable("down")},
+			numRetries:    3,
+			expectedCalls: 3,
+		},
+		"errors not retried": {
+			errs:          []error{apierrors.NewServiceUnavailable("down")},
+			numRetries:    1,
+			expectFail:    true,
+			expectedCalls: 1,
+		},
+		"name conflicts retried regardless": {
+			errs:          []error{apierrors.NewAlreadyExists(csrResource, "csr"), apierrors.NewAlreadyExists(csrResource, "csr")},
+			numRetries:    1,
+			expectedCalls: 3,
+		},
+		"name conflicts bounded": {
+			errs: []error{
+				apierrors.NewAlreadyExists(csrResource, "csr"), apierrors.NewAlreadyExists(csrResource, "csr"),
+				apierrors.NewAlreadyExists(csrResource, "csr"),
+			},
+			numRetries:    1,
+			expectFail:    true,
+			expectedCalls: csrRetriesMax,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			calls := 0
+			client.PrependReactor("create", "certificatesigningrequests", func(kt.Action) (bool, runtime.Object, error) {
+				if calls++; calls <= len(tc.errs) {
+					return true, nil, tc.errs[calls-1]
+				}
+				return false, nil, nil
+			})
+			usages := []cert.KeyUsage{cert.UsageDigitalSignature, cert.UsageKeyEncipherment}
+			_, r, _, err := submitCSR(context.Background(), client, []byte("test-pem"), "test-signer", usages, GenCsrName,
+				nil, nil, tc.numRetries, DefaulCertTTL)
+			if tc.expectFail {
+				if err == nil {
+					t.Errorf("expected creating the CSR to fail")
+				}
+			} else if err != nil || r == nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if calls != tc.expectedCalls {
+				t.Errorf("expected %d CSR create calls, got %d", tc.expectedCalls, calls)
+			}
+		})
+	}
+}
+
+func TestCSRExpirationSeconds(t *testing.T) {
+	testCases := map[string]struct {
+		requestedLifetime time.Duration
+		// expected is the expected expirationSeconds, 0 if none.
+		expected int32
+	}{
+		"no lifetime":        {},
+		"lifetime":           {requestedLifetime: time.Hour, expected: 3600},
+		"min lifetime":       {requestedLifetime: MinCSRExpiration, expected: 600},
+		"too short lifetime": {requestedLifetime: 5 * time.Minute, expected: 600},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			got := csrExpirationSeconds(tc.requestedLifetime)
+			if tc.expected == 0 {
+				if got != nil {
+					t.Errorf("got expiration seconds %d, want none", *got)
+				}
+			} else if got == nil || *got != tc.expected {
+				t.Errorf("got expiration seconds %v, want %d", got, tc.expected)
+			}
+		})
+	}
+}
+
 func TestReadSignedCertificate(t *testing.T) {
 	testCases := map[string]struct {
 		gracePeriodRatio  float32
@@ -474,8 +874,8 @@ func TestReadSignedCertificate(t *testing.T) {
 
 		// 4. Read the signed certificate
 		csrName := fmt.Sprintf("domain-%s-ns-%s-secret-%s", spiffe.GetTrustDomain(), tc.secretNameSpace, tc.secretName)
-		_, _, err = readSignedCertificate(wc.clientset, csrName,
-			1*time.Second, certReadInterval, maxNumCertRead, wc.k8sCaCertFile, true, true)
+		_, _, err = readSignedCertificate(context.Background(), wc.clientset, csrName,
+			1*time.Second, certReadInterval, maxNumCertRead, wc.k8sCaCertFile, true, true, nil)
 
 		if tc.expectFail {
 			if err == nil {
@@ -487,6 +887,130 @@ func TestReadSignedCertificate(t *testing.T) {
 	}
 }
 
+func TestReadSignedCertificateEmptyCertificate(t *testing.T) {
+	testCases := map[string]struct {
+		emptyReads int
+		nilCert    bool
+		opts       *SignOptions
+		expectFail bool
+	}{
+		"populated after retries": {
+			emptyReads: 3,
+			opts:       &SignOptions{EmptyCertificateRetries: 3, EmptyCertificateRetryInterval: time.Millisecond},
+		},
+		"still empty after retries": {
+			emptyReads: 5,
+			opts:       &SignOptions{EmptyCertificateRetries: 3, EmptyCertificateRetryInterval: time.Millisecond},
+			expectFail: true,
+		},
+		"no retries": {
+			emptyReads: 1,
+			opts:       &SignOptions{},
+			expectFail: true,
+		},
+		"nil populated after retries": {
+			emptyReads: 3,
+			nilCert:    true,
+			opts:       &SignOptions{EmptyCertificateRetries: 3, EmptyCertificateRetryInterval: time.Millisecond},
+		},
+		"nil still empty after retries": {
+			emptyReads: 5,
+			nilCert:    true,
+			opts:       &SignOptions{EmptyCertificateRetries: 3, EmptyCertificateRetryInterval: time.Millisecond},
+			expectFail: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			gets := 0
+			client.PrependReactor("get", "certificatesigningrequests", func(act kt.Action) (bool, runtime.Object, error) {
+				gets++
+				csr := &cert.CertificateSigningRequest{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-csr"},
+					Status: cert.CertificateSigningRequestStatus{
+						Conditions:  []cert.CertificateSigningRequestCondition{{Type: cert.CertificateApproved, Status: corev1.ConditionTrue}},
+						Certificate: []byte{},
+					},
+				}
+				if tc.nilCert {
+					csr.Status.Certificate = nil
+				}
+				if gets > tc.emptyReads {
+					csr.Status.Certificate = []byte(exampleIssuedCert)
+				}
+				return true, csr, nil
+			})
+			certChain, _, err := readSignedCertificate(context.Background(), client, "test-csr", 10*time.Millisecond,
+				time.Millisecond, 1, "", false, true, tc.opts)
+			if tc.expectFail {
+				if !errors.Is(err, errCertificateEmpty) {
+					t.Fatalf("expected an error wrapping %v, got: %v", errCertificateEmpty, err)
+				}
+				want := tc.opts.EmptyCertificateRetries + 1
+				if tc.nilCert {
+					// A nil certificate leaves the CSR pending, and polled once more before it is read again.
+					want++
+				}
+				if gets != want {
+					t.Errorf("expected the CSR to be read %d times, got %d", want, gets)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(certChain) != exampleIssuedCert {
+				t.Errorf("expected the populated certificate, got %q", certChain)
+			}
+			if gets != tc.emptyReads+1 {
+				t.Errorf("expected the CSR to be read %d times, got %d", tc.emptyReads+1, gets)
+			}
+		})
+	}
+}
+
+func TestSignCSRK8sWithContextCancelled(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	usages := []cert.KeyUsage{
+		cert.UsageDigitalSignature,
+		cert.UsageKeyEncipherment,
+		cert.UsageServerAuth,
+		cert.UsageClientAuth,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, _, err := SignCSRK8sWithContext(ctx, client, []byte("test-pem"), "test-signer", nil,
+		usages, "", "", false, false, DefaulCertTTL, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected an error wrapping context.DeadlineExceeded, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("signing was not aborted by the context, took %v", elapsed)
+	}
+}
+
+func TestSignCSRK8sWithContextApprovalTimeout(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	usages := []cert.KeyUsage{
+		cert.UsageDigitalSignature,
+		cert.UsageKeyEncipherment,
+		cert.UsageServerAuth,
+		cert.UsageClientAuth,
+	}
+
+	_, _, err := SignCSRK8sWithContext(context.Background(), client, []byte("test-pem"), "test-signer", nil,
+		usages, "", "", false, false, DefaulCertTTL, &SignOptions{ApprovalTimeout: 100 * time.Millisecond})
+	if !errors.Is(err, ErrCSRNotIssued) {
+		t.Fatalf("expected an error wrapping ErrCSRNotIssued, got: %v", err)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the approval timeout not to be reported as a context error, got: %v", err)
+	}
+}
+
 // newMockTLSServer creates a mock TLS server for testing purpose.
 func newMockTLSServer(t *testing.T) *mockTLSServer {
 	server := &mockTLSServer{}
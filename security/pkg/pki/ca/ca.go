@@ -19,9 +19,11 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
+	"net"
 	"os"
 	"time"
 
+	certv1 "k8s.io/api/certificates/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 
@@ -67,12 +69,69 @@ type CertOpts struct {
 	// TTL is the requested lifetime (Time to live) to be applied in the certificate.
 	TTL time.Duration
 
+	// NotAfter is the requested expiry of the certificate, e.g. aligned to a maintenance window. It takes
+	// precedence over TTL: the lifetime requested is the time from now until NotAfter, which is still clamped
+	// to the maximum TTL. Only honored by RAs, which reject a NotAfter in the past.
+	NotAfter time.Time
+
 	// ForCA indicates whether the signed certificate if for CA.
 	// If true, the signed certificate is a CA certificate, otherwise, it is a workload certificate.
 	ForCA bool
 
 	// Cert Signer info
 	CertSigner string
+
+	// KeyUsages are the key usages requested for the certificate. When empty, the signer's default usages apply.
+	// Only honored by RAs using the K8s CSR API.
+	KeyUsages []certv1.KeyUsage
+
+	// DNSNames are DNS SANs requested for the certificate in addition to SubjectIDs, e.g. externally assigned
+	// hostnames. Only honored by RAs, which restrict them to an allow-list. As the CSR APIs of the RA signers
+	// cannot add SANs to a CSR, the names must also be in the CSR.
+	DNSNames []string
+
+	// IPAddresses are IP SANs requested for the certificate, e.g. for gateways reached by IP. Only honored by RAs,
+	// which restrict them to the WorkloadIPs or an allow-list. Like DNSNames, they must also be in the CSR, whose
+	// IP SANs must then all be requested.
+	IPAddresses []net.IP
+
+	// WorkloadIPs are the actual IPs of the requesting workload as verified by the caller, e.g. those of its pod.
+	// IPAddresses among them are allowed, so that workloads cannot obtain certificates for the IPs of others.
+	WorkloadIPs []net.IP
+
+	// RequesterNamespace is the K8s namespace of the requesting workload as verified by the caller. Only honored
+	// by RAs, which check it against their namespace policies for the SPIFFE identities of the certificate.
+	RequesterNamespace string
+
+	// Profile is the name of the certificate profile of the RA constraining the certificate, e.g. its key usages.
+	// Only honored by RAs.
+	Profile string
+
+	// ChainSelector is the name of the cert chain of the RA to append to the certificate instead of the default
+	// one, e.g. a cross-signed path. Only honored by RAs.
+	ChainSelector string
+
+	// CSRAnnotations are set on the K8s CSR object created for the request, e.g. for external approvers, in
+	// addition to the annotations configured in the RA. Only honored by RAs using the K8s CSR API.
+	CSRAnnotations map[string]string
+
+	// IdempotencyKey identifies the request across retries, e.g. by an agent after istiod restarted while
+	// signing. A certificate already issued for a request with the same key, CSR and signer is returned
+	// instead of signing the CSR again, unless it is due for renewal. Only honored by RAs using the K8s CSR API.
+	IdempotencyKey string
+
+	// RequestID correlates the request across the logs of the caller and the RA, and the objects created for
+	// it. RAs generate a random one if unset, label their log lines of the request with it and add it to
+	// the errors returned. RAs using the K8s CSR API also set it as the ra.istio.io/request-id annotation
+	// of the CSR. Concurrent identical requests sharing a signing share the CSR of the first of them.
+	RequestID string
+
+	// MustStaple requests the OCSP must-staple flag (the status_request TLS feature) in the certificate, and
+	// IssuingCertificateURLs, which must be absolute HTTP(S) URLs, in its Authority Information Access extension.
+	// None of the RA backends can request them per certificate, and RAs reject requests setting them with a
+	// CSRError before submitting anything.
+	MustStaple             bool
+	IssuingCertificateURLs []string
 }
 
 const (
@@ -14,7 +14,13 @@
 
 package error
 
-import "google.golang.org/grpc/codes"
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+)
 
 // ErrType is the type for CA errors.
 type ErrType int
@@ -32,20 +38,60 @@ const (
 	CAIllegalConfig
 	// CAInitFail means some other unexpected and fatal initilization failure
 	CAInitFail
+	// RequestCanceled means the caller's context was canceled or its deadline exceeded before signing finished.
+	RequestCanceled
+	// Unauthorized means the request was rejected by an authorization policy.
+	Unauthorized
+	// CSRDenied means the approver of the signer denied the CSR. Retrying the same request is not useful.
+	CSRDenied
+	// CSRPending means the CSR was neither approved nor denied, or not issued, in time. It may still be.
+	CSRPending
+	// CSRFailed means the signer failed to issue a certificate for the approved CSR.
+	CSRFailed
+	// RateLimited means the requesting identity exceeded its rate of signing requests, or the RA its maximum of
+	// outstanding requests to the signer. It may retry after backing off.
+	RateLimited
+	// SignerUnavailable means the circuit breaker of the signer is open after it failed repeatedly, and the
+	// request was failed without being sent to it. It may retry after the cooldown.
+	SignerUnavailable
 )
 
 // Error encapsulates the short and long errors.
 type Error struct {
-	t   ErrType
-	err error
+	t         ErrType
+	err       error
+	requestID string
 }
 
 // Error returns the string error message.
 func (e Error) Error() string {
+	if e.requestID != "" {
+		return fmt.Sprintf("%v (request ID %s)", e.err, e.requestID)
+	}
 	return e.err.Error()
 }
 
+// RequestID returns the ID of the signing request that failed, if known.
+func (e Error) RequestID() string {
+	return e.requestID
+}
+
+// WithRequestID returns a copy of the error for the signing request with ID requestID.
+func (e Error) WithRequestID(requestID string) *Error {
+	e.requestID = requestID
+	return &e
+}
+
+// Unwrap returns the underlying error.
+func (e Error) Unwrap() error {
+	return e.err
+}
+
 // ErrorType returns a short string representing the error type.
+func (e Error) ErrType() ErrType {
+	return e.t
+}
+
 func (e Error) ErrorType() string {
 	switch e.t {
 	case CANotReady:
@@ -56,6 +102,24 @@ func (e Error) ErrorType() string {
 		return "TTL_ERROR"
 	case CertGenError:
 		return "CERT_GEN_ERROR"
+	case CAIllegalConfig:
+		return "CA_ILLEGAL_CONFIG"
+	case CAInitFail:
+		return "CA_INIT_FAIL"
+	case RequestCanceled:
+		return "REQUEST_CANCELED"
+	case Unauthorized:
+		return "UNAUTHORIZED"
+	case CSRDenied:
+		return "CSR_DENIED"
+	case CSRPending:
+		return "CSR_PENDING"
+	case CSRFailed:
+		return "CSR_FAILED"
+	case RateLimited:
+		return "RATE_LIMITED"
+	case SignerUnavailable:
+		return "SIGNER_UNAVAILABLE"
 	}
 	return "UNKNOWN"
 }
@@ -71,6 +135,23 @@ func (e Error) HTTPErrorCode() codes.Code {
 		return codes.InvalidArgument
 	case TTLError:
 		return codes.InvalidArgument
+	case RequestCanceled:
+		if errors.Is(e.err, context.DeadlineExceeded) {
+			return codes.DeadlineExceeded
+		}
+		return codes.Canceled
+	case Unauthorized:
+		return codes.PermissionDenied
+	case CSRDenied:
+		return codes.PermissionDenied
+	case CSRPending:
+		return codes.Unavailable
+	case CSRFailed:
+		return codes.Internal
+	case RateLimited:
+		return codes.ResourceExhausted
+	case SignerUnavailable:
+		return codes.Unavailable
 	}
 	return codes.Internal
 }
@@ -15,6 +15,8 @@
 package error
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"testing"
 
@@ -52,6 +54,60 @@ func TestError(t *testing.T) {
 			message: "CERT_GEN_ERROR",
 			code:    codes.Internal,
 		},
+		"CA_ILLEGAL_CONFIG": {
+			eType:   CAIllegalConfig,
+			err:     fmt.Errorf("test error7"),
+			message: "CA_ILLEGAL_CONFIG",
+			code:    codes.Internal,
+		},
+		"CA_INIT_FAIL": {
+			eType:   CAInitFail,
+			err:     fmt.Errorf("test error8"),
+			message: "CA_INIT_FAIL",
+			code:    codes.Internal,
+		},
+		"REQUEST_CANCELED": {
+			eType:   RequestCanceled,
+			err:     fmt.Errorf("test error6: %w", context.DeadlineExceeded),
+			message: "REQUEST_CANCELED",
+			code:    codes.DeadlineExceeded,
+		},
+		"UNAUTHORIZED": {
+			eType:   Unauthorized,
+			err:     fmt.Errorf("test error7"),
+			message: "UNAUTHORIZED",
+			code:    codes.PermissionDenied,
+		},
+		"CSR_DENIED": {
+			eType:   CSRDenied,
+			err:     fmt.Errorf("test error8"),
+			message: "CSR_DENIED",
+			code:    codes.PermissionDenied,
+		},
+		"CSR_PENDING": {
+			eType:   CSRPending,
+			err:     fmt.Errorf("test error9"),
+			message: "CSR_PENDING",
+			code:    codes.Unavailable,
+		},
+		"CSR_FAILED": {
+			eType:   CSRFailed,
+			err:     fmt.Errorf("test error10"),
+			message: "CSR_FAILED",
+			code:    codes.Internal,
+		},
+		"RATE_LIMITED": {
+			eType:   RateLimited,
+			err:     fmt.Errorf("test error11"),
+			message: "RATE_LIMITED",
+			code:    codes.ResourceExhausted,
+		},
+		"SIGNER_UNAVAILABLE": {
+			eType:   SignerUnavailable,
+			err:     fmt.Errorf("test error12"),
+			message: "SIGNER_UNAVAILABLE",
+			code:    codes.Unavailable,
+		},
 		"UNKNOWN": {
 			eType:   -1,
 			err:     fmt.Errorf("test error5"),
@@ -65,11 +121,32 @@ func TestError(t *testing.T) {
 		if caErr.Error() != tc.err.Error() {
 			t.Errorf("[%s] unexpected error: '%s' VS (expected)'%s'", k, caErr.Error(), tc.err.Error())
 		}
+		if caErr.ErrType() != tc.eType {
+			t.Errorf("[%s] unexpected error type: '%d' VS (expected)'%d'", k, caErr.ErrType(), tc.eType)
+		}
 		if caErr.ErrorType() != tc.message {
 			t.Errorf("[%s] unexpected error type message: '%s' VS (expected)'%s'", k, caErr.ErrorType(), tc.message)
 		}
 		if caErr.HTTPErrorCode() != tc.code {
 			t.Errorf("[%s] unexpected error HTTP code: '%d' VS (expected)'%d'", k, caErr.HTTPErrorCode(), tc.code)
 		}
+		if !errors.Is(caErr, tc.err) {
+			t.Errorf("[%s] error does not wrap the underlying error", k)
+		}
+	}
+}
+
+func TestErrorWithRequestID(t *testing.T) {
+	err := fmt.Errorf("test error")
+	caErr := NewError(CSRPending, err)
+	withID := caErr.WithRequestID("req-1")
+	if got, want := withID.Error(), "test error (request ID req-1)"; got != want {
+		t.Errorf("unexpected error: '%s' VS (expected)'%s'", got, want)
+	}
+	if withID.RequestID() != "req-1" || withID.ErrorType() != caErr.ErrorType() || !errors.Is(withID, err) {
+		t.Errorf("error with request ID %v does not match %v", withID, caErr)
+	}
+	if caErr.RequestID() != "" || caErr.Error() != err.Error() {
+		t.Errorf("the original error was modified: %v", caErr)
 	}
 }
@@ -0,0 +1,118 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+
+	"istio.io/istio/security/pkg/pki/ca"
+	raerror "istio.io/istio/security/pkg/pki/error"
+)
+
+const (
+	auditFailureError   = "error"
+	auditFailureTimeout = "timeout"
+)
+
+// AuditEvent is the record of a certificate issued by the RA. It holds no private key material.
+type AuditEvent struct {
+	// RequestID is the RequestID of the signing request.
+	RequestID string
+	// SubjectIDs are the identities the certificate was requested for, those of the authenticated requester.
+	SubjectIDs []string
+	// RequesterNamespace is the namespace of the requester, if known.
+	RequesterNamespace string
+	// DNSNames are the DNS SANs requested for the certificate.
+	DNSNames []string
+	// IPAddresses are the IP SANs requested for the certificate.
+	IPAddresses []net.IP
+	// Profile is the CertProfiles entry requested, if any.
+	Profile string
+	// ForCA is whether a CA certificate was requested.
+	ForCA bool
+	// CertSigner is the signer that issued the certificate.
+	CertSigner string
+	// SerialNumber is the serial number of the certificate.
+	SerialNumber *big.Int
+	// NotBefore and NotAfter are the validity bounds of the certificate.
+	NotBefore time.Time
+	NotAfter  time.Time
+	// IssuedAt is when the RA received the certificate from the signer.
+	IssuedAt time.Time
+	// CertPEM is the PEM encoded certificate.
+	CertPEM []byte
+}
+
+// AuditSink records the certificates issued by the RA, e.g. to a log pipeline or a SIEM. RecordIssuance is called
+// once for each certificate issued, concurrently for concurrent requests, and must not modify the event.
+type AuditSink interface {
+	RecordIssuance(event AuditEvent) error
+}
+
+// recordIssuance records the certificate of result, issued for certOpts and labeled signer in metrics, to the
+// AuditSink of raOpts if any. Signing waits for the sink at most the AuditTimeout. Failures to record the
+// certificate are logged and metered, and only returned with RequireAudit.
+func recordIssuance(raOpts *IstioRAOptions, signer string, certOpts ca.CertOpts, result *SignResult) error {
+	if raOpts.AuditSink == nil {
+		return nil
+	}
+	event := AuditEvent{
+		RequestID:          certOpts.RequestID,
+		SubjectIDs:         certOpts.SubjectIDs,
+		RequesterNamespace: certOpts.RequesterNamespace,
+		DNSNames:           certOpts.DNSNames,
+		IPAddresses:        certOpts.IPAddresses,
+		Profile:            certOpts.Profile,
+		ForCA:              certOpts.ForCA,
+		CertSigner:         result.CertSigner,
+		SerialNumber:       result.SerialNumber,
+		NotBefore:          result.NotBefore,
+		NotAfter:           result.NotAfter,
+		IssuedAt:           time.Now(),
+		CertPEM:            result.CertPEM,
+	}
+	timeout := raOpts.AuditTimeout
+	if timeout <= 0 {
+		timeout = DefaultAuditTimeout
+	}
+	// Buffered so that a sink returning after the timeout does not leak its goroutine.
+	recorded := make(chan error, 1)
+	go func() {
+		recorded <- raOpts.AuditSink.RecordIssuance(event)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case err = <-recorded:
+		if err == nil {
+			return nil
+		}
+		auditFailureCounts.With(signerTag.Value(signer), reasonTag.Value(auditFailureError)).Increment()
+	case <-timer.C:
+		err = fmt.Errorf("the audit sink did not return within %v", timeout)
+		auditFailureCounts.With(signerTag.Value(signer), reasonTag.Value(auditFailureTimeout)).Increment()
+	}
+	requestLog(certOpts.RequestID).Errorf("failed to audit certificate %s issued by signer %s: %v",
+		result.SerialNumber, result.CertSigner, err)
+	if raOpts.RequireAudit {
+		return raerror.NewError(raerror.CertGenError, fmt.Errorf("failed to audit certificate %s issued by signer %s: %v",
+			result.SerialNumber, result.CertSigner, err))
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"istio.io/istio/security/pkg/pki/ca"
+	raerror "istio.io/istio/security/pkg/pki/error"
+)
+
+// fakeAuditSink records the events it receives, after delay, failing with err if set.
+type fakeAuditSink struct {
+	mutex  sync.Mutex
+	delay  time.Duration
+	err    error
+	events []AuditEvent
+}
+
+func (s *fakeAuditSink) RecordIssuance(event AuditEvent) error {
+	time.Sleep(s.delay)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.events = append(s.events, event)
+	return s.err
+}
+
+func (s *fakeAuditSink) recorded() []AuditEvent {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]AuditEvent(nil), s.events...)
+}
+
+func TestAuditSink(t *testing.T) {
+	sink := &fakeAuditSink{}
+	r := createFakeVaultRA(t, &fakeVault{leaseDuration: 3600})
+	r.raOpts.AuditSink = sink
+	certOpts := ca.CertOpts{
+		SubjectIDs: []string{testCsrHostName},
+		TTL:        10 * time.Minute,
+		RequestID:  "audited-request",
+	}
+	start := time.Now()
+	result, err := r.SignWithCertChainResponse(createFakeCsr(t), certOpts)
+	if err != nil {
+		t.Fatalf("Failed to sign through Vault: %v", err)
+	}
+	events := sink.recorded()
+	if len(events) != 1 {
+		t.Fatalf("got %d audit events, want 1", len(events))
+	}
+	event := events[0]
+	if event.RequestID != certOpts.RequestID || len(event.SubjectIDs) != 1 || event.SubjectIDs[0] != testCsrHostName {
+		t.Errorf("got audit event for request %q and identities %v, want %q and %s", event.RequestID,
+			event.SubjectIDs, certOpts.RequestID, testCsrHostName)
+	}
+	if event.CertSigner != vaultSignerLabel || event.SerialNumber.Cmp(result.SerialNumber) != 0 ||
+		!event.NotAfter.Equal(result.NotAfter) || !bytes.Equal(event.CertPEM, result.CertPEM) {
+		t.Errorf("the audit event %+v does not match the issued certificate", event)
+	}
+	if event.IssuedAt.Before(start) || event.IssuedAt.After(time.Now()) {
+		t.Errorf("got the certificate issued at %v, want it issued while signing", event.IssuedAt)
+	}
+
+	// signing fails before a certificate is issued
+	if _, err := r.Sign(createFakeCsr(t), ca.CertOpts{SubjectIDs: []string{testCsrHostName}, ForCA: true}); err == nil {
+		t.Fatalf("expected signing a CA certificate to fail")
+	}
+	if got := len(sink.recorded()); got != 1 {
+		t.Errorf("got %d audit events, want failed requests not to be audited", got)
+	}
+}
+
+func TestRecordIssuanceFailure(t *testing.T) {
+	result := &SignResult{CertSigner: "example.com/signer", SerialNumber: big.NewInt(42)}
+	testCases := map[string]struct {
+		sink           *fakeAuditSink
+		requireAudit   bool
+		expectedReason string
+	}{
+		"sink error": {
+			sink:           &fakeAuditSink{err: errors.New("pipeline unavailable")},
+			expectedReason: auditFailureError,
+		},
+		"slow sink": {
+			sink:           &fakeAuditSink{delay: time.Second},
+			expectedReason: auditFailureTimeout,
+		},
+		"sink error with audit required": {
+			sink:           &fakeAuditSink{err: errors.New("pipeline unavailable")},
+			requireAudit:   true,
+			expectedReason: auditFailureError,
+		},
+		"slow sink with audit required": {
+			sink:           &fakeAuditSink{delay: time.Second},
+			requireAudit:   true,
+			expectedReason: auditFailureTimeout,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			tags := map[string]string{signerLabel: "test", reasonLabel: tc.expectedReason}
+			failures := getMetricValue(t, "ra_cert_audit_failure_count", tags)
+			raOpts := &IstioRAOptions{AuditSink: tc.sink, AuditTimeout: 50 * time.Millisecond, RequireAudit: tc.requireAudit}
+			start := time.Now()
+			err := recordIssuance(raOpts, "test", ca.CertOpts{SubjectIDs: []string{testCsrHostName}}, result)
+			if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+				t.Errorf("signing waited %v for the audit sink, want at most the audit timeout", elapsed)
+			}
+			var raErr *raerror.Error
+			if tc.requireAudit && (!errors.As(err, &raErr) || raErr.ErrorType() != "CERT_GEN_ERROR") {
+				t.Errorf("expected a CERT_GEN_ERROR error, got: %v", err)
+			} else if !tc.requireAudit && err != nil {
+				t.Errorf("expected the audit failure not to fail signing, got: %v", err)
+			}
+			if got := getMetricValue(t, "ra_cert_audit_failure_count", tags); got != failures+1 {
+				t.Errorf("ra_cert_audit_failure_count: got %v, want %v", got, failures+1)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"fmt"
+
+	raerror "istio.io/istio/security/pkg/pki/error"
+)
+
+// validateNotBeforeBackdate checks that the NotBeforeBackdate of raOpts is within [0, MaxNotBeforeBackdate].
+func validateNotBeforeBackdate(raOpts *IstioRAOptions) error {
+	if raOpts.NotBeforeBackdate < 0 || raOpts.NotBeforeBackdate > MaxNotBeforeBackdate {
+		return raerror.NewError(raerror.CAInitFail, fmt.Errorf("NotBefore backdate %v is not within [0, %v]",
+			raOpts.NotBeforeBackdate, MaxNotBeforeBackdate))
+	}
+	return nil
+}
+
+// warnUnsupportedNotBeforeBackdate logs that the NotBeforeBackdate of raOpts, if set, is not applied by the
+// backend of the RA, which cannot set the NotBefore of the certificates it requests.
+func warnUnsupportedNotBeforeBackdate(raOpts *IstioRAOptions, backend string) {
+	if raOpts.NotBeforeBackdate <= 0 {
+		return
+	}
+	pkiRaLog.Warnf("the %s RA cannot backdate the NotBefore of the certificates it requests, NotBefore backdate %v "+
+		"is not applied: the certificates not valid yet beyond the clock skew tolerance are detected instead",
+		backend, raOpts.NotBeforeBackdate)
+}
@@ -0,0 +1,63 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateNotBeforeBackdate(t *testing.T) {
+	testCases := map[string]struct {
+		backdate  time.Duration
+		expectErr bool
+	}{
+		"unset": {},
+		"backdated": {
+			backdate: time.Minute,
+		},
+		"maximum": {
+			backdate: MaxNotBeforeBackdate,
+		},
+		"above maximum": {
+			backdate:  MaxNotBeforeBackdate + time.Second,
+			expectErr: true,
+		},
+		"negative": {
+			backdate:  -time.Minute,
+			expectErr: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := validateRAOptions(&IstioRAOptions{NotBeforeBackdate: tc.backdate})
+			if tc.expectErr != (err != nil) {
+				t.Errorf("got error %v, want an error: %v", err, tc.expectErr)
+			}
+		})
+	}
+}
+
+func TestNotBeforeBackdateUnsupported(t *testing.T) {
+	// the RAs are created with the backdate not applied, and report it
+	r := createFakeVaultRA(t, &fakeVault{})
+	r.raOpts.NotBeforeBackdate = time.Minute
+	if _, err := NewVaultRA(r.raOpts); err != nil {
+		t.Fatalf("unexpected error for an unsupported NotBefore backdate: %v", err)
+	}
+	if r.Capabilities().NotBeforeBackdate {
+		t.Errorf("expected the Vault RA not to report backdating certificates")
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"istio.io/istio/security/pkg/pki/ca"
+	raerror "istio.io/istio/security/pkg/pki/error"
+)
+
+// SignRequest is a CSR to sign in a SignBatch or SignStream call.
+type SignRequest struct {
+	// CSRPEM is the PEM encoded CSR.
+	CSRPEM []byte
+	// CertOpts are the options of the requested certificate.
+	CertOpts ca.CertOpts
+	// Timeout bounds signing this CSR, in addition to the context of the batch or stream. No timeout if zero.
+	Timeout time.Duration
+}
+
+// SignResponse is the outcome of a SignRequest.
+type SignResponse struct {
+	// Result is the issued certificate, nil if Err is set.
+	Result *SignResult
+	// Err is the error signing the CSR.
+	Err error
+}
+
+// SignBatch signs requests concurrently, with at most SignBatchConcurrency CSRs in flight at a time, and
+// returns a response for each request in the same order. Identical requests are only signed once, and share
+// the same response. A failure to sign a CSR does not fail the others.
+func (r *KubernetesRA) SignBatch(ctx context.Context, requests []SignRequest) []SignResponse {
+	responses := make([]SignResponse, len(requests))
+	// duplicates[i] holds the indexes of the requests identical to requests[i], which are only signed once.
+	duplicates := map[int][]int{}
+	var unique []int
+	for i := range requests {
+		found := false
+		for _, u := range unique {
+			if sameSignRequest(&requests[u], &requests[i]) {
+				duplicates[u] = append(duplicates[u], i)
+				found = true
+				break
+			}
+		}
+		if !found {
+			unique = append(unique, i)
+		}
+	}
+
+	concurrency := r.raOpts.SignBatchConcurrency
+	if concurrency <= 0 {
+		concurrency = DefaultSignBatchConcurrency
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, i := range unique {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var resp SignResponse
+			defer func() {
+				responses[i] = resp
+				for _, d := range duplicates[i] {
+					responses[d] = resp
+				}
+			}()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				resp.Err = raerror.NewError(raerror.RequestCanceled, ctx.Err())
+				return
+			}
+			resp = signRequest(ctx, &requests[i], r.SignWithCertChainResponseContext)
+		}(i)
+	}
+	wg.Wait()
+	return responses
+}
+
+func sameSignRequest(a, b *SignRequest) bool {
+	return bytes.Equal(a.CSRPEM, b.CSRPEM) && a.Timeout == b.Timeout && reflect.DeepEqual(a.CertOpts, b.CertOpts)
+}
@@ -0,0 +1,109 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	kt "k8s.io/client-go/testing"
+
+	"istio.io/istio/security/pkg/pki/ca"
+	raerror "istio.io/istio/security/pkg/pki/error"
+)
+
+func TestSignBatch(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	var mu sync.Mutex
+	creates, active, maxActive := 0, 0, 0
+	client.PrependReactor("create", "certificatesigningrequests", func(kt.Action) (bool, runtime.Object, error) {
+		mu.Lock()
+		creates++
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+		// Let the fake clientset create the CSR, which is never issued.
+		return false, nil, nil
+	})
+	r, err := createFakeK8sRA(client)
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	r.raOpts.SignBatchConcurrency = 2
+
+	certOpts := ca.CertOpts{SubjectIDs: []string{testCsrHostName}, TTL: time.Minute}
+	var requests []SignRequest
+	for i := 0; i < 5; i++ {
+		requests = append(requests, SignRequest{CSRPEM: createFakeCsr(t), CertOpts: certOpts, Timeout: 100 * time.Millisecond})
+	}
+	// A duplicate of the first request, and a malformed CSR.
+	requests = append(requests, requests[0], SignRequest{CSRPEM: []byte("not a CSR"), CertOpts: certOpts})
+
+	responses := r.SignBatch(context.Background(), requests)
+	if len(responses) != len(requests) {
+		t.Fatalf("expected %d responses, got %d", len(requests), len(responses))
+	}
+	for i, resp := range responses[:6] {
+		var raErr *raerror.Error
+		if !errors.As(resp.Err, &raErr) || raErr.ErrorType() != "REQUEST_CANCELED" {
+			t.Errorf("response %d: expected a REQUEST_CANCELED error, got %v", i, resp.Err)
+		}
+	}
+	var raErr *raerror.Error
+	if !errors.As(responses[6].Err, &raErr) || raErr.ErrorType() != "CSR_ERROR" {
+		t.Errorf("expected a CSR_ERROR for the malformed CSR, got %v", responses[6].Err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if creates != 5 {
+		t.Errorf("expected 5 CSRs to be created, got %d", creates)
+	}
+	if maxActive > 2 {
+		t.Errorf("expected at most 2 concurrent CSRs, got %d", maxActive)
+	}
+}
+
+func TestSignBatchCancelled(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	r, err := createFakeK8sRA(client)
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	certOpts := ca.CertOpts{SubjectIDs: []string{testCsrHostName}, TTL: time.Minute}
+	responses := r.SignBatch(ctx, []SignRequest{
+		{CSRPEM: createFakeCsr(t), CertOpts: certOpts},
+		{CSRPEM: createFakeCsr(t), CertOpts: certOpts},
+	})
+	for i, resp := range responses {
+		if resp.Result != nil || !errors.Is(resp.Err, context.Canceled) {
+			t.Errorf("response %d: expected the request to be canceled, got %v", i, resp.Err)
+		}
+	}
+}
@@ -0,0 +1,524 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+
+	raerror "istio.io/istio/security/pkg/pki/error"
+	"istio.io/istio/security/pkg/pki/util"
+)
+
+// retiredRootCert is a CA root cert that was removed from CaCertFile, but is still advertised until the
+// end of the RootCertOverlapPeriod.
+type retiredRootCert struct {
+	der   []byte
+	until time.Time
+}
+
+// loadCABundle reads the CA root certs in caCertFile, and returns an error if any of them cannot be parsed.
+func loadCABundle(caCertFile string) (*util.KeyCertBundle, error) {
+	rootCertBytes, err := readCACertFile(caCertFile)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := parseRootCerts(rootCertBytes); err != nil {
+		return nil, fmt.Errorf("invalid CA cert file %s: %v", caCertFile, err)
+	}
+	return util.NewKeyCertBundleFromPem(nil, nil, nil, rootCertBytes), nil
+}
+
+// readCACertFile returns the content of caCertFile. If caCertFile is a directory, the content of all the
+// .pem and .crt files in it is concatenated, in the order of their names.
+func readCACertFile(caCertFile string) ([]byte, error) {
+	info, err := os.Stat(caCertFile)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return os.ReadFile(caCertFile)
+	}
+	entries, err := os.ReadDir(caCertFile)
+	if err != nil {
+		return nil, err
+	}
+	var rootCertBytes []byte
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		// Skip hidden files, such as the timestamped directories of mounted K8s secrets.
+		if strings.HasPrefix(entry.Name(), ".") || (ext != ".pem" && ext != ".crt") {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(caCertFile, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if len(rootCertBytes) > 0 && !bytes.HasSuffix(rootCertBytes, []byte("\n")) {
+			rootCertBytes = append(rootCertBytes, '\n')
+		}
+		rootCertBytes = append(rootCertBytes, b...)
+	}
+	if len(rootCertBytes) == 0 {
+		return nil, fmt.Errorf("no CA cert files found in directory %s", caCertFile)
+	}
+	return rootCertBytes, nil
+}
+
+// parseRootCerts returns the DER encoding of every PEM block in rootCertBytes, and returns an error if any
+// of them is not a certificate.
+func parseRootCerts(rootCertBytes []byte) ([][]byte, error) {
+	var rootCerts [][]byte
+	for {
+		var block *pem.Block
+		block, rootCertBytes = pem.Decode(rootCertBytes)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("unexpected PEM block type %s", block.Type)
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return nil, err
+		}
+		rootCerts = append(rootCerts, block.Bytes)
+	}
+	if len(rootCerts) == 0 {
+		return nil, fmt.Errorf("no PEM encoded X.509 certificates parsed")
+	}
+	return rootCerts, nil
+}
+
+// watchCaCertFile starts watching the directory of CaCertFile, so that atomic replacements of the
+// file (e.g. by the kubelet updating a mounted secret) are observed as well. If CaCertFile is a
+// directory, it is watched itself.
+func (r *KubernetesRA) watchCaCertFile() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(watchDir(r.raOpts.CaCertFile)); err != nil {
+		_ = watcher.Close()
+		return err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	select {
+	case <-r.stopCh:
+		// The RA was closed while waiting for CaCertFile.
+		_ = watcher.Close()
+		return nil
+	default:
+	}
+	r.caCertWatcher = watcher
+	go r.handleCaCertFileWatch(watcher)
+	return nil
+}
+
+// watchDir returns the directory to watch for changes of caCertFile: caCertFile itself if it is a directory, or
+// else the directory it is in.
+func watchDir(caCertFile string) string {
+	if info, err := os.Stat(caCertFile); err == nil && info.IsDir() {
+		return caCertFile
+	}
+	return filepath.Dir(caCertFile)
+}
+
+func (r *KubernetesRA) handleCaCertFileWatch(watcher *fsnotify.Watcher) {
+	for {
+		select {
+		case <-r.stopCh:
+			return
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			// Chmod events do not change the content of the file.
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			r.reloadCABundle()
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			pkiRaLog.Errorf("error watching CA cert file %s: %v", r.raOpts.CaCertFile, err)
+		}
+	}
+}
+
+// waitForCaCertFile retries loading CaCertFile until it succeeds or the RA is closed, and then starts
+// watching CaCertFile if WatchCaCertFile is set.
+func (r *KubernetesRA) waitForCaCertFile() {
+	interval := r.raOpts.CaCertFilePollInterval
+	if interval <= 0 {
+		interval = DefaultCaCertFilePollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stopCh:
+			return
+		case <-ticker.C:
+		}
+		keyCertBundle, err := loadCABundle(r.raOpts.CaCertFile)
+		if err != nil {
+			pkiRaLog.Debugf("CA cert file %s cannot be loaded yet: %v", r.raOpts.CaCertFile, err)
+			continue
+		}
+		r.reloadMutex.Lock()
+		r.mutex.Lock()
+		// ReloadCABundle may have loaded it already.
+		loaded := r.caCertPending
+		if loaded {
+			r.keyCertBundle = keyCertBundle
+			r.caCertPending = false
+		}
+		callbacks := r.reloadCallbacks
+		r.mutex.Unlock()
+		r.reloadMutex.Unlock()
+		if loaded {
+			recordRootCertExpiry(r.raOpts.CaSigner, keyCertBundle.GetRootCertPem(), time.Now())
+			pkiRaLog.Infof("loaded CA cert file %s", r.raOpts.CaCertFile)
+			runReloadCallbacks(callbacks, keyCertBundle)
+		}
+		if r.raOpts.WatchCaCertFile {
+			if err := r.watchCaCertFile(); err != nil {
+				pkiRaLog.Errorf("error watching CA cert file %s: %v", r.raOpts.CaCertFile, err)
+			}
+		}
+		return
+	}
+}
+
+// reloadCABundle reloads the CA bundle with ReloadCABundle, logging the failure to do so.
+func (r *KubernetesRA) reloadCABundle() {
+	if err := r.ReloadCABundle(); err != nil {
+		pkiRaLog.Errorf("failed to reload %s, keeping the previous CA bundle: %v", r.caBundleSource(), err)
+	}
+}
+
+// ReloadCABundle swaps in the CA root certs in CaCertFile, or in the CA cert Secret, if they have changed, and
+// calls the reload callbacks with the new bundle. The CA bundle is reloaded this way when they change, this forces
+// it e.g. after they were rotated out-of-band. A file or Secret that cannot be read or parsed is rejected with an
+// error, keeping the previous bundle in place. Root certs that are removed are still advertised, after the current
+// ones, until the end of the RootCertOverlapPeriod. It is safe to call concurrently with signing and reloads.
+func (r *KubernetesRA) ReloadCABundle() error {
+	if r.caCertSecret == nil && r.raOpts.CaCertFile == "" {
+		return raerror.NewError(raerror.CAInitFail, fmt.Errorf("neither a CA cert file nor a CA cert Secret is configured"))
+	}
+	r.reloadMutex.Lock()
+	defer r.reloadMutex.Unlock()
+	keyCertBundle, err := r.readCABundle()
+	if err != nil {
+		return raerror.NewError(raerror.CAInitFail, fmt.Errorf("failed to reload %s: %v", r.caBundleSource(), err))
+	}
+	now := time.Now()
+	r.mutex.Lock()
+	caCertPending := r.caCertPending
+	r.caCertPending = false
+	rootCertPem := r.retainRootCerts(keyCertBundle.GetRootCertPem(), now)
+	if !caCertPending && bytes.Equal(rootCertPem, r.keyCertBundle.GetRootCertPem()) {
+		r.mutex.Unlock()
+		// The time until the root certs expire is refreshed on each reload, even of an unchanged bundle.
+		recordRootCertExpiry(r.raOpts.CaSigner, rootCertPem, now)
+		return nil
+	}
+	r.keyCertBundle = util.NewKeyCertBundleFromPem(nil, nil, nil, rootCertPem)
+	keyCertBundle = r.keyCertBundle
+	callbacks := r.reloadCallbacks
+	r.mutex.Unlock()
+	recordRootCertExpiry(r.raOpts.CaSigner, rootCertPem, now)
+	pkiRaLog.Infof("reloaded %s", r.caBundleSource())
+	runReloadCallbacks(callbacks, keyCertBundle)
+	return nil
+}
+
+// RegisterReloadCallback registers callback to be called with the new CA bundle each time it is swapped in,
+// when CaCertFile is loaded or reloaded, the CA cert Secret changes, or by ReloadCABundle. Callbacks are called
+// without holding the bundle lock, each in its own goroutine, so that a slow callback does not hold off reloads.
+func (r *KubernetesRA) RegisterReloadCallback(callback func(*util.KeyCertBundle)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.reloadCallbacks = append(r.reloadCallbacks, callback)
+}
+
+// runReloadCallbacks calls each of callbacks with keyCertBundle in its own goroutine.
+func runReloadCallbacks(callbacks []func(*util.KeyCertBundle), keyCertBundle *util.KeyCertBundle) {
+	for _, callback := range callbacks {
+		go callback(keyCertBundle)
+	}
+}
+
+// readCABundle reads the CA root certs of the RA from the CA cert Secret if any, or else from CaCertFile.
+func (r *KubernetesRA) readCABundle() (*util.KeyCertBundle, error) {
+	if r.caCertSecret != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), caCertSecretReadTimeout)
+		defer cancel()
+		return loadCABundleFromSecret(ctx, r.csrInterface, r.caCertSecret)
+	}
+	return loadCABundle(r.raOpts.CaCertFile)
+}
+
+// caBundleSource describes where the CA root certs of the RA are read from, for logging.
+func (r *KubernetesRA) caBundleSource() string {
+	if r.caCertSecret != nil {
+		return r.caCertSecret.String()
+	}
+	return "CA cert file " + r.raOpts.CaCertFile
+}
+
+// retainRootCerts returns rootCertPem followed by the root certs of the current bundle that are missing
+// from it, as long as they are within the RootCertOverlapPeriod. The caller must hold r.mutex.
+func (r *KubernetesRA) retainRootCerts(rootCertPem []byte, now time.Time) []byte {
+	if r.raOpts.RootCertOverlapPeriod <= 0 {
+		return rootCertPem
+	}
+	// Both have been validated by parseRootCerts already.
+	newRootCerts, _ := parseRootCerts(rootCertPem)
+	currentRootCerts, _ := parseRootCerts(r.keyCertBundle.GetRootCertPem())
+	contains := func(rootCerts [][]byte, der []byte) bool {
+		for _, rootCert := range rootCerts {
+			if bytes.Equal(rootCert, der) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var retired []retiredRootCert
+	var retiredDER [][]byte
+	for _, rootCert := range r.retiredRootCerts {
+		// Root certs at the end of their overlap period are not retired again below.
+		retiredDER = append(retiredDER, rootCert.der)
+		if now.Before(rootCert.until) && !contains(newRootCerts, rootCert.der) {
+			retired = append(retired, rootCert)
+		}
+	}
+	for _, der := range currentRootCerts {
+		if contains(newRootCerts, der) || contains(retiredDER, der) {
+			continue
+		}
+		retired = append(retired, retiredRootCert{der: der, until: now.Add(r.raOpts.RootCertOverlapPeriod)})
+		// Reload again once the overlap period is over, so that the retired root cert is dropped.
+		time.AfterFunc(r.raOpts.RootCertOverlapPeriod, func() {
+			select {
+			case <-r.stopCh:
+			default:
+				r.reloadCABundle()
+			}
+		})
+	}
+	r.retiredRootCerts = retired
+
+	rootCertPem = append([]byte{}, rootCertPem...)
+	for _, rootCert := range retired {
+		if !bytes.HasSuffix(rootCertPem, []byte("\n")) {
+			rootCertPem = append(rootCertPem, '\n')
+		}
+		rootCertPem = append(rootCertPem, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: rootCert.der})...)
+	}
+	return rootCertPem
+}
+
+// caCertSecretReadTimeout is the timeout of reading the CA cert Secret when reloading it.
+const caCertSecretReadTimeout = 10 * time.Second
+
+// caCertSecret is the key of a K8s Secret holding the CA root certs.
+type caCertSecret struct {
+	namespace string
+	name      string
+	key       string
+}
+
+func (s *caCertSecret) String() string {
+	return fmt.Sprintf("CA cert Secret %s/%s", s.namespace, s.name)
+}
+
+// loadCABundleFromSecret reads the CA root certs in the key of secret, and returns an error if any of them
+// cannot be parsed.
+func loadCABundleFromSecret(ctx context.Context, client clientset.Interface, secret *caCertSecret) (*util.KeyCertBundle, error) {
+	s, err := client.CoreV1().Secrets(secret.namespace).Get(ctx, secret.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("%s not found", secret)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", secret, err)
+	}
+	rootCertBytes, ok := s.Data[secret.key]
+	if !ok {
+		return nil, fmt.Errorf("%s has no key %s", secret, secret.key)
+	}
+	if _, err := parseRootCerts(rootCertBytes); err != nil {
+		return nil, fmt.Errorf("invalid key %s of %s: %v", secret.key, secret, err)
+	}
+	return util.NewKeyCertBundleFromPem(nil, nil, nil, rootCertBytes), nil
+}
+
+// watchCaCertSecret starts watching the CA cert Secret, reloading the CA bundle when it changes, until the
+// RA is closed.
+func (r *KubernetesRA) watchCaCertSecret() {
+	secret := r.caCertSecret
+	factory := informers.NewSharedInformerFactoryWithOptions(r.csrInterface, 0,
+		informers.WithNamespace(secret.namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", secret.name).String()
+		}))
+	isCaCertSecret := func(obj interface{}) bool {
+		s, ok := obj.(*corev1.Secret)
+		return ok && s.Name == secret.name
+	}
+	factory.Core().V1().Secrets().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if isCaCertSecret(obj) {
+				r.reloadCABundle()
+			}
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			if isCaCertSecret(obj) {
+				r.reloadCABundle()
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if isCaCertSecret(obj) {
+				pkiRaLog.Warnf("%s was deleted, keeping the previous CA bundle", secret)
+			}
+		},
+	})
+	factory.Start(r.stopCh)
+}
+
+// certChain is a cert chain of CertChainFiles, from the CA issuing the leaf certificates up to its root.
+type certChain struct {
+	// issuer is the first certificate of the chain, which issues the leaf certificates.
+	issuer *x509.Certificate
+	pem    []byte
+}
+
+// loadCertChains reads the cert chains in certChainFiles. At most one chain may be configured per issuing CA.
+func loadCertChains(certChainFiles []string) ([]certChain, error) {
+	var certChains []certChain
+	for _, certChainFile := range certChainFiles {
+		certChainBytes, err := os.ReadFile(certChainFile)
+		if err != nil {
+			return nil, err
+		}
+		certs, err := parseRootCerts(certChainBytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cert chain file %s: %v", certChainFile, err)
+		}
+		// parseRootCerts has validated every certificate.
+		issuer, _ := x509.ParseCertificate(certs[0])
+		for _, other := range certChains {
+			if sameIssuer(issuer, other.issuer) {
+				return nil, fmt.Errorf("cert chain file %s: another cert chain is configured for issuer %s",
+					certChainFile, issuer.Subject)
+			}
+		}
+		certChains = append(certChains, certChain{issuer: issuer, pem: certChainBytes})
+	}
+	return certChains, nil
+}
+
+// chainForIssuer returns the cert chain of certChains whose first certificate issued leafCert: the one whose
+// subject key ID is the authority key ID of leafCert, or else whose subject is the issuer of leafCert.
+func chainForIssuer(certChains []certChain, leafCert *x509.Certificate) ([]byte, bool) {
+	for _, chain := range certChains {
+		if issuedBy(leafCert, chain.issuer) {
+			return chain.pem, true
+		}
+	}
+	return nil, false
+}
+
+// issuedBy returns whether cert was issued by issuer, by authority key ID or else by issuer subject.
+func issuedBy(cert, issuer *x509.Certificate) bool {
+	if len(cert.AuthorityKeyId) > 0 && len(issuer.SubjectKeyId) > 0 {
+		return bytes.Equal(cert.AuthorityKeyId, issuer.SubjectKeyId)
+	}
+	return bytes.Equal(cert.RawIssuer, issuer.RawSubject)
+}
+
+// sameIssuer returns whether a and b are the same issuing CA, by subject key ID or else by subject.
+func sameIssuer(a, b *x509.Certificate) bool {
+	if len(a.SubjectKeyId) > 0 && len(b.SubjectKeyId) > 0 {
+		return bytes.Equal(a.SubjectKeyId, b.SubjectKeyId)
+	}
+	return bytes.Equal(a.RawSubject, b.RawSubject)
+}
+
+// BundleInfo describes the CA root certs of a CA bundle.
+type BundleInfo struct {
+	// Roots are the root certs of the bundle, in their order in the bundle.
+	Roots []CertInfo
+}
+
+// CertInfo describes a certificate.
+type CertInfo struct {
+	Subject      string
+	Issuer       string
+	SerialNumber *big.Int
+	NotBefore    time.Time
+	NotAfter     time.Time
+	// SubjectKeyID is the subject key identifier of the certificate, if it has one.
+	SubjectKeyID []byte
+	// SelfSigned is whether the certificate is issued by itself, i.e. its issuer is its subject and it is
+	// signed with its own key.
+	SelfSigned bool
+}
+
+// CABundleInfo returns a description of the CA root certs currently loaded, reflecting the latest reload.
+func (r *KubernetesRA) CABundleInfo() (*BundleInfo, error) {
+	rootCertPem := r.GetCAKeyCertBundle().GetRootCertPem()
+	if len(rootCertPem) == 0 {
+		return nil, fmt.Errorf("no CA root certificate is loaded")
+	}
+	rootCerts, err := util.ParsePemEncodedCertificateChain(rootCertPem)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CA root certificate: %v", err)
+	}
+	info := &BundleInfo{}
+	for _, rootCert := range rootCerts {
+		info.Roots = append(info.Roots, CertInfo{
+			Subject:      rootCert.Subject.String(),
+			Issuer:       rootCert.Issuer.String(),
+			SerialNumber: rootCert.SerialNumber,
+			NotBefore:    rootCert.NotBefore,
+			NotAfter:     rootCert.NotAfter,
+			SubjectKeyID: rootCert.SubjectKeyId,
+			SelfSigned: bytes.Equal(rootCert.RawIssuer, rootCert.RawSubject) &&
+				rootCert.CheckSignatureFrom(rootCert) == nil,
+		})
+	}
+	return info, nil
+}
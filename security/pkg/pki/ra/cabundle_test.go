@@ -0,0 +1,775 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"istio.io/istio/pkg/test/util/retry"
+	"istio.io/istio/security/pkg/pki/ca"
+	raerror "istio.io/istio/security/pkg/pki/error"
+	pkiutil "istio.io/istio/security/pkg/pki/util"
+)
+
+func readTestData(t *testing.T, name string) []byte {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("../testdata", name))
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", name, err)
+	}
+	return b
+}
+
+func TestWatchCaCertFile(t *testing.T) {
+	root1 := readTestData(t, "spiffe-root-cert-1.pem")
+	root2 := readTestData(t, "spiffe-root-cert-2.pem")
+	caCertFile := filepath.Join(t.TempDir(), "root-cert.pem")
+	if err := os.WriteFile(caCertFile, root1, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewKubernetesRA(&IstioRAOptions{
+		ExternalCAType:  ExtCAK8s,
+		DefaultCertTTL:  30 * time.Minute,
+		MaxCertTTL:      time.Hour,
+		CaSigner:        "kubernates.io/kube-apiserver-client",
+		CaCertFile:      caCertFile,
+		WatchCaCertFile: true,
+		K8sClient:       fake.NewSimpleClientset(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	defer r.Close()
+
+	// A malformed file must not replace the current bundle.
+	if err := os.WriteFile(caCertFile, []byte("not a certificate"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got := r.GetCAKeyCertBundle().GetRootCertPem(); !bytes.Equal(got, root1) {
+		t.Fatalf("malformed CA cert file replaced the CA bundle")
+	}
+
+	if err := os.WriteFile(caCertFile, root2, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	retry.UntilSuccessOrFail(t, func() error {
+		if got := r.GetCAKeyCertBundle().GetRootCertPem(); !bytes.Equal(got, root2) {
+			return fmt.Errorf("CA bundle was not reloaded")
+		}
+		return nil
+	}, retry.Timeout(5*time.Second))
+}
+
+func TestLoadCABundle(t *testing.T) {
+	root1 := readTestData(t, "spiffe-root-cert-1.pem")
+	root2 := readTestData(t, "spiffe-root-cert-2.pem")
+	writeFiles := func(t *testing.T, files map[string][]byte) string {
+		dir := t.TempDir()
+		for name, content := range files {
+			if err := os.WriteFile(filepath.Join(dir, name), content, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return dir
+	}
+
+	testCases := map[string]struct {
+		files         map[string][]byte
+		useDir        bool
+		expectedRoots int
+		expectErr     bool
+	}{
+		"single root": {
+			files:         map[string][]byte{"root-cert.pem": root1},
+			expectedRoots: 1,
+		},
+		"concatenated roots": {
+			files:         map[string][]byte{"root-cert.pem": append(append([]byte{}, root1...), root2...)},
+			expectedRoots: 2,
+		},
+		"directory of roots": {
+			files: map[string][]byte{
+				"root-1.pem":  root1,
+				"root-2.crt":  root2,
+				".root-3.pem": root1,
+				"README":      []byte("not a certificate"),
+			},
+			useDir:        true,
+			expectedRoots: 2,
+		},
+		"directory without roots": {
+			files:     map[string][]byte{"README": []byte("not a certificate")},
+			useDir:    true,
+			expectErr: true,
+		},
+		"unparsable root among valid roots": {
+			files: map[string][]byte{"root-cert.pem": append(append([]byte{}, root1...),
+				pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("not a certificate")})...)},
+			expectErr: true,
+		},
+		"key among roots": {
+			files:     map[string][]byte{"root-cert.pem": append(append([]byte{}, root1...), readTestData(t, "key.pem")...)},
+			expectErr: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			caCertFile := writeFiles(t, tc.files)
+			if !tc.useDir {
+				caCertFile = filepath.Join(caCertFile, "root-cert.pem")
+			}
+			bundle, err := loadCABundle(caCertFile)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			roots, err := parseRootCerts(bundle.GetRootCertPem())
+			if err != nil {
+				t.Fatalf("failed to parse the root certs of the bundle: %v", err)
+			}
+			if len(roots) != tc.expectedRoots {
+				t.Fatalf("expected %d root certs, got %d", tc.expectedRoots, len(roots))
+			}
+		})
+	}
+}
+
+func TestRootCertOverlapPeriod(t *testing.T) {
+	root1 := readTestData(t, "spiffe-root-cert-1.pem")
+	root2 := readTestData(t, "spiffe-root-cert-2.pem")
+	caCertFile := filepath.Join(t.TempDir(), "root-cert.pem")
+	if err := os.WriteFile(caCertFile, root1, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewKubernetesRA(&IstioRAOptions{
+		ExternalCAType:        ExtCAK8s,
+		CaSigner:              "kubernates.io/kube-apiserver-client",
+		CaCertFile:            caCertFile,
+		RootCertOverlapPeriod: 500 * time.Millisecond,
+		K8sClient:             fake.NewSimpleClientset(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	defer r.Close()
+
+	if err := os.WriteFile(caCertFile, root2, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r.reloadCABundle()
+	// The new root comes first, so that consumers reading a single root get the new one.
+	got := r.GetCAKeyCertBundle().GetRootCertPem()
+	if !bytes.HasPrefix(got, root2) || !bytes.Contains(got, bytes.TrimSpace(root1)) {
+		t.Fatalf("expected the new and the retired root cert, got:\n%s", got)
+	}
+
+	retry.UntilSuccessOrFail(t, func() error {
+		if got := r.GetCAKeyCertBundle().GetRootCertPem(); !bytes.Equal(got, root2) {
+			return fmt.Errorf("retired root cert was not dropped")
+		}
+		return nil
+	}, retry.Timeout(5*time.Second))
+}
+
+func TestReloadCallback(t *testing.T) {
+	root1 := readTestData(t, "spiffe-root-cert-1.pem")
+	root2 := readTestData(t, "spiffe-root-cert-2.pem")
+	caCertFile := filepath.Join(t.TempDir(), "root-cert.pem")
+	if err := os.WriteFile(caCertFile, root1, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewKubernetesRA(&IstioRAOptions{
+		ExternalCAType: ExtCAK8s,
+		CaSigner:       "kubernates.io/kube-apiserver-client",
+		CaCertFile:     caCertFile,
+		K8sClient:      fake.NewSimpleClientset(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	defer r.Close()
+	blocked := make(chan struct{})
+	defer close(blocked)
+	reloaded := make(chan *pkiutil.KeyCertBundle, 2)
+	r.RegisterReloadCallback(func(*pkiutil.KeyCertBundle) {
+		// a slow callback must not hold off the other callbacks nor the reload
+		<-blocked
+	})
+	r.RegisterReloadCallback(func(keyCertBundle *pkiutil.KeyCertBundle) {
+		// the bundle lock is not held while callbacks run
+		_ = r.GetCAKeyCertBundle()
+		reloaded <- keyCertBundle
+	})
+
+	// an unchanged bundle is not swapped
+	r.reloadCABundle()
+	if err := os.WriteFile(caCertFile, root2, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r.reloadCABundle()
+	select {
+	case keyCertBundle := <-reloaded:
+		if !bytes.Equal(keyCertBundle.GetRootCertPem(), root2) {
+			t.Errorf("expected the callback to get the reloaded bundle, got:\n%s", keyCertBundle.GetRootCertPem())
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the callback to be called once the bundle is reloaded")
+	}
+	select {
+	case <-reloaded:
+		t.Errorf("expected the callback to only be called when the bundle changes")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestReloadCABundleOnDemand(t *testing.T) {
+	root1 := readTestData(t, "spiffe-root-cert-1.pem")
+	root2 := readTestData(t, "spiffe-root-cert-2.pem")
+	caCertFile := filepath.Join(t.TempDir(), "root-cert.pem")
+	if err := os.WriteFile(caCertFile, root1, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewKubernetesRA(&IstioRAOptions{
+		ExternalCAType: ExtCAK8s,
+		CaSigner:       "kubernates.io/kube-apiserver-client",
+		CaCertFile:     caCertFile,
+		K8sClient:      fake.NewSimpleClientset(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	defer r.Close()
+	reloaded := make(chan *pkiutil.KeyCertBundle, 10)
+	r.RegisterReloadCallback(func(keyCertBundle *pkiutil.KeyCertBundle) {
+		reloaded <- keyCertBundle
+	})
+
+	if err := os.WriteFile(caCertFile, []byte("invalid"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var raErr *raerror.Error
+	if err := r.ReloadCABundle(); !errors.As(err, &raErr) || raErr.ErrorType() != "CA_INIT_FAIL" {
+		t.Errorf("expected a CA_INIT_FAIL error for an invalid CA cert file, got: %v", err)
+	}
+	if got := r.GetCAKeyCertBundle().GetRootCertPem(); !bytes.Equal(got, root1) {
+		t.Errorf("expected the previous CA bundle to be kept, got:\n%s", got)
+	}
+
+	if err := os.WriteFile(caCertFile, root2, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// concurrent reloads and signing requests reading the bundle
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := r.ReloadCABundle(); err != nil {
+				t.Errorf("failed to reload the CA bundle: %v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			_ = r.GetCAKeyCertBundle().GetRootCertPem()
+		}()
+	}
+	wg.Wait()
+	if got := r.GetCAKeyCertBundle().GetRootCertPem(); !bytes.Equal(got, root2) {
+		t.Errorf("expected the CA bundle to be reloaded, got:\n%s", got)
+	}
+	select {
+	case keyCertBundle := <-reloaded:
+		if !bytes.Equal(keyCertBundle.GetRootCertPem(), root2) {
+			t.Errorf("expected the callback to get the reloaded bundle, got:\n%s", keyCertBundle.GetRootCertPem())
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the callback to be called once the bundle is reloaded")
+	}
+	select {
+	case <-reloaded:
+		t.Errorf("expected the callback to be called only once the bundle changed")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// a CA cert file still waited for is loaded without waiting for the next poll
+	pendingFile := filepath.Join(t.TempDir(), "root-cert.pem")
+	r, err = NewKubernetesRA(&IstioRAOptions{
+		ExternalCAType:         ExtCAK8s,
+		CaSigner:               "kubernates.io/kube-apiserver-client",
+		CaCertFile:             pendingFile,
+		WaitForCaCertFile:      true,
+		CaCertFilePollInterval: time.Hour,
+		K8sClient:              fake.NewSimpleClientset(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	defer r.Close()
+	if err := os.WriteFile(pendingFile, root1, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.ReloadCABundle(); err != nil {
+		t.Fatalf("failed to reload the CA bundle: %v", err)
+	}
+	if got := r.GetCAKeyCertBundle().GetRootCertPem(); !bytes.Equal(got, root1) {
+		t.Errorf("expected the waited for CA cert file to be loaded, got:\n%s", got)
+	}
+	r.mutex.RLock()
+	caCertPending := r.caCertPending
+	r.mutex.RUnlock()
+	if caCertPending {
+		t.Errorf("expected the CA cert file not to be waited for once loaded")
+	}
+
+	r, err = NewKubernetesRA(&IstioRAOptions{CaSigner: "kubernates.io/kube-apiserver-client", K8sClient: fake.NewSimpleClientset()})
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	defer r.Close()
+	if err := r.ReloadCABundle(); err == nil {
+		t.Errorf("expected an error reloading without a CA cert file nor Secret")
+	}
+}
+
+func TestCABundleInfo(t *testing.T) {
+	root1 := readTestData(t, "spiffe-root-cert-1.pem")
+	root2 := readTestData(t, "spiffe-root-cert-2.pem")
+	caCertFile := filepath.Join(t.TempDir(), "root-cert.pem")
+	if err := os.WriteFile(caCertFile, root1, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewKubernetesRA(&IstioRAOptions{
+		ExternalCAType: ExtCAK8s,
+		CaSigner:       "kubernates.io/kube-apiserver-client",
+		CaCertFile:     caCertFile,
+		K8sClient:      fake.NewSimpleClientset(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	defer r.Close()
+	checkInfo := func(rootPEM []byte) {
+		t.Helper()
+		rootCert, err := pkiutil.ParsePemEncodedCertificate(rootPEM)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := r.CABundleInfo()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(info.Roots) != 1 {
+			t.Fatalf("got %d roots, want 1", len(info.Roots))
+		}
+		got := info.Roots[0]
+		if got.Subject != rootCert.Subject.String() || got.Issuer != rootCert.Issuer.String() ||
+			got.SerialNumber.Cmp(rootCert.SerialNumber) != 0 || !got.NotBefore.Equal(rootCert.NotBefore) ||
+			!got.NotAfter.Equal(rootCert.NotAfter) || !bytes.Equal(got.SubjectKeyID, rootCert.SubjectKeyId) {
+			t.Errorf("got root info %+v, want the one of %s", got, rootCert.Subject)
+		}
+		if !got.SelfSigned {
+			t.Errorf("expected the root cert to be self-signed")
+		}
+	}
+	checkInfo(root1)
+
+	if err := os.WriteFile(caCertFile, root2, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r.reloadCABundle()
+	checkInfo(root2)
+
+	r, err = NewKubernetesRA(&IstioRAOptions{
+		ExternalCAType: ExtCAK8s,
+		CaSigner:       "kubernates.io/kube-apiserver-client",
+		K8sClient:      fake.NewSimpleClientset(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	if _, err := r.CABundleInfo(); err == nil {
+		t.Errorf("expected an error without a CA root certificate")
+	}
+}
+
+func TestWaitForCaCertFile(t *testing.T) {
+	caCertFile := filepath.Join(t.TempDir(), "root-cert.pem")
+	raOpts := &IstioRAOptions{
+		ExternalCAType:         ExtCAK8s,
+		DefaultCertTTL:         30 * time.Minute,
+		MaxCertTTL:             time.Hour,
+		CaSigner:               "kubernates.io/kube-apiserver-client",
+		CaCertFile:             caCertFile,
+		WatchCaCertFile:        true,
+		CaCertFilePollInterval: 10 * time.Millisecond,
+		K8sClient:              fake.NewSimpleClientset(),
+	}
+	if _, err := NewKubernetesRA(raOpts); err == nil {
+		t.Fatalf("expected the RA to fail to start without its CA cert file by default")
+	}
+
+	raOpts.WaitForCaCertFile = true
+	r, err := NewKubernetesRA(raOpts)
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	defer r.Close()
+	var raErr *raerror.Error
+	_, err = r.Sign(createFakeCsr(t), ca.CertOpts{SubjectIDs: []string{testCsrHostName}, TTL: time.Hour})
+	if !errors.As(err, &raErr) || raErr.ErrorType() != "CA_INIT_FAIL" {
+		t.Errorf("expected a CA_INIT_FAIL error while waiting for the CA cert file, got: %v", err)
+	}
+	err = r.Check(context.Background())
+	if !errors.As(err, &raErr) || raErr.ErrorType() != "CA_NOT_READY" {
+		t.Errorf("expected a CA_NOT_READY error while waiting for the CA cert file, got: %v", err)
+	}
+
+	root1 := genRootCert(t, time.Now().Add(-time.Minute), time.Hour)
+	if err := os.WriteFile(caCertFile, root1, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	retry.UntilSuccessOrFail(t, func() error {
+		return r.Check(context.Background())
+	}, retry.Timeout(5*time.Second))
+	if got := r.GetCAKeyCertBundle().GetRootCertPem(); !bytes.Equal(got, root1) {
+		t.Errorf("got root certs %s, want %s", got, root1)
+	}
+
+	// CaCertFile is watched once it is loaded.
+	root2 := genRootCert(t, time.Now().Add(-time.Minute), time.Hour)
+	retry.UntilSuccessOrFail(t, func() error {
+		if err := os.WriteFile(caCertFile, root2, 0o644); err != nil {
+			return err
+		}
+		if got := r.GetCAKeyCertBundle().GetRootCertPem(); !bytes.Equal(got, root2) {
+			return fmt.Errorf("CA bundle was not reloaded")
+		}
+		return nil
+	}, retry.Timeout(5*time.Second))
+}
+
+func TestNewKubernetesRAFromSecret(t *testing.T) {
+	root1 := readTestData(t, "spiffe-root-cert-1.pem")
+	root2 := readTestData(t, "spiffe-root-cert-2.pem")
+	client := fake.NewSimpleClientset()
+	raOpts := &IstioRAOptions{
+		ExternalCAType: ExtCAK8s,
+		CaSigner:       "kubernates.io/kube-apiserver-client",
+		K8sClient:      client,
+	}
+	var raErr *raerror.Error
+	if _, err := NewKubernetesRAFromSecret(raOpts, "istio-system", "istio-ca", "ca.crt"); !errors.As(err, &raErr) ||
+		raErr.ErrorType() != "CA_INIT_FAIL" || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("expected a CA_INIT_FAIL error for a missing Secret, got: %v", err)
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "istio-ca"},
+		Data:       map[string][]byte{"root-cert.pem": root1},
+	}
+	if _, err := client.CoreV1().Secrets("istio-system").Create(context.Background(), secret, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewKubernetesRAFromSecret(raOpts, "istio-system", "istio-ca", "ca.crt"); !errors.As(err, &raErr) ||
+		raErr.ErrorType() != "CA_INIT_FAIL" || !strings.Contains(err.Error(), "has no key ca.crt") {
+		t.Errorf("expected a CA_INIT_FAIL error for a missing key, got: %v", err)
+	}
+
+	r, err := NewKubernetesRAFromSecret(raOpts, "istio-system", "istio-ca", "root-cert.pem")
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	defer r.Close()
+	if got := r.GetCAKeyCertBundle().GetRootCertPem(); !bytes.Equal(got, root1) {
+		t.Errorf("got root certs %s, want %s", got, root1)
+	}
+
+	secret.Data = map[string][]byte{"root-cert.pem": root2}
+	if _, err := client.CoreV1().Secrets("istio-system").Update(context.Background(), secret, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	retry.UntilSuccessOrFail(t, func() error {
+		if got := r.GetCAKeyCertBundle().GetRootCertPem(); !bytes.Equal(got, root2) {
+			return fmt.Errorf("CA bundle was not reloaded")
+		}
+		return nil
+	}, retry.Timeout(5*time.Second))
+}
+
+// caCertSecretClient returns a fake client with the Secret istio-system/istio-ca holding rootPEM under
+// root-cert.pem.
+func caCertSecretClient(t *testing.T, rootPEM []byte) *fake.Clientset {
+	t.Helper()
+	return fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "istio-system", Name: "istio-ca"},
+		Data:       map[string][]byte{"root-cert.pem": rootPEM},
+	})
+}
+
+func TestNewKubernetesRAFromSecretChecksBundle(t *testing.T) {
+	rootPEM := genRootCert(t, time.Now().Add(-time.Hour), 4*time.Hour)
+	raOpts := func(domain string) *IstioRAOptions {
+		return &IstioRAOptions{
+			ExternalCAType:          ExtCAK8s,
+			CaSigner:                "kubernates.io/kube-apiserver-client",
+			CertSignerDomain:        domain,
+			StrictSignerDomainCheck: true,
+			K8sClient:               caCertSecretClient(t, rootPEM),
+		}
+	}
+	// The root from the Secret is checked, rather than an empty bundle.
+	var raErr *raerror.Error
+	if _, err := NewKubernetesRAFromSecret(raOpts("example.com"), "istio-system", "istio-ca", "root-cert.pem"); !errors.As(err, &raErr) ||
+		raErr.ErrorType() != "CA_INIT_FAIL" {
+		t.Errorf("expected a CA_INIT_FAIL error for a signer domain not matching the root in the Secret, got: %v", err)
+	}
+	r, err := NewKubernetesRAFromSecret(raOpts("istio.io"), "istio-system", "istio-ca", "root-cert.pem")
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	defer r.Close()
+	// The expiry of the root from the Secret is recorded at startup.
+	tags := map[string]string{signerLabel: "kubernates.io/kube-apiserver-client"}
+	if got, want := getMetricValue(t, "ra_root_cert_expiry_seconds", tags), (3 * time.Hour).Seconds(); math.Abs(got-want) > 60 {
+		t.Errorf("ra_root_cert_expiry_seconds: got %v, want about %v", got, want)
+	}
+}
+
+// testCA is a CA issuing certificates from an intermediate of its root.
+type testCA struct {
+	rootPEM          []byte
+	rootCert         *x509.Certificate
+	rootKey          crypto.PrivateKey
+	ecdsaKeys        bool
+	intermediatePEM  []byte
+	intermediateCert *x509.Certificate
+	intermediateKey  crypto.PrivateKey
+}
+
+func newTestCA(t *testing.T, ecdsaKeys bool) *testCA {
+	t.Helper()
+	opts := pkiutil.CertOptions{
+		Host:         "root",
+		TTL:          time.Hour,
+		Org:          "istio.io",
+		IsCA:         true,
+		IsSelfSigned: true,
+		RSAKeySize:   2048,
+	}
+	if ecdsaKeys {
+		opts.ECSigAlg = pkiutil.EcdsaSigAlg
+	}
+	rootPEM, rootKeyPEM, err := pkiutil.GenCertKeyFromOptions(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootCert, err := pkiutil.ParsePemEncodedCertificate(rootPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootKey, err := pkiutil.ParsePemEncodedKey(rootKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := &testCA{rootPEM: rootPEM, rootCert: rootCert, rootKey: rootKey, ecdsaKeys: ecdsaKeys}
+	return root.withIntermediate(t, "intermediate")
+}
+
+// withIntermediate returns a CA with the same root as c, and a new intermediate of host.
+func (c *testCA) withIntermediate(t *testing.T, host string) *testCA {
+	t.Helper()
+	opts := pkiutil.CertOptions{
+		Host:       host,
+		TTL:        time.Hour,
+		Org:        "istio.io",
+		IsCA:       true,
+		RSAKeySize: 2048,
+		SignerCert: c.rootCert,
+		SignerPriv: c.rootKey,
+	}
+	if c.ecdsaKeys {
+		opts.ECSigAlg = pkiutil.EcdsaSigAlg
+	}
+	intermediatePEM, intermediateKeyPEM, err := pkiutil.GenCertKeyFromOptions(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	intermediateCert, err := pkiutil.ParsePemEncodedCertificate(intermediatePEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	intermediateKey, err := pkiutil.ParsePemEncodedKey(intermediateKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{
+		rootPEM:          c.rootPEM,
+		rootCert:         c.rootCert,
+		rootKey:          c.rootKey,
+		ecdsaKeys:        c.ecdsaKeys,
+		intermediatePEM:  intermediatePEM,
+		intermediateCert: intermediateCert,
+		intermediateKey:  intermediateKey,
+	}
+}
+
+// issue returns a leaf certificate issued by the intermediate of the CA.
+func (c *testCA) issue(t *testing.T) *x509.Certificate {
+	t.Helper()
+	csr, err := pkiutil.ParsePemEncodedCSR(createFakeCsr(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	certDER, err := pkiutil.GenCertFromCSR(csr, c.intermediateCert, csr.PublicKey, c.intermediateKey,
+		[]string{testCsrHostName}, time.Hour, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafCert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leafCert
+}
+
+// writeChain writes the chain of the CA, from its intermediate to its root, to a file in dir.
+func (c *testCA) writeChain(t *testing.T, dir string) string {
+	t.Helper()
+	certChainFile := filepath.Join(dir, fmt.Sprintf("cert-chain-%v.pem", c.intermediateCert.PublicKeyAlgorithm))
+	if err := os.WriteFile(certChainFile, append(append([]byte{}, c.intermediatePEM...), c.rootPEM...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return certChainFile
+}
+
+func TestCertChainFiles(t *testing.T) {
+	rsaCA, ecdsaCA := newTestCA(t, false), newTestCA(t, true)
+	dir := t.TempDir()
+	certChainFiles := []string{rsaCA.writeChain(t, dir), ecdsaCA.writeChain(t, dir)}
+
+	if _, err := loadCertChains([]string{certChainFiles[0], rsaCA.writeChain(t, t.TempDir())}); err == nil {
+		t.Errorf("expected an error for two cert chains of the same issuer")
+	}
+
+	// The fake signer issues certificates with the CA matching the key algorithm of the CSR.
+	client := initFakeKubeClient(func(csrPEM []byte) ([]byte, error) {
+		csr, err := pkiutil.ParsePemEncodedCSR(csrPEM)
+		if err != nil {
+			return nil, err
+		}
+		issuer := rsaCA
+		if csr.PublicKeyAlgorithm == x509.ECDSA {
+			issuer = ecdsaCA
+		}
+		certDER, err := pkiutil.GenCertFromCSR(csr, issuer.intermediateCert, csr.PublicKey, issuer.intermediateKey,
+			[]string{testCsrHostName}, time.Hour, false)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), nil
+	})
+	r, err := NewKubernetesRA(&IstioRAOptions{
+		ExternalCAType: ExtCAK8s,
+		DefaultCertTTL: 30 * time.Minute,
+		MaxCertTTL:     time.Hour,
+		CaSigner:       "kubernates.io/kube-apiserver-client",
+		CertChainFiles: certChainFiles,
+		K8sClient:      client,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	csrPEM, _, err := pkiutil.GenCSR(pkiutil.CertOptions{Host: testCsrHostName, ECSigAlg: pkiutil.EcdsaSigAlg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	certChainPEM, err := r.SignWithCertChain(csrPEM, ca.CertOpts{SubjectIDs: []string{testCsrHostName}, TTL: time.Hour})
+	if err != nil {
+		t.Fatalf("K8s CA Signing CSR failed: %v", err)
+	}
+	certChain, err := pkiutil.ParsePemEncodedCertificateChain(certChainPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certChain) != 3 {
+		t.Fatalf("got a cert chain of %d certificates, want the leaf, the intermediate and the root", len(certChain))
+	}
+	if !bytes.Equal(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certChain[2].Raw}), ecdsaCA.rootPEM) {
+		t.Errorf("expected the cert chain to terminate at the ECDSA root")
+	}
+	roots, intermediates := x509.NewCertPool(), x509.NewCertPool()
+	roots.AddCert(certChain[2])
+	intermediates.AddCert(certChain[1])
+	if _, err := certChain[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		t.Errorf("the appended cert chain does not validate the leaf: %v", err)
+	}
+}
+
+func TestCertChainFilesIssuer(t *testing.T) {
+	ca1 := newTestCA(t, false)
+	ca2 := ca1.withIntermediate(t, "intermediate")
+	unknownCA := ca1.withIntermediate(t, "intermediate")
+	r, err := NewKubernetesRA(&IstioRAOptions{
+		ExternalCAType: ExtCAK8s,
+		CaSigner:       "kubernates.io/kube-apiserver-client",
+		CertChainFiles: []string{ca1.writeChain(t, t.TempDir()), ca2.writeChain(t, t.TempDir())},
+		K8sClient:      fake.NewSimpleClientset(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	// The intermediates share their subject and key algorithm, and are told apart by their key IDs.
+	for name, issuer := range map[string]*testCA{"first intermediate": ca1, "second intermediate": ca2} {
+		t.Run(name, func(t *testing.T) {
+			certChainPEM, err := r.chainForCert(r.raOpts.CaSigner, issuer.issue(t))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !bytes.HasPrefix(certChainPEM, issuer.intermediatePEM) {
+				t.Errorf("expected the cert chain of the issuing intermediate, got:\n%s", certChainPEM)
+			}
+		})
+	}
+	if _, err := r.chainForCert(r.raOpts.CaSigner, unknownCA.issue(t)); err == nil {
+		t.Errorf("expected an error for a certificate issued by an intermediate without a cert chain")
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"sort"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+
+	"istio.io/istio/security/pkg/pki/ca"
+)
+
+// certCacheEntry is a certificate in the certCache.
+type certCacheEntry struct {
+	result *SignResult
+	// expiry is when the entry is evicted from the cache.
+	expiry time.Time
+}
+
+// certCache is a bounded cache of issued certificates, safe for concurrent use.
+type certCache struct {
+	cache       *lru.Cache
+	ttl         time.Duration
+	minValidity time.Duration
+}
+
+func newCertCache(raOpts *IstioRAOptions) (*certCache, error) {
+	cache, err := lru.New(raOpts.CertCacheSize)
+	if err != nil {
+		return nil, err
+	}
+	c := &certCache{
+		cache:       cache,
+		ttl:         raOpts.CertCacheTTL,
+		minValidity: raOpts.CertCacheMinValidity,
+	}
+	if c.ttl <= 0 {
+		c.ttl = DefaultCertCacheTTL
+	}
+	if c.minValidity <= 0 {
+		c.minValidity = DefaultCertCacheMinValidity
+	}
+	return c, nil
+}
+
+// certCacheKey returns the SHA-256 of csrPEM and certOpts.
+func certCacheKey(csrPEM []byte, certOpts ca.CertOpts) string {
+	rh := getRequestHasher()
+	defer putRequestHasher(rh)
+	// Every field is length-prefixed and every list count-prefixed, so that different requests cannot
+	// hash alike.
+	write := func(s string) {
+		rh.writeUint64(uint64(len(s)))
+		rh.writeString(s)
+	}
+	writeList := func(values []string) {
+		rh.writeUint64(uint64(len(values)))
+		for _, value := range values {
+			write(value)
+		}
+	}
+	rh.writeUint64(uint64(len(csrPEM)))
+	rh.writeBytes(csrPEM)
+	writeList(certOpts.SubjectIDs)
+	rh.writeUint64(uint64(certOpts.TTL))
+	if certOpts.NotAfter.IsZero() {
+		write("")
+	} else {
+		write(certOpts.NotAfter.UTC().Format(time.RFC3339Nano))
+	}
+	if certOpts.ForCA {
+		write("ca")
+	}
+	write(certOpts.CertSigner)
+	rh.writeUint64(uint64(len(certOpts.KeyUsages)))
+	for _, usage := range certOpts.KeyUsages {
+		write(string(usage))
+	}
+	writeList(certOpts.DNSNames)
+	writeIPs := func(ips []net.IP) {
+		values := make([]string, 0, len(ips))
+		for _, ip := range ips {
+			values = append(values, ip.String())
+		}
+		writeList(values)
+	}
+	writeIPs(certOpts.IPAddresses)
+	writeIPs(certOpts.WorkloadIPs)
+	write(certOpts.RequesterNamespace)
+	write(certOpts.Profile)
+	write(certOpts.ChainSelector)
+	if len(certOpts.CSRAnnotations) == 0 {
+		writeList(nil)
+	} else {
+		annotations := make([]string, 0, len(certOpts.CSRAnnotations))
+		for key, value := range certOpts.CSRAnnotations {
+			annotations = append(annotations, key+"="+value)
+		}
+		sort.Strings(annotations)
+		writeList(annotations)
+	}
+	if certOpts.MustStaple {
+		write("must-staple")
+	} else {
+		write("")
+	}
+	writeList(certOpts.IssuingCertificateURLs)
+	return hex.EncodeToString(rh.sumPrefix(sha256.Size))
+}
+
+// get returns a copy of the certificate cached for key, or nil if there is none that is valid for at
+// least minValidity at now and not due for renewal yet.
+func (c *certCache) get(key string, now time.Time) *SignResult {
+	if v, ok := c.cache.Get(key); ok {
+		entry := v.(*certCacheEntry)
+		if now.Before(entry.expiry) && entry.result.NotAfter.Sub(now) >= c.minValidity &&
+			(entry.result.RenewAt.IsZero() || now.Before(entry.result.RenewAt)) {
+			certCacheLookups.With(resultTag.Value(resultHit)).Increment()
+			result := cloneSignResult(entry.result)
+			// The cached certificate has less time left than when it was issued.
+			if remaining := result.NotAfter.Sub(now); remaining < result.EffectiveTTL {
+				result.EffectiveTTL = remaining
+			}
+			return result
+		}
+		c.cache.Remove(key)
+	}
+	certCacheLookups.With(resultTag.Value(resultMiss)).Increment()
+	return nil
+}
+
+// add caches result for key from now on, unless it is not valid long enough to be returned.
+func (c *certCache) add(key string, result *SignResult, now time.Time) {
+	if result.NotAfter.Sub(now) < c.minValidity {
+		return
+	}
+	c.cache.Add(key, &certCacheEntry{result: cloneSignResult(result), expiry: now.Add(c.ttl)})
+}
+
+// cloneSignResult returns a copy of result that does not share the PEM buffers, so that callers
+// cannot modify cached results.
+func cloneSignResult(result *SignResult) *SignResult {
+	clone := *result
+	clone.CertPEM = append([]byte{}, result.CertPEM...)
+	clone.CertChainPEM = append([]byte{}, result.CertChainPEM...)
+	return &clone
+}
@@ -0,0 +1,143 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	cert "k8s.io/api/certificates/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kt "k8s.io/client-go/testing"
+
+	"istio.io/istio/security/pkg/pki/ca"
+)
+
+func TestCertCacheKey(t *testing.T) {
+	csrPEM := []byte("csr")
+	base := ca.CertOpts{SubjectIDs: []string{"a", "b"}, TTL: time.Hour}
+	key := certCacheKey(csrPEM, base)
+	if got := certCacheKey(csrPEM, base); got != key {
+		t.Fatalf("expected the same key for the same request, got %s and %s", key, got)
+	}
+	for name, certOpts := range map[string]ca.CertOpts{
+		"subject IDs": {SubjectIDs: []string{"ab"}, TTL: time.Hour},
+		"TTL":         {SubjectIDs: []string{"a", "b"}, TTL: time.Minute},
+		"ForCA":       {SubjectIDs: []string{"a", "b"}, TTL: time.Hour, ForCA: true},
+		"signer":      {SubjectIDs: []string{"a", "b"}, TTL: time.Hour, CertSigner: "signer"},
+		"key usages":  {SubjectIDs: []string{"a", "b"}, TTL: time.Hour, KeyUsages: []cert.KeyUsage{cert.UsageClientAuth}},
+		"DNS names":   {SubjectIDs: []string{"a"}, TTL: time.Hour, DNSNames: []string{"b"}},
+		"profile":     {SubjectIDs: []string{"a", "b"}, TTL: time.Hour, Profile: "egress"},
+		"annotations": {SubjectIDs: []string{"a", "b"}, TTL: time.Hour, CSRAnnotations: map[string]string{"a": "b"}},
+	} {
+		if certCacheKey(csrPEM, certOpts) == key {
+			t.Errorf("expected a different key for different %s", name)
+		}
+	}
+	if certCacheKey([]byte("other"), base) == key {
+		t.Errorf("expected a different key for a different CSR")
+	}
+}
+
+func TestCertCache(t *testing.T) {
+	c, err := newCertCache(&IstioRAOptions{CertCacheSize: 2, CertCacheTTL: time.Minute, CertCacheMinValidity: 10 * time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	result := &SignResult{CertPEM: []byte("cert"), CertChainPEM: []byte("chain"), NotAfter: now.Add(time.Hour),
+		EffectiveTTL: time.Hour}
+	hits := getMetricValue(t, "ra_cert_cache_lookup_count", map[string]string{resultLabel: resultHit})
+	misses := getMetricValue(t, "ra_cert_cache_lookup_count", map[string]string{resultLabel: resultMiss})
+
+	if got := c.get("a", now); got != nil {
+		t.Fatalf("expected a miss in an empty cache")
+	}
+	c.add("a", result, now)
+	got := c.get("a", now.Add(time.Second))
+	if got == nil || !bytes.Equal(got.CertChainPEM, result.CertChainPEM) {
+		t.Fatalf("expected the cached result, got %v", got)
+	}
+	if got.EffectiveTTL != time.Hour-time.Second {
+		t.Errorf("got effective TTL %v, want the remaining lifetime %v", got.EffectiveTTL, time.Hour-time.Second)
+	}
+	got.CertChainPEM[0] = 'x'
+	if got := c.get("a", now.Add(time.Second)); got == nil || !bytes.Equal(got.CertChainPEM, []byte("chain")) {
+		t.Fatalf("cached result was modified by a caller")
+	}
+	if got := c.get("a", now.Add(2*time.Minute)); got != nil {
+		t.Fatalf("expected the entry to expire after the cache TTL")
+	}
+
+	c.add("short-lived", &SignResult{NotAfter: now.Add(5 * time.Minute)}, now)
+	if got := c.get("short-lived", now); got != nil {
+		t.Fatalf("expected certificates below the minimum validity not to be cached")
+	}
+	c.add("expiring", &SignResult{NotAfter: now.Add(10*time.Minute + 30*time.Second)}, now)
+	if got := c.get("expiring", now.Add(45*time.Second)); got != nil {
+		t.Fatalf("expected certificates below the minimum validity not to be returned")
+	}
+	c.add("due", &SignResult{NotAfter: now.Add(time.Hour), RenewAt: now.Add(20 * time.Second)}, now)
+	if got := c.get("due", now.Add(20*time.Second)); got != nil {
+		t.Fatalf("expected certificates due for renewal not to be returned")
+	}
+
+	c.add("a", result, now)
+	c.add("b", result, now)
+	c.add("c", result, now)
+	if got := c.get("a", now); got != nil {
+		t.Fatalf("expected the least recently used entry to be evicted")
+	}
+
+	if got := getMetricValue(t, "ra_cert_cache_lookup_count", map[string]string{resultLabel: resultHit}); got != hits+2 {
+		t.Errorf("ra_cert_cache_lookup_count hits: got %v, want %v", got, hits+2)
+	}
+	if got := getMetricValue(t, "ra_cert_cache_lookup_count", map[string]string{resultLabel: resultMiss}); got != misses+6 {
+		t.Errorf("ra_cert_cache_lookup_count misses: got %v, want %v", got, misses+6)
+	}
+}
+
+func TestK8sSignCached(t *testing.T) {
+	client := initFakeKubeClient(issueFakeCert)
+	creates := 0
+	client.PrependReactor("create", "certificatesigningrequests", func(kt.Action) (bool, runtime.Object, error) {
+		creates++
+		return false, nil, nil
+	})
+	r, err := createFakeK8sRA(client)
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	if r.certCache, err = newCertCache(&IstioRAOptions{CertCacheSize: 10}); err != nil {
+		t.Fatal(err)
+	}
+	csrPEM := createFakeCsr(t)
+	certOpts := ca.CertOpts{SubjectIDs: []string{testCsrHostName}, TTL: time.Hour}
+	first, err := r.SignWithCertChain(csrPEM, certOpts)
+	if err != nil {
+		t.Fatalf("K8s CA Signing CSR failed: %v", err)
+	}
+	second, err := r.SignWithCertChain(csrPEM, certOpts)
+	if err != nil {
+		t.Fatalf("K8s CA Signing CSR failed: %v", err)
+	}
+	if !bytes.Equal(first, second) {
+		t.Errorf("expected the cached certificate")
+	}
+	if creates != 1 {
+		t.Errorf("expected a single CSR to be created, got %d", creates)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"crypto/x509"
+	"fmt"
+
+	raerror "istio.io/istio/security/pkg/pki/error"
+	"istio.io/istio/security/pkg/pki/util"
+)
+
+// CertChainMismatchPolicy is what to do when the cert chain to append to an issued certificate does not chain
+// to it, as when the CA bundle is reloaded while the certificate is being signed.
+type CertChainMismatchPolicy string
+
+const (
+	// CertChainMismatchReject : Signing fails when the cert chain, fetched again, still does not chain to the
+	// issued certificate
+	CertChainMismatchReject CertChainMismatchPolicy = "Reject"
+	// CertChainMismatchAllow : The cert chain is appended to the issued certificate regardless, with a warning
+	CertChainMismatchAllow CertChainMismatchPolicy = "Allow"
+)
+
+// PEMNormalization is how the PEM encoded certificates and cert chains returned by the signers are re-encoded.
+type PEMNormalization string
+
+const (
+	// PEMNormalizeCompact : Each PEM block is re-encoded canonically, with LF line endings, and directly follows
+	// the previous one. Text and whitespace around the blocks are dropped
+	PEMNormalizeCompact PEMNormalization = "Compact"
+	// PEMNormalizeBlankLine : As PEMNormalizeCompact, with a blank line between the PEM blocks
+	PEMNormalizeBlankLine PEMNormalization = "BlankLine"
+	// PEMNormalizeNone : The certificates and cert chains are returned as encoded by the signers
+	PEMNormalizeNone PEMNormalization = "None"
+)
+
+// validatePEMNormalization checks that the PEMNormalization of raOpts is known.
+func validatePEMNormalization(raOpts *IstioRAOptions) error {
+	switch raOpts.PEMNormalization {
+	case "", PEMNormalizeCompact, PEMNormalizeBlankLine, PEMNormalizeNone:
+		return nil
+	}
+	return raerror.NewError(raerror.CAInitFail, fmt.Errorf("unknown PEM normalization %q", raOpts.PEMNormalization))
+}
+
+// normalizePEM returns the PEM blocks of pemBytes re-encoded as per normalization, or pemBytes itself if it has
+// none.
+func normalizePEM(normalization PEMNormalization, pemBytes []byte) []byte {
+	if normalization == PEMNormalizeNone {
+		return pemBytes
+	}
+	pb := getPEMBuffer()
+	defer putPEMBuffer(pb)
+	for block, rest, ok := pb.decode(pemBytes); ok; block, rest, ok = pb.decode(rest) {
+		if pb.out.Len() > 0 && normalization == PEMNormalizeBlankLine {
+			pb.out.WriteByte('\n')
+		}
+		pb.encode(block)
+	}
+	if pb.out.Len() == 0 {
+		return pemBytes
+	}
+	return append([]byte(nil), pb.out.Bytes()...)
+}
+
+// validateCertChainMismatchPolicy checks that the CertChainMismatchPolicy of raOpts is known.
+func validateCertChainMismatchPolicy(raOpts *IstioRAOptions) error {
+	switch raOpts.CertChainMismatchPolicy {
+	case "", CertChainMismatchReject, CertChainMismatchAllow:
+		return nil
+	}
+	return raerror.NewError(raerror.CAInitFail, fmt.Errorf("unknown cert chain mismatch policy %q", raOpts.CertChainMismatchPolicy))
+}
+
+// chainsTo returns whether the first cert of chainPEM issued the last cert of certPEM: the leaf cert, or the
+// last of the intermediates returned with it by the signer.
+func chainsTo(chainPEM, certPEM []byte) bool {
+	certs, err := util.ParsePemEncodedCertificateChain(certPEM)
+	if err != nil {
+		return false
+	}
+	issuer, err := util.ParsePemEncodedCertificate(chainPEM)
+	if err != nil {
+		return false
+	}
+	return issuedBy(certs[len(certs)-1], issuer)
+}
+
+// currentCertChain returns the cert chain of chainPEM to append to certPEM, whose leaf cert is leafCert. A chain
+// that does not chain to certPEM is fetched once more, in case the CA bundle was reloaded in the meantime, and
+// is then rejected unless the CertChainMismatchPolicy of raOpts allows it.
+func currentCertChain(raOpts *IstioRAOptions, requestID, certSigner string, certPEM []byte, leafCert *x509.Certificate,
+	chainPEM func(leafCert *x509.Certificate) ([]byte, error)) ([]byte, error) {
+	chain, err := chainPEM(leafCert)
+	if err != nil || len(chain) == 0 || chainsTo(chain, certPEM) {
+		return chain, err
+	}
+	requestLog(requestID).Warnf("the cert chain of signer %s does not chain to certificate %s issued by %q, fetching it again",
+		certSigner, leafCert.SerialNumber, leafCert.Issuer)
+	if chain, err = chainPEM(leafCert); err != nil || len(chain) == 0 || chainsTo(chain, certPEM) {
+		return chain, err
+	}
+	if raOpts.CertChainMismatchPolicy == CertChainMismatchAllow {
+		requestLog(requestID).Warnf("appending the cert chain of signer %s to certificate %s issued by %q, which it does not chain to",
+			certSigner, leafCert.SerialNumber, leafCert.Issuer)
+		return chain, nil
+	}
+	return nil, fmt.Errorf("the cert chain does not chain to certificate %s issued by %q", leafCert.SerialNumber, leafCert.Issuer)
+}
@@ -0,0 +1,253 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"istio.io/istio/security/pkg/pki/ca"
+	raerror "istio.io/istio/security/pkg/pki/error"
+	pkiutil "istio.io/istio/security/pkg/pki/util"
+	"istio.io/pkg/log"
+)
+
+func TestCertChainBundleSwap(t *testing.T) {
+	oldCA := newTestCA(t, false)
+	newCA := oldCA.withIntermediate(t, "rotated-intermediate")
+	oldChain := append(append([]byte{}, oldCA.intermediatePEM...), oldCA.rootPEM...)
+	newChain := append(append([]byte{}, newCA.intermediatePEM...), newCA.rootPEM...)
+
+	csrPEM := createFakeCsr(t)
+	csr, err := pkiutil.ParsePemEncodedCSR(csrPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the certificate is issued by the new CA, while the CA bundle of the RA is still the old one
+	certDER, err := pkiutil.GenCertFromCSR(csr, newCA.intermediateCert, csr.PublicKey, newCA.intermediateKey,
+		[]string{testCsrHostName}, time.Hour, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+
+	testCases := map[string]struct {
+		policy CertChainMismatchPolicy
+		// reloaded is the chain of the CA bundle once reloaded, after the first fetch.
+		reloaded      []byte
+		expectedChain []byte
+		expectedErr   bool
+	}{
+		"reloaded after signing": {
+			reloaded:      newChain,
+			expectedChain: newChain,
+		},
+		"not reloaded": {
+			reloaded:    oldChain,
+			expectedErr: true,
+		},
+		"not reloaded, mismatch allowed": {
+			policy:        CertChainMismatchAllow,
+			reloaded:      oldChain,
+			expectedChain: oldChain,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			raOpts := &IstioRAOptions{CertChainMismatchPolicy: tc.policy}
+			req, err := preSign(raOpts, nil, csrPEM, ca.CertOpts{SubjectIDs: []string{testCsrHostName}, TTL: time.Hour})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			bundle := pkiutil.NewKeyCertBundleFromPem(nil, nil, oldChain, oldCA.rootPEM)
+			fetches := 0
+			result, err := newSignResult(raOpts, req, certPEM, func(*x509.Certificate) ([]byte, error) {
+				fetches++
+				chain := bundle.GetCertChainPem()
+				bundle = pkiutil.NewKeyCertBundleFromPem(nil, nil, tc.reloaded, newCA.rootPEM)
+				return chain, nil
+			}, "test", "test", new(sync.Map))
+			if fetches != 2 {
+				t.Errorf("got the cert chain fetched %d times, want it fetched again once", fetches)
+			}
+			if tc.expectedErr {
+				var raErr *raerror.Error
+				if result != nil || !errors.As(err, &raErr) || raErr.ErrorType() != "CERT_GEN_ERROR" {
+					t.Fatalf("expected a CERT_GEN_ERROR error for a cert chain not chaining to the certificate, got: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if want := append(append([]byte{}, certPEM...), tc.expectedChain...); !bytes.Equal(result.CertChainPEM, want) {
+				t.Errorf("got an unexpected cert chain appended to the certificate")
+			}
+		})
+	}
+
+	// a cert chain chaining to the certificate is only fetched once
+	req, err := preSign(&IstioRAOptions{}, nil, csrPEM, ca.CertOpts{SubjectIDs: []string{testCsrHostName}, TTL: time.Hour})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fetches := 0
+	if _, err := newSignResult(&IstioRAOptions{}, req, certPEM, func(*x509.Certificate) ([]byte, error) {
+		fetches++
+		return newChain, nil
+	}, "test", "test", new(sync.Map)); err != nil || fetches != 1 {
+		t.Errorf("got the cert chain fetched %d times (error: %v), want it fetched once", fetches, err)
+	}
+
+	if err := validateRAOptions(&IstioRAOptions{CertChainMismatchPolicy: "Ignore"}); err == nil {
+		t.Errorf("expected an error for an unknown cert chain mismatch policy")
+	}
+}
+
+func TestNormalizePEM(t *testing.T) {
+	rootPEM := genRootCert(t, time.Now(), time.Hour)
+	intermediatePEM := genRootCert(t, time.Now(), time.Hour)
+	crlf := func(pemBytes []byte) []byte {
+		return bytes.ReplaceAll(pemBytes, []byte("\n"), []byte("\r\n"))
+	}
+	quirky := append(append(append([]byte("  \r\n"), crlf(intermediatePEM)...), "\r\n \t\r\nsubject=O = istio.io\r\n"...),
+		crlf(rootPEM)...)
+	quirky = append(quirky, "\r\n\r\n"...)
+	testCases := map[string]struct {
+		normalization PEMNormalization
+		input         []byte
+		expected      []byte
+	}{
+		"compact": {
+			input:    quirky,
+			expected: append(append([]byte{}, intermediatePEM...), rootPEM...),
+		},
+		"already compact": {
+			input:    append(append([]byte{}, intermediatePEM...), rootPEM...),
+			expected: append(append([]byte{}, intermediatePEM...), rootPEM...),
+		},
+		"blank line": {
+			normalization: PEMNormalizeBlankLine,
+			input:         quirky,
+			expected:      append(append(append([]byte{}, intermediatePEM...), '\n'), rootPEM...),
+		},
+		"none": {
+			normalization: PEMNormalizeNone,
+			input:         quirky,
+			expected:      quirky,
+		},
+		"no PEM blocks": {
+			input:    []byte("not a certificate"),
+			expected: []byte("not a certificate"),
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if got := normalizePEM(tc.normalization, tc.input); !bytes.Equal(got, tc.expected) {
+				t.Errorf("got\n%q\nwant\n%q", got, tc.expected)
+			}
+		})
+	}
+
+	if err := validateRAOptions(&IstioRAOptions{PEMNormalization: "Pretty"}); err == nil {
+		t.Errorf("expected an error for an unknown PEM normalization")
+	}
+}
+
+func TestSignResultNormalizedPEM(t *testing.T) {
+	issuer := newTestCA(t, false)
+	csrPEM := createFakeCsr(t)
+	csr, err := pkiutil.ParsePemEncodedCSR(csrPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certDER, err := pkiutil.GenCertFromCSR(csr, issuer.intermediateCert, csr.PublicKey, issuer.intermediateKey,
+		[]string{testCsrHostName}, time.Hour, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	chain := append(append([]byte{}, issuer.intermediatePEM...), issuer.rootPEM...)
+	// the signer returns the certificate and the chain with CRLF line endings and blank lines around them
+	crlf := func(pemBytes []byte) []byte {
+		return append(append([]byte("\r\n"), bytes.ReplaceAll(pemBytes, []byte("\n"), []byte("\r\n"))...), "\r\n"...)
+	}
+	raOpts := &IstioRAOptions{}
+	req, err := preSign(raOpts, nil, csrPEM, ca.CertOpts{SubjectIDs: []string{testCsrHostName}, TTL: time.Hour})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	result, err := newSignResult(raOpts, req, crlf(certPEM), func(*x509.Certificate) ([]byte, error) {
+		return crlf(chain), nil
+	}, "test", "test", new(sync.Map))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(result.CertPEM, certPEM) {
+		t.Errorf("got certificate\n%q\nwant it normalized", result.CertPEM)
+	}
+	if want := append(append([]byte{}, certPEM...), chain...); !bytes.Equal(result.CertChainPEM, want) {
+		t.Errorf("got cert chain\n%q\nwant it normalized", result.CertChainPEM)
+	}
+	if _, err := pkiutil.ParsePemEncodedCertificateChain(result.CertChainPEM); err != nil {
+		t.Errorf("failed to parse the normalized cert chain: %v", err)
+	}
+}
+
+func TestMissingCertChainWarnedPerRA(t *testing.T) {
+	csrPEM := createFakeCsr(t)
+	certPEM, err := issueFakeCert(csrPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := preSign(&IstioRAOptions{}, nil, csrPEM, ca.CertOpts{SubjectIDs: []string{testCsrHostName}, TTL: time.Hour})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	out := filepath.Join(t.TempDir(), "out.log")
+	opts := log.DefaultOptions()
+	opts.OutputPaths = []string{out}
+	if err := log.Configure(opts); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = log.Configure(log.DefaultOptions())
+	})
+	noChain := func(*x509.Certificate) ([]byte, error) { return nil, nil }
+	// Two RAs signing twice each for the same signer.
+	for _, missingCertChainSigners := range []*sync.Map{new(sync.Map), new(sync.Map)} {
+		for i := 0; i < 2; i++ {
+			if _, err := newSignResult(&IstioRAOptions{}, req, certPEM, noChain, "test", "test", missingCertChainSigners); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+	}
+	_ = log.Sync()
+	logs, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(logs), "no cert chain is configured for signer test"); got != 2 {
+		t.Errorf("got %d warnings about the missing cert chain, want one per RA", got)
+	}
+}
@@ -0,0 +1,459 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	cert "k8s.io/api/certificates/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+
+	"istio.io/istio/security/pkg/pki/ca"
+	raerror "istio.io/istio/security/pkg/pki/error"
+	"istio.io/istio/security/pkg/pki/util"
+)
+
+const (
+	// certManagerSignerLabel is the signer label value used in metrics for certificates signed by cert-manager.
+	certManagerSignerLabel = "cert-manager"
+
+	defaultCertManagerIssuerKind  = "Issuer"
+	defaultCertManagerIssuerGroup = "cert-manager.io"
+)
+
+var (
+	certificateRequestGVR = schema.GroupVersionResource{
+		Group:    "cert-manager.io",
+		Version:  "v1",
+		Resource: "certificaterequests",
+	}
+
+	// certManagerPollInterval is how often the status of a pending CertificateRequest is checked.
+	certManagerPollInterval = time.Second
+)
+
+// CertManagerRA integrated with an external CA using cert-manager CertificateRequests
+type CertManagerRA struct {
+	client        dynamic.Interface
+	raOpts        *IstioRAOptions
+	keyCertBundle *util.KeyCertBundle
+	// certCache caches the issued certificates when CertCacheSize is set.
+	certCache *certCache
+	// serials detects the serial numbers reused by the signers.
+	serials *serialTracker
+	// keyReuse detects the CSR keys reused between identities when RejectReusedKeys is set.
+	keyReuse *keyReuseTracker
+	// issuances remembers the certificates recently issued to each identity when RecentIssuancesPerIdentity is set.
+	issuances *issuanceIndex
+	// inflight deduplicates concurrent identical signing requests.
+	inflight inflightGroup
+	// missingCertChainSigners are the signers for which a missing cert chain has been logged.
+	missingCertChainSigners sync.Map
+}
+
+// NewCertManagerRA : Create a RA that signs certificates with the cert-manager issuer CertManagerIssuer
+func NewCertManagerRA(raOpts *IstioRAOptions) (*CertManagerRA, error) {
+	if raOpts.DynamicClient == nil {
+		return nil, raerror.NewError(raerror.CAInitFail, fmt.Errorf("a dynamic client is required for the cert-manager RA"))
+	}
+	if raOpts.CertManagerIssuer.Name == "" {
+		return nil, raerror.NewError(raerror.CAInitFail, fmt.Errorf("a cert-manager issuer is required for the cert-manager RA"))
+	}
+	if raOpts.CertManagerNamespace == "" {
+		return nil, raerror.NewError(raerror.CAInitFail, fmt.Errorf("a namespace is required for the cert-manager RA"))
+	}
+	if err := validateRAOptions(raOpts); err != nil {
+		return nil, err
+	}
+	warnUnsupportedNotBeforeBackdate(raOpts, "cert-manager")
+	keyCertBundle := util.NewKeyCertBundleFromPem(nil, nil, nil, nil)
+	if raOpts.CaCertFile != "" {
+		var err error
+		if keyCertBundle, err = loadCABundle(raOpts.CaCertFile); err != nil {
+			return nil, raerror.NewError(raerror.CAInitFail, fmt.Errorf("error processing Certificate Bundle for cert-manager RA: %v", err))
+		}
+	}
+	istioRA := &CertManagerRA{
+		client:        raOpts.DynamicClient,
+		raOpts:        raOpts,
+		keyCertBundle: keyCertBundle,
+		serials:       newSerialTracker(raOpts),
+	}
+	recordRootCertExpiry(certManagerSignerLabel, keyCertBundle.GetRootCertPem(), time.Now())
+	if raOpts.CertCacheSize > 0 {
+		certCache, err := newCertCache(raOpts)
+		if err != nil {
+			return nil, raerror.NewError(raerror.CAInitFail, fmt.Errorf("error creating the certificate cache: %v", err))
+		}
+		istioRA.certCache = certCache
+	}
+	keyReuse, err := newKeyReuseTracker(raOpts)
+	if err != nil {
+		return nil, raerror.NewError(raerror.CAInitFail, fmt.Errorf("error creating the key reuse tracker: %v", err))
+	}
+	istioRA.keyReuse = keyReuse
+	issuances, err := newIssuanceIndex(raOpts)
+	if err != nil {
+		return nil, raerror.NewError(raerror.CAInitFail, fmt.Errorf("error creating the issuance index: %v", err))
+	}
+	istioRA.issuances = issuances
+	return istioRA, nil
+}
+
+// Sign takes a PEM-encoded CSR and cert opts, and returns a certificate signed by the cert-manager issuer.
+func (r *CertManagerRA) Sign(csrPEM []byte, certOpts ca.CertOpts) ([]byte, error) {
+	return r.SignContext(context.Background(), csrPEM, certOpts)
+}
+
+// SignContext is similar to Sign, but gives up waiting for the cert-manager issuer once ctx is done.
+func (r *CertManagerRA) SignContext(ctx context.Context, csrPEM []byte, certOpts ca.CertOpts) ([]byte, error) {
+	result, err := r.SignWithCertChainResponseContext(ctx, csrPEM, certOpts)
+	if err != nil {
+		return nil, err
+	}
+	return result.CertPEM, nil
+}
+
+// SignWithCertChain is similar to Sign but returns the leaf cert and the entire cert chain.
+func (r *CertManagerRA) SignWithCertChain(csrPEM []byte, certOpts ca.CertOpts) ([]byte, error) {
+	return r.SignWithCertChainContext(context.Background(), csrPEM, certOpts)
+}
+
+// SignWithCertChainContext is similar to SignContext but returns the leaf cert and the entire cert chain.
+func (r *CertManagerRA) SignWithCertChainContext(ctx context.Context, csrPEM []byte, certOpts ca.CertOpts) ([]byte, error) {
+	result, err := r.SignWithCertChainResponseContext(ctx, csrPEM, certOpts)
+	if err != nil {
+		return nil, err
+	}
+	return result.CertChainPEM, nil
+}
+
+// SignWithCertChainResponse is similar to SignWithCertChain, but also returns the parsed details of
+// the issued certificate.
+func (r *CertManagerRA) SignWithCertChainResponse(csrPEM []byte, certOpts ca.CertOpts) (*SignResult, error) {
+	return r.SignWithCertChainResponseContext(context.Background(), csrPEM, certOpts)
+}
+
+// SignWithCertChainResponseContext is similar to SignWithCertChainResponse, but gives up waiting for
+// the cert-manager issuer once ctx is done.
+func (r *CertManagerRA) SignWithCertChainResponseContext(ctx context.Context, csrPEM []byte,
+	certOpts ca.CertOpts) (*SignResult, error) {
+	return r.SignParsed(ctx, nil, csrPEM, certOpts)
+}
+
+// SignParsed is similar to SignWithCertChainResponseContext, but takes the CSR raw already parsed as csr, so
+// that it is not parsed again. A nil csr is parsed from raw.
+func (r *CertManagerRA) SignParsed(ctx context.Context, csr *x509.CertificateRequest, raw []byte,
+	certOpts ca.CertOpts) (result *SignResult, err error) {
+	start := time.Now()
+	certOpts = withRequestID(certOpts)
+	ctx, span := startSignSpan(ctx, raw, certOpts.CertSigner, certOpts.RequestID)
+	signLog := startSignLog(certManagerSignerLabel, raw, certOpts)
+	defer func() {
+		recordSign(certManagerSignerLabel, start, err)
+		endSpan(span, err)
+		signLog.end(err)
+	}()
+	result, err = r.sign(ctx, csr, raw, certOpts)
+	return result, requestError(err, certOpts.RequestID)
+}
+
+// validate runs the checks of signing csrPEM, parsed as csr if not nil, with certOpts, and returns the
+// validated request and the key usages to request.
+func (r *CertManagerRA) validate(csr *x509.CertificateRequest, csrPEM []byte,
+	certOpts ca.CertOpts) (*validatedRequest, []cert.KeyUsage, error) {
+	req, err := preSign(r.raOpts, csr, csrPEM, certOpts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := validateSubject(r.raOpts, req.csr.Subject); err != nil {
+		return nil, nil, raerror.NewError(raerror.CSRError, fmt.Errorf("invalid CSR: %v", err))
+	}
+	if certOpts.CertSigner != "" {
+		return nil, nil, raerror.NewError(raerror.CertGenError,
+			fmt.Errorf("signer %s is not supported by the cert-manager RA", certOpts.CertSigner))
+	}
+	if err := checkProfileSigner(req.profile, certOpts.Profile, certManagerSignerLabel); err != nil {
+		return nil, nil, err
+	}
+	if req.lifetime, err = clampToRootExpiry(r.raOpts, r.keyCertBundle, req.lifetime, time.Now(), certOpts.RequestID); err != nil {
+		return nil, nil, err
+	}
+	usages, err := keyUsages(certOpts, req.profile)
+	if err != nil {
+		return nil, nil, err
+	}
+	if req.extKeyUsages, err = requestedExtKeyUsages(r.raOpts, certOpts); err != nil {
+		return nil, nil, err
+	}
+	// CertificateRequests cannot request them, they are added by the issuer configuration if at all.
+	if err := rejectUnsupportedExtensions(certOpts, "cert-manager"); err != nil {
+		return nil, nil, err
+	}
+	return req, usages, nil
+}
+
+// Validate checks whether csrPEM and certOpts would be accepted for signing, without creating a
+// CertificateRequest. It returns the same errors as Sign for requests that are not.
+func (r *CertManagerRA) Validate(csrPEM []byte, certOpts ca.CertOpts) error {
+	_, _, err := r.validate(nil, csrPEM, certOpts)
+	return requestError(err, certOpts.RequestID)
+}
+
+// sign validates and authorizes csrPEM, parsed as csr if not nil, and has it signed by the cert-manager issuer unless a
+// certificate is cached for it. Concurrent identical requests share a single signing.
+func (r *CertManagerRA) sign(ctx context.Context, csr *x509.CertificateRequest, csrPEM []byte,
+	certOpts ca.CertOpts) (*SignResult, error) {
+	req, usages, err := r.validate(csr, csrPEM, certOpts)
+	if err != nil {
+		return nil, err
+	}
+	// The identities listed again are dropped from the rest of the request, e.g. its cache and rate limit keys.
+	certOpts.SubjectIDs = req.subjectIDs
+	if err := runPreSignHook(ctx, r.raOpts, csrPEM, certOpts, req); err != nil {
+		return nil, err
+	}
+	if err := r.keyReuse.check(req, certOpts.SubjectIDs); err != nil {
+		return nil, err
+	}
+	key := certCacheKey(csrPEM, certOpts)
+	if r.certCache != nil {
+		if result := r.certCache.get(key, time.Now()); result != nil {
+			return result, nil
+		}
+	}
+	return signDeduplicated(ctx, &r.inflight, inflightKey(certManagerSignerLabel, key, certOpts), req.requestID, signTimeout(r.raOpts),
+		nil, func(ctx context.Context) (*SignResult, error) {
+			return r.signUncached(ctx, csrPEM, certOpts, req, usages, key)
+		})
+}
+
+// signUncached has the validated csrPEM signed by the cert-manager issuer, and caches the certificate under key.
+func (r *CertManagerRA) signUncached(ctx context.Context, csrPEM []byte, certOpts ca.CertOpts, req *validatedRequest,
+	usages []cert.KeyUsage, key string) (*SignResult, error) {
+	result, err := signCheckingClockSkew(r.raOpts, certManagerSignerLabel, req.requestID, func() (*SignResult, error) {
+		certPEM, caPEM, err := r.certManagerSign(ctx, csrPEM, certOpts, usages, req.lifetime)
+		if err != nil {
+			return nil, err
+		}
+		return newSignResult(r.raOpts, req, certPEM, func(*x509.Certificate) ([]byte, error) {
+			if len(caPEM) > 0 {
+				return caPEM, nil
+			}
+			return r.GetCAKeyCertBundle().GetCertChainPem(), nil
+		}, certManagerSignerLabel, certManagerSignerLabel, &r.missingCertChainSigners)
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.serials.observe(certManagerSignerLabel, certManagerSignerLabel, result)
+	r.keyReuse.observe(req, certOpts.SubjectIDs)
+	recordIssuedLifetime(certManagerSignerLabel, result)
+	checkCertChainDepth(r.raOpts, certManagerSignerLabel, req.requestID, result)
+	if err := recordIssuance(r.raOpts, certManagerSignerLabel, certOpts, result); err != nil {
+		return nil, err
+	}
+	r.issuances.observe(certOpts.SubjectIDs, result)
+	if r.certCache != nil {
+		r.certCache.add(key, result, time.Now())
+	}
+	return result, nil
+}
+
+// certManagerSign has csrPEM signed through a new CertificateRequest, and returns the issued certificate and CA.
+func (r *CertManagerRA) certManagerSign(ctx context.Context, csrPEM []byte, certOpts ca.CertOpts, usages []cert.KeyUsage,
+	lifetime time.Duration) ([]byte, []byte, error) {
+	certRequest := r.newCertificateRequest(csrPEM, certOpts, usages, lifetime)
+	var caPEM []byte
+	certPEM, err := signWithRetry(ctx, r.raOpts, certOpts.RequestID, func() ([]byte, error) {
+		var certPEM []byte
+		var err error
+		certPEM, caPEM, err = r.requestCertificate(ctx, certRequest, certOpts.RequestID)
+		return certPEM, err
+	})
+	if err != nil {
+		var raErr *raerror.Error
+		if errors.As(err, &raErr) {
+			return nil, nil, err
+		}
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return nil, nil, raerror.NewError(raerror.RequestCanceled, err)
+		}
+		return nil, nil, raerror.NewError(raerror.CertGenError, err)
+	}
+	return certPEM, caPEM, nil
+}
+
+// newCertificateRequest returns the CertificateRequest to create for csrPEM.
+func (r *CertManagerRA) newCertificateRequest(csrPEM []byte, certOpts ca.CertOpts, usages []cert.KeyUsage,
+	lifetime time.Duration) *unstructured.Unstructured {
+	csrNameFunc := r.raOpts.CSRNameFunc
+	if csrNameFunc == nil {
+		csrNameFunc = DefaultCSRName
+	}
+	issuer := r.raOpts.CertManagerIssuer
+	if issuer.Kind == "" {
+		issuer.Kind = defaultCertManagerIssuerKind
+	}
+	if issuer.Group == "" {
+		issuer.Group = defaultCertManagerIssuerGroup
+	}
+	certUsages := make([]interface{}, 0, len(usages))
+	for _, usage := range usages {
+		certUsages = append(certUsages, string(usage))
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": certificateRequestGVR.GroupVersion().String(),
+		"kind":       "CertificateRequest",
+		"metadata": map[string]interface{}{
+			"name":      csrNameFunc(csrPEM, certOpts),
+			"namespace": r.raOpts.CertManagerNamespace,
+		},
+		"spec": map[string]interface{}{
+			"request":  base64.StdEncoding.EncodeToString(csrPEM),
+			"duration": lifetime.String(),
+			"isCA":     certOpts.ForCA,
+			"usages":   certUsages,
+			"issuerRef": map[string]interface{}{
+				"name":  issuer.Name,
+				"kind":  issuer.Kind,
+				"group": issuer.Group,
+			},
+		},
+	}}
+}
+
+// requestCertificate creates certRequest for the request requestID, and returns the certificate and CA issued for it.
+func (r *CertManagerRA) requestCertificate(ctx context.Context, certRequest *unstructured.Unstructured,
+	requestID string) ([]byte, []byte, error) {
+	requests := r.client.Resource(certificateRequestGVR).Namespace(r.raOpts.CertManagerNamespace)
+	created, err := requests.Create(ctx, certRequest, metav1.CreateOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create CertificateRequest %s: %w", certRequest.GetName(), err)
+	}
+	name := created.GetName()
+	if r.raOpts.CleanupCSR == nil || *r.raOpts.CleanupCSR {
+		defer r.deleteCertificateRequest(name, requestID)
+	}
+
+	waitCtx := ctx
+	if r.raOpts.ApprovalTimeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, r.raOpts.ApprovalTimeout)
+		defer cancel()
+	}
+	ticker := time.NewTicker(certManagerPollInterval)
+	defer ticker.Stop()
+	for {
+		obj, err := requests.Get(waitCtx, name, metav1.GetOptions{})
+		if err != nil {
+			requestLog(requestID).Debugf("failed to get CertificateRequest %s, retrying: %v", name, err)
+		} else if done, certPEM, caPEM, err := certificateRequestResult(obj); done {
+			return certPEM, caPEM, err
+		}
+		select {
+		case <-ticker.C:
+		case <-waitCtx.Done():
+			if ctx.Err() != nil {
+				return nil, nil, raerror.NewError(raerror.RequestCanceled, ctx.Err())
+			}
+			return nil, nil, raerror.NewError(raerror.CertGenError,
+				fmt.Errorf("no certificate was issued for CertificateRequest %s within %v, check the approver and issuer of the request",
+					name, r.raOpts.ApprovalTimeout))
+		}
+	}
+}
+
+// certificateRequestResult returns whether the CertificateRequest obj is completed, and then the certificate
+// and CA issued for it, or why it failed.
+func certificateRequestResult(obj *unstructured.Unstructured) (bool, []byte, []byte, error) {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		conditionType, _, _ := unstructured.NestedString(condition, "type")
+		status, _, _ := unstructured.NestedString(condition, "status")
+		reason, _, _ := unstructured.NestedString(condition, "reason")
+		message, _, _ := unstructured.NestedString(condition, "message")
+		switch {
+		case conditionType == "Denied" && status == "True":
+			return true, nil, nil, raerror.NewError(raerror.CSRError,
+				fmt.Errorf("CertificateRequest %s was denied: %s: %s", obj.GetName(), reason, message))
+		case conditionType == "InvalidRequest" && status == "True":
+			return true, nil, nil, raerror.NewError(raerror.CSRError,
+				fmt.Errorf("CertificateRequest %s is invalid: %s: %s", obj.GetName(), reason, message))
+		case conditionType == "Ready" && status == "False" && reason == "Failed":
+			return true, nil, nil, raerror.NewError(raerror.CertGenError,
+				fmt.Errorf("CertificateRequest %s failed: %s", obj.GetName(), message))
+		case conditionType == "Ready" && status == "True":
+			certificate, _, _ := unstructured.NestedString(obj.Object, "status", "certificate")
+			certPEM, err := base64.StdEncoding.DecodeString(certificate)
+			if err != nil || len(certPEM) == 0 {
+				return true, nil, nil, raerror.NewError(raerror.CertGenError,
+					fmt.Errorf("CertificateRequest %s is ready without a valid certificate", obj.GetName()))
+			}
+			caCert, _, _ := unstructured.NestedString(obj.Object, "status", "ca")
+			caPEM, err := base64.StdEncoding.DecodeString(caCert)
+			if err != nil {
+				return true, nil, nil, raerror.NewError(raerror.CertGenError,
+					fmt.Errorf("CertificateRequest %s is ready with an invalid CA: %v", obj.GetName(), err))
+			}
+			return true, certPEM, caPEM, nil
+		}
+	}
+	return false, nil, nil, nil
+}
+
+// deleteCertificateRequest deletes the CertificateRequest name once signing completes or fails.
+func (r *CertManagerRA) deleteCertificateRequest(name, requestID string) {
+	timeout := r.raOpts.CSRCleanupTimeout
+	if timeout <= 0 {
+		timeout = DefaultCSRCleanupTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := r.client.Resource(certificateRequestGVR).Namespace(r.raOpts.CertManagerNamespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		requestLog(requestID).Warnf("failed to clean up CertificateRequest %s, it is left orphaned: %v", name, err)
+		orphanedCSRCounts.Increment()
+	}
+}
+
+// GetCAKeyCertBundle returns the KeyCertBundle for the CA.
+func (r *CertManagerRA) GetCAKeyCertBundle() *util.KeyCertBundle {
+	return r.keyCertBundle
+}
+
+// Capabilities reports the optional features supported by the cert-manager RA.
+func (r *CertManagerRA) Capabilities() RACapabilities {
+	return RACapabilities{
+		ForCA:             r.raOpts.AllowCASigning,
+		CustomSigners:     false,
+		CustomKeyUsages:   true,
+		NotBeforeBackdate: false,
+	}
+}
@@ -0,0 +1,267 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+	kt "k8s.io/client-go/testing"
+
+	"istio.io/istio/security/pkg/pki/ca"
+	raerror "istio.io/istio/security/pkg/pki/error"
+)
+
+const testCertManagerNamespace = "istio-system"
+
+// initFakeDynamicClient returns a fake client that sets the status returned by status on the requested
+// CertificateRequest when it is read. created receives the CertificateRequests as they are created.
+func initFakeDynamicClient(status func(csrPEM []byte) (map[string]interface{}, error),
+	created chan<- *unstructured.Unstructured) *fake.FakeDynamicClient {
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{certificateRequestGVR: "CertificateRequestList"})
+	client.PrependReactor("create", "certificaterequests", func(action kt.Action) (bool, runtime.Object, error) {
+		if created != nil {
+			created <- action.(kt.CreateAction).GetObject().(*unstructured.Unstructured).DeepCopy()
+		}
+		return false, nil, nil
+	})
+	client.PrependReactor("get", "certificaterequests", func(action kt.Action) (bool, runtime.Object, error) {
+		obj, err := client.Tracker().Get(action.GetResource(), action.GetNamespace(), action.(kt.GetAction).GetName())
+		if err != nil {
+			return true, nil, err
+		}
+		certRequest := obj.(*unstructured.Unstructured).DeepCopy()
+		request, _, _ := unstructured.NestedString(certRequest.Object, "spec", "request")
+		csrPEM, err := base64.StdEncoding.DecodeString(request)
+		if err != nil {
+			return true, nil, err
+		}
+		s, err := status(csrPEM)
+		if err != nil {
+			return true, nil, err
+		}
+		certRequest.Object["status"] = s
+		return true, certRequest, nil
+	})
+	return client
+}
+
+// readyStatus returns a Ready CertificateRequest status with a certificate issued for csrPEM and caPEM.
+func readyStatus(caPEM []byte) func(csrPEM []byte) (map[string]interface{}, error) {
+	return func(csrPEM []byte) (map[string]interface{}, error) {
+		certPEM, err := issueFakeCert(csrPEM)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "Approved", "status": "True", "reason": "cert-manager.io"},
+				map[string]interface{}{"type": "Ready", "status": "True", "reason": "Issued"},
+			},
+			"certificate": base64.StdEncoding.EncodeToString(certPEM),
+			"ca":          base64.StdEncoding.EncodeToString(caPEM),
+		}, nil
+	}
+}
+
+// conditionStatus returns a CertificateRequest status with the single condition conditionType.
+func conditionStatus(conditionType, status, reason string) func(csrPEM []byte) (map[string]interface{}, error) {
+	return func([]byte) (map[string]interface{}, error) {
+		return map[string]interface{}{
+			"conditions": []interface{}{
+				map[string]interface{}{"type": conditionType, "status": status, "reason": reason, "message": "test"},
+			},
+		}, nil
+	}
+}
+
+func createFakeCertManagerRA(client *fake.FakeDynamicClient) (*CertManagerRA, error) {
+	return NewCertManagerRA(&IstioRAOptions{
+		ExternalCAType:       ExtCACertManager,
+		DefaultCertTTL:       30 * time.Minute,
+		MaxCertTTL:           time.Hour,
+		DynamicClient:        client,
+		CertManagerIssuer:    CertManagerIssuerRef{Name: "istio-ca"},
+		CertManagerNamespace: testCertManagerNamespace,
+	})
+}
+
+func TestNewCertManagerRA(t *testing.T) {
+	client := initFakeDynamicClient(conditionStatus("Ready", "False", "Pending"), nil)
+	testCases := map[string]*IstioRAOptions{
+		"no dynamic client": {
+			CertManagerIssuer:    CertManagerIssuerRef{Name: "istio-ca"},
+			CertManagerNamespace: testCertManagerNamespace,
+		},
+		"no issuer": {
+			DynamicClient:        client,
+			CertManagerNamespace: testCertManagerNamespace,
+		},
+		"no namespace": {
+			DynamicClient:     client,
+			CertManagerIssuer: CertManagerIssuerRef{Name: "istio-ca"},
+		},
+	}
+	for name, raOpts := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewCertManagerRA(raOpts)
+			var raErr *raerror.Error
+			if !errors.As(err, &raErr) || raErr.ErrorType() != "CA_INIT_FAIL" {
+				t.Errorf("expected a CA_INIT_FAIL error, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestCertManagerSign(t *testing.T) {
+	certManagerPollInterval = 10 * time.Millisecond
+	caPEM := genRootCert(t, time.Now(), time.Hour)
+	created := make(chan *unstructured.Unstructured, 1)
+	client := initFakeDynamicClient(readyStatus(caPEM), created)
+	r, err := createFakeCertManagerRA(client)
+	if err != nil {
+		t.Fatalf("Failed to create Fake cert-manager RA: %v", err)
+	}
+	// the fake issuer signs with another CA than the one it returns as the chain
+	r.raOpts.CertChainMismatchPolicy = CertChainMismatchAllow
+	var ra RegistrationAuthority = r
+	csrPEM := createFakeCsr(t)
+	result, err := ra.SignWithCertChainResponse(csrPEM, ca.CertOpts{
+		SubjectIDs: []string{testCsrHostName},
+		TTL:        10 * time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("Failed to sign through cert-manager: %v", err)
+	}
+	if !bytes.Equal(result.CertChainPEM, append(append([]byte{}, result.CertPEM...), caPEM...)) {
+		t.Errorf("expected the cert chain to be the certificate followed by the issued CA")
+	}
+	if result.CertSigner != certManagerSignerLabel {
+		t.Errorf("got signer %q, want %q", result.CertSigner, certManagerSignerLabel)
+	}
+
+	certRequest := <-created
+	if got := certRequest.GetNamespace(); got != testCertManagerNamespace {
+		t.Errorf("got namespace %q, want %q", got, testCertManagerNamespace)
+	}
+	for field, want := range map[string]string{
+		"duration": "10m0s",
+		"request":  base64.StdEncoding.EncodeToString(csrPEM),
+	} {
+		if got, _, _ := unstructured.NestedString(certRequest.Object, "spec", field); got != want {
+			t.Errorf("got spec.%s %q, want %q", field, got, want)
+		}
+	}
+	issuerRef, _, _ := unstructured.NestedStringMap(certRequest.Object, "spec", "issuerRef")
+	if want := map[string]string{"name": "istio-ca", "kind": "Issuer", "group": "cert-manager.io"}; len(issuerRef) != len(want) ||
+		issuerRef["name"] != want["name"] || issuerRef["kind"] != want["kind"] || issuerRef["group"] != want["group"] {
+		t.Errorf("got spec.issuerRef %v, want %v", issuerRef, want)
+	}
+	if _, err := client.Tracker().Get(certificateRequestGVR, testCertManagerNamespace, certRequest.GetName()); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the CertificateRequest to be deleted, got: %v", err)
+	}
+}
+
+func TestCertManagerSignFailure(t *testing.T) {
+	certManagerPollInterval = 10 * time.Millisecond
+	testCases := map[string]struct {
+		status          func(csrPEM []byte) (map[string]interface{}, error)
+		certSigner      string
+		approvalTimeout time.Duration
+		expectedErrType string
+	}{
+		"denied": {
+			status:          conditionStatus("Denied", "True", "test"),
+			expectedErrType: "CSR_ERROR",
+		},
+		"invalid request": {
+			status:          conditionStatus("InvalidRequest", "True", "test"),
+			expectedErrType: "CSR_ERROR",
+		},
+		"failed": {
+			status:          conditionStatus("Ready", "False", "Failed"),
+			expectedErrType: "CERT_GEN_ERROR",
+		},
+		"not issued within the approval timeout": {
+			status:          conditionStatus("Ready", "False", "Pending"),
+			approvalTimeout: 100 * time.Millisecond,
+			expectedErrType: "CERT_GEN_ERROR",
+		},
+		"not issued before the deadline": {
+			status:          conditionStatus("Ready", "False", "Pending"),
+			expectedErrType: "REQUEST_CANCELED",
+		},
+		"custom signer": {
+			status:          readyStatus(nil),
+			certSigner:      "custom",
+			expectedErrType: "CERT_GEN_ERROR",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			r, err := createFakeCertManagerRA(initFakeDynamicClient(tc.status, nil))
+			if err != nil {
+				t.Fatalf("Failed to create Fake cert-manager RA: %v", err)
+			}
+			r.raOpts.ApprovalTimeout = tc.approvalTimeout
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			_, err = r.SignContext(ctx, createFakeCsr(t), ca.CertOpts{
+				SubjectIDs: []string{testCsrHostName},
+				TTL:        60 * time.Second,
+				CertSigner: tc.certSigner,
+			})
+			var raErr *raerror.Error
+			if !errors.As(err, &raErr) || raErr.ErrorType() != tc.expectedErrType {
+				t.Errorf("expected a %s error, got: %v", tc.expectedErrType, err)
+			}
+		})
+	}
+}
+
+func TestCertManagerSignUnsupportedExtensions(t *testing.T) {
+	created := make(chan *unstructured.Unstructured, 1)
+	r, err := createFakeCertManagerRA(initFakeDynamicClient(readyStatus(nil), created))
+	if err != nil {
+		t.Fatalf("Failed to create Fake cert-manager RA: %v", err)
+	}
+	for name, certOpts := range map[string]ca.CertOpts{
+		"must-staple":              {MustStaple: true},
+		"issuing certificate URLs": {IssuingCertificateURLs: []string{"http://ca.example.com/ca.crt"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			certOpts.SubjectIDs = []string{testCsrHostName}
+			certOpts.TTL = 60 * time.Second
+			_, err := r.Sign(createFakeCsr(t), certOpts)
+			var raErr *raerror.Error
+			if !errors.As(err, &raErr) || raErr.ErrorType() != "CSR_ERROR" {
+				t.Errorf("expected a CSR_ERROR, got: %v", err)
+			}
+		})
+	}
+	if len(created) != 0 {
+		t.Errorf("expected no CertificateRequest to be created for unsupported extensions")
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"crypto/x509"
+	"fmt"
+
+	raerror "istio.io/istio/security/pkg/pki/error"
+)
+
+// validateNamedCertChains checks that the NamedCertChains of raOpts are named and hold valid certificates.
+func validateNamedCertChains(raOpts *IstioRAOptions) error {
+	for name, chainPEM := range raOpts.NamedCertChains {
+		if name == "" {
+			return raerror.NewError(raerror.CAInitFail, fmt.Errorf("named cert chains must have a name"))
+		}
+		if _, err := parseRootCerts(chainPEM); err != nil {
+			return raerror.NewError(raerror.CAInitFail, fmt.Errorf("invalid cert chain %q: %v", name, err))
+		}
+	}
+	return nil
+}
+
+// resolveChainSelector returns the NamedCertChains entry named chainSelector, or nil if chainSelector is empty.
+func resolveChainSelector(raOpts *IstioRAOptions, chainSelector string) ([]byte, error) {
+	if chainSelector == "" {
+		return nil, nil
+	}
+	chainPEM, ok := raOpts.NamedCertChains[chainSelector]
+	if !ok {
+		return nil, raerror.NewError(raerror.CSRError, fmt.Errorf("unknown cert chain %q", chainSelector))
+	}
+	return chainPEM, nil
+}
+
+// selectedCertChain returns chainPEM, selected by the request, to append to certPEM, whose leaf cert is
+// leafCert. Unlike the default chain, a selected chain that does not chain to certPEM is always rejected, as
+// it is static and the request explicitly asked for it.
+func selectedCertChain(chainPEM, certPEM []byte, leafCert *x509.Certificate) ([]byte, error) {
+	if !chainsTo(chainPEM, certPEM) {
+		return nil, fmt.Errorf("the selected cert chain does not chain to certificate %s issued by %q",
+			leafCert.SerialNumber, leafCert.Issuer)
+	}
+	return chainPEM, nil
+}
@@ -0,0 +1,107 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"istio.io/istio/security/pkg/pki/ca"
+	raerror "istio.io/istio/security/pkg/pki/error"
+	pkiutil "istio.io/istio/security/pkg/pki/util"
+)
+
+func TestChainSelector(t *testing.T) {
+	issuingCA := newTestCA(t, false)
+	otherCA := newTestCA(t, false)
+	defaultChain := append(append([]byte{}, issuingCA.intermediatePEM...), issuingCA.rootPEM...)
+	crossSignedChain := append(append([]byte{}, issuingCA.intermediatePEM...), otherCA.rootPEM...)
+	otherChain := append(append([]byte{}, otherCA.intermediatePEM...), otherCA.rootPEM...)
+
+	csrPEM := createFakeCsr(t)
+	csr, err := pkiutil.ParsePemEncodedCSR(csrPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certDER, err := pkiutil.GenCertFromCSR(csr, issuingCA.intermediateCert, csr.PublicKey, issuingCA.intermediateKey,
+		[]string{testCsrHostName}, time.Hour, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+
+	raOpts := &IstioRAOptions{
+		CertChainMismatchPolicy: CertChainMismatchAllow,
+		NamedCertChains: map[string][]byte{
+			"cross-signed": crossSignedChain,
+			"other":        otherChain,
+		},
+	}
+	testCases := map[string]struct {
+		chainSelector string
+		expectedChain []byte
+		expectedErr   string
+	}{
+		"default": {
+			expectedChain: defaultChain,
+		},
+		"selected": {
+			chainSelector: "cross-signed",
+			expectedChain: crossSignedChain,
+		},
+		"unknown": {
+			chainSelector: "unknown",
+			expectedErr:   "CSR_ERROR",
+		},
+		"not chaining to the certificate": {
+			chainSelector: "other",
+			expectedErr:   "CERT_GEN_ERROR",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var raErr *raerror.Error
+			certOpts := ca.CertOpts{SubjectIDs: []string{testCsrHostName}, TTL: time.Hour, ChainSelector: tc.chainSelector}
+			req, err := preSign(raOpts, nil, csrPEM, certOpts)
+			if err == nil {
+				var result *SignResult
+				result, err = newSignResult(raOpts, req, certPEM, func(*x509.Certificate) ([]byte, error) {
+					return defaultChain, nil
+				}, "test", "test", new(sync.Map))
+				if err == nil && tc.expectedErr == "" {
+					if want := append(append([]byte{}, certPEM...), tc.expectedChain...); !bytes.Equal(result.CertChainPEM, want) {
+						t.Errorf("got an unexpected cert chain appended to the certificate")
+					}
+					return
+				}
+			}
+			if !errors.As(err, &raErr) || raErr.ErrorType() != tc.expectedErr {
+				t.Fatalf("got error %v, want a %s error", err, tc.expectedErr)
+			}
+		})
+	}
+
+	if key := certCacheKey(csrPEM, ca.CertOpts{ChainSelector: "cross-signed"}); key == certCacheKey(csrPEM, ca.CertOpts{}) {
+		t.Errorf("got the same cache key for requests selecting different cert chains")
+	}
+	if err := validateRAOptions(&IstioRAOptions{NamedCertChains: map[string][]byte{"invalid": []byte("invalid")}}); err == nil {
+		t.Errorf("expected an error for an invalid named cert chain")
+	}
+}
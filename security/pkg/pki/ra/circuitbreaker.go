@@ -0,0 +1,188 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	raerror "istio.io/istio/security/pkg/pki/error"
+)
+
+// ErrCircuitOpen is wrapped by the SignerUnavailable errors of the signing requests failed without being sent
+// to their signer, because its circuit breaker is open.
+var ErrCircuitOpen = errors.New("the circuit breaker of the signer is open")
+
+// circuitState is the state of the circuit breaker of a signer, as recorded by the ra_signer_circuit_state metric.
+type circuitState int
+
+const (
+	// circuitClosed : Requests are sent to the signer
+	circuitClosed circuitState = iota
+	// circuitHalfOpen : The cooldown elapsed, a single request is sent to the signer to probe whether it recovered
+	circuitHalfOpen
+	// circuitOpen : Requests fail at once
+	circuitOpen
+)
+
+// validateCircuitBreaker checks that the circuit breaker options of raOpts are not negative.
+func validateCircuitBreaker(raOpts *IstioRAOptions) error {
+	if raOpts.CircuitBreakerFailureThreshold < 0 || raOpts.CircuitBreakerWindow < 0 || raOpts.CircuitBreakerCooldown < 0 {
+		return raerror.NewError(raerror.CAInitFail, fmt.Errorf("circuit breaker failure threshold %d, window %v and cooldown %v cannot be negative",
+			raOpts.CircuitBreakerFailureThreshold, raOpts.CircuitBreakerWindow, raOpts.CircuitBreakerCooldown))
+	}
+	return nil
+}
+
+// circuitBreakers keeps the signers of an RA that failed CircuitBreakerFailureThreshold consecutive times within
+// the CircuitBreakerWindow from being sent requests for the CircuitBreakerCooldown. A nil circuitBreakers never
+// opens a circuit. It is safe for concurrent use.
+type circuitBreakers struct {
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+	mutex     sync.Mutex
+	// circuits are the circuits of the signers, keyed by signer name.
+	circuits map[string]*circuit
+}
+
+// circuit is the circuit breaker of a signer.
+type circuit struct {
+	state circuitState
+	// failures is the number of consecutive failures since firstFailure.
+	failures     int
+	firstFailure time.Time
+	// openedAt is when the circuit was last opened.
+	openedAt time.Time
+	// probing is whether the request probing the signer while half-open is in flight.
+	probing bool
+}
+
+// newCircuitBreakers returns the circuit breakers of raOpts, or nil if circuits are never opened.
+func newCircuitBreakers(raOpts *IstioRAOptions) *circuitBreakers {
+	if raOpts.CircuitBreakerFailureThreshold <= 0 {
+		return nil
+	}
+	window := raOpts.CircuitBreakerWindow
+	if window <= 0 {
+		window = DefaultCircuitBreakerWindow
+	}
+	cooldown := raOpts.CircuitBreakerCooldown
+	if cooldown <= 0 {
+		cooldown = DefaultCircuitBreakerCooldown
+	}
+	return &circuitBreakers{
+		threshold: raOpts.CircuitBreakerFailureThreshold,
+		window:    window,
+		cooldown:  cooldown,
+		circuits:  map[string]*circuit{},
+	}
+}
+
+// allow returns an error if a request may not be sent to signer, labeled signerLabel in the metrics, as its
+// circuit is open, or half-open with the probing request in flight. Every allowed request must be followed by a
+// call to done with its outcome.
+func (b *circuitBreakers) allow(signer, signerLabel string) error {
+	if b == nil {
+		return nil
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	c, ok := b.circuits[signer]
+	if !ok {
+		return nil
+	}
+	switch c.state {
+	case circuitOpen:
+		if time.Since(c.openedAt) < b.cooldown {
+			break
+		}
+		c.state = circuitHalfOpen
+		recordSignerCircuitState(signerLabel, circuitHalfOpen)
+		fallthrough
+	case circuitHalfOpen:
+		if c.probing {
+			break
+		}
+		c.probing = true
+		return nil
+	default:
+		return nil
+	}
+	return raerror.NewError(raerror.SignerUnavailable, fmt.Errorf("%w: signer %s failed %d consecutive times, retrying it after %v",
+		ErrCircuitOpen, signer, b.threshold, b.cooldown))
+}
+
+// done records the outcome err of a request allowed by allow. Failures of the signer open its circuit once
+// there are enough of them, or again if it was half-open, and a success closes it. Other errors, e.g. of the
+// request itself, leave it as is.
+func (b *circuitBreakers) done(signer, signerLabel string, err error) {
+	if b == nil {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	c, ok := b.circuits[signer]
+	if err == nil {
+		if ok && c.state != circuitClosed {
+			pkiRaLog.Infof("signer %s recovered, closing its circuit", signer)
+			recordSignerCircuitState(signerLabel, circuitClosed)
+		}
+		delete(b.circuits, signer)
+		return
+	}
+	if !signerFailed(err) {
+		if ok {
+			c.probing = false
+		}
+		return
+	}
+	if !ok {
+		c = &circuit{}
+		b.circuits[signer] = c
+	}
+	wasProbing := c.probing
+	c.probing = false
+	now := time.Now()
+	if c.state == circuitHalfOpen && wasProbing {
+		pkiRaLog.Warnf("signer %s is still failing, opening its circuit again for %v: %v", signer, b.cooldown, err)
+		c.state = circuitOpen
+		c.openedAt = now
+		recordSignerCircuitState(signerLabel, circuitOpen)
+		return
+	}
+	if c.state != circuitClosed {
+		return
+	}
+	if c.failures == 0 || now.Sub(c.firstFailure) > b.window {
+		c.failures = 0
+		c.firstFailure = now
+	}
+	c.failures++
+	if c.failures >= b.threshold {
+		pkiRaLog.Warnf("signer %s failed %d consecutive times within %v, opening its circuit for %v: %v", signer,
+			c.failures, b.window, b.cooldown, err)
+		c.state = circuitOpen
+		c.openedAt = now
+		recordSignerCircuitState(signerLabel, circuitOpen)
+	}
+}
+
+// signerFailed returns whether signing failed with err because of the signer, rather than of the request.
+func signerFailed(err error) bool {
+	return signerUnavailable(err) || hasErrorType(err, raerror.CSRFailed)
+}
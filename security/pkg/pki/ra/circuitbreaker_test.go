@@ -0,0 +1,156 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cert "k8s.io/api/certificates/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	kt "k8s.io/client-go/testing"
+
+	"istio.io/istio/security/pkg/pki/ca"
+	raerror "istio.io/istio/security/pkg/pki/error"
+)
+
+func TestCircuitBreakers(t *testing.T) {
+	const signer = "example.com/circuit"
+	failure := raerror.NewError(raerror.CSRPending, fmt.Errorf("not issued"))
+	state := func() float64 {
+		return getMetricValue(t, "ra_signer_circuit_state", map[string]string{"signer": signer})
+	}
+	expectOpen := func(b *circuitBreakers, open bool) {
+		t.Helper()
+		err := b.allow(signer, signer)
+		var raErr *raerror.Error
+		if open && (!errors.Is(err, ErrCircuitOpen) || !errors.As(err, &raErr) || raErr.ErrorType() != "SIGNER_UNAVAILABLE") {
+			t.Fatalf("expected a SIGNER_UNAVAILABLE error for an open circuit, got: %v", err)
+		}
+		if !open && err != nil {
+			t.Fatalf("unexpected error for a closed circuit: %v", err)
+		}
+	}
+
+	var b *circuitBreakers
+	b.done(signer, signer, failure)
+	expectOpen(b, false)
+	if b = newCircuitBreakers(&IstioRAOptions{}); b != nil {
+		t.Fatalf("expected no circuit breakers without a failure threshold")
+	}
+
+	b = newCircuitBreakers(&IstioRAOptions{CircuitBreakerFailureThreshold: 2, CircuitBreakerCooldown: 100 * time.Millisecond})
+	expectOpen(b, false)
+	b.done(signer, signer, failure)
+	// errors of the request itself are not failures of the signer
+	expectOpen(b, false)
+	b.done(signer, signer, raerror.NewError(raerror.CSRDenied, fmt.Errorf("denied")))
+	expectOpen(b, false)
+	b.done(signer, signer, failure)
+	expectOpen(b, true)
+	if got := state(); got != float64(circuitOpen) {
+		t.Errorf("got circuit state %v, want open", got)
+	}
+
+	// a single request probes the signer once the cooldown elapsed, and opens the circuit again if it fails
+	time.Sleep(100 * time.Millisecond)
+	expectOpen(b, false)
+	if got := state(); got != float64(circuitHalfOpen) {
+		t.Errorf("got circuit state %v, want half-open", got)
+	}
+	expectOpen(b, true)
+	b.done(signer, signer, failure)
+	expectOpen(b, true)
+
+	// a successful probe closes the circuit
+	time.Sleep(100 * time.Millisecond)
+	expectOpen(b, false)
+	b.done(signer, signer, nil)
+	if got := state(); got != float64(circuitClosed) {
+		t.Errorf("got circuit state %v, want closed", got)
+	}
+	expectOpen(b, false)
+	b.done(signer, signer, nil)
+
+	// failures are only consecutive within the window
+	b = newCircuitBreakers(&IstioRAOptions{CircuitBreakerFailureThreshold: 2, CircuitBreakerWindow: 50 * time.Millisecond})
+	expectOpen(b, false)
+	b.done(signer, signer, failure)
+	time.Sleep(100 * time.Millisecond)
+	expectOpen(b, false)
+	b.done(signer, signer, failure)
+	expectOpen(b, false)
+
+	if err := validateRAOptions(&IstioRAOptions{CircuitBreakerFailureThreshold: -1}); err == nil {
+		t.Errorf("expected an error for a negative failure threshold")
+	}
+}
+
+func TestSignCircuitBreakerFallback(t *testing.T) {
+	const primarySigner, fallbackSigner = "example.com/primary", "example.com/fallback"
+	var primaryCSRs int32
+	client := initFakeKubeClient(issueFakeCert)
+	client.PrependReactor("create", "certificatesigningrequests", func(action kt.Action) (bool, runtime.Object, error) {
+		csr := action.(kt.CreateAction).GetObject().(*cert.CertificateSigningRequest)
+		if csr.Spec.SignerName == primarySigner {
+			atomic.AddInt32(&primaryCSRs, 1)
+			return true, nil, apierrors.NewServiceUnavailable("primary CA is down")
+		}
+		return false, nil, nil
+	})
+	r, err := NewKubernetesRA(&IstioRAOptions{
+		ExternalCAType:                 ExtCAK8s,
+		CaSigner:                       primarySigner,
+		CaCertFile:                     TestCACertFile,
+		FallbackSigners:                []string{fallbackSigner},
+		SignerCaCertFiles:              map[string]string{fallbackSigner: "../testdata/spiffe-root-cert-1.pem"},
+		SignMaxAttempts:                1,
+		CircuitBreakerFailureThreshold: 2,
+		CircuitBreakerCooldown:         time.Hour,
+		K8sClient:                      client,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	sign := func() {
+		t.Helper()
+		result, err := r.SignWithCertChainResponse(createFakeCsr(t), ca.CertOpts{SubjectIDs: []string{testCsrHostName}, TTL: time.Hour})
+		if err != nil {
+			t.Fatalf("expected signing to fall back to the fallback signer, got: %v", err)
+		}
+		if result.CertSigner != fallbackSigner {
+			t.Errorf("got signer %q, want the fallback signer %q", result.CertSigner, fallbackSigner)
+		}
+	}
+	sign()
+	sign()
+	failed := atomic.LoadInt32(&primaryCSRs)
+	if failed == 0 {
+		t.Fatalf("expected the primary signer to be tried until its circuit opens")
+	}
+	sign()
+	sign()
+	// the primary signer is not sent requests once its circuit is open
+	if got := atomic.LoadInt32(&primaryCSRs); got != failed {
+		t.Errorf("got %d CSRs created for the primary signer with its circuit open", got-failed)
+	}
+	if got := getMetricValue(t, "ra_signer_circuit_state", map[string]string{"signer": primarySigner}); got != float64(circuitOpen) {
+		t.Errorf("got circuit state %v for the primary signer, want open", got)
+	}
+}
@@ -14,21 +14,115 @@
 package ra
 
 import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/asn1"
 	"fmt"
+	"math/big"
+	"math/rand"
+	"net/http"
+	"sync"
 	"time"
 
+	cert "k8s.io/api/certificates/v1"
+	"k8s.io/client-go/dynamic"
 	clientset "k8s.io/client-go/kubernetes"
 
+	"istio.io/istio/security/pkg/k8s/chiron"
+	"istio.io/istio/security/pkg/pki/ca"
 	raerror "istio.io/istio/security/pkg/pki/error"
 	"istio.io/istio/security/pkg/pki/util"
 	caserver "istio.io/istio/security/pkg/server/ca"
+	"istio.io/pkg/log"
 )
 
+var pkiRaLog = log.RegisterScope("pkira", "Istio RA log", 0)
+
 // RegistrationAuthority : Registration Authority interface.
 type RegistrationAuthority interface {
 	caserver.CertificateAuthority
+	// SignContext is similar to Sign, but aborts signing once ctx is done.
+	SignContext(ctx context.Context, csrPEM []byte, opts ca.CertOpts) ([]byte, error)
+	// SignWithCertChainContext is similar to SignWithCertChain, but aborts signing once ctx is done.
+	SignWithCertChainContext(ctx context.Context, csrPEM []byte, opts ca.CertOpts) ([]byte, error)
+	// SignWithCertChainResponse is similar to SignWithCertChain, but also returns the parsed details of
+	// the issued certificate.
+	SignWithCertChainResponse(csrPEM []byte, opts ca.CertOpts) (*SignResult, error)
+	// SignParsed is similar to SignWithCertChainResponse, but takes the CSR raw already parsed as csr by the
+	// caller, so that it is not parsed again. A nil csr is parsed from raw.
+	SignParsed(ctx context.Context, csr *x509.CertificateRequest, raw []byte, opts ca.CertOpts) (*SignResult, error)
+	// SignWithSeparateChain is similar to SignWithCertChain, but returns the leaf cert, its intermediate chain
+	// and the CA root certs separately, e.g. for the distinct certificate chain and validation context of SDS
+	// resources.
+	SignWithSeparateChain(csrPEM []byte, opts ca.CertOpts) (leaf, chain, roots []byte, err error)
+	// SignWithSeparateChainContext is similar to SignWithSeparateChain, but aborts signing once ctx is done.
+	SignWithSeparateChainContext(ctx context.Context, csrPEM []byte, opts ca.CertOpts) (leaf, chain, roots []byte, err error)
+	// SignStream signs the requests received on requests one at a time and in order, and sends the response
+	// of each on the returned channel before reading the next one. The channel is closed once requests is
+	// closed or ctx is done.
+	SignStream(ctx context.Context, requests <-chan SignRequest) <-chan SignResponse
+	// Capabilities reports the optional features supported by the RA.
+	Capabilities() RACapabilities
+	// Validate checks whether csrPEM and opts would be accepted for signing, without signing them. It returns
+	// the same errors as Sign for requests that are not, but does not run the PreSignHook nor generate a
+	// RequestID for requests without one.
+	Validate(csrPEM []byte, opts ca.CertOpts) error
+	// TrustBundleHandler returns a read-only http.Handler serving the current CA root certs of the RA, as PEM or
+	// as a JWKS, never any key material.
+	TrustBundleHandler() http.Handler
+}
+
+// RACapabilities are the optional features supported by a RegistrationAuthority.
+type RACapabilities struct {
+	// ForCA is whether the RA issues CA certificates, requested with CertOpts.ForCA.
+	ForCA bool
+	// CustomSigners is whether the RA signs with signers requested with CertOpts.CertSigner.
+	CustomSigners bool
+	// CustomKeyUsages is whether the RA honors the key usages requested with CertOpts.KeyUsages.
+	CustomKeyUsages bool
+	// NotBeforeBackdate is whether the RA backdates the NotBefore of issued certificates by the NotBeforeBackdate.
+	NotBeforeBackdate bool
+}
+
+// SignResult is a certificate issued by the RA.
+type SignResult struct {
+	// CertPEM is the PEM encoded certificate as returned by the signer, normalized as per the PEMNormalization.
+	CertPEM []byte
+	// CertChainPEM is CertPEM followed by the cert chain of the signer.
+	CertChainPEM []byte
+	// NotBefore is the start of the validity period of the leaf certificate.
+	NotBefore time.Time
+	// NotAfter is the end of the validity period of the leaf certificate.
+	NotAfter time.Time
+	// SerialNumber is the serial number of the leaf certificate.
+	SerialNumber *big.Int
+	// SerialNumberDecimal is SerialNumber in decimal, e.g. for CA servers to return it to the workloads.
+	SerialNumberDecimal string
+	// SerialNumberHex is SerialNumber in upper case hexadecimal, as printed by openssl.
+	SerialNumberHex string
+	// CertSigner is the name of the signer that issued the certificate.
+	CertSigner string
+	// EffectiveTTL is the lifetime the certificate was issued with: the requested TTL after clamping, or the
+	// remaining time until NotAfter when the signer issued the certificate for less than that.
+	EffectiveTTL time.Duration
+	// RenewAt is when the certificate should be renewed: after the RenewalFraction of its lifetime, and never
+	// before it was issued.
+	RenewAt time.Time
 }
 
+// Identity is a SAN identity of a CSR.
+type Identity struct {
+	// Type is the type of the SAN.
+	Type util.IdentityType
+	// Value is the identity, IP addresses are in their string form.
+	Value string
+}
+
+// PreSignHook authorizes signing csrPEM, with the SAN identities of the CSR, for certOpts. Signing is aborted
+// with an Unauthorized error when it returns an error.
+type PreSignHook func(ctx context.Context, csrPEM []byte, certOpts ca.CertOpts, identities []Identity) error
+
 // CaExternalType : Type of External CA integration
 type CaExternalType string
 
@@ -38,91 +132,894 @@ type IstioRAOptions struct {
 	ExternalCAType CaExternalType
 	// DefaultCertTTL: Default Certificate TTL
 	DefaultCertTTL time.Duration
-	// MaxCertTTL: Maximum Certificate TTL that can be requested
+	// MaxCertTTL: Maximum Certificate TTL that can be requested, longer requested TTLs are clamped to it
 	MaxCertTTL time.Duration
-	// CaCertFile : File containing PEM encoded CA root certificate of external CA
+	// MinCertTTL: Minimum Certificate TTL that can be requested. Defaults to DefaultMinCertTTL
+	MinCertTTL time.Duration
+	// CaCertFile : File containing PEM encoded CA root certificates of external CA, or a directory of such
+	// .pem or .crt files
 	CaCertFile string
-	// CaSigner : To indicate custom CA Signer name when using external K8s CA
+	// CertChainFiles : Files containing PEM encoded cert chains of the external CA, from the CA issuing the
+	// certificates up to its root, one per issuing CA. The chain of the CA that issued a certificate, matched by
+	// authority key ID or else by issuer, is appended to it instead of the chain of CaCertFile
+	CertChainFiles []string
+	// SignerCaCertFiles : Files containing PEM encoded CA root certificates of individual external CA signers,
+	// keyed by the full K8s signer name. Signers without an entry use CaCertFile.
+	SignerCaCertFiles map[string]string
+	// WatchCaCertFile : Whether to reload the CA root certificate when CaCertFile changes, and those of each signer
+	// when its SignerCaCertFiles entry changes
+	WatchCaCertFile bool
+	// WaitForCaCertFile : Whether to start without the CA root certificates when CaCertFile cannot be loaded yet,
+	// instead of failing. Signing then fails with CAInitFail and Check reports the RA as not ready until
+	// CaCertFile is loaded, which is retried every CaCertFilePollInterval
+	WaitForCaCertFile bool
+	// CaCertFilePollInterval : How often to retry loading CaCertFile with WaitForCaCertFile. Defaults to
+	// DefaultCaCertFilePollInterval
+	CaCertFilePollInterval time.Duration
+	// RootCertOverlapPeriod : How long CA root certificates removed from CaCertFile are still advertised
+	// when reloading it, so that certificates they signed stay trusted during a root rotation
+	RootCertOverlapPeriod time.Duration
+	// CaSigner : To indicate custom CA Signer name when using external K8s CA. Required by ExtCAK8s unless
+	// CertSignerDomain is set, in which case workloads must request a signer
 	CaSigner string
 	// VerifyAppendCA : Whether to use caCertFile containing CA root cert to verify and append to signed cert-chain
 	VerifyAppendCA bool
 	// K8sClient : K8s API client
 	K8sClient clientset.Interface
+	// ClientForSigner : Returns the K8s API client creating the CSRs of signer, e.g. of the cluster the signer
+	// lives in for multi-cluster meshes. The permissions of the signers of CertSignerDomain are checked with the
+	// client of each AllowedSigners entry, or of <CertSignerDomain>/* without them. Defaults to K8sClient for
+	// every signer
+	ClientForSigner func(signer string) (clientset.Interface, error)
+	// DynamicClient : K8s dynamic API client, used by ExtCACertManager to manage cert-manager CertificateRequests
+	DynamicClient dynamic.Interface
+	// CertManagerIssuer : The cert-manager issuer signing the CertificateRequests of ExtCACertManager
+	CertManagerIssuer CertManagerIssuerRef
+	// CertManagerNamespace : Namespace of the CertificateRequests created by ExtCACertManager
+	CertManagerNamespace string
+	// Vault : Vault server and PKI role signing the CSRs of ExtCAVault
+	Vault VaultRAOptions
 	// TrustDomain
 	TrustDomain string
-	// CertSignerDomain info
+	// TrustedDomains : SPIFFE trust domains the RA is allowed to sign identities for. All trust domains
+	// are allowed when empty
+	TrustedDomains []string
+	// AllowedExtendedKeyUsages : Extended key usages, other than server auth and client auth, that may be
+	// requested with CertOpts.KeyUsages, e.g. code signing for specialized control plane components
+	AllowedExtendedKeyUsages []cert.KeyUsage
+	// AllowedEKUs : Extended key usages that CSRs may request with their extended key usage extension, which some
+	// signers embed in the certificates: custom K8s signers and cert-manager issuers may, while the built-in K8s
+	// signers issue the usages of the K8s CSR and Vault those of its PKI role. Not checked if empty
+	AllowedEKUs []cert.KeyUsage
+	// CSRExtKeyUsageMode : Whether CSRs requesting extended key usages that are not in the AllowedEKUs are rejected,
+	// or signed without them. Defaults to CSRExtKeyUsageReject
+	CSRExtKeyUsageMode CSRExtKeyUsageMode
+	// CSRUsageConflictMode : What is done when the extended key usage extension of a CSR requests extended key
+	// usages other than those of the key usages the RA requests, which some signers reject. Defaults to
+	// CSRUsageConflictIgnore
+	CSRUsageConflictMode CSRUsageConflictMode
+	// AllowCASigning : Whether to sign CA certificates requested with CertOpts.ForCA, for the identities in
+	// CASigningIdentities only
+	AllowCASigning bool
+	// CASigningIdentities : SAN identities allowed to obtain CA certificates when AllowCASigning is set. No
+	// identity is allowed when empty
+	CASigningIdentities []string
+	// AllowedDNSNames : DNS names that may be requested with CertOpts.DNSNames. An entry *.<domain> allows the
+	// names directly in <domain>. No DNS name may be requested when empty
+	AllowedDNSNames []string
+	// AllowWildcardDNSNames : Whether wildcard DNS names allowed by AllowedDNSNames may be requested
+	AllowWildcardDNSNames bool
+	// NamespacePolicies : Restrict the CertOpts.RequesterNamespace of the requests for the SPIFFE identities of
+	// the trust domains and namespaces they match, which are rejected with a CSRError unless the requester
+	// namespace is allowed by one of the matching policies. Identities matched by no policy are not restricted
+	NamespacePolicies []NamespacePolicy
+	// AllowedIPRanges : CIDRs of the IP addresses that may be requested with CertOpts.IPAddresses in addition to
+	// the CertOpts.WorkloadIPs of the requesting workload. Only the WorkloadIPs may be requested if empty
+	AllowedIPRanges []string
+	// IPScopePolicy : Whether IP addresses in AllowedIPRanges may be requested in another scope, private or public,
+	// than the WorkloadIPs. Defaults to IPScopeMismatchReject
+	IPScopePolicy IPScopePolicy
+	// PreSignHook : Authorizes the requests that passed validation, before they are signed. Not run by Validate
+	PreSignHook PreSignHook
+	// ClockSkewTolerance : How far in the future the NotBefore of issued certificates may be, relative to the
+	// local clock, before the clocks of the signer and the RA are considered skewed. Defaults to
+	// DefaultClockSkewTolerance
+	ClockSkewTolerance time.Duration
+	// RejectClockSkewedCerts : Whether to sign again once, and then fail, when an issued certificate is
+	// not valid yet beyond the ClockSkewTolerance, instead of returning it with a warning
+	RejectClockSkewedCerts bool
+	// NotBeforeBackdate : How far in the past to set the NotBefore of issued certificates, so that they are valid on
+	// nodes with clocks slightly behind, up to MaxNotBeforeBackdate. Only applied by the RAs whose backend lets them
+	// set the NotBefore of the certificates, as reported by RACapabilities.NotBeforeBackdate, which none of the
+	// Kubernetes, cert-manager and Vault backends does: the built-in K8s signers backdate the certificates
+	// themselves, and Vault by the not_before_duration of its PKI role. With the others, the certificates not valid
+	// yet beyond the ClockSkewTolerance are detected instead
+	NotBeforeBackdate time.Duration
+	// TTLJitter : Fraction in [0, 1) of the EffectiveTTL reported for issued certificates up to which it is
+	// randomly reduced, so that workloads issued certificates at the same time do not rotate them at the same
+	// time. Only the reported lifetime is affected, not the NotAfter of the certificates
+	TTLJitter float64
+	// RenewalFraction : Fraction in (0, 1) of the lifetime of issued certificates, from their NotBefore, after which
+	// SignResult.RenewAt recommends renewing them. Defaults to DefaultRenewalFraction
+	RenewalFraction float64
+	// CertSignerDomain : Domain of the signers workloads request through CertOpts.CertSigner, joined with the
+	// requested signer by a single slash into its K8s signer name
 	CertSignerDomain string
+	// StrictSignerDomainCheck : Whether NewKubernetesRA fails with a CAInitFail error, rather than logging a
+	// warning, when the CertSignerDomain matches none of the names of the CA root certs of CaCertFile, or of the
+	// Secret with NewKubernetesRAFromSecret
+	StrictSignerDomainCheck bool
+	// AllowedSigners : Full K8s signer names that workloads may request through CertOpts.CertSigner. A trailing
+	// * matches any suffix, e.g. example.com/istio-*. All signers in CertSignerDomain are allowed when empty
+	AllowedSigners []string
+	// FallbackSigners : Full K8s signer names tried in order for requests to the CaSigner, when signing with the
+	// previous one fails with a retryable error after exhausting retries, or its CSR is not issued in time.
+	// They must be allowed by AllowedSigners if set
+	FallbackSigners []string
+	// SANValidationMode : How strictly the SAN identities of CSRs are checked against the requested SubjectIDs and
+	// DNSNames. Defaults to SANValidationEnforce, which keeps workloads from obtaining certificates for identities
+	// they did not request. To migrate deployments with CSRs without SANs, set SANValidationWarn, which signs CSRs
+	// that fail the check, until the ra_csr_san_validation_failure_count metric and the warnings logged show no
+	// more failures, then unset it
+	SANValidationMode SANValidationMode
+	// SubjectIDFormats : Formats allowed for the SubjectIDs of signing requests, which must have at least one.
+	// Defaults to SPIFFE IDs, DNS names and IP addresses. SubjectIDFormatAny allows custom identity formats
+	SubjectIDFormats []SubjectIDFormat
+	// DuplicateSubjectIDMode : What is done when the SubjectIDs of a signing request list an identity more than
+	// once, which are deduplicated in any case. Defaults to DuplicateSubjectIDIgnore
+	DuplicateSubjectIDMode DuplicateSubjectIDMode
+	// CertProfiles : Certificate profiles that requests may select with CertOpts.Profile, keyed by name
+	CertProfiles map[string]CertProfile
+	// NamedCertChains : PEM encoded cert chains, from the CA issuing the certificates up to its root, that
+	// requests may select with CertOpts.ChainSelector, keyed by name, e.g. a cross-signed path during a CA
+	// migration. The selected chain is appended to the certificate instead of the default one, and must chain to it
+	NamedCertChains map[string][]byte
+	// RootExpiryMode : What is done when the lifetime requested for a certificate extends beyond the expiry of the
+	// last of the CA root certificates of its signer to expire, minus the RootExpiryMargin, after which the
+	// certificate is useless. Defaults to RootExpiryIgnore
+	RootExpiryMode RootExpiryMode
+	// RootExpiryMargin : Minimum remaining validity of the CA root certificates when the issued certificates expire,
+	// with RootExpiryMode
+	RootExpiryMargin time.Duration
+	// MinRSAKeySize : Minimum size in bits of RSA keys in CSRs. Defaults to DefaultMinRSAKeySize
+	MinRSAKeySize int
+	// MinECKeySize : Minimum curve size in bits of ECDSA keys in CSRs. Defaults to DefaultMinECKeySize
+	MinECKeySize int
+	// MaxCSRSize : Maximum size in bytes of the PEM encoded CSRs to sign. Defaults to DefaultMaxCSRSize
+	MaxCSRSize int
+	// AllowedKeyAlgorithms : Public key algorithms allowed in CSRs. Defaults to RSA and ECDSA
+	AllowedKeyAlgorithms []x509.PublicKeyAlgorithm
+	// AutoApprove : Whether the RA approves the K8s CSRs it creates itself, which requires the permission to
+	// approve CSRs for the signer. Only CSRs for CaSigner or signers in CertSignerDomain are approved.
+	// Defaults to true when nil
+	AutoApprove *bool
+	// ApprovalTimeout : Maximum time to wait for a K8s CSR to be approved and issued by the signer, independently
+	// of the deadline of the sign request. No limit other than the deadline if zero
+	ApprovalTimeout time.Duration
+	// SignTimeout : Maximum time of signing a request shared by concurrent identical requests, which runs detached
+	// from the deadlines of these requests so that one of them being canceled does not fail the others. Defaults
+	// to DefaultSignTimeout
+	SignTimeout time.Duration
+	// VerifyIssuedCert : Whether to check that certificates issued by the signer have the public key and the SAN
+	// identities of the CSR. Defaults to true when nil
+	VerifyIssuedCert *bool
+	// PostSignValidators : Check that the certificates issued by the signer meet the policies of the mesh,
+	// e.g. on their subject or chain. They run in order on the parsed certificate, after the VerifyIssuedCert
+	// checks, and signing fails with the error of the first validator rejecting the certificate
+	PostSignValidators []func(leafCert *x509.Certificate) error
+	// AuditSink : Records each certificate issued, after signing succeeds. Certificates returned from the cache
+	// are not recorded again. No audit if nil
+	AuditSink AuditSink
+	// AuditTimeout : Maximum time signing waits for the AuditSink to record a certificate. Defaults to
+	// DefaultAuditTimeout
+	AuditTimeout time.Duration
+	// RequireAudit : Whether signing fails with a CertGenError when the AuditSink fails to record a certificate or
+	// times out, instead of only logging and metering the failure. The certificate is issued but not returned
+	RequireAudit bool
+	// RequireCertChain : Whether signing fails when no cert chain is configured for the signer of a certificate,
+	// instead of returning the certificate alone with a warning. Peers cannot validate certificates issued by
+	// intermediate CAs without the chain
+	RequireCertChain bool
+	// CertChainMismatchPolicy : Whether a cert chain whose first cert is not the issuer of the certificate, e.g.
+	// fetched while the CA bundle was being reloaded, is appended to it anyway once fetched again. Defaults to
+	// CertChainMismatchReject
+	CertChainMismatchPolicy CertChainMismatchPolicy
+	// PEMNormalization : How the certificates and cert chains returned by the signers are re-encoded, e.g. to drop
+	// the CRLF line endings or the text between the PEM blocks of some signers. Defaults to PEMNormalizeCompact
+	PEMNormalization PEMNormalization
+	// MinCertChainDepth : Number of certificates, the issued one included, below which the returned cert chains are
+	// logged as unexpected, e.g. 2 to catch certificates returned without the chain of their issuer. Not checked if
+	// zero
+	MinCertChainDepth int
+	// MaxCertChainDepth : Number of certificates, the issued one included, above which the returned cert chains are
+	// logged as unexpected. Not checked if zero
+	MaxCertChainDepth int
+	// DefaultOrganization : Organizations the subject of the issued certificates must have exactly, regardless of
+	// order. None of the RAs can set them: the Kubernetes and cert-manager signers issue certificates with the
+	// subject of the CSR, so these RAs reject with a CSRError the CSRs without them, and the Vault PKI role sets
+	// the subject, which must be configured with them. The certificates issued without them are rejected with
+	// VerifyIssuedCert. Not required if empty
+	DefaultOrganization []string
+	// DefaultOU : Organizational units the subject of the issued certificates must have exactly, like
+	// DefaultOrganization. Not required if empty
+	DefaultOU []string
+	// CSRNameFunc : Generates the names of the K8s CSR objects. Defaults to DefaultCSRName
+	CSRNameFunc CSRNameFunc
+	// CSRLabels : Labels set on the K8s CSR objects, e.g. for external approvers. They cannot set the
+	// ra.istio.io/instance label of the InstanceLabel
+	CSRLabels map[string]string
+	// InstanceLabel : Identifies the istiod revision or instance of the RA, e.g. the revision name, as the value of
+	// the ra.istio.io/instance label set on the K8s CSR objects it creates. It must be a valid label value: at most
+	// 63 alphanumeric characters, '-', '_' or '.', starting and ending with an alphanumeric character. The CSRs
+	// the RA looks up and cleans up beyond its own signing requests, e.g. for idempotency keys, are scoped to
+	// those with its InstanceLabel, or to those without the label if empty, so that the instances sharing a
+	// cluster never clean up the CSRs of one another. The RecentIssuances are always those of the instance
+	InstanceLabel string
+	// CSRAnnotations : Annotations set on the K8s CSR objects, e.g. for external approvers. Requests cannot
+	// override them with CertOpts.CSRAnnotations
+	CSRAnnotations map[string]string
+	// CleanupCSR : Whether to delete the K8s CSR object once signing completes or fails. Defaults to true when nil
+	CleanupCSR *bool
+	// CSRCleanupTimeout : Timeout of deleting a K8s CSR object. Defaults to DefaultCSRCleanupTimeout
+	CSRCleanupTimeout time.Duration
+	// CSRWaitStrategy : How to wait for the K8s CSR objects to be issued, chiron.WaitWatch to watch them and poll
+	// them when the watch fails, or chiron.WaitPoll to only poll them. A watch is cheaper for the API server than
+	// polling in large clusters. Defaults to chiron.WaitWatch
+	CSRWaitStrategy chiron.WaitStrategy
+	// CSRPollInitialDelay : Interval between the first reads of a polled K8s CSR object, doubled after each read
+	// up to CSRPollMaxInterval. With chiron.WaitPoll, CSRs are first read after this delay. Defaults to
+	// DefaultCSRPollInitialDelay
+	CSRPollInitialDelay time.Duration
+	// CSRPollMaxInterval : Maximum interval between reads of a polled K8s CSR object. Defaults to
+	// DefaultCSRPollMaxInterval
+	CSRPollMaxInterval time.Duration
+	// CSREmptyCertificateRetries : Number of times a K8s CSR object issued with a still nil or empty certificate, which API
+	// servers briefly report under high churn, is read again before signing fails, independently of SignMaxAttempts.
+	// Defaults to DefaultCSREmptyCertificateRetries, negative to fail at once
+	CSREmptyCertificateRetries int
+	// CSREmptyCertificateRetryInterval : Interval between these reads. Defaults to
+	// DefaultCSREmptyCertificateRetryInterval
+	CSREmptyCertificateRetryInterval time.Duration
+	// IdempotencyKeyTTL : How long the K8s CSR objects of requests with a CertOpts.IdempotencyKey are kept, and
+	// their certificates returned to retries of the requests. Such CSRs are not deleted once signing completes,
+	// and the RA must be allowed to list CSRs. Defaults to DefaultIdempotencyKeyTTL
+	IdempotencyKeyTTL time.Duration
+	// CheckCSRPermissions : Whether Check verifies with SelfSubjectAccessReviews that the RA is allowed to
+	// create, read and, with CleanupCSR, delete CSRs and, with AutoApprove, approve CSRs for CaSigner, for the
+	// signers of the CertSignerDomain, as the AllowedSigners or as <CertSignerDomain>/* without them, and for the
+	// FallbackSigners
+	CheckCSRPermissions bool
+	// CAExpiryGracePeriod : How long before the soonest expiring CA root certificate expires Check starts
+	// failing, to leave time to rotate it before signing fails. Check only fails once it is expired if zero
+	CAExpiryGracePeriod time.Duration
+	// EagerRBACCheck : Whether NewKubernetesRA verifies with SelfSubjectAccessReviews that the RA has all the
+	// permissions checked by CheckCSRPermissions, failing with the list of the missing ones. Leave it unset where
+	// SelfSubjectAccessReviews are not available
+	EagerRBACCheck bool
+	// ProbeSigner : Whether NewKubernetesRA signs a throwaway CSR with CaSigner, cleaned up right after, to check
+	// that a controller serves it. Check fails until one issues, denies or fails the CSR, and the probe is
+	// repeated until then. Leave it unset where throwaway signing is undesirable
+	ProbeSigner bool
+	// SignerProbeTimeout : How long the signer probe waits for the throwaway CSR to be answered. Defaults to
+	// DefaultSignerProbeTimeout
+	SignerProbeTimeout time.Duration
+	// CertCacheSize : Maximum number of issued certificates cached by CSR and cert opts, so that identical requests
+	// are not signed again. No certificates are cached if zero
+	CertCacheSize int
+	// CertCacheTTL : How long issued certificates are cached. Defaults to DefaultCertCacheTTL
+	CertCacheTTL time.Duration
+	// CertCacheMinValidity : Minimum remaining lifetime of cached certificates to be returned. Defaults to
+	// DefaultCertCacheMinValidity
+	CertCacheMinValidity time.Duration
+	// SerialWindowSize : Number of serial numbers most recently issued by each signer that are remembered, to log
+	// and count the certificates issued with a serial number reused by the signer. Defaults to
+	// DefaultSerialWindowSize
+	SerialWindowSize int
+	// RejectReusedKeys : Whether to reject with a CSRError the CSRs whose public key was issued to another
+	// requesting identity, the first SubjectID of the requests, within the ReusedKeyWindowSize most recently
+	// issued keys. Leave it unset where workloads legitimately share keys between identities. Concurrent requests
+	// with the same key may both be issued
+	RejectReusedKeys bool
+	// ReusedKeyWindowSize : Number of public keys most recently issued whose identity is remembered with
+	// RejectReusedKeys. Defaults to DefaultReusedKeyWindowSize
+	ReusedKeyWindowSize int
+	// RecentIssuancesPerIdentity : Number of certificates most recently issued to each requesting identity, the
+	// first SubjectID of the requests, whose serial number, NotAfter and signer are remembered in memory for
+	// RecentIssuances. Not remembered if zero
+	RecentIssuancesPerIdentity int
+	// RecentIssuancesIdentities : Maximum number of identities whose recent issuances are remembered, those least
+	// recently issued a certificate are forgotten beyond it. Defaults to DefaultRecentIssuancesIdentities
+	RecentIssuancesIdentities int
+	// IdentityRateLimit : Rate of signing requests per second allowed for each requesting identity, the first
+	// SubjectID of the requests, beyond which the requests are rejected with a RateLimited error. Requests are not
+	// rate limited if zero
+	IdentityRateLimit float64
+	// IdentityRateBurst : Number of signing requests an identity can make at once, above the IdentityRateLimit.
+	// Defaults to DefaultIdentityRateBurst
+	IdentityRateBurst int
+	// IdentityRateLimiterSize : Maximum number of identities whose rate limits are tracked, the least recently
+	// seen ones are forgotten beyond it. Defaults to DefaultIdentityRateLimiterSize
+	IdentityRateLimiterSize int
+	// MaxConcurrentCSRs : Maximum number of K8s CSR objects the Kubernetes RA has outstanding at once, across all
+	// the signing requests, beyond which the requests wait or are rejected as set by the CSRConcurrencyMode.
+	// Requests served from the certificate cache or deduplicated do not create CSRs. Not limited if zero
+	MaxConcurrentCSRs int
+	// CSRConcurrencyMode : What signing requests do once MaxConcurrentCSRs CSRs are outstanding. Defaults to
+	// CSRConcurrencyBlock
+	CSRConcurrencyMode CSRConcurrencyMode
+	// CircuitBreakerFailureThreshold : Number of consecutive failures of a signer of the Kubernetes RA within the
+	// CircuitBreakerWindow that opens its circuit: its requests then fail at once with a SignerUnavailable error
+	// wrapping ErrCircuitOpen, or go to the FallbackSigners, for the CircuitBreakerCooldown, after which a single
+	// request probes whether it recovered. Failures are the retryable errors, CSRs not issued in time and failed
+	// CSRs. No circuit is opened if zero
+	CircuitBreakerFailureThreshold int
+	// CircuitBreakerWindow : Time within which the consecutive failures of a signer are counted. Defaults to
+	// DefaultCircuitBreakerWindow
+	CircuitBreakerWindow time.Duration
+	// CircuitBreakerCooldown : How long the circuit of a signer stays open. Defaults to DefaultCircuitBreakerCooldown
+	CircuitBreakerCooldown time.Duration
+	// SignBatchConcurrency : Maximum number of CSRs of a SignBatch call that are signed concurrently.
+	// Defaults to DefaultSignBatchConcurrency
+	SignBatchConcurrency int
+	// SignMaxAttempts : Maximum number of attempts to sign a CSR when the K8s API returns retryable errors, each
+	// creating the K8s CSR object once. Defaults to DefaultSignMaxAttempts, 1 disables these retries, the CSR object
+	// then being created up to 3 times as chiron does.
+	SignMaxAttempts int
+	// SignRetryInitialInterval : Backoff before the first retry, doubled on each further retry.
+	// Defaults to DefaultSignRetryInitialInterval.
+	SignRetryInitialInterval time.Duration
+	// SignRetryMaxInterval : Maximum backoff between retries. Defaults to DefaultSignRetryMaxInterval.
+	SignRetryMaxInterval time.Duration
+}
+
+// CertManagerIssuerRef : Reference to a cert-manager Issuer or ClusterIssuer
+type CertManagerIssuerRef struct {
+	// Name : Name of the issuer
+	Name string
+	// Kind : Kind of the issuer, Issuer or ClusterIssuer. Defaults to Issuer
+	Kind string
+	// Group : API group of the issuer. Defaults to cert-manager.io
+	Group string
 }
 
 const (
 	// ExtCAK8s : Integrate with external CA using k8s CSR API
 	ExtCAK8s CaExternalType = "ISTIOD_RA_KUBERNETES_API"
 
+	// ExtCACertManager : Integrate with a cert-manager issuer using the CertificateRequest API
+	ExtCACertManager CaExternalType = "ISTIOD_RA_CERT_MANAGER_API"
+
+	// ExtCAVault : Integrate with the PKI secrets engine of Vault
+	ExtCAVault CaExternalType = "ISTIOD_RA_VAULT_API"
+
 	// ExtCAGrpc : Integration with external CA using Istio CA gRPC API
 	ExtCAGrpc CaExternalType = "ISTIOD_RA_ISTIO_API"
 
 	// DefaultExtCACertDir : Location of external CA certificate
 	DefaultExtCACertDir string = "./etc/external-ca-cert"
+
+	// DefaultMinCertTTL : Default minimum certificate TTL that can be requested
+	DefaultMinCertTTL = time.Minute
+	// DefaultSignTimeout : Default maximum time of signing a request shared by concurrent identical requests
+	DefaultSignTimeout = 5 * time.Minute
+	// DefaultAuditTimeout : Default maximum time signing waits for the AuditSink to record a certificate
+	DefaultAuditTimeout = time.Second
+	// DefaultRenewalFraction : Default fraction of the lifetime of issued certificates after which to renew them
+	DefaultRenewalFraction = 0.5
+
+	// DefaultMinRSAKeySize : Default minimum size in bits of RSA keys in CSRs
+	DefaultMinRSAKeySize = 2048
+	// DefaultMinECKeySize : Default minimum curve size in bits of ECDSA keys in CSRs
+	DefaultMinECKeySize = 256
+	// DefaultMaxCSRSize : Default maximum size in bytes of PEM encoded CSRs
+	DefaultMaxCSRSize = 16 * 1024
+
+	// DefaultCaCertFilePollInterval : Default interval of retrying to load CaCertFile with WaitForCaCertFile
+	DefaultCaCertFilePollInterval = 5 * time.Second
+
+	// DefaultClockSkewTolerance : Default tolerance of issued certificates not being valid yet
+	DefaultClockSkewTolerance = time.Minute
+	// MaxNotBeforeBackdate : Maximum NotBeforeBackdate of issued certificates
+	MaxNotBeforeBackdate = 10 * time.Minute
+
+	// DefaultCSRCleanupTimeout : Default timeout of deleting a K8s CSR object
+	DefaultCSRCleanupTimeout = 5 * time.Second
+	// DefaultCSRPollInitialDelay : Default interval between the first reads of a polled K8s CSR object
+	DefaultCSRPollInitialDelay = 500 * time.Millisecond
+	// DefaultCSRPollMaxInterval : Default maximum interval between reads of a polled K8s CSR object
+	DefaultCSRPollMaxInterval = 2 * time.Second
+	// DefaultCSREmptyCertificateRetries : Default number of times a K8s CSR object issued with a nil or empty certificate
+	// is read again
+	DefaultCSREmptyCertificateRetries = 3
+	// DefaultCSREmptyCertificateRetryInterval : Default interval between the reads of a K8s CSR object issued with an
+	// empty certificate
+	DefaultCSREmptyCertificateRetryInterval = 200 * time.Millisecond
+	// DefaultCircuitBreakerWindow : Default time within which the consecutive failures of a signer are counted
+	DefaultCircuitBreakerWindow = time.Minute
+	// DefaultCircuitBreakerCooldown : Default time the circuit of a failing signer stays open
+	DefaultCircuitBreakerCooldown = 30 * time.Second
+	// DefaultSignerProbeTimeout : Default time the signer probe waits for the throwaway CSR to be answered
+	DefaultSignerProbeTimeout = 30 * time.Second
+	// DefaultIdempotencyKeyTTL : Default time the CSRs of requests with an idempotency key are kept, which is
+	// when K8s garbage collects issued CSRs
+	DefaultIdempotencyKeyTTL = time.Hour
+
+	// DefaultCertCacheTTL : Default time issued certificates are cached
+	DefaultCertCacheTTL = 30 * time.Second
+	// DefaultCertCacheMinValidity : Default minimum remaining lifetime of cached certificates to be returned
+	DefaultCertCacheMinValidity = 10 * time.Minute
+	// DefaultSerialWindowSize : Default number of serial numbers remembered per signer
+	DefaultSerialWindowSize = 10000
+
+	// DefaultReusedKeyWindowSize : Default number of issued public keys whose identity is remembered
+	DefaultReusedKeyWindowSize = 10000
+
+	// DefaultRecentIssuancesIdentities : Default maximum number of identities whose recent issuances are remembered
+	DefaultRecentIssuancesIdentities = 10000
+
+	// DefaultIdentityRateBurst : Default number of signing requests an identity can make at once
+	DefaultIdentityRateBurst = 10
+	// DefaultIdentityRateLimiterSize : Default maximum number of identities whose rate limits are tracked
+	DefaultIdentityRateLimiterSize = 10000
+
+	// DefaultSignBatchConcurrency : Default maximum number of CSRs of a batch that are signed concurrently
+	DefaultSignBatchConcurrency = 10
+
+	// DefaultSignMaxAttempts : Default maximum number of attempts to sign a CSR
+	DefaultSignMaxAttempts = 3
+	// DefaultSignRetryInitialInterval : Default backoff before the first retry
+	DefaultSignRetryInitialInterval = 100 * time.Millisecond
+	// DefaultSignRetryMaxInterval : Default maximum backoff between retries
+	DefaultSignRetryMaxInterval = 2 * time.Second
 )
 
 // ValidateCSR : Validate all SAN extensions in csrPEM match authenticated identities
 func ValidateCSR(csrPEM []byte, subjectIDs []string) bool {
-	var match bool
 	csr, err := util.ParsePemEncodedCSR(csrPEM)
 	if err != nil {
 		return false
 	}
-	csrIDs, err := util.ExtractIDs(csr.Extensions)
+	identities, err := csrIdentities(csr)
 	if err != nil {
 		return false
 	}
-	for _, s1 := range csrIDs {
-		match = false
-		for _, s2 := range subjectIDs {
-			if s1 == s2 {
-				match = true
-				break
-			}
+	return validateCSRIdentities(identities, subjectIDs) == nil
+}
+
+// validatedRequest is a signing request that passed preSign validation.
+type validatedRequest struct {
+	// csr is the parsed CSR.
+	csr *x509.CertificateRequest
+	// identities are the SAN identities in csr.
+	identities []csrIdentity
+	// subjectIDs are the SubjectIDs of the request without the identities listed again, which replace them in the
+	// rest of the request.
+	subjectIDs []string
+	// lifetime is the lifetime to request for the certificate.
+	lifetime time.Duration
+	// profile is the CertProfiles entry selected by the request, if any.
+	profile *CertProfile
+	// extKeyUsages are the extended key usages requested beyond the default ones, which the certificate must have.
+	extKeyUsages []x509.ExtKeyUsage
+	// strippedExtKeyUsages are the extended key usages stripped from the CSR, which the certificate must not have.
+	strippedExtKeyUsages []asn1.ObjectIdentifier
+	// requestID is the RequestID of the request.
+	requestID string
+	// selectedChain is the NamedCertChains entry selected by the request, if any.
+	selectedChain []byte
+}
+
+// preSign : Validation checks to execute before signing certificates
+// csr is csrPEM as already parsed by the caller, or nil to parse it here.
+func preSign(raOpts *IstioRAOptions, csr *x509.CertificateRequest, csrPEM []byte, certOpts ca.CertOpts) (*validatedRequest, error) {
+	if certOpts.ForCA && !raOpts.AllowCASigning {
+		return nil, raerror.NewError(raerror.CSRError,
+			fmt.Errorf("unable to generate CA certifificates"))
+	}
+	maxCSRSize := raOpts.MaxCSRSize
+	if maxCSRSize <= 0 {
+		maxCSRSize = DefaultMaxCSRSize
+	}
+	// Checked before parsing, so that oversized CSRs cost nothing to reject.
+	if len(csrPEM) > maxCSRSize {
+		return nil, raerror.NewError(raerror.CSRError,
+			fmt.Errorf("CSR size %d bytes exceeds the maximum of %d bytes", len(csrPEM), maxCSRSize))
+	}
+	profile, err := resolveProfile(raOpts, certOpts.Profile)
+	if err != nil {
+		return nil, err
+	}
+	selectedChain, err := resolveChainSelector(raOpts, certOpts.ChainSelector)
+	if err != nil {
+		return nil, err
+	}
+	if csr == nil {
+		if csr, err = parseCSRPEM(csrPEM); err != nil {
+			return nil, raerror.NewError(raerror.CSRError, err)
+		}
+	} else if !parsedCSRMatches(csr, csrPEM) {
+		return nil, raerror.NewError(raerror.CSRError, fmt.Errorf("the parsed CSR does not match the PEM encoded CSR"))
+	}
+	if err := validateCSRKey(raOpts, csr); err != nil {
+		return nil, raerror.NewError(raerror.CSRError, err)
+	}
+	var strippedExtKeyUsages []asn1.ObjectIdentifier
+	if len(raOpts.AllowedEKUs) > 0 || checksCSRUsageConflicts(raOpts) {
+		usages, err := keyUsages(certOpts, profile)
+		if err != nil {
+			return nil, err
+		}
+		if strippedExtKeyUsages, err = validateCSRExtKeyUsages(raOpts, csr, usages); err != nil {
+			return nil, raerror.NewError(raerror.CSRError, err)
+		}
+		conflicting, err := reconcileCSRUsageConflicts(raOpts, csr, usages, certOpts.RequestID)
+		if err != nil {
+			return nil, raerror.NewError(raerror.CSRError, err)
 		}
-		if !match {
-			return false
+		strippedExtKeyUsages = append(strippedExtKeyUsages, conflicting...)
+	}
+	certOpts.SubjectIDs = dedupeSubjectIDs(raOpts, certOpts.SubjectIDs, certOpts.RequestID)
+	if err := validateSubjectIDs(raOpts, certOpts.SubjectIDs); err != nil {
+		return nil, raerror.NewError(raerror.CSRError, fmt.Errorf("unable to validate subject IDs: %v", err))
+	}
+	if err := validateDNSNames(raOpts, certOpts.DNSNames); err != nil {
+		return nil, raerror.NewError(raerror.CSRError, fmt.Errorf("unable to validate requested DNS names: %v", err))
+	}
+	if err := validateIPAddresses(raOpts, certOpts); err != nil {
+		return nil, raerror.NewError(raerror.CSRError, fmt.Errorf("unable to validate requested IP addresses: %v", err))
+	}
+	if err := validateIssuingCertificateURLs(certOpts); err != nil {
+		return nil, raerror.NewError(raerror.CSRError, err)
+	}
+	identities, err := validateCSRSANs(raOpts, csr, certOpts.SubjectIDs, certOpts.DNSNames, certOpts.IPAddresses,
+		certOpts.RequestID)
+	if err != nil {
+		return nil, raerror.NewError(raerror.CSRError, fmt.Errorf(
+			"unable to validate SAN Identities in CSR: %v", err))
+	}
+	if err := validateTrustDomains(raOpts, identities); err != nil {
+		return nil, raerror.NewError(raerror.CSRError, err)
+	}
+	if err := validateRequesterNamespace(raOpts, identities, certOpts.RequesterNamespace); err != nil {
+		return nil, raerror.NewError(raerror.CSRError, err)
+	}
+	if certOpts.ForCA {
+		if err := validateCAIdentities(raOpts, identities); err != nil {
+			return nil, raerror.NewError(raerror.CSRError, fmt.Errorf("unable to generate CA certificates: %v", err))
 		}
 	}
+	lifetime, err := requestedLifetime(certOpts, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	lifetime, err = clampLifetime(raOpts, lifetime, certOpts.RequestID)
+	if err != nil {
+		return nil, err
+	}
+	return &validatedRequest{
+		csr:                  csr,
+		identities:           identities,
+		subjectIDs:           certOpts.SubjectIDs,
+		lifetime:             profileLifetime(profile, lifetime),
+		profile:              profile,
+		strippedExtKeyUsages: strippedExtKeyUsages,
+		requestID:            certOpts.RequestID,
+		selectedChain:        selectedChain,
+	}, nil
+}
+
+// parsedCSRMatches returns whether csr was parsed from csrPEM. Only the PEM encoding is decoded, which is
+// cheap compared to parsing the CSR again.
+func parsedCSRMatches(csr *x509.CertificateRequest, csrPEM []byte) bool {
+	pb := getPEMBuffer()
+	defer putPEMBuffer(pb)
+	block, _, ok := pb.decode(csrPEM)
+	return ok && bytes.Equal(block.der, csr.Raw)
+}
+
+// runPreSignHook calls the PreSignHook, if any, for csrPEM validated as req.
+func runPreSignHook(ctx context.Context, raOpts *IstioRAOptions, csrPEM []byte, certOpts ca.CertOpts,
+	req *validatedRequest) error {
+	if raOpts.PreSignHook == nil {
+		return nil
+	}
+	identities := make([]Identity, 0, len(req.identities))
+	for _, id := range req.identities {
+		identities = append(identities, Identity{Type: id.idType, Value: id.value})
+	}
+	if err := raOpts.PreSignHook(ctx, csrPEM, certOpts, identities); err != nil {
+		return raerror.NewError(raerror.Unauthorized, fmt.Errorf("signing is not authorized: %w", err))
+	}
+	return nil
+}
+
+// signCheckingClockSkew returns the certificate issued by sign for the request requestID, labeled signer in
+// metrics, after checking that it is already valid within the ClockSkewTolerance. With RejectClockSkewedCerts,
+// a skewed certificate is signed again once, and rejected if still skewed.
+func signCheckingClockSkew(raOpts *IstioRAOptions, signer, requestID string,
+	sign func() (*SignResult, error)) (*SignResult, error) {
+	result, err := sign()
+	if err != nil || !clockSkewed(raOpts, signer, requestID, result, time.Now()) || !raOpts.RejectClockSkewedCerts {
+		return result, err
+	}
+	if result, err = sign(); err != nil {
+		return nil, err
+	}
+	if clockSkewed(raOpts, signer, requestID, result, time.Now()) {
+		return nil, raerror.NewError(raerror.CertGenError, fmt.Errorf(
+			"signer %s issued a certificate that is not valid before %v, check the clocks of the signer and the RA",
+			result.CertSigner, result.NotBefore))
+	}
+	return result, nil
+}
+
+// clockSkewed returns whether result is not valid at now beyond the ClockSkewTolerance, and then records it.
+func clockSkewed(raOpts *IstioRAOptions, signer, requestID string, result *SignResult, now time.Time) bool {
+	tolerance := raOpts.ClockSkewTolerance
+	if tolerance <= 0 {
+		tolerance = DefaultClockSkewTolerance
+	}
+	skew := result.NotBefore.Sub(now)
+	if skew <= tolerance {
+		return false
+	}
+	requestLog(requestID).Warnf("certificate issued by signer %s is not valid for another %v, the clocks of the "+
+		"signer and the RA may be skewed", result.CertSigner, skew)
+	clockSkewCounts.With(signerTag.Value(signer)).Increment()
 	return true
 }
 
-// NewIstioRA is a factory method that returns an RA that implements the RegistrationAuthority functionality.
-// the caOptions defines the external provider
-func NewIstioRA(opts *IstioRAOptions) (RegistrationAuthority, error) {
-	if opts.ExternalCAType == ExtCAK8s {
-		istioRA, err := NewKubernetesRA(opts)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create an K8s CA: %v", err)
+// checkCertChainDepth records the number of certificates in the chain of result, issued for the request requestID
+// and labeled signer in metrics, and logs chains outside the MinCertChainDepth and MaxCertChainDepth of raOpts.
+func checkCertChainDepth(raOpts *IstioRAOptions, signer, requestID string, result *SignResult) {
+	depth := certChainDepth(result.CertChainPEM)
+	certChainDepths.With(signerTag.Value(signer)).Record(float64(depth))
+	if raOpts.MinCertChainDepth > 0 && depth < raOpts.MinCertChainDepth {
+		requestLog(requestID).Warnf("signer %s returned a cert chain of %d certificates, expected at least %d: "+
+			"the chain of its issuer may not be configured", result.CertSigner, depth, raOpts.MinCertChainDepth)
+	} else if raOpts.MaxCertChainDepth > 0 && depth > raOpts.MaxCertChainDepth {
+		requestLog(requestID).Warnf("signer %s returned a cert chain of %d certificates, expected at most %d",
+			result.CertSigner, depth, raOpts.MaxCertChainDepth)
+	}
+}
+
+// certChainDepth returns the number of certificates in chainPEM.
+func certChainDepth(chainPEM []byte) int {
+	pb := getPEMBuffer()
+	defer putPEMBuffer(pb)
+	depth := 0
+	for block, rest, ok := pb.decode(chainPEM); ok; block, rest, ok = pb.decode(rest) {
+		if bytes.Equal(block.typ, certificateBlock) {
+			depth++
 		}
-		return istioRA, err
 	}
-	return nil, fmt.Errorf("invalid CA Name %s", opts.ExternalCAType)
+	return depth
 }
 
-// preSign : Validation checks to execute before signing certificates
-func preSign(raOpts *IstioRAOptions, csrPEM []byte, subjectIDs []string, requestedLifetime time.Duration, forCA bool) (time.Duration, error) {
-	if forCA {
-		return requestedLifetime, raerror.NewError(raerror.CSRError,
-			fmt.Errorf("unable to generate CA certifificates"))
+// newSignResult checks the certificate certPEM issued by certSigner for req, and returns it followed by its
+// chain. Certificates failing the checks are logged and recorded with signerLabel as the signer label value.
+// chainPEM returns the chain to append for the parsed certificate. A missing chain is only logged once per signer
+// in missingCertChainSigners, the signers of the RA for which it has been logged.
+func newSignResult(raOpts *IstioRAOptions, req *validatedRequest, certPEM []byte,
+	chainPEM func(leafCert *x509.Certificate) ([]byte, error), certSigner, signerLabel string,
+	missingCertChainSigners *sync.Map) (*SignResult, error) {
+	leafCert, err := util.ParsePemEncodedCertificate(certPEM)
+	if err != nil {
+		postSignValidationFailureCounts.With(validationTag.Value(validationParse), signerTag.Value(signerLabel)).Increment()
+		requestLog(req.requestID).Errorf("signer %s issued a certificate that cannot be parsed: %v", certSigner, err)
+		return nil, raerror.NewError(raerror.CertGenError, fmt.Errorf("failed to parse the issued certificate: %v", err))
+	}
+	// rejected records leafCert failing validation with err, which the signer should not have issued.
+	rejected := func(validation string, err error) {
+		postSignValidationFailureCounts.With(validationTag.Value(validation), signerTag.Value(signerLabel)).Increment()
+		requestLog(req.requestID).Errorf("signer %s issued certificate %s with subject %q failing %s validation: %v",
+			certSigner, leafCert.SerialNumber, leafCert.Subject, validation, err)
+	}
+	if raOpts.VerifyIssuedCert == nil || *raOpts.VerifyIssuedCert {
+		for _, check := range []struct {
+			validation string
+			validate   func() error
+		}{
+			{validationKey, func() error { return validateIssuedKey(req.csr, leafCert) }},
+			{validationSAN, func() error { return validateIssuedSANs(req.identities, leafCert) }},
+			{validationSubject, func() error { return validateSubject(raOpts, leafCert.Subject) }},
+			{validationExtKeyUsage, func() error {
+				if err := checkExtKeyUsages(leafCert, req.extKeyUsages); err != nil {
+					return err
+				}
+				return checkStrippedExtKeyUsages(leafCert, req.strippedExtKeyUsages)
+			}},
+		} {
+			if err := check.validate(); err != nil {
+				rejected(check.validation, err)
+				return nil, raerror.NewError(raerror.CertGenError, fmt.Errorf("signer %s issued an invalid certificate: %v", certSigner, err))
+			}
+		}
+	}
+	for i, validator := range raOpts.PostSignValidators {
+		if err := validator(leafCert); err != nil {
+			rejected(validationCustom, err)
+			return nil, raerror.NewError(raerror.CertGenError, fmt.Errorf(
+				"certificate issued by signer %s rejected by post-sign validator %d: %v", certSigner, i, err))
+		}
+	}
+	var chain []byte
+	if req.selectedChain != nil {
+		chain, err = selectedCertChain(req.selectedChain, certPEM, leafCert)
+	} else {
+		chain, err = currentCertChain(raOpts, req.requestID, certSigner, certPEM, leafCert, chainPEM)
+	}
+	if err != nil {
+		return nil, raerror.NewError(raerror.CertGenError, fmt.Errorf("signer %s: %v", certSigner, err))
+	}
+	certPEM = normalizePEM(raOpts.PEMNormalization, certPEM)
+	certChainPEM := append([]byte{}, certPEM...)
+	if len(chain) > 0 {
+		certChainPEM = normalizePEM(raOpts.PEMNormalization, append(certChainPEM, chain...))
+	} else if raOpts.RequireCertChain {
+		return nil, raerror.NewError(raerror.CertGenError, fmt.Errorf("no cert chain is configured for signer %s", certSigner))
+	} else if _, warned := missingCertChainSigners.LoadOrStore(certSigner, struct{}{}); !warned {
+		requestLog(req.requestID).Warnf("no cert chain is configured for signer %s, returning issued certificates without it", certSigner)
+	}
+	now := time.Now()
+	return &SignResult{
+		CertPEM:             certPEM,
+		CertChainPEM:        certChainPEM,
+		NotBefore:           leafCert.NotBefore,
+		NotAfter:            leafCert.NotAfter,
+		SerialNumber:        leafCert.SerialNumber,
+		SerialNumberDecimal: leafCert.SerialNumber.String(),
+		SerialNumberHex:     fmt.Sprintf("%X", leafCert.SerialNumber),
+		CertSigner:          certSigner,
+		EffectiveTTL: jitterTTL(effectiveTTL(req.lifetime, leafCert.NotBefore, leafCert.NotAfter, now),
+			raOpts.TTLJitter, rand.Float64()),
+		RenewAt: renewAt(raOpts.renewalFraction(), leafCert.NotBefore, leafCert.NotAfter, now),
+	}, nil
+}
+
+// validateRAOptions checks the options of raOpts that are common to all RAs.
+func validateRAOptions(raOpts *IstioRAOptions) error {
+	if err := validateTTLJitter(raOpts); err != nil {
+		return err
+	}
+	if err := validateSANValidationMode(raOpts); err != nil {
+		return err
+	}
+	if err := validateCertChainDepth(raOpts); err != nil {
+		return err
+	}
+	if err := validateCertChainMismatchPolicy(raOpts); err != nil {
+		return err
+	}
+	if err := validatePEMNormalization(raOpts); err != nil {
+		return err
+	}
+	if err := validateAllowedEKUs(raOpts); err != nil {
+		return err
+	}
+	if err := validateCSRUsageConflictMode(raOpts); err != nil {
+		return err
+	}
+	if err := validateIPSANOptions(raOpts); err != nil {
+		return err
+	}
+	if err := validateNamespacePolicies(raOpts); err != nil {
+		return err
+	}
+	if err := validateCSRConcurrency(raOpts); err != nil {
+		return err
 	}
-	if !ValidateCSR(csrPEM, subjectIDs) {
-		return requestedLifetime, raerror.NewError(raerror.CSRError, fmt.Errorf(
-			"unable to validate SAN Identities in CSR"))
+	if err := validateCircuitBreaker(raOpts); err != nil {
+		return err
 	}
-	// If the requested requestedLifetime is non-positive, apply the default TTL.
+	if err := validateDuplicateSubjectIDMode(raOpts); err != nil {
+		return err
+	}
+	if err := validateNotBeforeBackdate(raOpts); err != nil {
+		return err
+	}
+	if err := validateNamedCertChains(raOpts); err != nil {
+		return err
+	}
+	if err := validateRootExpiry(raOpts); err != nil {
+		return err
+	}
+	return validateRenewalFraction(raOpts)
+}
+
+// validateCertChainDepth checks that the MinCertChainDepth and MaxCertChainDepth of raOpts are a range, or unset.
+func validateCertChainDepth(raOpts *IstioRAOptions) error {
+	if raOpts.MinCertChainDepth < 0 || raOpts.MaxCertChainDepth < 0 {
+		return raerror.NewError(raerror.CAInitFail, fmt.Errorf("cert chain depths %d and %d cannot be negative",
+			raOpts.MinCertChainDepth, raOpts.MaxCertChainDepth))
+	}
+	if raOpts.MaxCertChainDepth > 0 && raOpts.MinCertChainDepth > raOpts.MaxCertChainDepth {
+		return raerror.NewError(raerror.CAInitFail, fmt.Errorf("minimum cert chain depth %d is above the maximum %d",
+			raOpts.MinCertChainDepth, raOpts.MaxCertChainDepth))
+	}
+	return nil
+}
+
+// validateTTLJitter checks that the TTLJitter of raOpts is a fraction in [0, 1).
+func validateTTLJitter(raOpts *IstioRAOptions) error {
+	if raOpts.TTLJitter < 0 || raOpts.TTLJitter >= 1 {
+		return raerror.NewError(raerror.CAInitFail, fmt.Errorf("TTL jitter %v is not in [0, 1)", raOpts.TTLJitter))
+	}
+	return nil
+}
+
+// validateRenewalFraction checks that the RenewalFraction of raOpts is a fraction in (0, 1), or unset.
+func validateRenewalFraction(raOpts *IstioRAOptions) error {
+	if raOpts.RenewalFraction < 0 || raOpts.RenewalFraction >= 1 {
+		return raerror.NewError(raerror.CAInitFail, fmt.Errorf("renewal fraction %v is not in (0, 1)", raOpts.RenewalFraction))
+	}
+	return nil
+}
+
+// renewalFraction returns the RenewalFraction, or its default.
+func (o *IstioRAOptions) renewalFraction() float64 {
+	if o.RenewalFraction > 0 {
+		return o.RenewalFraction
+	}
+	return DefaultRenewalFraction
+}
+
+// renewAt returns when to renew a certificate valid from notBefore to notAfter, issued at now: after fraction of
+// its lifetime. Signers backdate the NotBefore of certificates against clock skew, by more than that fraction of
+// short lifetimes, and renewal is then due after fraction of the lifetime remaining at now instead.
+func renewAt(fraction float64, notBefore, notAfter, now time.Time) time.Time {
+	if at := notBefore.Add(time.Duration(float64(notAfter.Sub(notBefore)) * fraction)); at.After(now) {
+		return at
+	}
+	if !notAfter.After(now) {
+		return notAfter
+	}
+	return now.Add(time.Duration(float64(notAfter.Sub(now)) * fraction))
+}
+
+// jitterTTL returns ttl reduced by the fraction r in [0, 1) of jitter.
+func jitterTTL(ttl time.Duration, jitter, r float64) time.Duration {
+	if jitter <= 0 {
+		return ttl
+	}
+	return ttl - time.Duration(float64(ttl)*jitter*r)
+}
+
+// effectiveTTL returns the lifetime at now of a certificate requested with lifetime and valid from notBefore
+// to notAfter. Signers may issue certificates for less than the requested lifetime, which is then
+// derived from notAfter.
+func effectiveTTL(lifetime time.Duration, notBefore, notAfter, now time.Time) time.Duration {
+	if notAfter.Sub(notBefore) >= lifetime {
+		return lifetime
+	}
+	if remaining := notAfter.Sub(now); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// clampLifetime returns the lifetime to request for a certificate: the default TTL if requestedLifetime
+// is non-positive, clamped to MaxCertTTL. Lifetimes shorter than MinCertTTL are rejected.
+func clampLifetime(raOpts *IstioRAOptions, requestedLifetime time.Duration, requestID string) (time.Duration, error) {
 	lifetime := requestedLifetime
-	if requestedLifetime.Seconds() <= 0 {
+	if lifetime <= 0 {
 		lifetime = raOpts.DefaultCertTTL
 	}
-	// If the requested TTL is greater than maxCertTTL, return an error
-	if requestedLifetime.Seconds() > raOpts.MaxCertTTL.Seconds() {
+	if raOpts.MaxCertTTL > 0 && lifetime > raOpts.MaxCertTTL {
+		requestLog(requestID).Warnf("requested TTL %s is greater than the max allowed TTL %s, clamping it", lifetime, raOpts.MaxCertTTL)
+		lifetime = raOpts.MaxCertTTL
+	}
+	// A zero lifetime leaves the lifetime to the signer.
+	if minCertTTL := raOpts.minCertTTL(); lifetime > 0 && lifetime < minCertTTL {
 		return lifetime, raerror.NewError(raerror.TTLError, fmt.Errorf(
-			"requested TTL %s is greater than the max allowed TTL %s", requestedLifetime, raOpts.MaxCertTTL))
+			"requested TTL %s is less than the min allowed TTL %s", lifetime, minCertTTL))
 	}
 	return lifetime, nil
 }
+
+func (o *IstioRAOptions) minCertTTL() time.Duration {
+	if o.MinCertTTL > 0 {
+		return o.MinCertTTL
+	}
+	return DefaultMinCertTTL
+}
@@ -0,0 +1,100 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	raerror "istio.io/istio/security/pkg/pki/error"
+)
+
+// CSRConcurrencyMode is what signing requests do once MaxConcurrentCSRs CSRs are outstanding.
+type CSRConcurrencyMode string
+
+const (
+	// CSRConcurrencyBlock : Requests wait for an outstanding CSR to complete, until their context is done
+	CSRConcurrencyBlock CSRConcurrencyMode = "Block"
+	// CSRConcurrencyReject : Requests fail at once with a RateLimited error wrapping ErrTooManyCSRs
+	CSRConcurrencyReject CSRConcurrencyMode = "Reject"
+)
+
+// ErrTooManyCSRs is wrapped by the RateLimited errors of the signing requests rejected because MaxConcurrentCSRs
+// CSRs were outstanding, with CSRConcurrencyReject.
+var ErrTooManyCSRs = errors.New("too many outstanding CSRs")
+
+// validateCSRConcurrency checks that the MaxConcurrentCSRs of raOpts is not negative, and that its
+// CSRConcurrencyMode is known.
+func validateCSRConcurrency(raOpts *IstioRAOptions) error {
+	if raOpts.MaxConcurrentCSRs < 0 {
+		return raerror.NewError(raerror.CAInitFail, fmt.Errorf("maximum number of concurrent CSRs %d cannot be negative",
+			raOpts.MaxConcurrentCSRs))
+	}
+	switch raOpts.CSRConcurrencyMode {
+	case "", CSRConcurrencyBlock, CSRConcurrencyReject:
+		return nil
+	}
+	return raerror.NewError(raerror.CAInitFail, fmt.Errorf("unknown CSR concurrency mode %q", raOpts.CSRConcurrencyMode))
+}
+
+// csrLimiter caps the number of CSRs outstanding at once across all the signing requests of an RA, to protect
+// the K8s API server. A nil csrLimiter does not limit them, but still counts them. It is safe for concurrent use.
+type csrLimiter struct {
+	// slots holds a token for each outstanding CSR.
+	slots  chan struct{}
+	reject bool
+}
+
+// newCSRLimiter returns the CSR limiter of raOpts, or nil if the CSRs outstanding are not limited.
+func newCSRLimiter(raOpts *IstioRAOptions) *csrLimiter {
+	if raOpts.MaxConcurrentCSRs <= 0 {
+		return nil
+	}
+	return &csrLimiter{
+		slots:  make(chan struct{}, raOpts.MaxConcurrentCSRs),
+		reject: raOpts.CSRConcurrencyMode == CSRConcurrencyReject,
+	}
+}
+
+// acquire registers an outstanding CSR once there are fewer than the maximum, waiting until ctx is done for one to
+// complete, or failing at once with CSRConcurrencyReject. release must be called once the CSR completes.
+func (l *csrLimiter) acquire(ctx context.Context) error {
+	if l != nil {
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			if l.reject {
+				return raerror.NewError(raerror.RateLimited, fmt.Errorf("%w: %d CSRs are in flight", ErrTooManyCSRs, cap(l.slots)))
+			}
+			select {
+			case l.slots <- struct{}{}:
+			case <-ctx.Done():
+				return raerror.NewError(raerror.RequestCanceled, fmt.Errorf("waiting for an outstanding CSR to complete: %w",
+					ctx.Err()))
+			}
+		}
+	}
+	recordOutstandingCSRs(1)
+	return nil
+}
+
+// release unregisters an outstanding CSR registered by acquire.
+func (l *csrLimiter) release() {
+	recordOutstandingCSRs(-1)
+	if l != nil {
+		<-l.slots
+	}
+}
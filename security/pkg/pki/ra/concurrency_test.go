@@ -0,0 +1,129 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+
+	"istio.io/istio/pkg/test/util/retry"
+	"istio.io/istio/security/pkg/pki/ca"
+	raerror "istio.io/istio/security/pkg/pki/error"
+)
+
+func TestCSRLimiter(t *testing.T) {
+	outstanding := func() float64 {
+		return getMetricValue(t, "ra_outstanding_csr_count", nil)
+	}
+	var raErr *raerror.Error
+
+	if limiter := newCSRLimiter(&IstioRAOptions{}); limiter != nil {
+		t.Fatalf("expected no CSR limiter without a maximum, got %v", limiter)
+	}
+	var unlimited *csrLimiter
+	if err := unlimited.acquire(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := outstanding(); got != 1 {
+		t.Errorf("got %v outstanding CSRs, want the unlimited CSR counted", got)
+	}
+	unlimited.release()
+
+	limiter := newCSRLimiter(&IstioRAOptions{MaxConcurrentCSRs: 1, CSRConcurrencyMode: CSRConcurrencyReject})
+	if err := limiter.acquire(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err := limiter.acquire(context.Background())
+	if !errors.As(err, &raErr) || raErr.ErrorType() != "RATE_LIMITED" || !errors.Is(err, ErrTooManyCSRs) {
+		t.Errorf("expected a RATE_LIMITED error wrapping ErrTooManyCSRs beyond the maximum, got: %v", err)
+	}
+	if got := outstanding(); got != 1 {
+		t.Errorf("got %v outstanding CSRs, want 1", got)
+	}
+	limiter.release()
+	if err := limiter.acquire(context.Background()); err != nil {
+		t.Errorf("expected a CSR to be allowed once the outstanding one completed, got: %v", err)
+	}
+	limiter.release()
+
+	limiter = newCSRLimiter(&IstioRAOptions{MaxConcurrentCSRs: 1})
+	if err := limiter.acquire(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := limiter.acquire(ctx); !errors.As(err, &raErr) || raErr.ErrorType() != "REQUEST_CANCELED" {
+		t.Errorf("expected a REQUEST_CANCELED error once the context is done, got: %v", err)
+	}
+	time.AfterFunc(50*time.Millisecond, limiter.release)
+	if err := limiter.acquire(context.Background()); err != nil {
+		t.Errorf("expected the blocked CSR to be allowed once the outstanding one completed, got: %v", err)
+	}
+	limiter.release()
+	if got := outstanding(); got != 0 {
+		t.Errorf("got %v outstanding CSRs, want 0", got)
+	}
+
+	if err := validateRAOptions(&IstioRAOptions{MaxConcurrentCSRs: -1}); err == nil {
+		t.Errorf("expected an error for a negative maximum number of concurrent CSRs")
+	}
+	if err := validateRAOptions(&IstioRAOptions{CSRConcurrencyMode: "Queue"}); err == nil {
+		t.Errorf("expected an error for an unknown CSR concurrency mode")
+	}
+}
+
+func TestSignMaxConcurrentCSRs(t *testing.T) {
+	r, err := NewKubernetesRA(&IstioRAOptions{
+		ExternalCAType:     ExtCAK8s,
+		CaSigner:           "kubernates.io/kube-apiserver-client",
+		ApprovalTimeout:    time.Second,
+		MaxConcurrentCSRs:  1,
+		CSRConcurrencyMode: CSRConcurrencyReject,
+		K8sClient:          fake.NewSimpleClientset(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	certOpts := ca.CertOpts{SubjectIDs: []string{testCsrHostName}, TTL: time.Hour}
+	var raErr *raerror.Error
+
+	// the CSRs are never issued by the fake client, so the first one is outstanding until the approval timeout
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := r.Sign(createFakeCsr(t), certOpts)
+		firstErr <- err
+	}()
+	retry.UntilSuccessOrFail(t, func() error {
+		if len(r.csrLimiter.slots) != 1 {
+			return fmt.Errorf("the first CSR is not outstanding")
+		}
+		return nil
+	}, retry.Timeout(time.Second))
+	_, err = r.Sign(createFakeCsr(t), certOpts)
+	if !errors.As(err, &raErr) || raErr.ErrorType() != "RATE_LIMITED" || !errors.Is(err, ErrTooManyCSRs) {
+		t.Errorf("expected a RATE_LIMITED error wrapping ErrTooManyCSRs beyond the maximum, got: %v", err)
+	}
+	if err := <-firstErr; !errors.As(err, &raErr) || raErr.ErrorType() != "CSR_PENDING" {
+		t.Errorf("expected the first request to be signed, got: %v", err)
+	}
+	if len(r.csrLimiter.slots) != 0 {
+		t.Errorf("expected no CSR outstanding once the requests completed")
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"encoding/hex"
+
+	"k8s.io/apimachinery/pkg/util/rand"
+
+	"istio.io/istio/security/pkg/pki/ca"
+)
+
+const (
+	// csrNamePrefix is the prefix of the CSR names generated by DefaultCSRName.
+	csrNamePrefix = "csr-workload-"
+	// csrNameHashLength is the number of hex characters of the request hash in CSR names.
+	csrNameHashLength = 16
+	// csrNameSuffixLength is the length of the random suffix of CSR names.
+	csrNameSuffixLength = 5
+)
+
+// CSRNameFunc generates the name of the K8s CSR object created to sign csrPEM. It is called again with
+// the same arguments when the generated name already exists, so it should not be fully deterministic.
+// Names must be valid DNS subdomains, which is checked before creating the CSR.
+type CSRNameFunc func(csrPEM []byte, certOpts ca.CertOpts) string
+
+// DefaultCSRName generates CSR names of the form csr-workload-<hash>-<suffix>, where <hash> is the
+// first 16 hex characters of the SHA-256 of csrPEM and the requested SubjectIDs, and <suffix> is 5 random
+// alphanumeric characters. CSRs created for the same request thus share the csr-workload-<hash> prefix,
+// while retries do not collide. The names are 35 characters long and valid DNS subdomains.
+func DefaultCSRName(csrPEM []byte, certOpts ca.CertOpts) string {
+	rh := getRequestHasher()
+	defer putRequestHasher(rh)
+	rh.writeBytes(csrPEM)
+	// The separators keep different splits of the same bytes from hashing alike.
+	for _, subjectID := range certOpts.SubjectIDs {
+		rh.writeString("\x00")
+		rh.writeString(subjectID)
+	}
+	if len(certOpts.SubjectIDs) == 0 {
+		rh.writeString("\x00")
+	}
+	hash := hex.EncodeToString(rh.sumPrefix(csrNameHashLength / 2))
+	return csrNamePrefix + hash + "-" + rand.String(csrNameSuffixLength)
+}
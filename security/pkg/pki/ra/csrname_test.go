@@ -0,0 +1,114 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	cert "k8s.io/api/certificates/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes/fake"
+	kt "k8s.io/client-go/testing"
+
+	"istio.io/istio/security/pkg/pki/ca"
+)
+
+func TestDefaultCSRName(t *testing.T) {
+	csrPEM := createFakeCsr(t)
+	opts := ca.CertOpts{SubjectIDs: []string{testCsrHostName}}
+	name := DefaultCSRName(csrPEM, opts)
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+		t.Fatalf("invalid CSR name %q: %v", name, errs)
+	}
+	if len(name) != len(csrNamePrefix)+csrNameHashLength+1+csrNameSuffixLength {
+		t.Errorf("unexpected length of CSR name %q", name)
+	}
+	prefix := name[:len(name)-csrNameSuffixLength]
+
+	again := DefaultCSRName(csrPEM, opts)
+	if !strings.HasPrefix(again, prefix) {
+		t.Errorf("expected CSR names of the same request to share the prefix %q, got %q", prefix, again)
+	}
+	if again == name {
+		t.Errorf("expected CSR names of the same request to differ, got %q twice", name)
+	}
+	otherIdentity := DefaultCSRName(csrPEM, ca.CertOpts{SubjectIDs: []string{"other"}})
+	if strings.HasPrefix(otherIdentity, prefix) {
+		t.Errorf("expected CSR names of different identities to differ, got %q and %q", name, otherIdentity)
+	}
+	otherCSR := DefaultCSRName(createFakeCsr(t), opts)
+	if strings.HasPrefix(otherCSR, prefix) {
+		t.Errorf("expected CSR names of different CSRs to differ, got %q and %q", name, otherCSR)
+	}
+}
+
+func TestCSRNameFunc(t *testing.T) {
+	testCases := map[string]struct {
+		csrNameFunc   CSRNameFunc
+		expectedName  string
+		expectCreated bool
+	}{
+		"custom name": {
+			csrNameFunc:   func([]byte, ca.CertOpts) string { return "custom-csr" },
+			expectedName:  "custom-csr",
+			expectCreated: true,
+		},
+		"invalid name": {
+			csrNameFunc: func([]byte, ca.CertOpts) string { return "Invalid_Name" },
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			var mutex sync.Mutex
+			var created []string
+			client.PrependReactor("create", "certificatesigningrequests", func(action kt.Action) (bool, runtime.Object, error) {
+				mutex.Lock()
+				defer mutex.Unlock()
+				created = append(created, action.(kt.CreateAction).GetObject().(*cert.CertificateSigningRequest).Name)
+				return false, nil, nil
+			})
+			r, err := createFakeK8sRA(client)
+			if err != nil {
+				t.Fatalf("Failed to create Fake K8s RA: %v", err)
+			}
+			r.raOpts.CSRNameFunc = tc.csrNameFunc
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			if _, err := r.SignContext(ctx, createFakeCsr(t), ca.CertOpts{
+				SubjectIDs: []string{testCsrHostName},
+				TTL:        time.Minute,
+			}); err == nil {
+				t.Fatalf("expected signing to fail")
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			if !tc.expectCreated {
+				if len(created) > 0 {
+					t.Fatalf("expected no CSR to be created, got %v", created)
+				}
+				return
+			}
+			if len(created) != 1 || created[0] != tc.expectedName {
+				t.Fatalf("expected CSR %q to be created, got %v", tc.expectedName, created)
+			}
+		})
+	}
+}
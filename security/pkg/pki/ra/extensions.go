@@ -0,0 +1,50 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"fmt"
+	"net/url"
+
+	"istio.io/istio/security/pkg/pki/ca"
+	raerror "istio.io/istio/security/pkg/pki/error"
+)
+
+// validateIssuingCertificateURLs checks that the IssuingCertificateURLs of certOpts are absolute HTTP(S) URLs.
+func validateIssuingCertificateURLs(certOpts ca.CertOpts) error {
+	for _, rawURL := range certOpts.IssuingCertificateURLs {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return fmt.Errorf("invalid issuing certificate URL %q: %v", rawURL, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("issuing certificate URL %q is not an absolute HTTP(S) URL", rawURL)
+		}
+	}
+	return nil
+}
+
+// rejectUnsupportedExtensions returns a CSRError if certOpts requests the OCSP must-staple flag or issuing
+// certificate URLs, which backend cannot request per certificate. They are rejected before anything is submitted
+// to backend, rather than ignored or checked once the certificate is issued.
+func rejectUnsupportedExtensions(certOpts ca.CertOpts, backend string) error {
+	if certOpts.MustStaple {
+		return raerror.NewError(raerror.CSRError, fmt.Errorf("%s cannot request the OCSP must-staple flag", backend))
+	}
+	if len(certOpts.IssuingCertificateURLs) > 0 {
+		return raerror.NewError(raerror.CSRError, fmt.Errorf("%s cannot request issuing certificate URLs", backend))
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"errors"
+	"testing"
+
+	"istio.io/istio/security/pkg/pki/ca"
+	raerror "istio.io/istio/security/pkg/pki/error"
+)
+
+func TestValidateIssuingCertificateURLs(t *testing.T) {
+	testCases := map[string]struct {
+		urls       []string
+		expectFail bool
+	}{
+		"no URLs":       {},
+		"HTTP URLs":     {urls: []string{"http://ca.example.com/ca.crt", "https://ca.example.com/ca.crt"}},
+		"relative URL":  {urls: []string{"/ca.crt"}, expectFail: true},
+		"other scheme":  {urls: []string{"ldap://ca.example.com/ca.crt"}, expectFail: true},
+		"malformed URL": {urls: []string{"http://ca example.com/ca.crt"}, expectFail: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := validateIssuingCertificateURLs(ca.CertOpts{IssuingCertificateURLs: tc.urls})
+			if tc.expectFail != (err != nil) {
+				t.Errorf("got error %v, expected failure: %v", err, tc.expectFail)
+			}
+		})
+	}
+}
+
+func TestRejectUnsupportedExtensions(t *testing.T) {
+	testCases := map[string]struct {
+		certOpts   ca.CertOpts
+		expectFail bool
+	}{
+		"no extensions":            {},
+		"must-staple":              {certOpts: ca.CertOpts{MustStaple: true}, expectFail: true},
+		"issuing certificate URLs": {certOpts: ca.CertOpts{IssuingCertificateURLs: []string{"http://ca.example.com/ca.crt"}}, expectFail: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := rejectUnsupportedExtensions(tc.certOpts, "backend")
+			var raErr *raerror.Error
+			if tc.expectFail != (err != nil) || (err != nil && (!errors.As(err, &raErr) || raErr.ErrorType() != "CSR_ERROR")) {
+				t.Errorf("got error %v, expected a CSR_ERROR: %v", err, tc.expectFail)
+			}
+		})
+	}
+}
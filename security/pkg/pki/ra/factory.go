@@ -0,0 +1,145 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"errors"
+	"fmt"
+
+	raerror "istio.io/istio/security/pkg/pki/error"
+)
+
+// RABackend is the implementation of the RA created by NewRA.
+type RABackend string
+
+const (
+	// RABackendKubernetes : Sign with a K8s signer using the CSR API, as the KubernetesRA
+	RABackendKubernetes RABackend = "kubernetes"
+	// RABackendCertManager : Sign with a cert-manager issuer using the CertificateRequest API, as the CertManagerRA
+	RABackendCertManager RABackend = "cert-manager"
+	// RABackendVault : Sign with a role of the PKI secrets engine of Vault, as the VaultRA
+	RABackendVault RABackend = "vault"
+)
+
+// ErrUnknownBackend is wrapped by the CAIllegalConfig errors of NewRA for backends it does not implement.
+var ErrUnknownBackend = errors.New("unknown RA backend")
+
+// RAConfig : Configuration of the RA created by NewRA
+type RAConfig struct {
+	// Backend : The implementation of the RA
+	Backend RABackend
+	// Options : The options of the RA. Its ExternalCAType defaults to that of Backend, and must match it if set
+	Options *IstioRAOptions
+}
+
+// raBackend creates the RAs of a RABackend.
+type raBackend struct {
+	// name is the name of the backend in the errors of NewIstioRA.
+	name           string
+	externalCAType CaExternalType
+	// validate checks that the options required by the backend are set.
+	validate func(raOpts *IstioRAOptions) error
+	create   func(raOpts *IstioRAOptions) (RegistrationAuthority, error)
+}
+
+// raBackends are the backends of NewRA and NewIstioRA.
+var raBackends = map[RABackend]raBackend{
+	RABackendKubernetes: {
+		name:           "Kubernetes",
+		externalCAType: ExtCAK8s,
+		validate: func(raOpts *IstioRAOptions) error {
+			if raOpts.K8sClient == nil && raOpts.ClientForSigner == nil {
+				return fmt.Errorf("a K8s client is required")
+			}
+			if raOpts.CaSigner == "" && raOpts.CertSignerDomain == "" {
+				return fmt.Errorf("a CA signer or a signer domain for the requested signers is required")
+			}
+			return nil
+		},
+		create: func(raOpts *IstioRAOptions) (RegistrationAuthority, error) {
+			return NewKubernetesRA(raOpts)
+		},
+	},
+	RABackendCertManager: {
+		name:           "cert-manager",
+		externalCAType: ExtCACertManager,
+		validate: func(raOpts *IstioRAOptions) error {
+			if raOpts.DynamicClient == nil {
+				return fmt.Errorf("a dynamic client is required")
+			}
+			if raOpts.CertManagerIssuer.Name == "" || raOpts.CertManagerNamespace == "" {
+				return fmt.Errorf("a cert-manager issuer and the namespace of its CertificateRequests are required")
+			}
+			return nil
+		},
+		create: func(raOpts *IstioRAOptions) (RegistrationAuthority, error) {
+			return NewCertManagerRA(raOpts)
+		},
+	},
+	RABackendVault: {
+		name:           "Vault",
+		externalCAType: ExtCAVault,
+		validate: func(raOpts *IstioRAOptions) error {
+			if raOpts.Vault.Address == "" || raOpts.Vault.Role == "" || raOpts.Vault.AuthRole == "" {
+				return fmt.Errorf("a Vault address, PKI role and Kubernetes auth role are required")
+			}
+			return nil
+		},
+		create: func(raOpts *IstioRAOptions) (RegistrationAuthority, error) {
+			return NewVaultRA(raOpts)
+		},
+	},
+}
+
+// NewRA returns an RA of the Backend of config, once the options required by the backend are checked. Unknown
+// backends and missing options are CAIllegalConfig errors, wrapping ErrUnknownBackend for the former.
+func NewRA(config RAConfig) (RegistrationAuthority, error) {
+	backend, ok := raBackends[config.Backend]
+	if !ok {
+		return nil, raerror.NewError(raerror.CAIllegalConfig, fmt.Errorf("%w %q", ErrUnknownBackend, config.Backend))
+	}
+	raOpts := config.Options
+	if raOpts == nil {
+		raOpts = &IstioRAOptions{}
+	}
+	switch raOpts.ExternalCAType {
+	case "":
+		raOpts.ExternalCAType = backend.externalCAType
+	case backend.externalCAType:
+	default:
+		return nil, raerror.NewError(raerror.CAIllegalConfig, fmt.Errorf("external CA type %s does not match RA backend %s",
+			raOpts.ExternalCAType, config.Backend))
+	}
+	if err := backend.validate(raOpts); err != nil {
+		return nil, raerror.NewError(raerror.CAIllegalConfig, fmt.Errorf("invalid options for RA backend %s: %v", config.Backend, err))
+	}
+	return backend.create(raOpts)
+}
+
+// NewIstioRA is a factory method that returns an RA that implements the RegistrationAuthority functionality.
+// the caOptions defines the external provider
+func NewIstioRA(opts *IstioRAOptions) (RegistrationAuthority, error) {
+	for _, backend := range raBackends {
+		if backend.externalCAType != opts.ExternalCAType {
+			continue
+		}
+		istioRA, err := backend.create(opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create a %s CA: %v", backend.name, err)
+		}
+		return istioRA, nil
+	}
+	return nil, fmt.Errorf("invalid CA Name %s", opts.ExternalCAType)
+}
@@ -0,0 +1,130 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"k8s.io/client-go/kubernetes/fake"
+
+	raerror "istio.io/istio/security/pkg/pki/error"
+)
+
+func TestNewRA(t *testing.T) {
+	k8sOpts := func() *IstioRAOptions {
+		return &IstioRAOptions{CaSigner: "kubernates.io/kube-apiserver-client", K8sClient: fake.NewSimpleClientset()}
+	}
+	certManagerOpts := func() *IstioRAOptions {
+		return &IstioRAOptions{
+			DynamicClient:        initFakeDynamicClient(conditionStatus("Ready", "False", "Pending"), nil),
+			CertManagerIssuer:    CertManagerIssuerRef{Name: "istio-ca"},
+			CertManagerNamespace: testCertManagerNamespace,
+		}
+	}
+	vaultOpts := func() *IstioRAOptions {
+		return &IstioRAOptions{Vault: VaultRAOptions{Address: "https://vault:8200", Role: "istio", AuthRole: "istiod"}}
+	}
+	testCases := map[string]struct {
+		config RAConfig
+		// expectedType is the type of the RA created, if any.
+		expectedType string
+		unknown      bool
+	}{
+		"kubernetes": {
+			config:       RAConfig{Backend: RABackendKubernetes, Options: k8sOpts()},
+			expectedType: "*ra.KubernetesRA",
+		},
+		"cert-manager": {
+			config:       RAConfig{Backend: RABackendCertManager, Options: certManagerOpts()},
+			expectedType: "*ra.CertManagerRA",
+		},
+		"vault": {
+			config:       RAConfig{Backend: RABackendVault, Options: vaultOpts()},
+			expectedType: "*ra.VaultRA",
+		},
+		"unknown backend": {
+			config:  RAConfig{Backend: "istio", Options: k8sOpts()},
+			unknown: true,
+		},
+		"no backend": {
+			config:  RAConfig{Options: k8sOpts()},
+			unknown: true,
+		},
+		"kubernetes without client": {
+			config: RAConfig{Backend: RABackendKubernetes, Options: &IstioRAOptions{CaSigner: "kubernates.io/kube-apiserver-client"}},
+		},
+		"kubernetes without signer": {
+			config: RAConfig{Backend: RABackendKubernetes, Options: &IstioRAOptions{K8sClient: fake.NewSimpleClientset()}},
+		},
+		"cert-manager without issuer": {
+			config: RAConfig{Backend: RABackendCertManager, Options: &IstioRAOptions{
+				DynamicClient:        certManagerOpts().DynamicClient,
+				CertManagerNamespace: testCertManagerNamespace,
+			}},
+		},
+		"vault without options": {
+			config: RAConfig{Backend: RABackendVault},
+		},
+		"mismatching external CA type": {
+			config: RAConfig{Backend: RABackendVault, Options: func() *IstioRAOptions {
+				raOpts := vaultOpts()
+				raOpts.ExternalCAType = ExtCACertManager
+				return raOpts
+			}()},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			r, err := NewRA(tc.config)
+			if tc.expectedType != "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if got := fmt.Sprintf("%T", r); got != tc.expectedType {
+					t.Errorf("got an RA of type %s, want %s", got, tc.expectedType)
+				}
+				if got := raBackends[tc.config.Backend].externalCAType; tc.config.Options.ExternalCAType != got {
+					t.Errorf("got external CA type %s, want it to default to %s", tc.config.Options.ExternalCAType, got)
+				}
+				return
+			}
+			var raErr *raerror.Error
+			if r != nil || !errors.As(err, &raErr) || raErr.ErrorType() != "CA_ILLEGAL_CONFIG" {
+				t.Fatalf("expected a CA_ILLEGAL_CONFIG error, got: %v", err)
+			}
+			if errors.Is(err, ErrUnknownBackend) != tc.unknown {
+				t.Errorf("got error %v, want it to wrap ErrUnknownBackend: %v", err, tc.unknown)
+			}
+		})
+	}
+}
+
+func TestNewIstioRA(t *testing.T) {
+	r, err := NewIstioRA(&IstioRAOptions{
+		ExternalCAType: ExtCAVault,
+		Vault:          VaultRAOptions{Address: "https://vault:8200", Role: "istio", AuthRole: "istiod"},
+	})
+	if _, ok := r.(*VaultRA); err != nil || !ok {
+		t.Errorf("expected a Vault RA, got %T: %v", r, err)
+	}
+	if _, err := NewIstioRA(&IstioRAOptions{ExternalCAType: ExtCAVault}); err == nil {
+		t.Errorf("expected an error for a Vault RA without options")
+	}
+	if _, err := NewIstioRA(&IstioRAOptions{ExternalCAType: ExtCAGrpc}); err == nil {
+		t.Errorf("expected an error for an external CA type without RA")
+	}
+}
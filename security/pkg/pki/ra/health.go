@@ -0,0 +1,257 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientset "k8s.io/client-go/kubernetes"
+
+	raerror "istio.io/istio/security/pkg/pki/error"
+	"istio.io/istio/security/pkg/pki/util"
+)
+
+var (
+	// ErrCARootExpired is returned by Check when a CA root certificate has expired or is not valid yet.
+	ErrCARootExpired = errors.New("CA root certificate is not valid")
+	// ErrCARootExpiring is returned by Check when a CA root certificate expires within the CAExpiryGracePeriod.
+	ErrCARootExpiring = errors.New("CA root certificate is about to expire")
+	// ErrCSRPermissionDenied is returned by Check when the RA is not allowed to create or approve CSRs.
+	ErrCSRPermissionDenied = errors.New("not permitted to create or approve CSRs")
+)
+
+// Check verifies that the RA is able to sign certificates: the CA root certificates must be loaded, valid
+// and not expire within the CAExpiryGracePeriod and, when CheckCSRPermissions is set, the RA must be allowed to create, read and delete CSRs and,
+// with AutoApprove, to approve CSRs for CaSigner, the signers of the CertSignerDomain and the FallbackSigners. The returned error wraps ErrCARootExpired, ErrCARootExpiring or
+// ErrCSRPermissionDenied for those failures. With ProbeSigner, it fails until the signer probe finds CaSigner
+// served, wrapping ErrSignerUnserved if it does not.
+// Check also refreshes the CA root cert expiry metric of CaSigner and of the signers in SignerCaCertFiles, which is
+// otherwise only updated when their CA bundles are loaded or reloaded. It fails with ErrShuttingDown once Shutdown
+// was called.
+func (r *KubernetesRA) Check(ctx context.Context) error {
+	if r.isShuttingDown() {
+		return raerror.NewError(raerror.CANotReady, ErrShuttingDown)
+	}
+	r.mutex.RLock()
+	caCertPending := r.caCertPending
+	signerProbeErr := r.signerProbeErr
+	r.mutex.RUnlock()
+	if caCertPending {
+		return raerror.NewError(raerror.CANotReady, fmt.Errorf("CA cert file %s is not loaded yet", r.raOpts.CaCertFile))
+	}
+	if signerProbeErr != nil {
+		return raerror.NewError(raerror.CANotReady, signerProbeErr)
+	}
+	now := time.Now()
+	keyCertBundle := r.GetCAKeyCertBundle()
+	recordRootCertExpiry(r.raOpts.CaSigner, keyCertBundle.GetRootCertPem(), now)
+	if err := checkCABundle(keyCertBundle, now, r.raOpts.CAExpiryGracePeriod); err != nil {
+		return raerror.NewError(raerror.CANotReady, err)
+	}
+	r.mutex.RLock()
+	signerBundles := make(map[string]*util.KeyCertBundle, len(r.signerBundles))
+	for signerName, signerBundle := range r.signerBundles {
+		signerBundles[signerName] = signerBundle
+	}
+	r.mutex.RUnlock()
+	for signerName, signerBundle := range signerBundles {
+		recordRootCertExpiry(signerName, signerBundle.GetRootCertPem(), now)
+		if err := checkCABundle(signerBundle, now, r.raOpts.CAExpiryGracePeriod); err != nil {
+			return raerror.NewError(raerror.CANotReady, fmt.Errorf("signer %s: %w", signerName, err))
+		}
+	}
+	if r.raOpts.CheckCSRPermissions {
+		if err := r.checkCSRPermissions(ctx); err != nil {
+			return raerror.NewError(raerror.CANotReady, err)
+		}
+	}
+	return nil
+}
+
+// checkCABundle returns an error if bundle has no root certificate, if any of them is not valid at now, or if
+// the soonest expiring one expires within gracePeriod of now.
+func checkCABundle(bundle *util.KeyCertBundle, now time.Time, gracePeriod time.Duration) error {
+	rootCertPem := bundle.GetRootCertPem()
+	if len(rootCertPem) == 0 {
+		return fmt.Errorf("no CA root certificate is loaded")
+	}
+	rootCerts, err := util.ParsePemEncodedCertificateChain(rootCertPem)
+	if err != nil {
+		return fmt.Errorf("invalid CA root certificate: %v", err)
+	}
+	soonest := rootCerts[0]
+	for _, rootCert := range rootCerts {
+		if now.After(rootCert.NotAfter) {
+			return fmt.Errorf("%w: %s expired at %v", ErrCARootExpired, rootCert.Subject, rootCert.NotAfter)
+		}
+		if now.Before(rootCert.NotBefore) {
+			return fmt.Errorf("%w: %s is not valid before %v", ErrCARootExpired, rootCert.Subject, rootCert.NotBefore)
+		}
+		if rootCert.NotAfter.Before(soonest.NotAfter) {
+			soonest = rootCert
+		}
+	}
+	if now.Add(gracePeriod).After(soonest.NotAfter) {
+		return fmt.Errorf("%w: %s expires at %v, within the grace period of %v", ErrCARootExpiring, soonest.Subject,
+			soonest.NotAfter, gracePeriod)
+	}
+	return nil
+}
+
+// checkCSRPermissions checks that the RA is allowed to perform every step of kubernetesSign for each of the
+// checkedSigners, with the K8s client of the signer, and returns an error listing all the missing permissions.
+func (r *KubernetesRA) checkCSRPermissions(ctx context.Context) error {
+	csrAttributes := []authorizationv1.ResourceAttributes{
+		{Group: "certificates.k8s.io", Resource: "certificatesigningrequests", Verb: "create"},
+		{Group: "certificates.k8s.io", Resource: "certificatesigningrequests", Verb: "get"},
+		{Group: "certificates.k8s.io", Resource: "certificatesigningrequests", Verb: "watch"},
+	}
+	if r.raOpts.CleanupCSR == nil || *r.raOpts.CleanupCSR {
+		csrAttributes = append(csrAttributes,
+			authorizationv1.ResourceAttributes{Group: "certificates.k8s.io", Resource: "certificatesigningrequests", Verb: "delete"})
+	}
+	if r.autoApprove() {
+		csrAttributes = append(csrAttributes,
+			authorizationv1.ResourceAttributes{Group: "certificates.k8s.io", Resource: "certificatesigningrequests",
+				Subresource: "approval", Verb: "update"})
+	}
+	var missing []string
+	for i, signerName := range r.checkedSigners() {
+		client, err := r.clientForSigner(signerName)
+		if err != nil {
+			return err
+		}
+		prefix := ""
+		if r.raOpts.ClientForSigner != nil {
+			prefix = "signer " + signerName + ": "
+		}
+		// Without ClientForSigner, the CSRs of all the signers are created with the same client.
+		if i == 0 || r.raOpts.ClientForSigner != nil {
+			for j := range csrAttributes {
+				denied, err := reviewAccess(ctx, client, &csrAttributes[j])
+				if err != nil {
+					return err
+				}
+				if denied != "" {
+					missing = append(missing, prefix+denied)
+				}
+			}
+		}
+		if !r.autoApprove() || !r.approvesSigner(signerName) {
+			continue
+		}
+		// The approval of a CSR is allowed by the approve permission on its signer or on the wildcard of the
+		// signer domain, as the K8s API server checks both.
+		var denials []string
+		for _, name := range signerApprovalNames(signerName) {
+			attributes := authorizationv1.ResourceAttributes{Group: "certificates.k8s.io", Resource: "signers",
+				Name: name, Verb: "approve"}
+			denied, err := reviewAccess(ctx, client, &attributes)
+			if err != nil {
+				return err
+			}
+			if denied == "" {
+				denials = nil
+				break
+			}
+			denials = append(denials, denied)
+		}
+		for _, denied := range denials {
+			missing = append(missing, prefix+denied)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %s", ErrCSRPermissionDenied, strings.Join(missing, "; "))
+	}
+	return nil
+}
+
+// checkedSigners returns the signers the RA signs with whose permissions checkCSRPermissions checks: CaSigner,
+// the signers of the CertSignerDomain, as the AllowedSigners or as <CertSignerDomain>/* without them, and the
+// FallbackSigners.
+func (r *KubernetesRA) checkedSigners() []string {
+	var signers []string
+	if r.raOpts.CaSigner != "" {
+		signers = append(signers, r.raOpts.CaSigner)
+	}
+	if r.raOpts.CertSignerDomain != "" {
+		if len(r.raOpts.AllowedSigners) > 0 {
+			signers = append(signers, r.raOpts.AllowedSigners...)
+		} else {
+			signers = append(signers, joinSignerName(r.raOpts.CertSignerDomain, "*"))
+		}
+	}
+	signers = append(signers, r.raOpts.FallbackSigners...)
+	seen := make(map[string]bool, len(signers))
+	checked := signers[:0]
+	for _, signerName := range signers {
+		if !seen[signerName] {
+			seen[signerName] = true
+			checked = append(checked, signerName)
+		}
+	}
+	return checked
+}
+
+// signerApprovalNames returns the names of the signers resource whose approve permission allows approving the
+// CSRs of signerName: signerName and the wildcard of its domain, or only the wildcard if signerName ends with *.
+func signerApprovalNames(signerName string) []string {
+	domain := signerName
+	if i := strings.Index(signerName, "/"); i >= 0 {
+		domain = signerName[:i]
+	}
+	wildcard := domain + "/*"
+	if strings.HasSuffix(signerName, "*") {
+		return []string{wildcard}
+	}
+	return []string{signerName, wildcard}
+}
+
+// reviewAccess reviews with a SelfSubjectAccessReview whether the RA is allowed access to attributes with
+// client, and returns why it is not, or an empty string if it is.
+func reviewAccess(ctx context.Context, client clientset.Interface, attributes *authorizationv1.ResourceAttributes) (string, error) {
+	review := &authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{ResourceAttributes: attributes},
+	}
+	resp, err := client.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to review access to %s: %v", describeAttributes(attributes), err)
+	}
+	if resp.Status.Allowed {
+		return "", nil
+	}
+	denied := describeAttributes(attributes) + " is not allowed"
+	if resp.Status.Reason != "" {
+		denied += ": " + resp.Status.Reason
+	}
+	return denied, nil
+}
+
+func describeAttributes(a *authorizationv1.ResourceAttributes) string {
+	resource := a.Resource
+	if a.Subresource != "" {
+		resource += "/" + a.Subresource
+	}
+	if a.Name != "" {
+		resource += " " + a.Name
+	}
+	return a.Verb + " " + resource
+}
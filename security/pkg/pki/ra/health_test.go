@@ -0,0 +1,371 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	kt "k8s.io/client-go/testing"
+
+	raerror "istio.io/istio/security/pkg/pki/error"
+	"istio.io/istio/security/pkg/pki/util"
+)
+
+func genRootCert(t *testing.T, notBefore time.Time, ttl time.Duration) []byte {
+	t.Helper()
+	rootCert, _, err := util.GenCertKeyFromOptions(util.CertOptions{
+		Host:         "test-ca",
+		NotBefore:    notBefore,
+		TTL:          ttl,
+		Org:          "istio.io",
+		IsCA:         true,
+		IsSelfSigned: true,
+		RSAKeySize:   2048,
+	})
+	if err != nil {
+		t.Fatalf("failed to generate root cert: %v", err)
+	}
+	return rootCert
+}
+
+func TestCheck(t *testing.T) {
+	now := time.Now()
+	validRoot := genRootCert(t, now.Add(-time.Hour), 24*time.Hour)
+	expiredRoot := genRootCert(t, now.Add(-2*time.Hour), time.Hour)
+	futureRoot := genRootCert(t, now.Add(time.Hour), time.Hour)
+	longRoot := genRootCert(t, now.Add(-time.Hour), 100*time.Hour)
+	noAutoApprove := false
+
+	testCases := map[string]struct {
+		rootCert         []byte
+		signerRootCert   []byte
+		gracePeriod      time.Duration
+		checkPermissions bool
+		autoApprove      *bool
+		deniedVerb       string
+		expectedErr      error
+		expectedErrMsg   string
+	}{
+		"valid root": {
+			rootCert: validRoot,
+		},
+		"no root": {
+			expectedErrMsg: "no CA root certificate is loaded",
+		},
+		"expired root": {
+			rootCert:    expiredRoot,
+			expectedErr: ErrCARootExpired,
+		},
+		"expired root among valid roots": {
+			rootCert:    append(append([]byte{}, validRoot...), expiredRoot...),
+			expectedErr: ErrCARootExpired,
+		},
+		"root not valid yet": {
+			rootCert:    futureRoot,
+			expectedErr: ErrCARootExpired,
+		},
+		"root within the grace period": {
+			rootCert:    validRoot,
+			gracePeriod: 48 * time.Hour,
+			expectedErr: ErrCARootExpiring,
+		},
+		"root outside the grace period": {
+			rootCert:    validRoot,
+			gracePeriod: time.Hour,
+		},
+		"soonest expiring root within the grace period": {
+			rootCert:    append(append([]byte{}, longRoot...), validRoot...),
+			gracePeriod: 48 * time.Hour,
+			expectedErr: ErrCARootExpiring,
+		},
+		"expired signer root": {
+			rootCert:       validRoot,
+			signerRootCert: expiredRoot,
+			expectedErr:    ErrCARootExpired,
+		},
+		"permissions granted": {
+			rootCert:         validRoot,
+			checkPermissions: true,
+		},
+		"create not permitted": {
+			rootCert:         validRoot,
+			checkPermissions: true,
+			deniedVerb:       "create",
+			expectedErr:      ErrCSRPermissionDenied,
+		},
+		"approve not permitted": {
+			rootCert:         validRoot,
+			checkPermissions: true,
+			deniedVerb:       "approve",
+			expectedErr:      ErrCSRPermissionDenied,
+		},
+		"approve not checked without auto-approval": {
+			rootCert:         validRoot,
+			checkPermissions: true,
+			autoApprove:      &noAutoApprove,
+			deniedVerb:       "approve",
+		},
+		"permissions not checked": {
+			rootCert:   validRoot,
+			deniedVerb: "create",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			reviews := 0
+			client.PrependReactor("create", "selfsubjectaccessreviews", func(action kt.Action) (bool, runtime.Object, error) {
+				reviews++
+				review := action.(kt.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+				review.Status.Allowed = review.Spec.ResourceAttributes.Verb != tc.deniedVerb
+				return true, review, nil
+			})
+			r, err := createFakeK8sRA(client)
+			if err != nil {
+				t.Fatalf("Failed to create Fake K8s RA: %v", err)
+			}
+			r.raOpts.CheckCSRPermissions = tc.checkPermissions
+			r.raOpts.AutoApprove = tc.autoApprove
+			r.raOpts.CAExpiryGracePeriod = tc.gracePeriod
+			r.keyCertBundle = util.NewKeyCertBundleFromPem(nil, nil, nil, tc.rootCert)
+			if tc.signerRootCert != nil {
+				r.signerBundles["example.com/signer"] = util.NewKeyCertBundleFromPem(nil, nil, nil, tc.signerRootCert)
+			}
+
+			err = r.Check(context.Background())
+			switch {
+			case tc.expectedErr != nil:
+				if !errors.Is(err, tc.expectedErr) {
+					t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+				}
+			case tc.expectedErrMsg != "":
+				if err == nil || err.Error() != tc.expectedErrMsg {
+					t.Fatalf("expected error %q, got %v", tc.expectedErrMsg, err)
+				}
+			case err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !tc.checkPermissions && reviews > 0 {
+				t.Fatalf("expected no access reviews, got %d", reviews)
+			}
+		})
+	}
+}
+
+func TestCheckCSRPermissionsSignerDomain(t *testing.T) {
+	testCases := map[string]struct {
+		caSigner        string
+		allowedApproves []string
+		expectedMissing []string
+	}{
+		"approval allowed in the signer domain": {
+			allowedApproves: []string{"example.com/*"},
+		},
+		"approval not allowed in the signer domain": {
+			allowedApproves: []string{"kubernates.io/kube-apiserver-client"},
+			expectedMissing: []string{"approve signers example.com/* is not allowed"},
+		},
+		"CA signer approval allowed by its signer domain": {
+			caSigner:        "kubernates.io/kube-apiserver-client",
+			allowedApproves: []string{"kubernates.io/*", "example.com/*"},
+		},
+		"CA signer approval not allowed": {
+			caSigner:        "kubernates.io/kube-apiserver-client",
+			allowedApproves: []string{"example.com/*"},
+			expectedMissing: []string{
+				"approve signers kubernates.io/kube-apiserver-client is not allowed",
+				"approve signers kubernates.io/* is not allowed",
+			},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			client.PrependReactor("create", "selfsubjectaccessreviews", func(action kt.Action) (bool, runtime.Object, error) {
+				review := action.(kt.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+				attributes := review.Spec.ResourceAttributes
+				if attributes.Resource == "signers" && attributes.Name == "" {
+					t.Errorf("expected the approval to be reviewed for a signer, got a review for all signers")
+				}
+				review.Status.Allowed = attributes.Resource != "signers"
+				for _, allowed := range tc.allowedApproves {
+					if attributes.Name == allowed {
+						review.Status.Allowed = true
+					}
+				}
+				return true, review, nil
+			})
+			r, err := createFakeK8sRA(client)
+			if err != nil {
+				t.Fatalf("Failed to create Fake K8s RA: %v", err)
+			}
+			r.raOpts.CaSigner = tc.caSigner
+			r.raOpts.CertSignerDomain = "example.com"
+			var clientSigners []string
+			r.raOpts.ClientForSigner = func(signer string) (clientset.Interface, error) {
+				clientSigners = append(clientSigners, signer)
+				return client, nil
+			}
+
+			err = r.checkCSRPermissions(context.Background())
+			if len(tc.expectedMissing) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			} else if !errors.Is(err, ErrCSRPermissionDenied) {
+				t.Fatalf("expected error %v, got %v", ErrCSRPermissionDenied, err)
+			}
+			for _, missing := range tc.expectedMissing {
+				if !strings.Contains(err.Error(), missing) {
+					t.Errorf("expected the error to list %q, got: %v", missing, err)
+				}
+			}
+			if got := clientSigners[len(clientSigners)-1]; got != "example.com/*" {
+				t.Errorf("got the permissions of the signer domain checked with the client of %q, want example.com/*", got)
+			}
+		})
+	}
+}
+
+func TestEagerRBACCheck(t *testing.T) {
+	testCases := map[string]struct {
+		eagerRBACCheck   bool
+		caSigner         string
+		certSignerDomain string
+		allowedSigners   []string
+		fallbackSigners  []string
+		deniedVerbs      []string
+		approvedSigners  []string
+		expectedMissing  []string
+	}{
+		"permissions granted": {
+			eagerRBACCheck: true,
+		},
+		"permissions missing": {
+			eagerRBACCheck: true,
+			deniedVerbs:    []string{"approve", "delete"},
+			expectedMissing: []string{
+				"delete certificatesigningrequests is not allowed",
+				"approve signers kubernates.io/kube-apiserver-client is not allowed",
+			},
+		},
+		"permissions not checked": {
+			deniedVerbs: []string{"create"},
+		},
+		"approval allowed in the signer domain": {
+			eagerRBACCheck:   true,
+			certSignerDomain: "example.com",
+			approvedSigners:  []string{"example.com/*"},
+		},
+		"approval not allowed in the signer domain": {
+			eagerRBACCheck:   true,
+			certSignerDomain: "example.com",
+			approvedSigners:  []string{"kubernates.io/kube-apiserver-client"},
+			expectedMissing:  []string{"approve signers example.com/* is not allowed"},
+		},
+		"approval allowed for the allowed signers": {
+			eagerRBACCheck:   true,
+			certSignerDomain: "example.com",
+			allowedSigners:   []string{"example.com/istio", "example.com/istio-*"},
+			approvedSigners:  []string{"example.com/istio", "example.com/*"},
+		},
+		"approval not allowed for a fallback signer": {
+			eagerRBACCheck:   true,
+			caSigner:         "kubernates.io/kube-apiserver-client",
+			certSignerDomain: "example.com",
+			fallbackSigners:  []string{"example.com/fallback"},
+			approvedSigners:  []string{"kubernates.io/kube-apiserver-client", "example.com/primary"},
+			expectedMissing: []string{
+				"approve signers example.com/fallback is not allowed",
+				"approve signers example.com/* is not allowed",
+			},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			reviews := 0
+			client.PrependReactor("create", "selfsubjectaccessreviews", func(action kt.Action) (bool, runtime.Object, error) {
+				reviews++
+				review := action.(kt.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+				attributes := review.Spec.ResourceAttributes
+				review.Status.Allowed = true
+				for _, verb := range tc.deniedVerbs {
+					if attributes.Verb == verb {
+						review.Status.Allowed = false
+					}
+				}
+				if tc.approvedSigners != nil && attributes.Resource == "signers" {
+					review.Status.Allowed = false
+					for _, signer := range tc.approvedSigners {
+						if attributes.Name == signer {
+							review.Status.Allowed = true
+						}
+					}
+				}
+				return true, review, nil
+			})
+			caSigner := tc.caSigner
+			if caSigner == "" && tc.certSignerDomain == "" {
+				caSigner = "kubernates.io/kube-apiserver-client"
+			}
+			var clientSigners []string
+			_, err := NewKubernetesRA(&IstioRAOptions{
+				ExternalCAType:   ExtCAK8s,
+				CaSigner:         caSigner,
+				CertSignerDomain: tc.certSignerDomain,
+				AllowedSigners:   tc.allowedSigners,
+				FallbackSigners:  tc.fallbackSigners,
+				K8sClient:        client,
+				ClientForSigner: func(signer string) (clientset.Interface, error) {
+					clientSigners = append(clientSigners, signer)
+					return client, nil
+				},
+				EagerRBACCheck: tc.eagerRBACCheck,
+			})
+			for _, signer := range clientSigners {
+				if signer == "" {
+					t.Errorf("expected the permissions to be checked with the client of a signer, got the client of an empty signer")
+				}
+			}
+			if len(tc.expectedMissing) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !tc.eagerRBACCheck && reviews > 0 {
+					t.Fatalf("expected no access reviews, got %d", reviews)
+				}
+				return
+			}
+			var raErr *raerror.Error
+			if !errors.As(err, &raErr) || raErr.ErrorType() != "CA_INIT_FAIL" || !errors.Is(err, ErrCSRPermissionDenied) {
+				t.Fatalf("expected a CA_INIT_FAIL error wrapping %v, got: %v", ErrCSRPermissionDenied, err)
+			}
+			for _, missing := range tc.expectedMissing {
+				if !strings.Contains(err.Error(), missing) {
+					t.Errorf("expected the error to list %q, got: %v", missing, err)
+				}
+			}
+		})
+	}
+}
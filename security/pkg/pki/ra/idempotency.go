@@ -0,0 +1,125 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	cert "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientset "k8s.io/client-go/kubernetes"
+
+	"istio.io/istio/security/pkg/pki/ca"
+	"istio.io/istio/security/pkg/pki/util"
+)
+
+// idempotencyKeyLabel is the label of the K8s CSR objects of requests with an idempotency key. Its value is
+// idempotencyKeyHash of the key, as keys may not be valid label values.
+const idempotencyKeyLabel = "ra.istio.io/idempotency-key"
+
+// idempotencyKeyHash returns the value of the idempotencyKeyLabel of CSRs for key and signerName.
+func idempotencyKeyHash(signerName, key string) string {
+	sum := sha256.Sum256([]byte(signerName + "\x00" + key))
+	// Label values are limited to 63 characters.
+	return hex.EncodeToString(sum[:16])
+}
+
+// idempotencyKeyTTL returns the IdempotencyKeyTTL, or its default.
+func (r *KubernetesRA) idempotencyKeyTTL() time.Duration {
+	if r.raOpts.IdempotencyKeyTTL > 0 {
+		return r.raOpts.IdempotencyKeyTTL
+	}
+	return DefaultIdempotencyKeyTTL
+}
+
+// issuedForIdempotencyKey returns the certificate issued for csrPEM by signerName to an earlier request with
+// the IdempotencyKey of certOpts, if any. CSRs of the key older than the IdempotencyKeyTTL are deleted. Only the
+// CSRs created by the RA instance are looked up and deleted. Failures to look up CSRs are only logged, so that the
+// CSR is signed again. Certificates that would be due for renewal if issued with the requested lifetime are not
+// returned either, so that retries never get expired or nearly expired certificates.
+func (r *KubernetesRA) issuedForIdempotencyKey(ctx context.Context, client clientset.Interface, csrPEM []byte,
+	signerName string, certOpts ca.CertOpts, lifetime time.Duration) []byte {
+	key := certOpts.IdempotencyKey
+	reqLog := requestLog(certOpts.RequestID)
+	csrs, err := client.CertificatesV1().CertificateSigningRequests().List(ctx, metav1.ListOptions{
+		LabelSelector: idempotencyKeyLabel + "=" + idempotencyKeyHash(signerName, key) + "," + r.instanceSelector(),
+	})
+	if err != nil {
+		reqLog.Warnf("failed to look up the CSRs of idempotency key %q, signing the CSR again: %v", key, err)
+		return nil
+	}
+	now := time.Now()
+	var issued []byte
+	for i := range csrs.Items {
+		csr := &csrs.Items[i]
+		if csr.Spec.SignerName != signerName {
+			continue
+		}
+		if now.Sub(csr.CreationTimestamp.Time) > r.idempotencyKeyTTL() {
+			if err := client.CertificatesV1().CertificateSigningRequests().Delete(ctx, csr.Name, metav1.DeleteOptions{}); err != nil {
+				reqLog.Warnf("failed to delete CSR %s of expired idempotency key %q: %v", csr.Name, key, err)
+			}
+			continue
+		}
+		if issued == nil && bytes.Equal(csr.Spec.Request, csrPEM) && csrApproved(csr) && len(csr.Status.Certificate) > 0 {
+			if err := r.checkReusable(csr.Status.Certificate, lifetime, now); err != nil {
+				reqLog.Infof("not returning the certificate of CSR %s for idempotency key %q: %v", csr.Name, key, err)
+				continue
+			}
+			issued = csr.Status.Certificate
+		}
+	}
+	if issued != nil {
+		reqLog.Infof("returning the certificate already issued for idempotency key %q", key)
+	}
+	return issued
+}
+
+// checkReusable returns an error unless certPEM, issued earlier, is valid at now for longer than the renewal
+// margin of a certificate issued with lifetime, i.e. the part of lifetime left when renewal is due.
+func (r *KubernetesRA) checkReusable(certPEM []byte, lifetime time.Duration, now time.Time) error {
+	leafCert, err := util.ParsePemEncodedCertificate(certPEM)
+	if err != nil {
+		return err
+	}
+	margin := time.Duration(float64(lifetime) * (1 - r.raOpts.renewalFraction()))
+	if remaining := leafCert.NotAfter.Sub(now); remaining < margin {
+		return fmt.Errorf("the certificate expires in %v, less than its renewal margin %v", remaining.Truncate(time.Second), margin)
+	}
+	return nil
+}
+
+// csrApproved returns whether csr is approved, and neither denied nor failed.
+func csrApproved(csr *cert.CertificateSigningRequest) bool {
+	approved := false
+	for _, c := range csr.Status.Conditions {
+		if c.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch c.Type {
+		case cert.CertificateApproved:
+			approved = true
+		case cert.CertificateDenied, cert.CertificateFailed:
+			return false
+		}
+	}
+	return approved
+}
@@ -0,0 +1,183 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	cert "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	kt "k8s.io/client-go/testing"
+
+	"istio.io/istio/security/pkg/pki/ca"
+	raerror "istio.io/istio/security/pkg/pki/error"
+)
+
+const testIdempotencyKey = "agent-1/rotation-42"
+
+// issuedCSR returns an issued CSR of the idempotency key for csrPEM, created at created.
+func issuedCSR(t *testing.T, name, signerName string, csrPEM []byte, created time.Time) *cert.CertificateSigningRequest {
+	t.Helper()
+	certPEM, err := issueFakeCert(csrPEM)
+	if err != nil {
+		t.Fatalf("failed to issue certificate: %v", err)
+	}
+	return &cert.CertificateSigningRequest{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Labels:            map[string]string{idempotencyKeyLabel: idempotencyKeyHash(signerName, testIdempotencyKey)},
+			CreationTimestamp: metav1.NewTime(created),
+		},
+		Spec: cert.CertificateSigningRequestSpec{Request: csrPEM, SignerName: signerName},
+		Status: cert.CertificateSigningRequestStatus{
+			Conditions:  []cert.CertificateSigningRequestCondition{{Type: cert.CertificateApproved, Status: corev1.ConditionTrue}},
+			Certificate: certPEM,
+		},
+	}
+}
+
+func TestIdempotencyKeyIssued(t *testing.T) {
+	csrPEM := createFakeCsr(t)
+	client := fake.NewSimpleClientset()
+	r, err := createFakeK8sRA(client)
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	issued := issuedCSR(t, "csr-issued", r.raOpts.CaSigner, csrPEM, time.Now().Add(-time.Minute))
+	if _, err := client.CertificatesV1().CertificateSigningRequests().Create(context.Background(), issued,
+		metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create CSR: %v", err)
+	}
+	client.ClearActions()
+
+	certPEM, err := r.Sign(csrPEM, ca.CertOpts{
+		SubjectIDs:     []string{testCsrHostName},
+		TTL:            60 * time.Second,
+		IdempotencyKey: testIdempotencyKey,
+	})
+	if err != nil {
+		t.Fatalf("Failed to sign with an idempotency key: %v", err)
+	}
+	if !bytes.Equal(certPEM, issued.Status.Certificate) {
+		t.Errorf("expected the certificate issued for the idempotency key to be returned")
+	}
+	for _, action := range client.Actions() {
+		if action.GetVerb() != "list" {
+			t.Errorf("expected the issued CSR to only be listed, got action %s", action.GetVerb())
+		}
+	}
+}
+
+func TestIdempotencyKeyNotIssued(t *testing.T) {
+	csrPEM := createFakeCsr(t)
+	client := fake.NewSimpleClientset()
+	r, err := createFakeK8sRA(client)
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	r.raOpts.ApprovalTimeout = 100 * time.Millisecond
+	for _, csr := range []*cert.CertificateSigningRequest{
+		issuedCSR(t, "csr-expired", r.raOpts.CaSigner, csrPEM, time.Now().Add(-2*time.Hour)),
+		issuedCSR(t, "csr-other-request", r.raOpts.CaSigner, createFakeCsr(t), time.Now()),
+		issuedCSR(t, "csr-other-signer", "example.com/other", csrPEM, time.Now()),
+	} {
+		if _, err := client.CertificatesV1().CertificateSigningRequests().Create(context.Background(), csr,
+			metav1.CreateOptions{}); err != nil {
+			t.Fatalf("failed to create CSR: %v", err)
+		}
+	}
+	client.ClearActions()
+
+	_, err = r.Sign(csrPEM, ca.CertOpts{
+		SubjectIDs:     []string{testCsrHostName},
+		TTL:            60 * time.Second,
+		IdempotencyKey: testIdempotencyKey,
+	})
+	var raErr *raerror.Error
+	if !errors.As(err, &raErr) || raErr.ErrorType() != "CSR_PENDING" {
+		t.Fatalf("expected a new CSR to be created and left pending, got: %v", err)
+	}
+	var created *cert.CertificateSigningRequest
+	for _, action := range client.Actions() {
+		switch action.GetVerb() {
+		case "create":
+			created = action.(kt.CreateAction).GetObject().(*cert.CertificateSigningRequest)
+		case "delete":
+			if name := action.(kt.DeleteAction).GetName(); name != "csr-expired" {
+				t.Errorf("expected only the expired CSR to be deleted, got %s", name)
+			}
+		}
+	}
+	if created == nil {
+		t.Fatalf("expected a new CSR to be created")
+	}
+	if got, want := created.Labels[idempotencyKeyLabel], idempotencyKeyHash(r.raOpts.CaSigner, testIdempotencyKey); got != want {
+		t.Errorf("got idempotency key label %q, want %q", got, want)
+	}
+	if _, err := client.CertificatesV1().CertificateSigningRequests().Get(context.Background(), "csr-expired",
+		metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the expired CSR to be deleted, got: %v", err)
+	}
+	if _, err := client.CertificatesV1().CertificateSigningRequests().Get(context.Background(), created.Name,
+		metav1.GetOptions{}); err != nil {
+		t.Errorf("expected the new CSR to be kept for retries, got: %v", err)
+	}
+}
+
+func TestIdempotencyKeyIssuedDueForRenewal(t *testing.T) {
+	csrPEM := createFakeCsr(t)
+	client := fake.NewSimpleClientset()
+	r, err := createFakeK8sRA(client)
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	r.raOpts.ApprovalTimeout = 100 * time.Millisecond
+	issued := issuedCSR(t, "csr-short-lived", r.raOpts.CaSigner, csrPEM, time.Now().Add(-time.Minute))
+	// For the requested hour, renewal is due half an hour before the certificate expires.
+	if issued.Status.Certificate, err = issueFakeCertWithTTL(csrPEM, 10*time.Minute); err != nil {
+		t.Fatalf("failed to issue certificate: %v", err)
+	}
+	if _, err := client.CertificatesV1().CertificateSigningRequests().Create(context.Background(), issued,
+		metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create CSR: %v", err)
+	}
+	client.ClearActions()
+
+	_, err = r.Sign(csrPEM, ca.CertOpts{
+		SubjectIDs:     []string{testCsrHostName},
+		TTL:            time.Hour,
+		IdempotencyKey: testIdempotencyKey,
+	})
+	var raErr *raerror.Error
+	if !errors.As(err, &raErr) || raErr.ErrorType() != "CSR_PENDING" {
+		t.Fatalf("expected a new CSR to be created and left pending, got: %v", err)
+	}
+	created := false
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "create" {
+			created = true
+		}
+	}
+	if !created {
+		t.Errorf("expected a new CSR to be created rather than the short-lived certificate returned")
+	}
+}
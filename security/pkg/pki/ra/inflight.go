@@ -0,0 +1,137 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.opencensus.io/trace"
+
+	"istio.io/istio/security/pkg/pki/ca"
+	raerror "istio.io/istio/security/pkg/pki/error"
+)
+
+// inflightKey returns the key deduplicating in-flight requests for csrPEM, identified by its certCacheKey, to
+// signerName with certOpts. Requests to different signers are never deduplicated, nor requests whose fields
+// reaching the CSR object differ, which the certCacheKey does not cover.
+func inflightKey(signerName, cacheKey string, certOpts ca.CertOpts) string {
+	return signerName + "\x00" + cacheKey + "\x00" + certOpts.IdempotencyKey
+}
+
+// signTimeout returns the maximum time of a signing shared by concurrent identical requests.
+func signTimeout(raOpts *IstioRAOptions) time.Duration {
+	if raOpts.SignTimeout <= 0 {
+		return DefaultSignTimeout
+	}
+	return raOpts.SignTimeout
+}
+
+// inflightGroup deduplicates the concurrent signing requests with the same key: the first of them starts a
+// single shared signing, which the others wait for. The zero value is ready to use.
+type inflightGroup struct {
+	mutex sync.Mutex
+	// calls are the shared signings in flight, keyed by request key.
+	calls map[string]*inflightCall
+}
+
+// inflightCall is a shared signing in flight.
+type inflightCall struct {
+	// done is closed once result and err are set.
+	done   chan struct{}
+	result *SignResult
+	err    error
+	// cancel cancels the context of the signing.
+	cancel context.CancelFunc
+	// waiters is the number of callers waiting for the signing.
+	waiters int
+	// requestID is the RequestID of the request that started the signing.
+	requestID string
+}
+
+// signDeduplicated returns the certificate issued by sign for the request requestID, sharing a single call of
+// sign among the concurrent callers with the same key. The callers get their own copy of the result, or the
+// error of the shared call. sign runs under a context detached from those of the callers, bounded by timeout if
+// positive, so that a caller giving up does not fail the others. A caller stops waiting once ctx is done, and the
+// last caller to stop waiting cancels the call and waits for it to return, so that it never outlives all its
+// callers. The call is tracked in tracker, if not nil, e.g. for a shutdown to wait for it.
+func signDeduplicated(ctx context.Context, group *inflightGroup, key, requestID string, timeout time.Duration,
+	tracker *sync.WaitGroup, sign func(ctx context.Context) (*SignResult, error)) (*SignResult, error) {
+	group.mutex.Lock()
+	c, ok := group.calls[key]
+	if ok {
+		c.waiters++
+		requestLog(requestID).Infof("sharing the signing of the identical request %s in flight", c.requestID)
+	} else {
+		// The signing is traced as part of the request starting it, but not canceled with it.
+		detached := trace.NewContext(context.Background(), trace.FromContext(ctx))
+		signCtx, cancel := context.WithCancel(detached)
+		if timeout > 0 {
+			signCtx, cancel = context.WithTimeout(detached, timeout)
+		}
+		c = &inflightCall{done: make(chan struct{}), cancel: cancel, waiters: 1, requestID: requestID}
+		if group.calls == nil {
+			group.calls = map[string]*inflightCall{}
+		}
+		group.calls[key] = c
+		if tracker != nil {
+			tracker.Add(1)
+		}
+		go func() {
+			if tracker != nil {
+				defer tracker.Done()
+			}
+			defer cancel()
+			result, err := sign(signCtx)
+			group.mutex.Lock()
+			group.forget(key, c)
+			c.result, c.err = result, err
+			group.mutex.Unlock()
+			close(c.done)
+		}()
+	}
+	group.mutex.Unlock()
+
+	select {
+	case <-c.done:
+		if c.err != nil {
+			return nil, c.err
+		}
+		return cloneSignResult(c.result), nil
+	case <-ctx.Done():
+		group.mutex.Lock()
+		c.waiters--
+		last := c.waiters == 0
+		if last {
+			// Later callers start a signing of their own rather than joining the canceled one.
+			group.forget(key, c)
+		}
+		group.mutex.Unlock()
+		if last {
+			c.cancel()
+			<-c.done
+		}
+		return nil, raerror.NewError(raerror.RequestCanceled, ctx.Err())
+	}
+}
+
+// forget removes c from the calls in flight, unless another call replaced it for key. The caller must hold the
+// mutex.
+func (g *inflightGroup) forget(key string, c *inflightCall) {
+	if g.calls[key] == c {
+		delete(g.calls, key)
+	}
+}
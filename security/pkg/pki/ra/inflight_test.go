@@ -0,0 +1,219 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"istio.io/istio/security/pkg/pki/ca"
+	raerror "istio.io/istio/security/pkg/pki/error"
+)
+
+// runConcurrently calls signDeduplicated for each key concurrently, once sign is blocked in all of them.
+func runConcurrently(inflight *inflightGroup, keys []string, sign func(context.Context) (*SignResult, error)) ([]*SignResult, []error) {
+	results := make([]*SignResult, len(keys))
+	errs := make([]error, len(keys))
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		wg.Add(1)
+		go func(i int, key string) {
+			defer wg.Done()
+			results[i], errs[i] = signDeduplicated(context.Background(), inflight, key, "", time.Minute, nil, sign)
+		}(i, key)
+	}
+	wg.Wait()
+	return results, errs
+}
+
+func TestSignDeduplicated(t *testing.T) {
+	var inflight inflightGroup
+	var calls int32
+	sign := func(context.Context) (*SignResult, error) {
+		atomic.AddInt32(&calls, 1)
+		// wait for the other callers to join
+		time.Sleep(100 * time.Millisecond)
+		return &SignResult{CertPEM: []byte("cert")}, nil
+	}
+	results, errs := runConcurrently(&inflight, []string{"a", "a", "a", "b"}, sign)
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("unexpected error of caller %d: %v", i, err)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("got %d calls, want one per key", calls)
+	}
+	results[0].CertPEM[0] = 'x'
+	if string(results[1].CertPEM) != "cert" {
+		t.Errorf("expected every caller to get its own copy of the result")
+	}
+
+	errSign := errors.New("sign failed")
+	_, errs = runConcurrently(&inflight, []string{"a", "a", "a"}, func(context.Context) (*SignResult, error) {
+		time.Sleep(100 * time.Millisecond)
+		return nil, errSign
+	})
+	for i, err := range errs {
+		if !errors.Is(err, errSign) {
+			t.Errorf("expected caller %d to get the shared error, got: %v", i, err)
+		}
+	}
+}
+
+func TestSignDeduplicatedCanceled(t *testing.T) {
+	var inflight inflightGroup
+	var tracker sync.WaitGroup
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	release := make(chan struct{})
+	firstDone := make(chan error, 1)
+	go func() {
+		_, err := signDeduplicated(ctx, &inflight, "a", "first", time.Minute, &tracker, func(ctx context.Context) (*SignResult, error) {
+			close(started)
+			select {
+			case <-release:
+				return &SignResult{CertPEM: []byte("cert")}, nil
+			case <-ctx.Done():
+				return nil, raerror.NewError(raerror.RequestCanceled, ctx.Err())
+			}
+		})
+		firstDone <- err
+	}()
+	<-started
+	secondDone := make(chan error, 1)
+	go func() {
+		_, err := signDeduplicated(context.Background(), &inflight, "a", "second", time.Minute, &tracker,
+			func(context.Context) (*SignResult, error) {
+				t.Error("expected the second caller to share the signing of the first one")
+				return nil, nil
+			})
+		secondDone <- err
+	}()
+	// let the second caller join the first call before canceling it
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	var raErr *raerror.Error
+	if err := <-firstDone; !errors.As(err, &raErr) || raErr.ErrorType() != "REQUEST_CANCELED" {
+		t.Errorf("expected a REQUEST_CANCELED error for the canceled caller, got: %v", err)
+	}
+	// The signing goes on for the second caller, rather than failing with the cancellation of the first one.
+	close(release)
+	if err := <-secondDone; err != nil {
+		t.Errorf("unexpected error of the second caller: %v", err)
+	}
+	tracker.Wait()
+}
+
+func TestSignDeduplicatedAllCanceled(t *testing.T) {
+	var inflight inflightGroup
+	var tracker sync.WaitGroup
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	var returned int32
+	done := make(chan error, 1)
+	go func() {
+		_, err := signDeduplicated(ctx, &inflight, "a", "", time.Minute, &tracker, func(ctx context.Context) (*SignResult, error) {
+			close(started)
+			<-ctx.Done()
+			// the caller must still wait for the signing to return
+			time.Sleep(50 * time.Millisecond)
+			atomic.StoreInt32(&returned, 1)
+			return nil, ctx.Err()
+		})
+		done <- err
+	}()
+	<-started
+	cancel()
+	var raErr *raerror.Error
+	if err := <-done; !errors.As(err, &raErr) || raErr.ErrorType() != "REQUEST_CANCELED" {
+		t.Errorf("expected a REQUEST_CANCELED error for the canceled caller, got: %v", err)
+	}
+	if atomic.LoadInt32(&returned) == 0 {
+		t.Errorf("expected the signing to be canceled and done once its last caller returned")
+	}
+	tracker.Wait()
+
+	// A later identical request starts a signing of its own.
+	result, err := signDeduplicated(context.Background(), &inflight, "a", "", time.Minute, nil,
+		func(context.Context) (*SignResult, error) {
+			return &SignResult{CertPEM: []byte("cert")}, nil
+		})
+	if err != nil || string(result.CertPEM) != "cert" {
+		t.Errorf("expected a new signing, got %v, %v", result, err)
+	}
+}
+
+func TestSignDeduplicatedTimeout(t *testing.T) {
+	var inflight inflightGroup
+	_, err := signDeduplicated(context.Background(), &inflight, "a", "", 50*time.Millisecond, nil,
+		func(ctx context.Context) (*SignResult, error) {
+			<-ctx.Done()
+			return nil, raerror.NewError(raerror.RequestCanceled, ctx.Err())
+		})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the signing to time out, got: %v", err)
+	}
+}
+
+func TestInflightKey(t *testing.T) {
+	certOpts := ca.CertOpts{SubjectIDs: []string{testCsrHostName}}
+	key := inflightKey("signer", "cache", certOpts)
+	if inflightKey("other", "cache", certOpts) == key {
+		t.Errorf("expected requests to different signers to have different keys")
+	}
+	certOpts.IdempotencyKey = "retry"
+	if inflightKey("signer", "cache", certOpts) == key {
+		t.Errorf("expected requests with different idempotency keys to have different keys")
+	}
+}
+
+func TestCertManagerSignDeduplicated(t *testing.T) {
+	certManagerPollInterval = 10 * time.Millisecond
+	created := make(chan *unstructured.Unstructured, 10)
+	r, err := createFakeCertManagerRA(initFakeDynamicClient(readyStatus(genRootCert(t, time.Now(), time.Hour)), created))
+	if err != nil {
+		t.Fatalf("Failed to create Fake cert-manager RA: %v", err)
+	}
+	// the fake issuer signs with another CA than the one it returns as the chain
+	r.raOpts.CertChainMismatchPolicy = CertChainMismatchAllow
+	csrPEM := createFakeCsr(t)
+	certOpts := ca.CertOpts{SubjectIDs: []string{testCsrHostName}, TTL: 10 * time.Minute}
+	var wg sync.WaitGroup
+	errs := make([]error, 5)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = r.SignWithCertChainResponse(csrPEM, certOpts)
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("unexpected error of caller %d: %v", i, err)
+		}
+	}
+	// The concurrent requests may not all have overlapped, but most of them must have been shared.
+	if len(created) >= len(errs) {
+		t.Errorf("got %d CertificateRequests for %d identical concurrent requests", len(created), len(errs))
+	}
+}
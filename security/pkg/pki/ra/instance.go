@@ -0,0 +1,46 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"fmt"
+
+	"istio.io/istio/security/pkg/k8s/chiron"
+)
+
+// instanceLabel is the label of the K8s CSR objects created by an RA with an InstanceLabel, whose value it is.
+const instanceLabel = "ra.istio.io/instance"
+
+// validateInstanceLabel checks that the InstanceLabel of raOpts is a valid label value, and that the CSRLabels
+// do not set the instanceLabel, which would have the CSRs pass as those of another instance.
+func validateInstanceLabel(raOpts *IstioRAOptions) error {
+	if _, ok := raOpts.CSRLabels[instanceLabel]; ok {
+		return fmt.Errorf("CSR label %q is set by the RA, use the instance label instead", instanceLabel)
+	}
+	if raOpts.InstanceLabel == "" {
+		return nil
+	}
+	return chiron.ValidateCSRMetadata(map[string]string{instanceLabel: raOpts.InstanceLabel}, nil)
+}
+
+// instanceSelector returns the label selector of the K8s CSR objects created by the RA: those with its
+// InstanceLabel, or those without any for an RA without one, so that RAs never select the CSRs of another
+// instance.
+func (r *KubernetesRA) instanceSelector() string {
+	if r.raOpts.InstanceLabel == "" {
+		return "!" + instanceLabel
+	}
+	return instanceLabel + "=" + r.raOpts.InstanceLabel
+}
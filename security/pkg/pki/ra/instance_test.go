@@ -0,0 +1,125 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	cert "k8s.io/api/certificates/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	kt "k8s.io/client-go/testing"
+
+	"istio.io/istio/security/pkg/pki/ca"
+	raerror "istio.io/istio/security/pkg/pki/error"
+)
+
+func TestValidateInstanceLabel(t *testing.T) {
+	testCases := map[string]struct {
+		raOpts    IstioRAOptions
+		expectErr bool
+	}{
+		"unset": {},
+		"revision": {
+			raOpts: IstioRAOptions{InstanceLabel: "1-12.canary"},
+		},
+		"invalid value": {
+			raOpts:    IstioRAOptions{InstanceLabel: "rev/a"},
+			expectErr: true,
+		},
+		"too long": {
+			raOpts:    IstioRAOptions{InstanceLabel: strings.Repeat("a", 64)},
+			expectErr: true,
+		},
+		"set by the CSR labels": {
+			raOpts:    IstioRAOptions{CSRLabels: map[string]string{instanceLabel: "rev-b"}},
+			expectErr: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := validateInstanceLabel(&tc.raOpts)
+			if tc.expectErr != (err != nil) {
+				t.Errorf("expected error %v, got: %v", tc.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestInstanceLabelScopesCSRs(t *testing.T) {
+	csrPEM := createFakeCsr(t)
+	client := fake.NewSimpleClientset()
+	r, err := createFakeK8sRA(client)
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	r.raOpts.InstanceLabel = "rev-a"
+	r.raOpts.ApprovalTimeout = 100 * time.Millisecond
+	withInstance := func(csr *cert.CertificateSigningRequest, instance string) *cert.CertificateSigningRequest {
+		if instance != "" {
+			csr.Labels[instanceLabel] = instance
+		}
+		return csr
+	}
+	for _, csr := range []*cert.CertificateSigningRequest{
+		withInstance(issuedCSR(t, "csr-expired-rev-a", r.raOpts.CaSigner, csrPEM, time.Now().Add(-2*time.Hour)), "rev-a"),
+		withInstance(issuedCSR(t, "csr-expired-rev-b", r.raOpts.CaSigner, csrPEM, time.Now().Add(-2*time.Hour)), "rev-b"),
+		withInstance(issuedCSR(t, "csr-expired-unlabeled", r.raOpts.CaSigner, csrPEM, time.Now().Add(-2*time.Hour)), ""),
+		withInstance(issuedCSR(t, "csr-issued-rev-b", r.raOpts.CaSigner, csrPEM, time.Now()), "rev-b"),
+	} {
+		if _, err := client.CertificatesV1().CertificateSigningRequests().Create(context.Background(), csr,
+			metav1.CreateOptions{}); err != nil {
+			t.Fatalf("failed to create CSR: %v", err)
+		}
+	}
+	client.ClearActions()
+
+	_, err = r.Sign(csrPEM, ca.CertOpts{
+		SubjectIDs:     []string{testCsrHostName},
+		TTL:            60 * time.Second,
+		IdempotencyKey: testIdempotencyKey,
+	})
+	var raErr *raerror.Error
+	if !errors.As(err, &raErr) || raErr.ErrorType() != "CSR_PENDING" {
+		t.Fatalf("expected the certificate issued to another instance not to be returned, got: %v", err)
+	}
+	var created *cert.CertificateSigningRequest
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "create" {
+			created = action.(kt.CreateAction).GetObject().(*cert.CertificateSigningRequest)
+		}
+	}
+	if created == nil {
+		t.Fatalf("expected a new CSR to be created")
+	}
+	if got := created.Labels[instanceLabel]; got != "rev-a" {
+		t.Errorf("got instance label %q, want %q", got, "rev-a")
+	}
+	if _, err := client.CertificatesV1().CertificateSigningRequests().Get(context.Background(), "csr-expired-rev-a",
+		metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the expired CSR of the instance to be deleted, got: %v", err)
+	}
+	for _, name := range []string{"csr-expired-rev-b", "csr-expired-unlabeled", "csr-issued-rev-b"} {
+		if _, err := client.CertificatesV1().CertificateSigningRequests().Get(context.Background(), name,
+			metav1.GetOptions{}); err != nil {
+			t.Errorf("expected CSR %s of another instance to be kept, got: %v", name, err)
+		}
+	}
+}
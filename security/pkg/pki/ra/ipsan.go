@@ -0,0 +1,151 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"fmt"
+	"net"
+
+	"istio.io/istio/security/pkg/pki/ca"
+	raerror "istio.io/istio/security/pkg/pki/error"
+	"istio.io/istio/security/pkg/pki/util"
+)
+
+// IPScopePolicy is whether IP SANs in AllowedIPRanges may be requested in another scope, private or public,
+// than the IPs of the requesting workload.
+type IPScopePolicy string
+
+const (
+	// IPScopeMismatchReject : Requests from workloads with private IPs only for public IP SANs, or with public IPs
+	// only for private IP SANs, are rejected
+	IPScopeMismatchReject IPScopePolicy = "Reject"
+	// IPScopeMismatchAllow : IP SANs in AllowedIPRanges may be requested regardless of the IPs of the workload
+	IPScopeMismatchAllow IPScopePolicy = "Allow"
+)
+
+// privateIPRanges are the private IPv4 (RFC 1918) and IPv6 (RFC 4193) address ranges.
+var privateIPRanges = func() []*net.IPNet {
+	var ranges []*net.IPNet
+	for _, cidr := range []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"} {
+		_, ipNet, _ := net.ParseCIDR(cidr)
+		ranges = append(ranges, ipNet)
+	}
+	return ranges
+}()
+
+// isPrivateIP returns whether ip is in one of the privateIPRanges.
+func isPrivateIP(ip net.IP) bool {
+	for _, ipNet := range privateIPRanges {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateIPSANOptions checks that the AllowedIPRanges of raOpts are CIDRs, and that its IPScopePolicy is known.
+func validateIPSANOptions(raOpts *IstioRAOptions) error {
+	for _, cidr := range raOpts.AllowedIPRanges {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return raerror.NewError(raerror.CAInitFail, fmt.Errorf("invalid allowed IP range: %v", err))
+		}
+	}
+	switch raOpts.IPScopePolicy {
+	case "", IPScopeMismatchReject, IPScopeMismatchAllow:
+		return nil
+	}
+	return raerror.NewError(raerror.CAInitFail, fmt.Errorf("unknown IP scope policy %q", raOpts.IPScopePolicy))
+}
+
+// validateIPAddresses checks that every IP address requested by certOpts is one of its WorkloadIPs, or else in
+// the AllowedIPRanges of raOpts and, unless the IPScopePolicy allows it, in the scope of one of the WorkloadIPs.
+func validateIPAddresses(raOpts *IstioRAOptions, certOpts ca.CertOpts) error {
+	for _, ip := range certOpts.IPAddresses {
+		if ip == nil || ip.IsUnspecified() {
+			return fmt.Errorf("invalid IP address %q", ip)
+		}
+		if containsIP(certOpts.WorkloadIPs, ip) {
+			continue
+		}
+		if !ipAllowed(raOpts.AllowedIPRanges, ip) {
+			return fmt.Errorf("IP address %s is neither an IP of the workload nor in the allowed IP ranges", ip)
+		}
+		if raOpts.IPScopePolicy == IPScopeMismatchAllow || len(certOpts.WorkloadIPs) == 0 {
+			continue
+		}
+		sameScope := false
+		for _, workloadIP := range certOpts.WorkloadIPs {
+			if isPrivateIP(workloadIP) == isPrivateIP(ip) {
+				sameScope = true
+				break
+			}
+		}
+		if !sameScope {
+			scope, workloadScope := "public", "private"
+			if isPrivateIP(ip) {
+				scope, workloadScope = workloadScope, scope
+			}
+			return fmt.Errorf("IP address %s is %s but the IPs of the workload %v are %s", ip, scope,
+				certOpts.WorkloadIPs, workloadScope)
+		}
+	}
+	return nil
+}
+
+// ipAllowed returns whether ip is in one of the allowed CIDRs, which are valid.
+func ipAllowed(allowed []string, ip net.IP) bool {
+	for _, cidr := range allowed {
+		if _, ipNet, err := net.ParseCIDR(cidr); err == nil && ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsIP returns whether ips contains ip, regardless of their IPv4 and IPv4-in-IPv6 forms.
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, other := range ips {
+		if other.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateCSRIPAddresses checks that the requested ipAddresses are exactly the IP SANs of the CSR, regardless of
+// order, as no signer can add SANs to a CSR and the IP SANs of the CSR must have been validated as requested.
+// CSRs are not checked when no IP address is requested, their IP SANs are then subject to the SANValidationMode.
+func validateCSRIPAddresses(identities []csrIdentity, ipAddresses []net.IP) error {
+	if len(ipAddresses) == 0 {
+		return nil
+	}
+	var csrIPs []net.IP
+	for _, id := range identities {
+		if id.idType == util.TypeIP {
+			csrIPs = append(csrIPs, net.ParseIP(id.value))
+		}
+	}
+	for _, ip := range ipAddresses {
+		if !containsIP(csrIPs, ip) {
+			return fmt.Errorf("requested IP address %s is not in the CSR, the signer cannot add it", ip)
+		}
+	}
+	for _, ip := range csrIPs {
+		if !containsIP(ipAddresses, ip) {
+			return fmt.Errorf("IP address %s of the CSR is not one of the requested IP addresses", ip)
+		}
+	}
+	return nil
+}
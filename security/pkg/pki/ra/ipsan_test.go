@@ -0,0 +1,194 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"istio.io/istio/security/pkg/pki/ca"
+	raerror "istio.io/istio/security/pkg/pki/error"
+	pkiutil "istio.io/istio/security/pkg/pki/util"
+)
+
+func parseIPs(ips ...string) []net.IP {
+	parsed := make([]net.IP, 0, len(ips))
+	for _, ip := range ips {
+		parsed = append(parsed, net.ParseIP(ip))
+	}
+	return parsed
+}
+
+func TestValidateIPAddresses(t *testing.T) {
+	allowedRanges := []string{"10.0.0.0/24", "203.0.113.0/24", "2001:db8::/32"}
+	testCases := map[string]struct {
+		policy      IPScopePolicy
+		ipAddresses []net.IP
+		workloadIPs []net.IP
+		expectErr   bool
+	}{
+		"none requested": {},
+		"IP of the workload": {
+			ipAddresses: parseIPs("192.168.1.10"),
+			workloadIPs: parseIPs("192.168.1.10"),
+		},
+		"IPv4-in-IPv6 form of the IP of the workload": {
+			ipAddresses: parseIPs("::ffff:192.168.1.10"),
+			workloadIPs: parseIPs("192.168.1.10"),
+		},
+		"IP of another workload": {
+			ipAddresses: parseIPs("192.168.1.11"),
+			workloadIPs: parseIPs("192.168.1.10"),
+			expectErr:   true,
+		},
+		"allowed IP without workload IPs": {
+			ipAddresses: parseIPs("203.0.113.5", "2001:db8::1"),
+		},
+		"IP neither of the workload nor allowed": {
+			ipAddresses: parseIPs("198.51.100.1"),
+			expectErr:   true,
+		},
+		"allowed IP in the scope of the workload": {
+			ipAddresses: parseIPs("10.0.0.5"),
+			workloadIPs: parseIPs("192.168.1.10"),
+		},
+		"allowed public IP for a private workload": {
+			ipAddresses: parseIPs("203.0.113.5"),
+			workloadIPs: parseIPs("192.168.1.10"),
+			expectErr:   true,
+		},
+		"allowed private IP for a public workload": {
+			ipAddresses: parseIPs("10.0.0.5"),
+			workloadIPs: parseIPs("198.51.100.1"),
+			expectErr:   true,
+		},
+		"allowed public IP for a dual-scope workload": {
+			ipAddresses: parseIPs("203.0.113.5"),
+			workloadIPs: parseIPs("192.168.1.10", "2001:db8::10"),
+		},
+		"allowed public IP for a private workload with mismatches allowed": {
+			policy:      IPScopeMismatchAllow,
+			ipAddresses: parseIPs("203.0.113.5"),
+			workloadIPs: parseIPs("192.168.1.10"),
+		},
+		"unspecified IP": {
+			ipAddresses: parseIPs("0.0.0.0"),
+			workloadIPs: parseIPs("0.0.0.0"),
+			expectErr:   true,
+		},
+		"invalid IP": {
+			ipAddresses: []net.IP{nil},
+			expectErr:   true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			raOpts := &IstioRAOptions{AllowedIPRanges: allowedRanges, IPScopePolicy: tc.policy}
+			err := validateIPAddresses(raOpts, ca.CertOpts{IPAddresses: tc.ipAddresses, WorkloadIPs: tc.workloadIPs})
+			if tc.expectErr != (err != nil) {
+				t.Errorf("got error %v, expected an error: %v", err, tc.expectErr)
+			}
+		})
+	}
+}
+
+func TestValidateIPSANOptions(t *testing.T) {
+	for _, raOpts := range []*IstioRAOptions{
+		{},
+		{AllowedIPRanges: []string{"10.0.0.0/8", "2001:db8::/32"}, IPScopePolicy: IPScopeMismatchAllow},
+		{IPScopePolicy: IPScopeMismatchReject},
+	} {
+		if err := validateIPSANOptions(raOpts); err != nil {
+			t.Errorf("unexpected error for %+v: %v", raOpts, err)
+		}
+	}
+	for _, raOpts := range []*IstioRAOptions{
+		{AllowedIPRanges: []string{"10.0.0.1"}},
+		{IPScopePolicy: "reject"},
+	} {
+		var raErr *raerror.Error
+		if err := validateIPSANOptions(raOpts); !errors.As(err, &raErr) || raErr.ErrorType() != "CA_INIT_FAIL" {
+			t.Errorf("expected a CA_INIT_FAIL error for %+v, got: %v", raOpts, err)
+		}
+	}
+}
+
+func TestPreSignIPAddresses(t *testing.T) {
+	csrPEM, _, err := pkiutil.GenCSR(pkiutil.CertOptions{
+		Host:       testCsrHostName + ",10.0.0.5",
+		RSAKeySize: 2048,
+	})
+	if err != nil {
+		t.Fatalf("failed to create CSR: %v", err)
+	}
+	raOpts := &IstioRAOptions{SANValidationMode: SANValidationEnforce}
+	testCases := map[string]struct {
+		ipAddresses []net.IP
+		workloadIPs []net.IP
+		expectErr   bool
+	}{
+		"IP of the CSR requested for the workload": {
+			ipAddresses: parseIPs("10.0.0.5"),
+			workloadIPs: parseIPs("10.0.0.5"),
+		},
+		"IP of the CSR not requested": {
+			workloadIPs: parseIPs("10.0.0.5"),
+			expectErr:   true,
+		},
+		"IP of the CSR requested for another workload": {
+			ipAddresses: parseIPs("10.0.0.5"),
+			workloadIPs: parseIPs("10.0.0.6"),
+			expectErr:   true,
+		},
+		"IP not in the CSR": {
+			ipAddresses: parseIPs("10.0.0.5", "10.0.0.6"),
+			workloadIPs: parseIPs("10.0.0.5", "10.0.0.6"),
+			expectErr:   true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := preSign(raOpts, nil, csrPEM, ca.CertOpts{
+				SubjectIDs:  []string{testCsrHostName},
+				IPAddresses: tc.ipAddresses,
+				WorkloadIPs: tc.workloadIPs,
+				TTL:         time.Hour,
+			})
+			if !tc.expectErr {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			var raErr *raerror.Error
+			if !errors.As(err, &raErr) || raErr.ErrorType() != "CSR_ERROR" {
+				t.Fatalf("expected a CSR_ERROR error, got: %v", err)
+			}
+		})
+	}
+
+	// The IP SANs of the CSR must be requested even when SAN validation is off.
+	_, err = preSign(&IstioRAOptions{SANValidationMode: SANValidationOff}, nil, csrPEM, ca.CertOpts{
+		SubjectIDs:  []string{testCsrHostName},
+		IPAddresses: parseIPs("10.0.0.6"),
+		WorkloadIPs: parseIPs("10.0.0.6"),
+		TTL:         time.Hour,
+	})
+	if err == nil {
+		t.Errorf("expected an error for a CSR with IP SANs other than those requested")
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"math/big"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// IssuanceRecord is the metadata of a certificate recently issued by the RA, returned by RecentIssuances. It
+// holds neither the certificate nor any key material.
+type IssuanceRecord struct {
+	// SerialNumber is the serial number of the certificate.
+	SerialNumber *big.Int
+	// NotAfter is the end of the validity period of the certificate.
+	NotAfter time.Time
+	// CertSigner is the signer that issued the certificate.
+	CertSigner string
+	// IssuedAt is when the RA received the certificate from the signer.
+	IssuedAt time.Time
+}
+
+// issuanceIndex remembers the RecentIssuancesPerIdentity certificates most recently issued to each requesting
+// identity, the first SubjectID of the requests, for debugging. Only the RecentIssuancesIdentities identities
+// most recently issued a certificate are remembered. It is in memory and best effort, the AuditSink records
+// the issued certificates durably. It is safe for concurrent use.
+type issuanceIndex struct {
+	perIdentity int
+	mutex       sync.Mutex
+	// issuances are the issuanceRings of the identities.
+	issuances *lru.Cache
+}
+
+// issuanceRing is a ring buffer of the records of the certificates issued to an identity.
+type issuanceRing struct {
+	records []IssuanceRecord
+	// next is the index of records the next record is written to, once records is full.
+	next int
+}
+
+// newIssuanceIndex returns the issuance index of raOpts, or nil if the issuances are not recorded.
+func newIssuanceIndex(raOpts *IstioRAOptions) (*issuanceIndex, error) {
+	if raOpts.RecentIssuancesPerIdentity <= 0 {
+		return nil, nil
+	}
+	size := raOpts.RecentIssuancesIdentities
+	if size <= 0 {
+		size = DefaultRecentIssuancesIdentities
+	}
+	issuances, err := lru.New(size)
+	if err != nil {
+		return nil, err
+	}
+	return &issuanceIndex{
+		perIdentity: raOpts.RecentIssuancesPerIdentity,
+		issuances:   issuances,
+	}, nil
+}
+
+// observe records the certificate of result, issued for subjectIDs, evicting the oldest record of the identity
+// beyond the RecentIssuancesPerIdentity.
+func (x *issuanceIndex) observe(subjectIDs []string, result *SignResult) {
+	if x == nil || len(subjectIDs) == 0 {
+		return
+	}
+	record := IssuanceRecord{
+		NotAfter:   result.NotAfter,
+		CertSigner: result.CertSigner,
+		IssuedAt:   time.Now(),
+	}
+	if result.SerialNumber != nil {
+		record.SerialNumber = new(big.Int).Set(result.SerialNumber)
+	}
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	var ring *issuanceRing
+	if value, ok := x.issuances.Get(subjectIDs[0]); ok {
+		ring = value.(*issuanceRing)
+	} else {
+		ring = &issuanceRing{}
+		x.issuances.Add(subjectIDs[0], ring)
+	}
+	if len(ring.records) < x.perIdentity {
+		ring.records = append(ring.records, record)
+		return
+	}
+	ring.records[ring.next] = record
+	ring.next = (ring.next + 1) % len(ring.records)
+}
+
+// recent returns the records of the certificates recently issued to subjectID, most recent first.
+func (x *issuanceIndex) recent(subjectID string) []IssuanceRecord {
+	if x == nil {
+		return nil
+	}
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	value, ok := x.issuances.Peek(subjectID)
+	if !ok {
+		return nil
+	}
+	ring := value.(*issuanceRing)
+	records := make([]IssuanceRecord, 0, len(ring.records))
+	for i := 1; i <= len(ring.records); i++ {
+		record := ring.records[(ring.next-i+len(ring.records))%len(ring.records)]
+		if record.SerialNumber != nil {
+			record.SerialNumber = new(big.Int).Set(record.SerialNumber)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// RecentIssuances returns the metadata of the certificates recently issued to subjectID as the first SubjectID
+// of the requests, most recent first, when RecentIssuancesPerIdentity is set. It is best effort: certificates
+// returned from the certificate cache are not recorded again, and the records are lost when the RA restarts.
+func (r *KubernetesRA) RecentIssuances(subjectID string) []IssuanceRecord {
+	return r.issuances.recent(subjectID)
+}
+
+// RecentIssuances returns the metadata of the certificates recently issued to subjectID as the first SubjectID
+// of the requests, most recent first, when RecentIssuancesPerIdentity is set. It is best effort: certificates
+// returned from the certificate cache are not recorded again, and the records are lost when the RA restarts.
+func (r *CertManagerRA) RecentIssuances(subjectID string) []IssuanceRecord {
+	return r.issuances.recent(subjectID)
+}
+
+// RecentIssuances returns the metadata of the certificates recently issued to subjectID as the first SubjectID
+// of the requests, most recent first, when RecentIssuancesPerIdentity is set. It is best effort: certificates
+// returned from the certificate cache are not recorded again, and the records are lost when the RA restarts.
+func (r *VaultRA) RecentIssuances(subjectID string) []IssuanceRecord {
+	return r.issuances.recent(subjectID)
+}
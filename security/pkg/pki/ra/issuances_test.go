@@ -0,0 +1,132 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"istio.io/istio/security/pkg/pki/ca"
+)
+
+func TestIssuanceIndex(t *testing.T) {
+	index, err := newIssuanceIndex(&IstioRAOptions{})
+	if err != nil || index != nil {
+		t.Fatalf("expected no issuance index without RecentIssuancesPerIdentity, got %v, %v", index, err)
+	}
+	index.observe([]string{"a"}, &SignResult{SerialNumber: big.NewInt(1)})
+	if got := index.recent("a"); got != nil {
+		t.Errorf("got %v recorded without an issuance index", got)
+	}
+
+	index, err = newIssuanceIndex(&IstioRAOptions{RecentIssuancesPerIdentity: 2, RecentIssuancesIdentities: 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	serials := func(records []IssuanceRecord) []int64 {
+		var out []int64
+		for _, record := range records {
+			out = append(out, record.SerialNumber.Int64())
+		}
+		return out
+	}
+	notAfter := time.Now().Add(time.Hour)
+	for i := int64(1); i <= 3; i++ {
+		index.observe([]string{"a", "other"}, &SignResult{SerialNumber: big.NewInt(i), NotAfter: notAfter, CertSigner: "signer"})
+	}
+	records := index.recent("a")
+	if got := serials(records); len(got) != 2 || got[0] != 3 || got[1] != 2 {
+		t.Fatalf("got serial numbers %v, want the 2 most recent first", got)
+	}
+	if !records[0].NotAfter.Equal(notAfter) || records[0].CertSigner != "signer" || records[0].IssuedAt.IsZero() {
+		t.Errorf("got record %+v, want the NotAfter, signer and issuance time of the certificate", records[0])
+	}
+	if got := index.recent("other"); got != nil {
+		t.Errorf("got %v recorded for a SubjectID after the first", got)
+	}
+	// the records returned do not alias those of the index
+	records[0].SerialNumber.SetInt64(42)
+	if got := serials(index.recent("a")); got[0] != 3 {
+		t.Errorf("got serial number %d after modifying a returned record, want 3", got[0])
+	}
+
+	// a is forgotten once b and c are issued certificates
+	index.observe([]string{"b"}, &SignResult{SerialNumber: big.NewInt(4)})
+	index.observe([]string{"c"}, &SignResult{SerialNumber: big.NewInt(5)})
+	if got := index.recent("a"); got != nil {
+		t.Errorf("got %v recorded for an evicted identity", got)
+	}
+	if got := serials(index.recent("c")); len(got) != 1 || got[0] != 5 {
+		t.Errorf("got serial numbers %v, want [5]", got)
+	}
+}
+
+func TestIssuanceIndexConcurrent(t *testing.T) {
+	index, err := newIssuanceIndex(&IstioRAOptions{RecentIssuancesPerIdentity: 3})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				index.observe([]string{"a"}, &SignResult{SerialNumber: big.NewInt(int64(i*100 + j))})
+				index.recent("a")
+			}
+		}(i)
+	}
+	wg.Wait()
+	if got := len(index.recent("a")); got != 3 {
+		t.Errorf("got %d records, want 3", got)
+	}
+}
+
+func TestK8sRecentIssuances(t *testing.T) {
+	r, err := NewKubernetesRA(&IstioRAOptions{
+		ExternalCAType:             ExtCAK8s,
+		DefaultCertTTL:             30 * time.Minute,
+		MaxCertTTL:                 time.Hour,
+		CaSigner:                   "kubernates.io/kube-apiserver-client",
+		CaCertFile:                 "../testdata/example-ca-cert.pem",
+		RecentIssuancesPerIdentity: 2,
+		K8sClient:                  initFakeKubeClient(issueFakeCert),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	var results []*SignResult
+	for i := 0; i < 3; i++ {
+		result, err := r.SignWithCertChainResponse(createFakeCsr(t), ca.CertOpts{SubjectIDs: []string{testCsrHostName}, TTL: time.Hour})
+		if err != nil {
+			t.Fatalf("K8s CA Signing CSR failed: %v", err)
+		}
+		results = append(results, result)
+	}
+	records := r.RecentIssuances(testCsrHostName)
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2", len(records))
+	}
+	for i, record := range records {
+		result := results[len(results)-1-i]
+		if record.SerialNumber.Cmp(result.SerialNumber) != 0 || !record.NotAfter.Equal(result.NotAfter) ||
+			record.CertSigner != result.CertSigner {
+			t.Errorf("got record %d %+v, want that of certificate %d", i, record, len(results)-1-i)
+		}
+	}
+}
@@ -15,9 +15,16 @@
 package ra
 
 import (
+	"context"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
+	"go.opencensus.io/trace"
 	cert "k8s.io/api/certificates/v1"
 	clientset "k8s.io/client-go/kubernetes"
 
@@ -27,77 +34,766 @@ import (
 	"istio.io/istio/security/pkg/pki/util"
 )
 
+// eagerRBACCheckTimeout is the timeout of the EagerRBACCheck of NewKubernetesRA.
+const eagerRBACCheckTimeout = 10 * time.Second
+
 // KubernetesRA integrated with an external CA using Kubernetes CSR API
 type KubernetesRA struct {
-	csrInterface  clientset.Interface
+	csrInterface clientset.Interface
+	raOpts       *IstioRAOptions
+	// mutex protects keyCertBundle, which is swapped when CaCertFile is reloaded, retiredRootCerts,
+	// signerBundles, caCertPending, signerProbeErr, caCertWatcher, signerCaCertWatchers and reloadCallbacks.
+	mutex sync.RWMutex
+	// reloadMutex serializes the reloads of the CA bundle, so that a bundle read before another one is not
+	// swapped in after it.
+	reloadMutex   sync.Mutex
 	keyCertBundle *util.KeyCertBundle
-	raOpts        *IstioRAOptions
+	// retiredRootCerts are the root certs removed from CaCertFile that are still in keyCertBundle.
+	retiredRootCerts []retiredRootCert
+	// certChains holds the chains of CertChainFiles, one per issuing CA.
+	certChains []certChain
+	// signerBundles holds the CA bundles of the signers in SignerCaCertFiles, keyed by signer name.
+	signerBundles map[string]*util.KeyCertBundle
+	// caCertPending is set while waiting for CaCertFile to be loaded with WaitForCaCertFile.
+	caCertPending bool
+	// signerProbeErr is the result of the last signer probe when ProbeSigner is set, errSignerProbePending until
+	// the first one completes.
+	signerProbeErr error
+	// caCertSecret is the Secret the CA root certs are read from instead of CaCertFile, when created with
+	// NewKubernetesRAFromSecret.
+	caCertSecret *caCertSecret
+	// caCertWatcher watches CaCertFile for changes when WatchCaCertFile is set.
+	caCertWatcher *fsnotify.Watcher
+	// signerCaCertWatchers watch the SignerCaCertFiles for changes when WatchCaCertFile is set, one per signer.
+	signerCaCertWatchers []*fsnotify.Watcher
+	// signerReloadMutex serializes the reloads of the CA bundles of the signers.
+	signerReloadMutex sync.Mutex
+	// reloadCallbacks are called with the new CA bundle each time it is swapped in.
+	reloadCallbacks []func(*util.KeyCertBundle)
+	// certCache caches the issued certificates when CertCacheSize is set.
+	certCache *certCache
+	// serials detects the serial numbers reused by the signers.
+	serials *serialTracker
+	// keyReuse detects the CSR keys reused between identities when RejectReusedKeys is set.
+	keyReuse *keyReuseTracker
+	// issuances remembers the certificates recently issued to each identity when RecentIssuancesPerIdentity is set.
+	issuances *issuanceIndex
+	// rateLimiter limits the rate of signing requests of each identity when IdentityRateLimit is set.
+	rateLimiter *identityRateLimiter
+	// csrLimiter limits the CSRs outstanding at once when MaxConcurrentCSRs is set.
+	csrLimiter *csrLimiter
+	// circuits stop sending requests to the failing signers when CircuitBreakerFailureThreshold is set.
+	circuits *circuitBreakers
+	// missingCertChainSigners are the signers for which a missing cert chain has been logged.
+	missingCertChainSigners sync.Map
+	// inflight deduplicates concurrent identical signing requests.
+	inflight  inflightGroup
+	stopCh    chan struct{}
+	closeOnce sync.Once
+	// shutdownMutex guards shuttingDown, and is held for reading while registering signing requests in
+	// inflightSigns, so that none is registered once Shutdown waits for them.
+	shutdownMutex sync.RWMutex
+	shuttingDown  bool
+	// inflightSigns tracks the signing requests in flight and the background cleanup of their CSRs.
+	inflightSigns sync.WaitGroup
 }
 
 // NewKubernetesRA : Create a RA that interfaces with K8S CSR CA
 func NewKubernetesRA(raOpts *IstioRAOptions) (*KubernetesRA, error) {
-	keyCertBundle, err := util.NewKeyCertBundleWithRootCertFromFile(raOpts.CaCertFile)
-	if err != nil {
-		return nil, raerror.NewError(raerror.CAInitFail, fmt.Errorf("error processing Certificate Bundle for Kubernetes RA"))
+	return newKubernetesRA(raOpts, nil)
+}
+
+// newKubernetesRA creates a RA that interfaces with K8S CSR CA, reading the CA root certs from secret if not nil,
+// or else from CaCertFile. The CA bundle is loaded before it is checked and recorded.
+func newKubernetesRA(raOpts *IstioRAOptions, secret *caCertSecret) (*KubernetesRA, error) {
+	if raOpts.CaSigner == "" && raOpts.CertSignerDomain == "" {
+		return nil, raerror.NewError(raerror.CAInitFail,
+			fmt.Errorf("a CA signer or a signer domain for the requested signers is required for the Kubernetes RA"))
+	}
+	if err := validateRAOptions(raOpts); err != nil {
+		return nil, err
+	}
+	warnUnsupportedNotBeforeBackdate(raOpts, "Kubernetes")
+	if err := chiron.ValidateCSRMetadata(raOpts.CSRLabels, raOpts.CSRAnnotations); err != nil {
+		return nil, raerror.NewError(raerror.CAInitFail, err)
+	}
+	if err := validateInstanceLabel(raOpts); err != nil {
+		return nil, raerror.NewError(raerror.CAInitFail, err)
+	}
+	if err := chiron.ValidateWaitStrategy(raOpts.CSRWaitStrategy); err != nil {
+		return nil, raerror.NewError(raerror.CAInitFail, err)
+	}
+	if raOpts.CaSigner != "" {
+		if err := validateSignerName(raOpts.CaSigner); err != nil {
+			return nil, raerror.NewError(raerror.CAInitFail, fmt.Errorf("invalid CA signer: %v", err))
+		}
+	}
+	if raOpts.CertSignerDomain != "" {
+		if err := validateSignerName(joinSignerName(raOpts.CertSignerDomain, "signer")); err != nil {
+			return nil, raerror.NewError(raerror.CAInitFail, fmt.Errorf("invalid signer domain %q: %v", raOpts.CertSignerDomain, err))
+		}
+	}
+	for _, signerName := range raOpts.FallbackSigners {
+		if err := validateSignerName(signerName); err != nil {
+			return nil, raerror.NewError(raerror.CAInitFail, fmt.Errorf("invalid fallback signer: %v", err))
+		}
+		if len(raOpts.AllowedSigners) > 0 && !signerAllowed(raOpts.AllowedSigners, signerName) {
+			return nil, raerror.NewError(raerror.CAInitFail, fmt.Errorf("fallback signer %s is not allowed", signerName))
+		}
+	}
+	keyCertBundle := util.NewKeyCertBundleFromPem(nil, nil, nil, nil)
+	caCertPending := false
+	if secret != nil {
+		loaded, err := loadCABundleFromSecret(context.Background(), raOpts.K8sClient, secret)
+		if err != nil {
+			return nil, raerror.NewError(raerror.CAInitFail, fmt.Errorf("error processing Certificate Bundle for Kubernetes RA: %v", err))
+		}
+		keyCertBundle = loaded
+	} else if raOpts.CaCertFile != "" {
+		loaded, err := loadCABundle(raOpts.CaCertFile)
+		switch {
+		case err == nil:
+			keyCertBundle = loaded
+		case raOpts.WaitForCaCertFile:
+			pkiRaLog.Warnf("CA cert file %s cannot be loaded yet, waiting for it: %v", raOpts.CaCertFile, err)
+			caCertPending = true
+		default:
+			return nil, raerror.NewError(raerror.CAInitFail, fmt.Errorf("error processing Certificate Bundle for Kubernetes RA: %v", err))
+		}
 	}
 	istioRA := &KubernetesRA{
 		csrInterface:  raOpts.K8sClient,
 		raOpts:        raOpts,
 		keyCertBundle: keyCertBundle,
+		caCertPending: caCertPending,
+		caCertSecret:  secret,
+		signerBundles: map[string]*util.KeyCertBundle{},
+		stopCh:        make(chan struct{}),
+		serials:       newSerialTracker(raOpts),
+		csrLimiter:    newCSRLimiter(raOpts),
+		circuits:      newCircuitBreakers(raOpts),
+	}
+	recordRootCertExpiry(raOpts.CaSigner, keyCertBundle.GetRootCertPem(), time.Now())
+	if err := checkSignerDomain(raOpts, keyCertBundle.GetRootCertPem()); err != nil {
+		return nil, err
+	}
+	if raOpts.CertCacheSize > 0 {
+		certCache, err := newCertCache(raOpts)
+		if err != nil {
+			return nil, raerror.NewError(raerror.CAInitFail, fmt.Errorf("error creating the certificate cache: %v", err))
+		}
+		istioRA.certCache = certCache
+	}
+	keyReuse, err := newKeyReuseTracker(raOpts)
+	if err != nil {
+		return nil, raerror.NewError(raerror.CAInitFail, fmt.Errorf("error creating the key reuse tracker: %v", err))
+	}
+	istioRA.keyReuse = keyReuse
+	issuances, err := newIssuanceIndex(raOpts)
+	if err != nil {
+		return nil, raerror.NewError(raerror.CAInitFail, fmt.Errorf("error creating the issuance index: %v", err))
+	}
+	istioRA.issuances = issuances
+	rateLimiter, err := newIdentityRateLimiter(raOpts)
+	if err != nil {
+		return nil, raerror.NewError(raerror.CAInitFail, fmt.Errorf("error creating the rate limiter: %v", err))
+	}
+	istioRA.rateLimiter = rateLimiter
+	if len(raOpts.CertChainFiles) > 0 {
+		certChains, err := loadCertChains(raOpts.CertChainFiles)
+		if err != nil {
+			return nil, raerror.NewError(raerror.CAInitFail, fmt.Errorf("error processing cert chains for Kubernetes RA: %v", err))
+		}
+		istioRA.certChains = certChains
+	}
+	if raOpts.EagerRBACCheck {
+		ctx, cancel := context.WithTimeout(context.Background(), eagerRBACCheckTimeout)
+		defer cancel()
+		if err := istioRA.checkCSRPermissions(ctx); err != nil {
+			return nil, raerror.NewError(raerror.CAInitFail, fmt.Errorf("error checking the RBAC permissions of Kubernetes RA: %w", err))
+		}
+	}
+	for signerName, caCertFile := range raOpts.SignerCaCertFiles {
+		signerBundle, err := loadCABundle(caCertFile)
+		if err != nil {
+			return nil, raerror.NewError(raerror.CAInitFail, fmt.Errorf("error processing Certificate Bundle of signer %s for Kubernetes RA: %v",
+				signerName, err))
+		}
+		istioRA.signerBundles[signerName] = signerBundle
+		now := time.Now()
+		recordSignerCABundleReload(signerName, now)
+		recordRootCertExpiry(signerName, signerBundle.GetRootCertPem(), now)
+	}
+	if raOpts.WatchCaCertFile {
+		for signerName, caCertFile := range raOpts.SignerCaCertFiles {
+			if err := istioRA.watchSignerCaCertFile(signerName, caCertFile); err != nil {
+				istioRA.Close()
+				return nil, raerror.NewError(raerror.CAInitFail, fmt.Errorf("error watching CA cert file %s of signer %s: %v",
+					caCertFile, signerName, err))
+			}
+		}
+	}
+	if raOpts.ProbeSigner && raOpts.CaSigner != "" {
+		istioRA.signerProbeErr = errSignerProbePending
+		go istioRA.runSignerProbe()
+	}
+	if caCertPending {
+		go istioRA.waitForCaCertFile()
+	} else if raOpts.WatchCaCertFile && raOpts.CaCertFile != "" {
+		if err := istioRA.watchCaCertFile(); err != nil {
+			// Stops the signer CA watchers and the signer probe started above.
+			istioRA.Close()
+			return nil, raerror.NewError(raerror.CAInitFail, fmt.Errorf("error watching CA cert file %s: %v", raOpts.CaCertFile, err))
+		}
+	}
+	if secret != nil {
+		istioRA.watchCaCertSecret()
 	}
 	return istioRA, nil
 }
 
-func (r *KubernetesRA) kubernetesSign(csrPEM []byte, caCertFile string, certSigner string,
-	requestedLifetime time.Duration) ([]byte, error) {
+// NewKubernetesRAFromSecret : Create a RA that interfaces with K8S CSR CA, reading the CA root certs from the key
+// of the Secret namespace/secretName with K8sClient instead of from CaCertFile. The CA bundle is reloaded when
+// the Secret changes.
+func NewKubernetesRAFromSecret(raOpts *IstioRAOptions, namespace, secretName, key string) (*KubernetesRA, error) {
+	if raOpts.K8sClient == nil {
+		return nil, raerror.NewError(raerror.CAInitFail, fmt.Errorf("a K8s client is required to read the CA cert Secret"))
+	}
+	if raOpts.CaCertFile != "" {
+		return nil, raerror.NewError(raerror.CAInitFail, fmt.Errorf("a CA cert file and a CA cert Secret cannot both be configured"))
+	}
+	return newKubernetesRA(raOpts, &caCertSecret{namespace: namespace, name: secretName, key: key})
+}
+
+// resolveSigner returns the name of the K8s signer to use for the certSigner requested by a workload.
+func (r *KubernetesRA) resolveSigner(certSigner string) (string, error) {
 	certSignerDomain := r.raOpts.CertSignerDomain
 	if certSignerDomain == "" && certSigner != "" {
-		return nil, raerror.NewError(raerror.CertGenError, fmt.Errorf("certSignerDomain is requiered for signer %s", certSigner))
+		return "", raerror.NewError(raerror.CertGenError, fmt.Errorf("certSignerDomain is requiered for signer %s", certSigner))
 	}
 	if certSignerDomain != "" && certSigner != "" {
-		certSigner = certSignerDomain + "/" + certSigner
-	} else {
-		certSigner = r.raOpts.CaSigner
-	}
-	usages := []cert.KeyUsage{
-		cert.UsageDigitalSignature,
-		cert.UsageKeyEncipherment,
-		cert.UsageServerAuth,
-		cert.UsageClientAuth,
-	}
-	certChain, _, err := chiron.SignCSRK8s(r.csrInterface, csrPEM, certSigner,
-		nil, usages, "", caCertFile, true, false, requestedLifetime)
+		signerName := joinSignerName(certSignerDomain, certSigner)
+		if err := validateSignerName(signerName); err != nil {
+			return "", raerror.NewError(raerror.CertGenError, fmt.Errorf("invalid requested signer: %v", err))
+		}
+		if len(r.raOpts.AllowedSigners) > 0 && !signerAllowed(r.raOpts.AllowedSigners, signerName) {
+			return "", raerror.NewError(raerror.CertGenError, fmt.Errorf("signer %s is not allowed", signerName))
+		}
+		return signerName, nil
+	}
+	if r.raOpts.CaSigner == "" {
+		return "", raerror.NewError(raerror.CertGenError, fmt.Errorf(
+			"no signer is requested and no CA signer is configured, a signer must be requested with signer domain %s",
+			certSignerDomain))
+	}
+	return r.raOpts.CaSigner, nil
+}
+
+// joinSignerName returns the name of signer in the signer domain, ignoring the slashes around them, so that a
+// domain configured with a trailing slash does not yield an empty path segment.
+func joinSignerName(domain, signer string) string {
+	return strings.TrimRight(domain, "/") + "/" + strings.TrimLeft(signer, "/")
+}
+
+// caCertFileForSigner returns the CA cert file trusted for signerName, falling back to CaCertFile
+// for signers without a SignerCaCertFiles entry.
+func (r *KubernetesRA) caCertFileForSigner(signerName string) (string, error) {
+	if caCertFile, ok := r.raOpts.SignerCaCertFiles[signerName]; ok {
+		return caCertFile, nil
+	}
+	if len(r.raOpts.SignerCaCertFiles) > 0 && r.raOpts.CaCertFile == "" {
+		return "", raerror.NewError(raerror.CertGenError, fmt.Errorf("no CA cert file is configured for signer %s", signerName))
+	}
+	return r.raOpts.CaCertFile, nil
+}
+
+// bundleForSigner returns the CA bundle of signerName, falling back to the default CA bundle
+// for signers without a SignerCaCertFiles entry.
+func (r *KubernetesRA) bundleForSigner(signerName string) *util.KeyCertBundle {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	if signerBundle, ok := r.signerBundles[signerName]; ok {
+		return signerBundle
+	}
+	return r.keyCertBundle
+}
+
+// chainForCert returns the cert chain to append to leafCert issued by signerName: the chain of its
+// SignerCaCertFiles entry if any, or else the CertChainFiles chain of the CA that issued leafCert, or else the
+// chain of the default CA bundle. It is an error for none of the CertChainFiles to match the issuer of leafCert,
+// as the chain appended would not validate it.
+func (r *KubernetesRA) chainForCert(signerName string, leafCert *x509.Certificate) ([]byte, error) {
+	if _, ok := r.raOpts.SignerCaCertFiles[signerName]; !ok && len(r.certChains) > 0 {
+		if certChain, ok := chainForIssuer(r.certChains, leafCert); ok {
+			return certChain, nil
+		}
+		return nil, fmt.Errorf("no cert chain is configured for issuer %s of the certificate", leafCert.Issuer)
+	}
+	return r.bundleForSigner(signerName).GetCertChainPem(), nil
+}
+
+func (r *KubernetesRA) kubernetesSign(ctx context.Context, csrPEM []byte, certOpts ca.CertOpts, caCertFile string,
+	certSigner string, usages []cert.KeyUsage, requestedLifetime time.Duration) (_ []byte, err error) {
+	ctx, span := trace.StartSpan(ctx, kubernetesSignSpanName)
+	span.AddAttributes(trace.StringAttribute(signerAttribute, certSigner))
+	defer func() {
+		endSpan(span, err)
+	}()
+	client, err := r.clientForSigner(certSigner)
 	if err != nil {
 		return nil, raerror.NewError(raerror.CertGenError, err)
 	}
+	if certOpts.IdempotencyKey != "" {
+		if certChain := r.issuedForIdempotencyKey(ctx, client, csrPEM, certSigner, certOpts, requestedLifetime); certChain != nil {
+			return certChain, nil
+		}
+	}
+	signOpts := r.chironSignOptions(csrPEM, certOpts, certSigner)
+	// The configured signers, including the FallbackSigners, are labeled by name.
+	signerLabel := certSigner
+	if certOpts.CertSigner != "" {
+		signerLabel = customSignerLabel
+	}
+	if err := r.circuits.allow(certSigner, signerLabel); err != nil {
+		return nil, err
+	}
+	defer func() {
+		r.circuits.done(certSigner, signerLabel, err)
+	}()
+	if err := r.csrLimiter.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer r.csrLimiter.release()
+	attempts := 0
+	certChain, err := signWithRetry(ctx, r.raOpts, certOpts.RequestID, func() ([]byte, error) {
+		attempts++
+		certChain, _, err := chiron.SignCSRK8sWithContext(ctx, client, csrPEM, certSigner, nil, usages, "",
+			caCertFile, r.shouldApprove(certSigner, certOpts.RequestID), false, requestedLifetime, signOpts)
+		return certChain, err
+	})
+	span.AddAttributes(trace.Int64Attribute(retryCountAttribute, int64(attempts-1)))
+	if err != nil {
+		switch {
+		case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+			return nil, raerror.NewError(raerror.RequestCanceled, err)
+		case errors.Is(err, chiron.ErrCSRDenied):
+			return nil, raerror.NewError(raerror.CSRDenied, err)
+		case errors.Is(err, chiron.ErrCSRNotIssued):
+			return nil, raerror.NewError(raerror.CSRPending, err)
+		case errors.Is(err, chiron.ErrCSRFailed):
+			return nil, raerror.NewError(raerror.CSRFailed, err)
+		}
+		return nil, raerror.NewError(raerror.CertGenError, err)
+	}
 	return certChain, err
 }
 
+// clientForSigner returns the K8s API client creating the CSRs of signerName.
+func (r *KubernetesRA) clientForSigner(signerName string) (clientset.Interface, error) {
+	if r.raOpts.ClientForSigner == nil {
+		return r.csrInterface, nil
+	}
+	client, err := r.raOpts.ClientForSigner(signerName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the K8s client of signer %s: %v", signerName, err)
+	}
+	if client == nil {
+		return nil, fmt.Errorf("no K8s client is configured for signer %s", signerName)
+	}
+	return client, nil
+}
+
+// autoApprove returns whether AutoApprove is enabled.
+func (r *KubernetesRA) autoApprove() bool {
+	return r.raOpts.AutoApprove == nil || *r.raOpts.AutoApprove
+}
+
+// shouldApprove returns whether the RA approves the CSR it creates for signerName for the request requestID.
+// Chiron only approves the CSR it has just created, never pre-existing ones.
+func (r *KubernetesRA) shouldApprove(signerName, requestID string) bool {
+	if !r.autoApprove() {
+		return false
+	}
+	if r.approvesSigner(signerName) {
+		return true
+	}
+	requestLog(requestID).Warnf("not approving CSR for signer %s outside of the configured signer domain", signerName)
+	return false
+}
+
+// approvesSigner returns whether signerName is CaSigner or in the CertSignerDomain, whose CSRs the RA approves
+// with AutoApprove.
+func (r *KubernetesRA) approvesSigner(signerName string) bool {
+	if signerName == r.raOpts.CaSigner {
+		return true
+	}
+	return r.raOpts.CertSignerDomain != "" && strings.HasPrefix(signerName, joinSignerName(r.raOpts.CertSignerDomain, ""))
+}
+
+// chironSignOptions returns the options for signing csrPEM through chiron with certSigner.
+func (r *KubernetesRA) chironSignOptions(csrPEM []byte, certOpts ca.CertOpts, certSigner string) *chiron.SignOptions {
+	cleanUpTimeout := r.raOpts.CSRCleanupTimeout
+	if cleanUpTimeout <= 0 {
+		cleanUpTimeout = DefaultCSRCleanupTimeout
+	}
+	pollInitialDelay := r.raOpts.CSRPollInitialDelay
+	if pollInitialDelay <= 0 {
+		pollInitialDelay = DefaultCSRPollInitialDelay
+	}
+	pollMaxInterval := r.raOpts.CSRPollMaxInterval
+	if pollMaxInterval <= 0 {
+		pollMaxInterval = DefaultCSRPollMaxInterval
+	}
+	// Negative retries disable them, chiron then reads the CSR no more times.
+	emptyCertificateRetries := r.raOpts.CSREmptyCertificateRetries
+	if emptyCertificateRetries == 0 {
+		emptyCertificateRetries = DefaultCSREmptyCertificateRetries
+	}
+	emptyCertificateRetryInterval := r.raOpts.CSREmptyCertificateRetryInterval
+	if emptyCertificateRetryInterval <= 0 {
+		emptyCertificateRetryInterval = DefaultCSREmptyCertificateRetryInterval
+	}
+	// While signing is retried, chiron creates the CSR only once per attempt, rather than with its own retries.
+	submitRetries := 0
+	if signMaxAttempts(r.raOpts) > 1 {
+		submitRetries = 1
+	}
+	csrNameFunc := r.raOpts.CSRNameFunc
+	if csrNameFunc == nil {
+		csrNameFunc = DefaultCSRName
+	}
+	annotations := map[string]string{}
+	for key, value := range certOpts.CSRAnnotations {
+		annotations[key] = value
+	}
+	for key, value := range r.raOpts.CSRAnnotations {
+		annotations[key] = value
+	}
+	annotations[requestIDAnnotation] = certOpts.RequestID
+	labels := r.raOpts.CSRLabels
+	if r.raOpts.InstanceLabel != "" || certOpts.IdempotencyKey != "" {
+		labels = map[string]string{}
+		for key, value := range r.raOpts.CSRLabels {
+			labels[key] = value
+		}
+		if r.raOpts.InstanceLabel != "" {
+			labels[instanceLabel] = r.raOpts.InstanceLabel
+		}
+	}
+	skipCleanUp := r.raOpts.CleanupCSR != nil && !*r.raOpts.CleanupCSR
+	if certOpts.IdempotencyKey != "" {
+		labels[idempotencyKeyLabel] = idempotencyKeyHash(certSigner, certOpts.IdempotencyKey)
+		// The CSR is kept for retries of the request, until K8s garbage collects it or a request with the
+		// same key finds it expired.
+		skipCleanUp = true
+	}
+	return &chiron.SignOptions{
+		Labels:          labels,
+		Annotations:     annotations,
+		ApprovalTimeout: r.raOpts.ApprovalTimeout,
+		GenCSRName: func() string {
+			return csrNameFunc(csrPEM, certOpts)
+		},
+		SkipCleanUp:    skipCleanUp,
+		CleanUpTimeout: cleanUpTimeout,
+		CleanUpGroup:   &r.inflightSigns,
+		OnCleanUpFailure: func(csrName string, err error) {
+			requestLog(certOpts.RequestID).Warnf("failed to clean up CSR %s, it is left orphaned: %v", csrName, err)
+			orphanedCSRCounts.Increment()
+		},
+		WaitStrategy:                  r.raOpts.CSRWaitStrategy,
+		PollInitialDelay:              pollInitialDelay,
+		PollMaxInterval:               pollMaxInterval,
+		EmptyCertificateRetries:       emptyCertificateRetries,
+		EmptyCertificateRetryInterval: emptyCertificateRetryInterval,
+		SubmitRetries:                 submitRetries,
+		OnWaitStrategy: func(strategy chiron.WaitStrategy) {
+			csrWaitCounts.With(strategyTag.Value(string(strategy))).Increment()
+		},
+	}
+}
+
 // Sign takes a PEM-encoded CSR and cert opts, and returns a certificate signed by k8s CA.
 func (r *KubernetesRA) Sign(csrPEM []byte, certOpts ca.CertOpts) ([]byte, error) {
-	_, err := preSign(r.raOpts, csrPEM, certOpts.SubjectIDs, certOpts.TTL, certOpts.ForCA)
+	return r.SignContext(context.Background(), csrPEM, certOpts)
+}
+
+// SignContext is similar to Sign, but gives up waiting for the k8s CA once ctx is done.
+func (r *KubernetesRA) SignContext(ctx context.Context, csrPEM []byte, certOpts ca.CertOpts) ([]byte, error) {
+	result, err := r.SignWithCertChainResponseContext(ctx, csrPEM, certOpts)
 	if err != nil {
 		return nil, err
 	}
-	certSigner := certOpts.CertSigner
-
-	return r.kubernetesSign(csrPEM, r.raOpts.CaCertFile, certSigner, certOpts.TTL)
+	return result.CertPEM, nil
 }
 
 // SignWithCertChain is similar to Sign but returns the leaf cert and the entire cert chain.
 func (r *KubernetesRA) SignWithCertChain(csrPEM []byte, certOpts ca.CertOpts) ([]byte, error) {
-	cert, err := r.Sign(csrPEM, certOpts)
+	return r.SignWithCertChainContext(context.Background(), csrPEM, certOpts)
+}
+
+// SignWithCertChainContext is similar to SignContext but returns the leaf cert and the entire cert chain.
+func (r *KubernetesRA) SignWithCertChainContext(ctx context.Context, csrPEM []byte, certOpts ca.CertOpts) ([]byte, error) {
+	result, err := r.SignWithCertChainResponseContext(ctx, csrPEM, certOpts)
+	if err != nil {
+		return nil, err
+	}
+	return result.CertChainPEM, nil
+}
+
+// SignWithCertChainResponse is similar to SignWithCertChain, but also returns the parsed details of
+// the issued certificate.
+func (r *KubernetesRA) SignWithCertChainResponse(csrPEM []byte, certOpts ca.CertOpts) (*SignResult, error) {
+	return r.SignWithCertChainResponseContext(context.Background(), csrPEM, certOpts)
+}
+
+// SignWithCertChainResponseContext is similar to SignWithCertChainResponse, but gives up waiting for
+// the k8s CA once ctx is done.
+func (r *KubernetesRA) SignWithCertChainResponseContext(ctx context.Context, csrPEM []byte,
+	certOpts ca.CertOpts) (*SignResult, error) {
+	return r.SignParsed(ctx, nil, csrPEM, certOpts)
+}
+
+// SignParsed is similar to SignWithCertChainResponseContext, but takes the CSR raw already parsed as csr, so
+// that it is not parsed again. A nil csr is parsed from raw.
+func (r *KubernetesRA) SignParsed(ctx context.Context, csr *x509.CertificateRequest, raw []byte,
+	certOpts ca.CertOpts) (result *SignResult, err error) {
+	start := time.Now()
+	certOpts = withRequestID(certOpts)
+	ctx, span := startSignSpan(ctx, raw, certOpts.CertSigner, certOpts.RequestID)
+	signLog := startSignLog(r.signerMetricLabel(certOpts.CertSigner), raw, certOpts)
+	defer func() {
+		recordSign(r.signerMetricLabel(certOpts.CertSigner), start, err)
+		endSpan(span, err)
+		signLog.end(err)
+	}()
+	if err = r.beginSign(); err != nil {
+		return nil, requestError(err, certOpts.RequestID)
+	}
+	defer r.endSign()
+	result, err = r.sign(ctx, csr, raw, certOpts)
+	return result, requestError(err, certOpts.RequestID)
+}
+
+// kubernetesRequest is a signing request validated for the K8s CSR API.
+type kubernetesRequest struct {
+	*validatedRequest
+	// signer is the name of the K8s signer to sign with.
+	signer string
+	// caCertFile is the CA cert file trusted for signer.
+	caCertFile string
+	// usages are the key usages to request.
+	usages []cert.KeyUsage
+}
+
+// validate runs the checks of signing csrPEM, parsed as csr if not nil, with certOpts, and returns the
+// validated request.
+func (r *KubernetesRA) validate(csr *x509.CertificateRequest, csrPEM []byte,
+	certOpts ca.CertOpts) (*kubernetesRequest, error) {
+	r.mutex.RLock()
+	caCertPending := r.caCertPending
+	r.mutex.RUnlock()
+	if caCertPending {
+		return nil, raerror.NewError(raerror.CAInitFail, fmt.Errorf("CA cert file %s is not loaded yet", r.raOpts.CaCertFile))
+	}
+	req, err := preSign(r.raOpts, csr, csrPEM, certOpts)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateSubject(r.raOpts, req.csr.Subject); err != nil {
+		return nil, raerror.NewError(raerror.CSRError, fmt.Errorf("invalid CSR: %v", err))
+	}
+	certSigner, err := r.resolveSigner(certOpts.CertSigner)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkProfileSigner(req.profile, certOpts.Profile, certSigner); err != nil {
+		return nil, err
+	}
+	if req.lifetime, err = clampToRootExpiry(r.raOpts, r.bundleForSigner(certSigner), req.lifetime, time.Now(),
+		certOpts.RequestID); err != nil {
+		return nil, err
+	}
+	caCertFile, err := r.caCertFileForSigner(certSigner)
+	if err != nil {
+		return nil, err
+	}
+	usages, err := keyUsages(certOpts, req.profile)
+	if err != nil {
+		return nil, err
+	}
+	if req.extKeyUsages, err = requestedExtKeyUsages(r.raOpts, certOpts); err != nil {
+		return nil, err
+	}
+	if err := r.validateCSRAnnotations(certOpts.CSRAnnotations); err != nil {
+		return nil, raerror.NewError(raerror.CSRError, err)
+	}
+	// The K8s CSR API cannot request them, they are added by the signer if at all.
+	if err := rejectUnsupportedExtensions(certOpts, "the K8s CSR API"); err != nil {
+		return nil, err
+	}
+	return &kubernetesRequest{
+		validatedRequest: req,
+		signer:           certSigner,
+		caCertFile:       caCertFile,
+		usages:           usages,
+	}, nil
+}
+
+// validateCSRAnnotations checks that the CSR annotations requested for a CSR are valid, and do not override
+// the CSRAnnotations of the RA or the request ID annotation.
+func (r *KubernetesRA) validateCSRAnnotations(annotations map[string]string) error {
+	for key := range annotations {
+		if _, ok := r.raOpts.CSRAnnotations[key]; ok || key == requestIDAnnotation {
+			return fmt.Errorf("CSR annotation %q is set by the RA", key)
+		}
+	}
+	return chiron.ValidateCSRMetadata(nil, annotations)
+}
+
+// Validate checks whether csrPEM and certOpts would be accepted for signing, without creating a CSR.
+// It returns the same errors as Sign for requests that are not.
+func (r *KubernetesRA) Validate(csrPEM []byte, certOpts ca.CertOpts) error {
+	_, err := r.validate(nil, csrPEM, certOpts)
+	return requestError(err, certOpts.RequestID)
+}
+
+// sign validates and authorizes csrPEM, parsed as csr if not nil, and has it signed by the k8s CA unless a
+// certificate is cached for it. Concurrent identical requests share a single signing.
+func (r *KubernetesRA) sign(ctx context.Context, csr *x509.CertificateRequest, csrPEM []byte,
+	certOpts ca.CertOpts) (*SignResult, error) {
+	req, err := r.validate(csr, csrPEM, certOpts)
 	if err != nil {
 		return nil, err
 	}
-	chainPem := r.GetCAKeyCertBundle().GetCertChainPem()
-	if len(chainPem) > 0 {
-		cert = append(cert, chainPem...)
+	// The identities listed again are dropped from the rest of the request, e.g. its cache and rate limit keys.
+	certOpts.SubjectIDs = req.subjectIDs
+	if err := runPreSignHook(ctx, r.raOpts, csrPEM, certOpts, req.validatedRequest); err != nil {
+		return nil, err
+	}
+	if err := r.keyReuse.check(req.validatedRequest, certOpts.SubjectIDs); err != nil {
+		return nil, err
+	}
+	key := certCacheKey(csrPEM, certOpts)
+	if r.certCache != nil {
+		if result := r.certCache.get(key, time.Now()); result != nil {
+			return result, nil
+		}
+	}
+	if err := checkRateLimit(r.rateLimiter, certOpts.SubjectIDs, req.signer, r.signerMetricLabel(certOpts.CertSigner)); err != nil {
+		return nil, err
 	}
-	return cert, nil
+	return signDeduplicated(ctx, &r.inflight, inflightKey(req.signer, key, certOpts), req.requestID, signTimeout(r.raOpts),
+		&r.inflightSigns, func(ctx context.Context) (*SignResult, error) {
+			return r.signUncached(ctx, csrPEM, certOpts, req, key)
+		})
+}
+
+// signUncached has the validated csrPEM signed by the k8s CA, and caches the certificate under key.
+func (r *KubernetesRA) signUncached(ctx context.Context, csrPEM []byte, certOpts ca.CertOpts, req *kubernetesRequest,
+	key string) (*SignResult, error) {
+	result, err := signCheckingClockSkew(r.raOpts, r.signerMetricLabel(certOpts.CertSigner), req.requestID, func() (*SignResult, error) {
+		return r.signWithFallback(ctx, csrPEM, certOpts, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.serials.observe(result.CertSigner, r.signerMetricLabel(certOpts.CertSigner), result)
+	r.keyReuse.observe(req.validatedRequest, certOpts.SubjectIDs)
+	recordIssuedLifetime(r.signerMetricLabel(certOpts.CertSigner), result)
+	checkCertChainDepth(r.raOpts, r.signerMetricLabel(certOpts.CertSigner), req.requestID, result)
+	if err := recordIssuance(r.raOpts, r.signerMetricLabel(certOpts.CertSigner), certOpts, result); err != nil {
+		return nil, err
+	}
+	r.issuances.observe(certOpts.SubjectIDs, result)
+	if r.certCache != nil {
+		r.certCache.add(key, result, time.Now())
+	}
+	return result, nil
+}
+
+// signWithFallback has csrPEM signed by the signer of req, and then by each of the FallbackSigners for
+// requests to the CaSigner, until one is available. The chain appended is the one of the signer that issued
+// the certificate.
+func (r *KubernetesRA) signWithFallback(ctx context.Context, csrPEM []byte, certOpts ca.CertOpts,
+	req *kubernetesRequest) (*SignResult, error) {
+	signers := []string{req.signer}
+	if certOpts.CertSigner == "" {
+		signers = append(signers, r.raOpts.FallbackSigners...)
+	}
+	var err error
+	for i, signer := range signers {
+		caCertFile := req.caCertFile
+		if i > 0 {
+			requestLog(req.requestID).Warnf("signer %s is unavailable, falling back to signer %s: %v", signers[i-1], signer, err)
+			signFallbackCounts.With(signerTag.Value(signer)).Increment()
+			if caCertFile, err = r.caCertFileForSigner(signer); err != nil {
+				return nil, err
+			}
+		}
+		var certPEM []byte
+		certPEM, err = r.kubernetesSign(ctx, csrPEM, certOpts, caCertFile, signer, req.usages, req.lifetime)
+		if err == nil {
+			signer := signer
+			// The configured signers are labeled by name, unlike the signers requested by workloads.
+			signerLabel := signer
+			if certOpts.CertSigner != "" {
+				signerLabel = customSignerLabel
+			}
+			return newSignResult(r.raOpts, req.validatedRequest, certPEM, func(leafCert *x509.Certificate) ([]byte, error) {
+				return r.chainForCert(signer, leafCert)
+			}, signer, signerLabel, &r.missingCertChainSigners)
+		}
+		if !signerUnavailable(err) {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+// signerUnavailable returns whether signing failed with err because the signer is unavailable: with a
+// retryable error, without the CSR being issued in time, or with its circuit open.
+func signerUnavailable(err error) bool {
+	return isRetryableError(err) || hasErrorType(err, raerror.CSRPending) || hasErrorType(err, raerror.SignerUnavailable)
 }
 
 // GetCAKeyCertBundle returns the KeyCertBundle for the CA.
 func (r *KubernetesRA) GetCAKeyCertBundle() *util.KeyCertBundle {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
 	return r.keyCertBundle
 }
+
+// Capabilities reports the optional features supported by the Kubernetes RA.
+func (r *KubernetesRA) Capabilities() RACapabilities {
+	return RACapabilities{
+		ForCA:             r.raOpts.AllowCASigning,
+		CustomSigners:     r.raOpts.CertSignerDomain != "",
+		CustomKeyUsages:   true,
+		NotBeforeBackdate: false,
+	}
+}
+
+// signerMetricLabel returns the signer label value used in metrics for a requested certSigner.
+func (r *KubernetesRA) signerMetricLabel(certSigner string) string {
+	if certSigner == "" {
+		return r.raOpts.CaSigner
+	}
+	return customSignerLabel
+}
+
+// Close stops watching the CA cert files, if they are being watched. Unlike Shutdown, it does not wait for the
+// signing requests in flight.
+func (r *KubernetesRA) Close() {
+	r.closeOnce.Do(func() {
+		close(r.stopCh)
+		r.mutex.RLock()
+		defer r.mutex.RUnlock()
+		if r.caCertWatcher != nil {
+			_ = r.caCertWatcher.Close()
+		}
+		for _, watcher := range r.signerCaCertWatchers {
+			_ = watcher.Close()
+		}
+	})
+}
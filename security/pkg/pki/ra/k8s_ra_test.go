@@ -15,55 +15,40 @@
 package ra
 
 import (
+	"bytes"
+	"context"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
 	cert "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	clientset "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
 	kt "k8s.io/client-go/testing"
 
 	"istio.io/istio/pkg/spiffe"
+	"istio.io/istio/pkg/test/util/retry"
 	"istio.io/istio/security/pkg/k8s/chiron"
 	"istio.io/istio/security/pkg/pki/ca"
+	raerror "istio.io/istio/security/pkg/pki/error"
 	pkiutil "istio.io/istio/security/pkg/pki/util"
 )
 
-const (
-	TestCertificatePEM = `-----BEGIN CERTIFICATE-----
-MIIDGDCCAgCgAwIBAgIRAKvYcPLFqnJcwtshCGfNzTswDQYJKoZIhvcNAQELBQAw
-LzEtMCsGA1UEAxMkYTc1OWM3MmQtZTY3Mi00MDM2LWFjM2MtZGMwMTAwZjE1ZDVl
-MB4XDTE5MDgwNjE5NTU0NVoXDTI0MDgwNDE5NTU0NVowCzEJMAcGA1UEChMAMIIB
-IjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKCAQEAyLIFJU5yJ5VXhbmizir+7Glm
-1tVEYXKGiqYbMRbfsFm7V6Z4l00D9/eHvfTXaFpqhv6HBm31MArjYB3OaaV6krvT
-whBUEPSkGBFe/eMPSFWBW27a0nw0cK2s/5yuFhTRtcUrZ9+ojJg4IS3oSm2UZ6UJ
-DuNI3qwB6OlPQOcWX8uEp4eAaolD1lIbLRQYvxYrBqnyCZBLE+MJgA1/VB3dAECB
-TxPtAqcwLFcvsM5ABys8yK8FrqRn5Bx54NiztgG+yU30W33xjdqzmEmuIIk4JjPU
-ZQRsug7XClDvQKM6lbYcYS1td2zT08hdgURFXJ9VR64ALFp00/bvglpryu8FmQID
-AQABo1MwUTAMBgNVHRMBAf8EAjAAMEEGA1UdEQQ6MDiCHHByb3RvbXV0YXRlLmlz
-dGlvLXN5c3RlbS5zdmOCGHByb3RvbXV0YXRlLmlzdGlvLXN5c3RlbTANBgkqhkiG
-9w0BAQsFAAOCAQEAhcVEZSuNMqMUJrWVb3b+6pmw9o1f7j6a51KWxOiIl6YuTYFS
-WaR0lHSW8wLesjsjm1awWO/F3QRuYWbalANy7434GMAGF53u/uc+Z8aE3EItER9o
-SpAJos6OfJqyok7JXDdOYRDD5/hBerj68R9llWzNJd27/1jZ0NF2sIE1W4QFddy/
-+8YA4+IqwkWB5/LbeRznl3EjFZDpCEJk0gg5XwAR5eIEy4QU8GueTwrDkssFdBGq
-0naco7/Es7CWQscYdKHAgYgk0UAyu8sGV235Uw3hlOrbZ/kqvyUmsSujgT8irmDV
-e+5z6MTAO6ktvHdQlSuH6ARn47bJrZOlkttAhg==
------END CERTIFICATE-----
-`
-)
-
 var (
 	testCsrHostName string = spiffe.Identity{TrustDomain: "cluster.local", Namespace: "default", ServiceAccount: "bookinfo-productpage"}.String()
 	TestCACertFile  string = "../testdata/example-ca-cert.pem"
 )
 
-func defaultReactionFunc(obj runtime.Object) kt.ReactionFunc {
-	return func(act kt.Action) (bool, runtime.Object, error) {
-		return true, obj, nil
-	}
-}
-
 func createFakeCsr(t *testing.T) []byte {
 	options := pkiutil.CertOptions{
 		Host:       testCsrHostName,
@@ -79,17 +64,66 @@ func createFakeCsr(t *testing.T) []byte {
 	return csrPEM
 }
 
-func initFakeKubeClient(csrName string) *fake.Clientset {
-	client := fake.NewSimpleClientset()
-	csr := &cert.CertificateSigningRequest{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: csrName,
-		},
-		Status: cert.CertificateSigningRequestStatus{
-			Certificate: []byte(TestCertificatePEM),
-		},
+// issueFakeCert returns a certificate for the CSR in csrPEM, signed by a throwaway CA.
+func issueFakeCert(csrPEM []byte) ([]byte, error) {
+	return issueFakeCertWithTTL(csrPEM, time.Hour)
+}
+
+// issueFakeCertWithTTL is issueFakeCert issuing certificates valid for ttl.
+func issueFakeCertWithTTL(csrPEM []byte, ttl time.Duration) ([]byte, error) {
+	caCertPEM, caKeyPEM, err := pkiutil.GenCertKeyFromOptions(pkiutil.CertOptions{
+		Host:         "fake-ca",
+		TTL:          time.Hour,
+		Org:          "istio.io",
+		IsCA:         true,
+		IsSelfSigned: true,
+		RSAKeySize:   2048,
+	})
+	if err != nil {
+		return nil, err
+	}
+	caCert, err := pkiutil.ParsePemEncodedCertificate(caCertPEM)
+	if err != nil {
+		return nil, err
+	}
+	caKey, err := pkiutil.ParsePemEncodedKey(caKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := pkiutil.ParsePemEncodedCSR(csrPEM)
+	if err != nil {
+		return nil, err
+	}
+	identities, err := csrIdentities(csr)
+	if err != nil {
+		return nil, err
+	}
+	var subjectIDs []string
+	for _, id := range identities {
+		subjectIDs = append(subjectIDs, id.value)
+	}
+	certDER, err := pkiutil.GenCertFromCSR(csr, caCert, csr.PublicKey, caKey, subjectIDs, ttl, false)
+	if err != nil {
+		return nil, err
 	}
-	client.PrependReactor("get", "certificatesigningrequests", defaultReactionFunc(csr))
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), nil
+}
+
+// initFakeKubeClient returns a fake client that issues a certificate for the requested CSR when it is read,
+// using issue to create the certificate.
+func initFakeKubeClient(issue func(csrPEM []byte) ([]byte, error)) *fake.Clientset {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("get", "certificatesigningrequests", func(action kt.Action) (bool, runtime.Object, error) {
+		obj, err := client.Tracker().Get(action.GetResource(), "", action.(kt.GetAction).GetName())
+		if err != nil {
+			return true, nil, err
+		}
+		csr := obj.(*cert.CertificateSigningRequest)
+		if csr.Status.Certificate, err = issue(csr.Spec.Request); err != nil {
+			return true, nil, err
+		}
+		return true, csr, nil
+	})
 	return client
 }
 
@@ -113,8 +147,7 @@ func createFakeK8sRA(client *fake.Clientset) (*KubernetesRA, error) {
 // TestK8sSign : Verify that ra.k8sSign returns a valid certPEM while using k8s Fake Client to create a CSR
 func TestK8sSign(t *testing.T) {
 	csrPEM := createFakeCsr(t)
-	csrName := chiron.GenCsrName()
-	client := initFakeKubeClient(csrName)
+	client := initFakeKubeClient(issueFakeCert)
 	r, err := createFakeK8sRA(client)
 	if err != nil {
 		t.Errorf("Validation CSR failed")
@@ -129,10 +162,804 @@ func TestK8sSign(t *testing.T) {
 	}
 }
 
+// TestK8sSignContextCancelled : Verify that signing is aborted once the caller's context is done
+func TestK8sSignWithCertChainResponse(t *testing.T) {
+	client := initFakeKubeClient(issueFakeCert)
+	r, err := createFakeK8sRA(client)
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	result, err := r.SignWithCertChainResponse(createFakeCsr(t), ca.CertOpts{
+		SubjectIDs: []string{testCsrHostName},
+		TTL:        60 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("K8s CA Signing CSR failed: %v", err)
+	}
+	leafCert, err := pkiutil.ParsePemEncodedCertificate(result.CertPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(result.CertChainPEM, result.CertPEM) {
+		t.Errorf("cert chain does not start with the leaf cert")
+	}
+	if !result.NotBefore.Equal(leafCert.NotBefore) || !result.NotAfter.Equal(leafCert.NotAfter) {
+		t.Errorf("got validity [%v, %v], want [%v, %v]", result.NotBefore, result.NotAfter, leafCert.NotBefore, leafCert.NotAfter)
+	}
+	if result.SerialNumber.Cmp(leafCert.SerialNumber) != 0 {
+		t.Errorf("got serial number %v, want %v", result.SerialNumber, leafCert.SerialNumber)
+	}
+	if result.CertSigner != r.raOpts.CaSigner {
+		t.Errorf("got signer %q, want %q", result.CertSigner, r.raOpts.CaSigner)
+	}
+	if result.EffectiveTTL != 60*time.Second {
+		t.Errorf("got effective TTL %v, want %v", result.EffectiveTTL, 60*time.Second)
+	}
+	if !result.RenewAt.After(result.NotBefore) || !result.RenewAt.Before(result.NotAfter) {
+		t.Errorf("got renewal at %v, want it within the validity [%v, %v]", result.RenewAt, result.NotBefore, result.NotAfter)
+	}
+}
+
+func TestK8sSignRequireCertChain(t *testing.T) {
+	r, err := createFakeK8sRA(initFakeKubeClient(issueFakeCert))
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	// no CA bundle and thus no cert chain is configured for the signer
+	r.keyCertBundle = pkiutil.NewKeyCertBundleFromPem(nil, nil, nil, nil)
+	r.raOpts.RequireCertChain = true
+	_, err = r.SignWithCertChainResponse(createFakeCsr(t), ca.CertOpts{
+		SubjectIDs: []string{testCsrHostName},
+		TTL:        60 * time.Second,
+	})
+	var raErr *raerror.Error
+	if !errors.As(err, &raErr) || raErr.ErrorType() != "CERT_GEN_ERROR" || !strings.Contains(err.Error(), "no cert chain") {
+		t.Errorf("expected a CERT_GEN_ERROR error for the missing cert chain, got: %v", err)
+	}
+}
+
+func TestK8sSignInvalidIssuedCert(t *testing.T) {
+	otherCSR := createFakeCsr(t)
+	// The signer issues a certificate for another key.
+	client := initFakeKubeClient(func([]byte) ([]byte, error) {
+		return issueFakeCert(otherCSR)
+	})
+	r, err := createFakeK8sRA(client)
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	_, err = r.Sign(createFakeCsr(t), ca.CertOpts{
+		SubjectIDs: []string{testCsrHostName},
+		TTL:        60 * time.Second,
+	})
+	var raErr *raerror.Error
+	if !errors.As(err, &raErr) || raErr.ErrorType() != "CERT_GEN_ERROR" {
+		t.Fatalf("expected a CERT_GEN_ERROR error, got: %v", err)
+	}
+	if !strings.Contains(err.Error(), "public key") {
+		t.Errorf("expected the error to report the public key mismatch, got: %v", err)
+	}
+}
+
+func TestK8sSignContextCancelled(t *testing.T) {
+	csrPEM := createFakeCsr(t)
+	// the CSR never gets a certificate issued
+	r, err := createFakeK8sRA(fake.NewSimpleClientset())
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = r.SignContext(ctx, csrPEM, ca.CertOpts{
+		SubjectIDs: []string{testCsrHostName},
+		TTL:        60 * time.Second, ForCA: false,
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected an error wrapping context.DeadlineExceeded, got: %v", err)
+	}
+	var raErr *raerror.Error
+	if !errors.As(err, &raErr) || raErr.ErrorType() != "REQUEST_CANCELED" {
+		t.Errorf("expected a REQUEST_CANCELED error, got: %v", err)
+	}
+}
+
+func TestK8sSignApprovalTimeout(t *testing.T) {
+	// the CSR never gets a certificate issued
+	r, err := createFakeK8sRA(fake.NewSimpleClientset())
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	r.raOpts.ApprovalTimeout = 100 * time.Millisecond
+	r.raOpts.CSRNameFunc = func([]byte, ca.CertOpts) string { return "test-csr" }
+	_, err = r.Sign(createFakeCsr(t), ca.CertOpts{
+		SubjectIDs: []string{testCsrHostName},
+		TTL:        60 * time.Second, ForCA: false,
+	})
+	if !errors.Is(err, chiron.ErrCSRNotIssued) {
+		t.Fatalf("expected an error wrapping chiron.ErrCSRNotIssued, got: %v", err)
+	}
+	var raErr *raerror.Error
+	if !errors.As(err, &raErr) || raErr.ErrorType() != "CSR_PENDING" {
+		t.Errorf("expected a CSR_PENDING error, got: %v", err)
+	}
+	if !strings.Contains(err.Error(), "test-csr") {
+		t.Errorf("expected the error to name the CSR, got: %v", err)
+	}
+}
+
+func TestK8sSignCSRConditions(t *testing.T) {
+	testCases := map[string]struct {
+		condition       cert.CertificateSigningRequestCondition
+		expectedErrType string
+	}{
+		"denied": {
+			condition:       cert.CertificateSigningRequestCondition{Type: cert.CertificateDenied, Reason: "PolicyViolation", Message: "not allowed"},
+			expectedErrType: "CSR_DENIED",
+		},
+		"failed": {
+			condition:       cert.CertificateSigningRequestCondition{Type: cert.CertificateFailed, Reason: "SignerError", Message: "not allowed"},
+			expectedErrType: "CSR_FAILED",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			client.PrependWatchReactor("certificatesigningrequests", func(kt.Action) (bool, watch.Interface, error) {
+				w := watch.NewFakeWithChanSize(1, false)
+				tc.condition.Status = corev1.ConditionTrue
+				w.Modify(&cert.CertificateSigningRequest{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-csr"},
+					Status:     cert.CertificateSigningRequestStatus{Conditions: []cert.CertificateSigningRequestCondition{tc.condition}},
+				})
+				return true, w, nil
+			})
+			r, err := createFakeK8sRA(client)
+			if err != nil {
+				t.Fatalf("Failed to create Fake K8s RA: %v", err)
+			}
+			r.raOpts.CSRNameFunc = func([]byte, ca.CertOpts) string { return "test-csr" }
+			_, err = r.Sign(createFakeCsr(t), ca.CertOpts{
+				SubjectIDs: []string{testCsrHostName},
+				TTL:        60 * time.Second,
+			})
+			var raErr *raerror.Error
+			if !errors.As(err, &raErr) || raErr.ErrorType() != tc.expectedErrType {
+				t.Fatalf("expected a %s error, got: %v", tc.expectedErrType, err)
+			}
+			if !strings.Contains(err.Error(), tc.condition.Reason) || !strings.Contains(err.Error(), tc.condition.Message) {
+				t.Errorf("expected the error to include the reason and message of the condition, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestK8sSignAutoApprove(t *testing.T) {
+	enabled, disabled := true, false
+	testCases := map[string]struct {
+		autoApprove      *bool
+		certSigner       string
+		expectedApproval bool
+	}{
+		"CSRs are approved by default": {
+			expectedApproval: true,
+		},
+		"CSRs for a signer in the signer domain are approved": {
+			autoApprove:      &enabled,
+			certSigner:       "custom",
+			expectedApproval: true,
+		},
+		"CSRs are not approved without auto-approval": {
+			autoApprove: &disabled,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			approvals := 0
+			client.PrependReactor("update", "certificatesigningrequests", func(action kt.Action) (bool, runtime.Object, error) {
+				if action.GetSubresource() == "approval" {
+					approvals++
+				}
+				return false, nil, nil
+			})
+			r, err := createFakeK8sRA(client)
+			if err != nil {
+				t.Fatalf("Failed to create Fake K8s RA: %v", err)
+			}
+			r.raOpts.AutoApprove = tc.autoApprove
+			r.raOpts.CertSignerDomain = "example.com"
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			// The CSR is never issued, so signing fails once the context expires.
+			_, _ = r.SignContext(ctx, createFakeCsr(t), ca.CertOpts{
+				SubjectIDs: []string{testCsrHostName},
+				TTL:        time.Minute,
+				CertSigner: tc.certSigner,
+			})
+			if approved := approvals > 0; approved != tc.expectedApproval {
+				t.Errorf("expected approval %v, got %d approvals", tc.expectedApproval, approvals)
+			}
+		})
+	}
+}
+
+func TestShouldApprove(t *testing.T) {
+	r, err := createFakeK8sRA(fake.NewSimpleClientset())
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	r.raOpts.CertSignerDomain = "example.com"
+	for signer, expected := range map[string]bool{
+		r.raOpts.CaSigner:              true,
+		"example.com/custom":           true,
+		"example.com.evil/custom":      false,
+		"kubernetes.io/legacy-unknown": false,
+	} {
+		if got := r.shouldApprove(signer, ""); got != expected {
+			t.Errorf("shouldApprove(%q): got %v, want %v", signer, got, expected)
+		}
+	}
+}
+
+func TestResolveSignerName(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	r, err := createFakeK8sRA(client)
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	testCases := map[string]struct {
+		domain   string
+		signer   string
+		expected string
+	}{
+		"domain and signer":                  {domain: "example.com", signer: "istio", expected: "example.com/istio"},
+		"domain with a trailing slash":       {domain: "example.com/", signer: "istio", expected: "example.com/istio"},
+		"domain with trailing slashes":       {domain: "example.com//", signer: "istio", expected: "example.com/istio"},
+		"signer with a leading slash":        {domain: "example.com", signer: "/istio", expected: "example.com/istio"},
+		"domain with a path":                 {domain: "example.com/mesh/", signer: "istio", expected: "example.com/mesh/istio"},
+		"signer with a path":                 {domain: "example.com", signer: "mesh/istio", expected: "example.com/mesh/istio"},
+		"empty segment in the signer":        {domain: "example.com", signer: "mesh//istio"},
+		"signer with a trailing slash":       {domain: "example.com", signer: "istio/"},
+		"signer of slashes":                  {domain: "example.com", signer: "//"},
+		"domain that is not fully qualified": {domain: "example", signer: "istio"},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			r.raOpts.CertSignerDomain = tc.domain
+			signerName, err := r.resolveSigner(tc.signer)
+			if tc.expected == "" {
+				var raErr *raerror.Error
+				if !errors.As(err, &raErr) || raErr.ErrorType() != "CERT_GEN_ERROR" {
+					t.Errorf("expected a CERT_GEN_ERROR error, got signer %q and error %v", signerName, err)
+				}
+				return
+			}
+			if err != nil || signerName != tc.expected {
+				t.Errorf("got signer %q and error %v, want %s", signerName, err, tc.expected)
+			}
+			if !r.shouldApprove(signerName, "") {
+				t.Errorf("expected the CSR for signer %s in the signer domain to be approved", signerName)
+			}
+		})
+	}
+	if actions := client.Actions(); len(actions) != 0 {
+		t.Errorf("expected no K8s API call to resolve signers, got %v", actions)
+	}
+}
+
+func TestNewKubernetesRAInvalidSigner(t *testing.T) {
+	for name, raOpts := range map[string]*IstioRAOptions{
+		"CA signer without a path":            {CaSigner: "kube-apiserver-client"},
+		"signer domain with an empty segment": {CertSignerDomain: "example.com//mesh"},
+		"invalid signer domain":               {CertSignerDomain: "Example"},
+		"invalid fallback signer": {
+			CaSigner:        "kubernates.io/kube-apiserver-client",
+			FallbackSigners: []string{"example.com/fallback/"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			raOpts.K8sClient = fake.NewSimpleClientset()
+			_, err := NewKubernetesRA(raOpts)
+			var raErr *raerror.Error
+			if !errors.As(err, &raErr) || raErr.ErrorType() != "CA_INIT_FAIL" {
+				t.Errorf("expected a CA_INIT_FAIL error, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestK8sSignCleanupCSR(t *testing.T) {
+	csrResource := schema.GroupResource{Group: "certificates.k8s.io", Resource: "certificatesigningrequests"}
+	keepCSR := false
+	testCases := map[string]struct {
+		cleanupCSR   *bool
+		deleteErr    error
+		expectedCSRs int
+		orphaned     float64
+	}{
+		"CSR is cleaned up by default": {
+			expectedCSRs: 0,
+		},
+		"CSR is kept": {
+			cleanupCSR:   &keepCSR,
+			expectedCSRs: 1,
+		},
+		"CSR already gone": {
+			deleteErr:    apierrors.NewNotFound(csrResource, "csr"),
+			expectedCSRs: 1,
+		},
+		"CSR cannot be deleted": {
+			deleteErr:    apierrors.NewForbidden(csrResource, "csr", fmt.Errorf("denied")),
+			expectedCSRs: 1,
+			orphaned:     1,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			deleted := make(chan struct{}, 1)
+			client.PrependReactor("delete", "certificatesigningrequests", func(kt.Action) (bool, runtime.Object, error) {
+				select {
+				case deleted <- struct{}{}:
+				default:
+				}
+				if tc.deleteErr != nil {
+					return true, nil, tc.deleteErr
+				}
+				return false, nil, nil
+			})
+			r, err := createFakeK8sRA(client)
+			if err != nil {
+				t.Fatalf("Failed to create Fake K8s RA: %v", err)
+			}
+			r.raOpts.CleanupCSR = tc.cleanupCSR
+			orphaned := getMetricValue(t, "ra_orphaned_csr_count", nil)
+
+			// The CSR is never issued, so signing fails once the context expires.
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			if _, err := r.SignContext(ctx, createFakeCsr(t), ca.CertOpts{
+				SubjectIDs: []string{testCsrHostName},
+				TTL:        time.Minute,
+			}); err == nil {
+				t.Fatalf("expected signing to fail")
+			}
+			if tc.cleanupCSR == nil {
+				select {
+				case <-deleted:
+				case <-time.After(5 * time.Second):
+					t.Fatalf("CSR was not cleaned up")
+				}
+			}
+			retry.UntilSuccessOrFail(t, func() error {
+				csrs, err := client.CertificatesV1().CertificateSigningRequests().List(context.Background(), metav1.ListOptions{})
+				if err != nil {
+					return err
+				}
+				if len(csrs.Items) != tc.expectedCSRs {
+					return fmt.Errorf("expected %d CSRs, got %d", tc.expectedCSRs, len(csrs.Items))
+				}
+				if got := getMetricValue(t, "ra_orphaned_csr_count", nil); got != orphaned+tc.orphaned {
+					return fmt.Errorf("expected %v orphaned CSRs, got %v", orphaned+tc.orphaned, got)
+				}
+				return nil
+			}, retry.Timeout(5*time.Second))
+		})
+	}
+}
+
+func TestSignerCaCertFiles(t *testing.T) {
+	tenantSigner := "example.com/tenant-a"
+	tenantCaCertFile := "../testdata/spiffe-root-cert-1.pem"
+	testCases := map[string]struct {
+		caCertFile   string
+		signer       string
+		expectedFile string
+		expectErr    bool
+	}{
+		"mapped signer": {
+			caCertFile:   TestCACertFile,
+			signer:       tenantSigner,
+			expectedFile: tenantCaCertFile,
+		},
+		"unmapped signer falls back to CaCertFile": {
+			caCertFile:   TestCACertFile,
+			signer:       "example.com/tenant-b",
+			expectedFile: TestCACertFile,
+		},
+		"unmapped signer without CaCertFile": {
+			signer:    "example.com/tenant-b",
+			expectErr: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			r, err := NewKubernetesRA(&IstioRAOptions{
+				ExternalCAType:    ExtCAK8s,
+				CaCertFile:        tc.caCertFile,
+				CertSignerDomain:  "example.com",
+				SignerCaCertFiles: map[string]string{tenantSigner: tenantCaCertFile},
+				K8sClient:         fake.NewSimpleClientset(),
+			})
+			if err != nil {
+				t.Fatalf("Failed to create Fake K8s RA: %v", err)
+			}
+			caCertFile, err := r.caCertFileForSigner(tc.signer)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatalf("expected an error for signer %s", tc.signer)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if caCertFile != tc.expectedFile {
+				t.Errorf("got CA cert file %s, want %s", caCertFile, tc.expectedFile)
+			}
+			expectedRoot, err := os.ReadFile(tc.expectedFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := r.bundleForSigner(tc.signer).GetRootCertPem(); !bytes.Equal(got, expectedRoot) {
+				t.Errorf("got the wrong CA bundle for signer %s", tc.signer)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	csrPEM := createFakeCsr(t)
+	client := fake.NewSimpleClientset()
+	r, err := createFakeK8sRA(client)
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	r.raOpts.SANValidationMode = SANValidationEnforce
+	testCases := map[string]struct {
+		csrPEM   []byte
+		certOpts ca.CertOpts
+	}{
+		"invalid CSR": {
+			csrPEM:   []byte("invalid"),
+			certOpts: ca.CertOpts{SubjectIDs: []string{testCsrHostName}, TTL: time.Hour},
+		},
+		"identity not requested": {
+			csrPEM:   csrPEM,
+			certOpts: ca.CertOpts{SubjectIDs: []string{"other"}, TTL: time.Hour},
+		},
+		"CA certificate": {
+			csrPEM:   csrPEM,
+			certOpts: ca.CertOpts{SubjectIDs: []string{testCsrHostName}, TTL: time.Hour, ForCA: true},
+		},
+		"custom signer without a signer domain": {
+			csrPEM:   csrPEM,
+			certOpts: ca.CertOpts{SubjectIDs: []string{testCsrHostName}, TTL: time.Hour, CertSigner: "custom"},
+		},
+		"invalid key usage": {
+			csrPEM:   csrPEM,
+			certOpts: ca.CertOpts{SubjectIDs: []string{testCsrHostName}, TTL: time.Hour, KeyUsages: []cert.KeyUsage{"invalid"}},
+		},
+		"must-staple": {
+			csrPEM:   csrPEM,
+			certOpts: ca.CertOpts{SubjectIDs: []string{testCsrHostName}, TTL: time.Hour, MustStaple: true},
+		},
+		"issuing certificate URLs": {
+			csrPEM: csrPEM,
+			certOpts: ca.CertOpts{SubjectIDs: []string{testCsrHostName}, TTL: time.Hour,
+				IssuingCertificateURLs: []string{"http://ca.example.com/ca.crt"}},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			// Sign would generate a different request ID for each request without one.
+			tc.certOpts.RequestID = "test-request"
+			validateErr := r.Validate(tc.csrPEM, tc.certOpts)
+			if validateErr == nil {
+				t.Fatalf("expected an error")
+			}
+			_, signErr := r.Sign(tc.csrPEM, tc.certOpts)
+			var validateRAErr, signRAErr *raerror.Error
+			if !errors.As(validateErr, &validateRAErr) || !errors.As(signErr, &signRAErr) ||
+				validateRAErr.ErrorType() != signRAErr.ErrorType() || validateErr.Error() != signErr.Error() {
+				t.Errorf("got error %v from Validate, but %v from Sign", validateErr, signErr)
+			}
+		})
+	}
+
+	if err := r.Validate(csrPEM, ca.CertOpts{SubjectIDs: []string{testCsrHostName}, TTL: time.Hour}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if actions := client.Actions(); len(actions) != 0 {
+		t.Errorf("expected Validate not to call the K8s API, got %v", actions)
+	}
+}
+
+func TestK8sRequiredSubject(t *testing.T) {
+	r, err := createFakeK8sRA(initFakeKubeClient(issueFakeCert))
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	r.raOpts.DefaultOrganization = []string{"istio.io"}
+	certOpts := ca.CertOpts{SubjectIDs: []string{testCsrHostName}, TTL: time.Hour}
+	var raErr *raerror.Error
+	if err := r.Validate(createFakeCsr(t), certOpts); !errors.As(err, &raErr) || raErr.ErrorType() != "CSR_ERROR" {
+		t.Errorf("expected a CSR_ERROR error for a CSR without the required organization, got: %v", err)
+	}
+
+	csrPEM, _, err := pkiutil.GenCSR(pkiutil.CertOptions{Host: testCsrHostName, Org: "istio.io", ECSigAlg: pkiutil.EcdsaSigAlg})
+	if err != nil {
+		t.Fatalf("failed to generate a CSR: %v", err)
+	}
+	if err := r.Validate(csrPEM, certOpts); err != nil {
+		t.Errorf("unexpected error for a CSR with the required organization: %v", err)
+	}
+	// the fake signer does not copy the subject of the CSR
+	if _, err := r.Sign(csrPEM, certOpts); !errors.As(err, &raErr) || raErr.ErrorType() != "CERT_GEN_ERROR" ||
+		!strings.Contains(err.Error(), "subject organization") {
+		t.Errorf("expected a CERT_GEN_ERROR error for a certificate issued without the required organization, got: %v", err)
+	}
+}
+
+func TestAllowedSigners(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	r, err := createFakeK8sRA(client)
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	r.raOpts.CertSignerDomain = "example.com"
+	r.raOpts.AllowedSigners = []string{"example.com/istio-*"}
+	if signerName, err := r.resolveSigner("istio-ingress"); err != nil || signerName != "example.com/istio-ingress" {
+		t.Errorf("got signer %q and error %v, want example.com/istio-ingress", signerName, err)
+	}
+	if signerName, err := r.resolveSigner(""); err != nil || signerName != r.raOpts.CaSigner {
+		t.Errorf("got signer %q and error %v, want the default signer %q", signerName, err, r.raOpts.CaSigner)
+	}
+	_, err = r.Sign(createFakeCsr(t), ca.CertOpts{
+		SubjectIDs: []string{testCsrHostName},
+		TTL:        60 * time.Second,
+		CertSigner: "kube-apiserver-client",
+	})
+	var raErr *raerror.Error
+	if !errors.As(err, &raErr) || raErr.ErrorType() != "CERT_GEN_ERROR" {
+		t.Errorf("expected a CERT_GEN_ERROR error, got: %v", err)
+	}
+	if actions := client.Actions(); len(actions) != 0 {
+		t.Errorf("expected no K8s API call for a signer that is not allowed, got %v", actions)
+	}
+}
+
+func TestPreSignHook(t *testing.T) {
+	errDenied := errors.New("denied by policy")
+	testCases := map[string]struct {
+		hookErr         error
+		expectedErrType string
+	}{
+		"allowed": {
+			// the CSR never gets a certificate issued
+			expectedErrType: "CSR_PENDING",
+		},
+		"denied": {
+			hookErr:         errDenied,
+			expectedErrType: "UNAUTHORIZED",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			r, err := createFakeK8sRA(client)
+			if err != nil {
+				t.Fatalf("Failed to create Fake K8s RA: %v", err)
+			}
+			r.raOpts.ApprovalTimeout = 100 * time.Millisecond
+			var identities []Identity
+			r.raOpts.PreSignHook = func(_ context.Context, _ []byte, _ ca.CertOpts, ids []Identity) error {
+				identities = ids
+				return tc.hookErr
+			}
+			_, err = r.Sign(createFakeCsr(t), ca.CertOpts{
+				SubjectIDs: []string{testCsrHostName},
+				TTL:        60 * time.Second,
+			})
+			var raErr *raerror.Error
+			if !errors.As(err, &raErr) || raErr.ErrorType() != tc.expectedErrType {
+				t.Fatalf("expected a %s error, got: %v", tc.expectedErrType, err)
+			}
+			if want := []Identity{{Type: pkiutil.TypeURI, Value: testCsrHostName}}; len(identities) != 1 || identities[0] != want[0] {
+				t.Errorf("got identities %v, want %v", identities, want)
+			}
+			if tc.hookErr != nil {
+				if !errors.Is(err, tc.hookErr) {
+					t.Errorf("expected the error to wrap the hook error, got: %v", err)
+				}
+				if actions := client.Actions(); len(actions) != 0 {
+					t.Errorf("expected no K8s API call for an unauthorized request, got %v", actions)
+				}
+			}
+		})
+	}
+}
+
+func TestCSRMetadata(t *testing.T) {
+	testCases := map[string]struct {
+		annotations         map[string]string
+		expectedErrType     string
+		expectedAnnotations map[string]string
+	}{
+		"RA and request annotations": {
+			annotations: map[string]string{"example.com/workload": "productpage"},
+			// the CSR never gets a certificate issued
+			expectedErrType: "CSR_PENDING",
+			expectedAnnotations: map[string]string{
+				"example.com/approver": "istio", "example.com/workload": "productpage",
+			},
+		},
+		"invalid request annotation": {
+			annotations:     map[string]string{"example.com/a/b": "productpage"},
+			expectedErrType: "CSR_ERROR",
+		},
+		"request annotation set by the RA": {
+			annotations:     map[string]string{"example.com/approver": "other"},
+			expectedErrType: "CSR_ERROR",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			var created *cert.CertificateSigningRequest
+			client.PrependReactor("create", "certificatesigningrequests", func(action kt.Action) (bool, runtime.Object, error) {
+				created = action.(kt.CreateAction).GetObject().(*cert.CertificateSigningRequest).DeepCopy()
+				return false, nil, nil
+			})
+			r, err := createFakeK8sRA(client)
+			if err != nil {
+				t.Fatalf("Failed to create Fake K8s RA: %v", err)
+			}
+			r.raOpts.ApprovalTimeout = 100 * time.Millisecond
+			r.raOpts.CSRLabels = map[string]string{"example.com/approver": "istio"}
+			r.raOpts.CSRAnnotations = map[string]string{"example.com/approver": "istio"}
+			_, err = r.Sign(createFakeCsr(t), ca.CertOpts{
+				SubjectIDs:     []string{testCsrHostName},
+				TTL:            60 * time.Second,
+				CSRAnnotations: tc.annotations,
+			})
+			var raErr *raerror.Error
+			if !errors.As(err, &raErr) || raErr.ErrorType() != tc.expectedErrType {
+				t.Fatalf("expected a %s error, got: %v", tc.expectedErrType, err)
+			}
+			if tc.expectedAnnotations == nil {
+				if actions := client.Actions(); len(actions) != 0 {
+					t.Errorf("expected no K8s API call for invalid CSR annotations, got %v", actions)
+				}
+				return
+			}
+			if created == nil {
+				t.Fatalf("no CSR was created")
+			}
+			if got := created.Labels["example.com/approver"]; len(created.Labels) != 1 || got != "istio" {
+				t.Errorf("got CSR labels %v, want %v", created.Labels, r.raOpts.CSRLabels)
+			}
+			for key, want := range tc.expectedAnnotations {
+				if got := created.Annotations[key]; got != want {
+					t.Errorf("got CSR annotation %s %q, want %q", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestNewKubernetesRAInvalidCSRMetadata(t *testing.T) {
+	for name, raOpts := range map[string]*IstioRAOptions{
+		"invalid label":      {CSRLabels: map[string]string{"example.com/approver": "not valid"}},
+		"invalid annotation": {CSRAnnotations: map[string]string{"-invalid": "istio"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			raOpts.K8sClient = fake.NewSimpleClientset()
+			raOpts.CaSigner = "kubernates.io/kube-apiserver-client"
+			raOpts.CaCertFile = "../testdata/example-ca-cert.pem"
+			_, err := NewKubernetesRA(raOpts)
+			var raErr *raerror.Error
+			if !errors.As(err, &raErr) || raErr.ErrorType() != "CA_INIT_FAIL" {
+				t.Errorf("expected a CA_INIT_FAIL error, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestSignerRequired(t *testing.T) {
+	_, err := NewKubernetesRA(&IstioRAOptions{K8sClient: fake.NewSimpleClientset()})
+	var raErr *raerror.Error
+	if !errors.As(err, &raErr) || raErr.ErrorType() != "CA_INIT_FAIL" {
+		t.Errorf("expected a CA_INIT_FAIL error without a CA signer nor a signer domain, got: %v", err)
+	}
+
+	client := fake.NewSimpleClientset()
+	r, err := NewKubernetesRA(&IstioRAOptions{K8sClient: client, CertSignerDomain: "example.com"})
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	_, err = r.Sign(createFakeCsr(t), ca.CertOpts{
+		SubjectIDs: []string{testCsrHostName},
+		TTL:        60 * time.Second,
+	})
+	if !errors.As(err, &raErr) || raErr.ErrorType() != "CERT_GEN_ERROR" || !strings.Contains(err.Error(), "no CA signer") {
+		t.Errorf("expected a CERT_GEN_ERROR error for a request without a signer, got: %v", err)
+	}
+	if len(client.Actions()) != 0 {
+		t.Errorf("expected no CSR to be created, got actions %v", client.Actions())
+	}
+}
+
+func TestClientForSigner(t *testing.T) {
+	errNoCluster := errors.New("no cluster")
+	for name, certSigner := range map[string]string{
+		"default signer": "",
+		"remote signer":  "remote",
+		"unknown signer": "unknown",
+	} {
+		t.Run(name, func(t *testing.T) {
+			defaultClient := fake.NewSimpleClientset()
+			remoteClient := fake.NewSimpleClientset()
+			r, err := createFakeK8sRA(defaultClient)
+			if err != nil {
+				t.Fatalf("Failed to create Fake K8s RA: %v", err)
+			}
+			r.raOpts.ApprovalTimeout = 100 * time.Millisecond
+			r.raOpts.CertSignerDomain = "example.com"
+			r.raOpts.ClientForSigner = func(signer string) (clientset.Interface, error) {
+				switch signer {
+				case "example.com/remote":
+					return remoteClient, nil
+				case "example.com/unknown":
+					return nil, errNoCluster
+				}
+				return defaultClient, nil
+			}
+			_, err = r.Sign(createFakeCsr(t), ca.CertOpts{
+				SubjectIDs: []string{testCsrHostName},
+				TTL:        60 * time.Second,
+				CertSigner: certSigner,
+			})
+			// the CSR never gets a certificate issued
+			expectedErrType := "CSR_PENDING"
+			if certSigner == "unknown" {
+				expectedErrType = "CERT_GEN_ERROR"
+			}
+			var raErr *raerror.Error
+			if !errors.As(err, &raErr) || raErr.ErrorType() != expectedErrType {
+				t.Fatalf("expected a %s error, got: %v", expectedErrType, err)
+			}
+			if certSigner == "unknown" && !strings.Contains(err.Error(), errNoCluster.Error()) {
+				t.Errorf("expected the error of the client factory, got: %v", err)
+			}
+			if got, want := len(defaultClient.Actions()) > 0, certSigner == ""; got != want {
+				t.Errorf("got K8s API calls %v on the default client", defaultClient.Actions())
+			}
+			if got, want := len(remoteClient.Actions()) > 0, certSigner == "remote"; got != want {
+				t.Errorf("got K8s API calls %v on the remote client", remoteClient.Actions())
+			}
+		})
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	r, err := createFakeK8sRA(fake.NewSimpleClientset())
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	var ra RegistrationAuthority = r
+	if got, want := ra.Capabilities(), (RACapabilities{CustomKeyUsages: true}); got != want {
+		t.Errorf("got capabilities %+v, want %+v", got, want)
+	}
+	r.raOpts.CertSignerDomain = "example.com"
+	if got, want := ra.Capabilities(), (RACapabilities{CustomSigners: true, CustomKeyUsages: true}); got != want {
+		t.Errorf("got capabilities %+v, want %+v", got, want)
+	}
+}
+
 func TestValidateCSR(t *testing.T) {
 	csrPEM := createFakeCsr(t)
-	csrName := chiron.GenCsrName()
-	client := initFakeKubeClient(csrName)
+	client := initFakeKubeClient(issueFakeCert)
 	_, err := createFakeK8sRA(client)
 	if err != nil {
 		t.Errorf("Validation CSR failed")
@@ -151,3 +978,199 @@ func TestValidateCSR(t *testing.T) {
 		t.Errorf("Test 2: CSR Validation failed")
 	}
 }
+
+func TestK8sSignExpirationSeconds(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	r, err := createFakeK8sRA(client)
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	r.raOpts.ApprovalTimeout = 100 * time.Millisecond
+	_, err = r.Sign(createFakeCsr(t), ca.CertOpts{
+		SubjectIDs: []string{testCsrHostName},
+		TTL:        10 * time.Minute,
+	})
+	var raErr *raerror.Error
+	if !errors.As(err, &raErr) || raErr.ErrorType() != "CSR_PENDING" {
+		t.Fatalf("expected the CSR to be created and left pending, got: %v", err)
+	}
+	for _, action := range client.Actions() {
+		if action.GetVerb() != "create" {
+			continue
+		}
+		csr := action.(kt.CreateAction).GetObject().(*cert.CertificateSigningRequest)
+		if csr.Spec.ExpirationSeconds == nil || *csr.Spec.ExpirationSeconds != 600 {
+			t.Errorf("got expiration seconds %v, want 600", csr.Spec.ExpirationSeconds)
+		}
+		return
+	}
+	t.Fatalf("expected a CSR to be created")
+}
+
+func TestK8sSignCSRWaitStrategy(t *testing.T) {
+	for _, strategy := range []chiron.WaitStrategy{chiron.WaitWatch, chiron.WaitPoll} {
+		t.Run(string(strategy), func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			r, err := createFakeK8sRA(client)
+			if err != nil {
+				t.Fatalf("Failed to create Fake K8s RA: %v", err)
+			}
+			r.raOpts.ApprovalTimeout = 100 * time.Millisecond
+			r.raOpts.CSRWaitStrategy = strategy
+			r.raOpts.CSRPollInitialDelay = 10 * time.Millisecond
+			waits := getMetricValue(t, "ra_csr_wait_strategy_count", map[string]string{strategyLabel: string(strategy)})
+			_, err = r.Sign(createFakeCsr(t), ca.CertOpts{
+				SubjectIDs: []string{testCsrHostName},
+				TTL:        60 * time.Second,
+			})
+			var raErr *raerror.Error
+			if !errors.As(err, &raErr) || raErr.ErrorType() != "CSR_PENDING" {
+				t.Fatalf("expected the CSR to be created and left pending, got: %v", err)
+			}
+			if got := getMetricValue(t, "ra_csr_wait_strategy_count", map[string]string{strategyLabel: string(strategy)}); got != waits+1 {
+				t.Errorf("expected the %s wait strategy to be counted once, got %v", strategy, got-waits)
+			}
+			watched := false
+			for _, action := range client.Actions() {
+				watched = watched || action.GetVerb() == "watch"
+			}
+			if watched != (strategy == chiron.WaitWatch) {
+				t.Errorf("expected the CSR to be watched only with the %s wait strategy, watched: %v", chiron.WaitWatch, watched)
+			}
+		})
+	}
+
+	_, err := NewKubernetesRA(&IstioRAOptions{
+		K8sClient:       fake.NewSimpleClientset(),
+		CaSigner:        "kubernates.io/kube-apiserver-client",
+		CSRWaitStrategy: "Stream",
+	})
+	var raErr *raerror.Error
+	if !errors.As(err, &raErr) || raErr.ErrorType() != "CA_INIT_FAIL" {
+		t.Errorf("expected a CA_INIT_FAIL error for an unknown CSR wait strategy, got: %v", err)
+	}
+}
+
+// TestK8sSignEmptyCertificate : Verify that a CSR issued with a still empty certificate is read again until
+// its certificate is populated
+func TestK8sSignEmptyCertificate(t *testing.T) {
+	testCases := map[string]struct {
+		retries    int
+		emptyReads int
+		nilCert    bool
+		expectFail bool
+	}{
+		"populated": {
+			emptyReads: 2,
+		},
+		"still empty": {
+			emptyReads: 5,
+			expectFail: true,
+		},
+		"retries disabled": {
+			retries:    -1,
+			emptyReads: 1,
+			expectFail: true,
+		},
+		"nil populated": {
+			emptyReads: 2,
+			nilCert:    true,
+		},
+		"nil still empty": {
+			emptyReads: 100,
+			nilCert:    true,
+			expectFail: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			reads := 0
+			r, err := createFakeK8sRA(initFakeKubeClient(func(csrPEM []byte) ([]byte, error) {
+				if reads++; reads <= tc.emptyReads {
+					if tc.nilCert {
+						return nil, nil
+					}
+					return []byte{}, nil
+				}
+				return issueFakeCert(csrPEM)
+			}))
+			if err != nil {
+				t.Fatalf("Failed to create Fake K8s RA: %v", err)
+			}
+			r.raOpts.SignMaxAttempts = 1
+			r.raOpts.CSREmptyCertificateRetries = tc.retries
+			r.raOpts.CSREmptyCertificateRetryInterval = 10 * time.Millisecond
+			if tc.nilCert {
+				// A nil certificate leaves the CSR pending until the ApprovalTimeout.
+				r.raOpts.ApprovalTimeout = 200 * time.Millisecond
+				r.raOpts.CSRWaitStrategy = chiron.WaitPoll
+				r.raOpts.CSRPollInitialDelay = 10 * time.Millisecond
+				r.raOpts.CSRPollMaxInterval = 10 * time.Millisecond
+			}
+			_, err = r.Sign(createFakeCsr(t), ca.CertOpts{
+				SubjectIDs: []string{testCsrHostName},
+				TTL:        60 * time.Second,
+			})
+			if tc.expectFail {
+				if err == nil {
+					t.Fatalf("expected signing to fail with a still empty certificate")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected the certificate to be returned once populated, got: %v", err)
+			}
+			if reads != tc.emptyReads+1 {
+				t.Errorf("expected the CSR to be read %d times, got %d", tc.emptyReads+1, reads)
+			}
+		})
+	}
+}
+
+func TestSignFallbackSigners(t *testing.T) {
+	const primarySigner, fallbackSigner = "example.com/primary", "example.com/fallback"
+	fallbackCaCertFile := "../testdata/spiffe-root-cert-1.pem"
+	client := initFakeKubeClient(issueFakeCert)
+	client.PrependReactor("create", "certificatesigningrequests", func(action kt.Action) (bool, runtime.Object, error) {
+		csr := action.(kt.CreateAction).GetObject().(*cert.CertificateSigningRequest)
+		if csr.Spec.SignerName == primarySigner {
+			return true, nil, apierrors.NewServiceUnavailable("primary CA is down")
+		}
+		return false, nil, nil
+	})
+	raOpts := &IstioRAOptions{
+		ExternalCAType:    ExtCAK8s,
+		CaSigner:          primarySigner,
+		CaCertFile:        TestCACertFile,
+		FallbackSigners:   []string{fallbackSigner},
+		SignerCaCertFiles: map[string]string{fallbackSigner: fallbackCaCertFile},
+		SignMaxAttempts:   1,
+		K8sClient:         client,
+	}
+	r, err := NewKubernetesRA(raOpts)
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	fallbacks := func() float64 {
+		return getMetricValue(t, "ra_cert_sign_fallback_count", map[string]string{"signer": fallbackSigner})
+	}
+	before := fallbacks()
+	result, err := r.SignWithCertChainResponse(createFakeCsr(t), ca.CertOpts{
+		SubjectIDs: []string{testCsrHostName},
+		TTL:        time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("expected signing to fall back to the fallback signer, got: %v", err)
+	}
+	if result.CertSigner != fallbackSigner {
+		t.Errorf("got signer %q, want the fallback signer %q", result.CertSigner, fallbackSigner)
+	}
+	if got := fallbacks() - before; got != 1 {
+		t.Errorf("got %v fallbacks recorded, want 1", got)
+	}
+
+	raOpts.AllowedSigners = []string{primarySigner}
+	if _, err := NewKubernetesRA(raOpts); err == nil {
+		t.Errorf("expected a fallback signer that is not allowed to be rejected")
+	}
+}
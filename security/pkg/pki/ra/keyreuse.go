@@ -0,0 +1,74 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	lru "github.com/hashicorp/golang-lru"
+
+	raerror "istio.io/istio/security/pkg/pki/error"
+)
+
+// keyReuseTracker remembers the identity the public keys of the most recently issued CSRs were issued to, to
+// detect keys shared between identities. It is safe for concurrent use.
+type keyReuseTracker struct {
+	// identities are the identities of the recently issued keys, by SHA-256 of the key.
+	identities *lru.Cache
+}
+
+// newKeyReuseTracker returns the key reuse tracker of raOpts, or nil if reused keys are not rejected.
+func newKeyReuseTracker(raOpts *IstioRAOptions) (*keyReuseTracker, error) {
+	if !raOpts.RejectReusedKeys {
+		return nil, nil
+	}
+	windowSize := raOpts.ReusedKeyWindowSize
+	if windowSize <= 0 {
+		windowSize = DefaultReusedKeyWindowSize
+	}
+	identities, err := lru.New(windowSize)
+	if err != nil {
+		return nil, err
+	}
+	return &keyReuseTracker{identities: identities}, nil
+}
+
+// check returns a CSRError if the public key of the CSR of req was issued to another identity than its first
+// SubjectID within the window.
+func (t *keyReuseTracker) check(req *validatedRequest, subjectIDs []string) error {
+	if t == nil || len(subjectIDs) == 0 {
+		return nil
+	}
+	identity, ok := t.identities.Peek(sha256.Sum256(req.csr.RawSubjectPublicKeyInfo))
+	if !ok || identity.(string) == subjectIDs[0] {
+		return nil
+	}
+	// The identity the key was issued to is not disclosed to the requester.
+	requestLog(req.requestID).Warnf("rejecting the CSR of identity %s, its public key was recently issued to identity %s",
+		subjectIDs[0], identity)
+	reusedKeyCounts.Increment()
+	return raerror.NewError(raerror.CSRError, fmt.Errorf(
+		"the public key of the CSR was recently issued to another identity, a new key is required"))
+}
+
+// observe records that the public key of the CSR of req was issued to its first SubjectID. Only the keys of
+// issued certificates are recorded, so that CSRs that are not issued cannot claim a key.
+func (t *keyReuseTracker) observe(req *validatedRequest, subjectIDs []string) {
+	if t == nil || len(subjectIDs) == 0 {
+		return
+	}
+	t.identities.Add(sha256.Sum256(req.csr.RawSubjectPublicKeyInfo), subjectIDs[0])
+}
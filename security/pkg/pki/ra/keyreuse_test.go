@@ -0,0 +1,122 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"istio.io/istio/security/pkg/pki/ca"
+	raerror "istio.io/istio/security/pkg/pki/error"
+)
+
+// csrFor returns a PEM encoded CSR for identity with key.
+func csrFor(t *testing.T, key crypto.Signer, identity string) []byte {
+	t.Helper()
+	uri, err := url.Parse(identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr := createTestCSR(t, key, &x509.CertificateRequest{URIs: []*url.URL{uri}})
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr.Raw})
+}
+
+func TestRejectReusedKeys(t *testing.T) {
+	const (
+		identityA = "spiffe://cluster.local/ns/default/sa/a"
+		identityB = "spiffe://cluster.local/ns/default/sa/b"
+	)
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	sign := func(r *VaultRA, key crypto.Signer, identity string) error {
+		_, err := r.Sign(csrFor(t, key, identity), ca.CertOpts{SubjectIDs: []string{identity}, TTL: time.Hour})
+		return err
+	}
+	newRA := func(t *testing.T, raOpts *IstioRAOptions, vault *fakeVault) *VaultRA {
+		r := createFakeVaultRA(t, vault)
+		r.raOpts.RejectReusedKeys = raOpts.RejectReusedKeys
+		r.raOpts.ReusedKeyWindowSize = raOpts.ReusedKeyWindowSize
+		var err error
+		if r.keyReuse, err = newKeyReuseTracker(r.raOpts); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	t.Run("reused key", func(t *testing.T) {
+		r := newRA(t, &IstioRAOptions{RejectReusedKeys: true}, &fakeVault{leaseDuration: 3600})
+		rejected := getMetricValue(t, "ra_csr_reused_key_count", nil)
+		if err := sign(r, key, identityA); err != nil {
+			t.Fatalf("Failed to sign through Vault: %v", err)
+		}
+		err := sign(r, key, identityB)
+		var raErr *raerror.Error
+		if !errors.As(err, &raErr) || raErr.ErrorType() != "CSR_ERROR" {
+			t.Fatalf("expected the key of identity a to be rejected for identity b, got: %v", err)
+		}
+		if got := getMetricValue(t, "ra_csr_reused_key_count", nil); got != rejected+1 {
+			t.Errorf("ra_csr_reused_key_count: got %v, want %v", got, rejected+1)
+		}
+		if err := sign(r, key, identityA); err != nil {
+			t.Errorf("expected the key to be signed again for identity a, got: %v", err)
+		}
+		if err := sign(r, otherKey, identityB); err != nil {
+			t.Errorf("expected a new key to be signed for identity b, got: %v", err)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		r := newRA(t, &IstioRAOptions{}, &fakeVault{leaseDuration: 3600})
+		if err := sign(r, key, identityA); err != nil {
+			t.Fatalf("Failed to sign through Vault: %v", err)
+		}
+		if err := sign(r, key, identityB); err != nil {
+			t.Errorf("expected keys to be reusable without RejectReusedKeys, got: %v", err)
+		}
+	})
+
+	t.Run("key out of the window", func(t *testing.T) {
+		r := newRA(t, &IstioRAOptions{RejectReusedKeys: true, ReusedKeyWindowSize: 1}, &fakeVault{leaseDuration: 3600})
+		if err := sign(r, key, identityA); err != nil {
+			t.Fatalf("Failed to sign through Vault: %v", err)
+		}
+		if err := sign(r, otherKey, identityA); err != nil {
+			t.Fatalf("Failed to sign through Vault: %v", err)
+		}
+		if err := sign(r, key, identityB); err != nil {
+			t.Errorf("expected keys out of the window to be forgotten, got: %v", err)
+		}
+	})
+
+	t.Run("key not issued", func(t *testing.T) {
+		vault := &fakeVault{leaseDuration: 3600, signStatus: 500}
+		r := newRA(t, &IstioRAOptions{RejectReusedKeys: true}, vault)
+		if err := sign(r, key, identityA); err == nil {
+			t.Fatalf("expected signing to fail")
+		}
+		vault.signStatus = 0
+		if err := sign(r, key, identityB); err != nil {
+			t.Errorf("expected the key of a CSR that was not issued not to be bound, got: %v", err)
+		}
+	})
+}
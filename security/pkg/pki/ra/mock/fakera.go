@@ -0,0 +1,274 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mock
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"istio.io/istio/security/pkg/pki/ca"
+	raerror "istio.io/istio/security/pkg/pki/error"
+	"istio.io/istio/security/pkg/pki/ra"
+	"istio.io/istio/security/pkg/pki/util"
+)
+
+const (
+	// FakeSigner is the CertSigner of the certificates issued by a FakeRA for requests without a CertSigner.
+	FakeSigner = "istio.io/fake-ra"
+	// DefaultCertTTL is the TTL of the certificates issued by a FakeRA for requests without a TTL.
+	DefaultCertTTL = 24 * time.Hour
+
+	fakeCATTL = 10 * 365 * 24 * time.Hour
+)
+
+var _ ra.RegistrationAuthority = &FakeRA{}
+
+// FakeRA is an in-memory RegistrationAuthority for tests. It signs CSRs with a self-signed CA generated in
+// process, honoring the TTL, ForCA and SubjectIDs of the cert opts, and returns the issued certificates
+// followed by the CA certificate as their chain. Failures are injected with SetSignError and FailNext.
+// It is safe for concurrent use.
+type FakeRA struct {
+	keyCertBundle *util.KeyCertBundle
+
+	mutex       sync.Mutex
+	signErr     error
+	failNext    int
+	failNextErr error
+	requests    []ca.CertOpts
+}
+
+// NewFakeRA returns a FakeRA signing with a newly generated self-signed CA.
+func NewFakeRA() (*FakeRA, error) {
+	caCertPEM, caKeyPEM, err := util.GenCertKeyFromOptions(util.CertOptions{
+		// Before the NotBefore of the issued certificates, which are backdated for clock skew.
+		NotBefore:    time.Now().Add(-time.Hour),
+		TTL:          fakeCATTL,
+		Org:          "Istio Fake RA",
+		IsCA:         true,
+		IsSelfSigned: true,
+		ECSigAlg:     util.EcdsaSigAlg,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate the fake CA: %v", err)
+	}
+	keyCertBundle, err := util.NewVerifiedKeyCertBundleFromPem(caCertPEM, caKeyPEM, nil, caCertPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the fake CA: %v", err)
+	}
+	return &FakeRA{keyCertBundle: keyCertBundle}, nil
+}
+
+// SetSignError makes signing and Validate fail with err, until it is called again with nil.
+func (r *FakeRA) SetSignError(err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.signErr = err
+}
+
+// FailNext makes the next n sign requests fail with err, before any error set with SetSignError.
+func (r *FakeRA) FailNext(n int, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.failNext = n
+	r.failNextErr = err
+}
+
+// Requests returns the cert opts of the sign requests received so far, in order, including the failed ones.
+func (r *FakeRA) Requests() []ca.CertOpts {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]ca.CertOpts(nil), r.requests...)
+}
+
+// receive records the sign request with certOpts, and returns the error it fails with, if any.
+func (r *FakeRA) receive(certOpts ca.CertOpts) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.requests = append(r.requests, certOpts)
+	if r.failNext > 0 {
+		r.failNext--
+		return r.failNextErr
+	}
+	return r.signErr
+}
+
+// Sign returns a certificate signed by the fake CA for csrPEM.
+func (r *FakeRA) Sign(csrPEM []byte, certOpts ca.CertOpts) ([]byte, error) {
+	return r.SignContext(context.Background(), csrPEM, certOpts)
+}
+
+// SignContext is similar to Sign, but fails once ctx is done.
+func (r *FakeRA) SignContext(ctx context.Context, csrPEM []byte, certOpts ca.CertOpts) ([]byte, error) {
+	result, err := r.SignWithCertChainResponseContext(ctx, csrPEM, certOpts)
+	if err != nil {
+		return nil, err
+	}
+	return result.CertPEM, nil
+}
+
+// SignWithCertChain is similar to Sign, but returns the certificate followed by the fake CA certificate.
+func (r *FakeRA) SignWithCertChain(csrPEM []byte, certOpts ca.CertOpts) ([]byte, error) {
+	return r.SignWithCertChainContext(context.Background(), csrPEM, certOpts)
+}
+
+// SignWithCertChainContext is similar to SignWithCertChain, but fails once ctx is done.
+func (r *FakeRA) SignWithCertChainContext(ctx context.Context, csrPEM []byte, certOpts ca.CertOpts) ([]byte, error) {
+	result, err := r.SignWithCertChainResponseContext(ctx, csrPEM, certOpts)
+	if err != nil {
+		return nil, err
+	}
+	return result.CertChainPEM, nil
+}
+
+// SignWithCertChainResponse is similar to SignWithCertChain, but also returns the parsed details of the
+// issued certificate.
+func (r *FakeRA) SignWithCertChainResponse(csrPEM []byte, certOpts ca.CertOpts) (*ra.SignResult, error) {
+	return r.SignWithCertChainResponseContext(context.Background(), csrPEM, certOpts)
+}
+
+// SignWithCertChainResponseContext is similar to SignWithCertChainResponse, but fails once ctx is done.
+func (r *FakeRA) SignWithCertChainResponseContext(ctx context.Context, csrPEM []byte, certOpts ca.CertOpts) (*ra.SignResult, error) {
+	return r.SignParsed(ctx, nil, csrPEM, certOpts)
+}
+
+// SignWithSeparateChain is similar to SignWithCertChain, but returns the certificate and the fake CA
+// certificate separately. The chain is empty, as the certificates are issued by the CA directly.
+func (r *FakeRA) SignWithSeparateChain(csrPEM []byte, certOpts ca.CertOpts) (leaf, chain, roots []byte, err error) {
+	return r.SignWithSeparateChainContext(context.Background(), csrPEM, certOpts)
+}
+
+// SignWithSeparateChainContext is similar to SignWithSeparateChain, but fails once ctx is done.
+func (r *FakeRA) SignWithSeparateChainContext(ctx context.Context, csrPEM []byte,
+	certOpts ca.CertOpts) (leaf, chain, roots []byte, err error) {
+	result, err := r.SignWithCertChainResponseContext(ctx, csrPEM, certOpts)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return result.CertPEM, nil, r.keyCertBundle.GetRootCertPem(), nil
+}
+
+// SignParsed is similar to SignWithCertChainResponseContext, but takes the CSR raw already parsed as csr.
+// A nil csr is parsed from raw.
+func (r *FakeRA) SignParsed(ctx context.Context, csr *x509.CertificateRequest, raw []byte, certOpts ca.CertOpts) (*ra.SignResult, error) {
+	if err := r.receive(certOpts); err != nil {
+		return nil, err
+	}
+	if ctx.Err() != nil {
+		return nil, raerror.NewError(raerror.RequestCanceled, ctx.Err())
+	}
+	if csr == nil {
+		var err error
+		if csr, err = util.ParsePemEncodedCSR(raw); err != nil {
+			return nil, raerror.NewError(raerror.CSRError, err)
+		}
+	}
+	ttl := certOpts.TTL
+	if ttl <= 0 {
+		ttl = DefaultCertTTL
+	}
+	caCert, caKey, _, caCertPEM := r.keyCertBundle.GetAll()
+	certDER, err := util.GenCertFromCSR(csr, caCert, csr.PublicKey, *caKey, certOpts.SubjectIDs, ttl, certOpts.ForCA)
+	if err != nil {
+		return nil, raerror.NewError(raerror.CertGenError, err)
+	}
+	leafCert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return nil, raerror.NewError(raerror.CertGenError, err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	certSigner := certOpts.CertSigner
+	if certSigner == "" {
+		certSigner = FakeSigner
+	}
+	return &ra.SignResult{
+		CertPEM:             certPEM,
+		CertChainPEM:        append(append([]byte{}, certPEM...), caCertPEM...),
+		NotBefore:           leafCert.NotBefore,
+		NotAfter:            leafCert.NotAfter,
+		SerialNumber:        leafCert.SerialNumber,
+		SerialNumberDecimal: leafCert.SerialNumber.String(),
+		SerialNumberHex:     fmt.Sprintf("%X", leafCert.SerialNumber),
+		CertSigner:          certSigner,
+		EffectiveTTL:        ttl,
+		RenewAt:             leafCert.NotBefore.Add(leafCert.NotAfter.Sub(leafCert.NotBefore) / 2),
+	}, nil
+}
+
+// SignStream signs the requests received on requests one at a time and in order, and sends the response
+// of each on the returned channel before reading the next one. The channel is closed once requests is
+// closed or ctx is done.
+func (r *FakeRA) SignStream(ctx context.Context, requests <-chan ra.SignRequest) <-chan ra.SignResponse {
+	responses := make(chan ra.SignResponse)
+	go func() {
+		defer close(responses)
+		for {
+			var req ra.SignRequest
+			select {
+			case next, ok := <-requests:
+				if !ok {
+					return
+				}
+				req = next
+			case <-ctx.Done():
+				return
+			}
+			result, err := r.SignWithCertChainResponseContext(ctx, req.CSRPEM, req.CertOpts)
+			select {
+			case responses <- ra.SignResponse{Result: result, Err: err}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return responses
+}
+
+// Capabilities reports that the FakeRA issues CA certificates and signs with any requested signer.
+func (r *FakeRA) Capabilities() ra.RACapabilities {
+	return ra.RACapabilities{
+		ForCA:         true,
+		CustomSigners: true,
+	}
+}
+
+// Validate returns the error set with SetSignError if any, or whether csrPEM can be parsed. It does not
+// record the request, nor count it for FailNext.
+func (r *FakeRA) Validate(csrPEM []byte, certOpts ca.CertOpts) error {
+	r.mutex.Lock()
+	signErr := r.signErr
+	r.mutex.Unlock()
+	if signErr != nil {
+		return signErr
+	}
+	if _, err := util.ParsePemEncodedCSR(csrPEM); err != nil {
+		return raerror.NewError(raerror.CSRError, err)
+	}
+	return nil
+}
+
+// TrustBundleHandler returns a read-only http.Handler serving the fake CA certificate.
+func (r *FakeRA) TrustBundleHandler() http.Handler {
+	return ra.NewTrustBundleHandler(r.GetCAKeyCertBundle)
+}
+
+// GetCAKeyCertBundle returns the KeyCertBundle of the fake CA, whose root cert validates the issued certificates.
+func (r *FakeRA) GetCAKeyCertBundle() *util.KeyCertBundle {
+	return r.keyCertBundle
+}
@@ -0,0 +1,196 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mock
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"istio.io/istio/security/pkg/pki/ca"
+	raerror "istio.io/istio/security/pkg/pki/error"
+	"istio.io/istio/security/pkg/pki/ra"
+	"istio.io/istio/security/pkg/pki/util"
+)
+
+const testSubjectID = "spiffe://cluster.local/ns/default/sa/bookinfo-productpage"
+
+func newTestCSR(t *testing.T) []byte {
+	t.Helper()
+	csrPEM, _, err := util.GenCSR(util.CertOptions{Host: testSubjectID, ECSigAlg: util.EcdsaSigAlg})
+	if err != nil {
+		t.Fatalf("failed to generate a CSR: %v", err)
+	}
+	return csrPEM
+}
+
+func TestFakeRASign(t *testing.T) {
+	r, err := NewFakeRA()
+	if err != nil {
+		t.Fatalf("failed to create the fake RA: %v", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(r.GetCAKeyCertBundle().GetRootCertPem()) {
+		t.Fatal("failed to load the root cert of the fake RA")
+	}
+	testCases := map[string]struct {
+		certOpts   ca.CertOpts
+		wantTTL    time.Duration
+		wantSigner string
+	}{
+		"workload": {
+			certOpts:   ca.CertOpts{SubjectIDs: []string{testSubjectID}, TTL: time.Hour},
+			wantTTL:    time.Hour,
+			wantSigner: FakeSigner,
+		},
+		"CA": {
+			certOpts:   ca.CertOpts{SubjectIDs: []string{testSubjectID}, TTL: time.Hour, ForCA: true},
+			wantTTL:    time.Hour,
+			wantSigner: FakeSigner,
+		},
+		"default TTL and custom signer": {
+			certOpts:   ca.CertOpts{SubjectIDs: []string{testSubjectID}, CertSigner: "example.com/signer"},
+			wantTTL:    DefaultCertTTL,
+			wantSigner: "example.com/signer",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			start := time.Now()
+			result, err := r.SignWithCertChainResponse(newTestCSR(t), tc.certOpts)
+			if err != nil {
+				t.Fatalf("failed to sign: %v", err)
+			}
+			certs, err := util.ParsePemEncodedCertificateChain(result.CertChainPEM)
+			if err != nil || len(certs) != 2 {
+				t.Fatalf("expected the certificate and the CA certificate as the chain, got %d certs: %v", len(certs), err)
+			}
+			if !bytes.HasPrefix(result.CertChainPEM, result.CertPEM) ||
+				!bytes.HasSuffix(result.CertChainPEM, r.GetCAKeyCertBundle().GetRootCertPem()) {
+				t.Errorf("expected the certificate followed by the root cert of the fake RA as the chain")
+			}
+			leaf := certs[0]
+			usage := x509.ExtKeyUsageClientAuth
+			if tc.certOpts.ForCA {
+				usage = x509.ExtKeyUsageAny
+			}
+			if _, err := leaf.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{usage}}); err != nil {
+				t.Errorf("failed to verify the certificate with the root cert of the fake RA: %v", err)
+			}
+			if leaf.IsCA != tc.certOpts.ForCA {
+				t.Errorf("got IsCA %v, want %v", leaf.IsCA, tc.certOpts.ForCA)
+			}
+			if len(leaf.URIs) != 1 || leaf.URIs[0].String() != testSubjectID {
+				t.Errorf("got URI SANs %v, want %s", leaf.URIs, testSubjectID)
+			}
+			if leaf.NotAfter.Before(start.Add(tc.wantTTL).Truncate(time.Second)) || leaf.NotAfter.After(time.Now().Add(tc.wantTTL)) {
+				t.Errorf("got NotAfter %v, want %v after signing", leaf.NotAfter, tc.wantTTL)
+			}
+			if result.EffectiveTTL != tc.wantTTL || result.CertSigner != tc.wantSigner {
+				t.Errorf("got TTL %v and signer %s, want %v and %s", result.EffectiveTTL, result.CertSigner, tc.wantTTL, tc.wantSigner)
+			}
+			if result.SerialNumber.Cmp(leaf.SerialNumber) != 0 || result.SerialNumberDecimal != leaf.SerialNumber.Text(10) ||
+				result.SerialNumberHex != strings.ToUpper(leaf.SerialNumber.Text(16)) || !result.RenewAt.After(leaf.NotBefore) ||
+				!result.RenewAt.Before(leaf.NotAfter) {
+				t.Errorf("the sign result %+v does not match the certificate", result)
+			}
+		})
+	}
+}
+
+func TestFakeRASignWithSeparateChain(t *testing.T) {
+	r, err := NewFakeRA()
+	if err != nil {
+		t.Fatalf("failed to create the fake RA: %v", err)
+	}
+	leaf, chain, roots, err := r.SignWithSeparateChain(newTestCSR(t), ca.CertOpts{SubjectIDs: []string{testSubjectID}})
+	if err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	if certs, err := util.ParsePemEncodedCertificateChain(leaf); err != nil || len(certs) != 1 {
+		t.Errorf("expected the certificate only as the leaf, got %d certs: %v", len(certs), err)
+	}
+	if len(chain) != 0 {
+		t.Errorf("expected no intermediate chain, got\n%s", chain)
+	}
+	if !bytes.Equal(roots, r.GetCAKeyCertBundle().GetRootCertPem()) {
+		t.Errorf("expected the root cert of the fake RA as the roots")
+	}
+}
+
+func TestFakeRAErrors(t *testing.T) {
+	r, err := NewFakeRA()
+	if err != nil {
+		t.Fatalf("failed to create the fake RA: %v", err)
+	}
+	csrPEM := newTestCSR(t)
+	certOpts := ca.CertOpts{SubjectIDs: []string{testSubjectID}, TTL: time.Hour}
+
+	unavailable := raerror.NewError(raerror.CertGenError, errors.New("signer unavailable"))
+	r.FailNext(2, unavailable)
+	for i := 0; i < 2; i++ {
+		if _, err := r.Sign(csrPEM, certOpts); err != unavailable {
+			t.Fatalf("expected sign request %d to fail with the injected error, got: %v", i, err)
+		}
+	}
+	if _, err := r.Sign(csrPEM, certOpts); err != nil {
+		t.Fatalf("expected signing to succeed once the injected failures are consumed, got: %v", err)
+	}
+
+	denied := raerror.NewError(raerror.CSRDenied, errors.New("denied"))
+	r.SetSignError(denied)
+	if _, err := r.SignWithCertChain(csrPEM, certOpts); err != denied {
+		t.Errorf("expected signing to fail with the injected error, got: %v", err)
+	}
+	if err := r.Validate(csrPEM, certOpts); err != denied {
+		t.Errorf("expected validation to fail with the injected error, got: %v", err)
+	}
+	r.SetSignError(nil)
+
+	var raErr *raerror.Error
+	if _, err := r.Sign([]byte("not a CSR"), certOpts); !errors.As(err, &raErr) || raErr.ErrorType() != "CSR_ERROR" {
+		t.Errorf("expected a CSR_ERROR error for an invalid CSR, got: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := r.SignContext(ctx, csrPEM, certOpts); !errors.As(err, &raErr) || raErr.ErrorType() != "REQUEST_CANCELED" {
+		t.Errorf("expected a REQUEST_CANCELED error for a canceled request, got: %v", err)
+	}
+	if got := len(r.Requests()); got != 6 {
+		t.Errorf("expected 6 requests to be recorded, got %d", got)
+	}
+}
+
+func TestFakeRASignStream(t *testing.T) {
+	r, err := NewFakeRA()
+	if err != nil {
+		t.Fatalf("failed to create the fake RA: %v", err)
+	}
+	requests := make(chan ra.SignRequest, 2)
+	requests <- ra.SignRequest{CSRPEM: newTestCSR(t), CertOpts: ca.CertOpts{SubjectIDs: []string{testSubjectID}}}
+	requests <- ra.SignRequest{CSRPEM: []byte("not a CSR")}
+	close(requests)
+	var responses []ra.SignResponse
+	for resp := range r.SignStream(context.Background(), requests) {
+		responses = append(responses, resp)
+	}
+	if len(responses) != 2 || responses[0].Err != nil || responses[0].Result == nil || responses[1].Err == nil {
+		t.Errorf("expected a certificate then an error, got %+v", responses)
+	}
+}
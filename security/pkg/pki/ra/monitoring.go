@@ -0,0 +1,334 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	raerror "istio.io/istio/security/pkg/pki/error"
+	"istio.io/istio/security/pkg/pki/util"
+	"istio.io/pkg/monitoring"
+)
+
+const (
+	signerLabel     = "signer"
+	resultLabel     = "result"
+	errorLabel      = "error"
+	modeLabel       = "mode"
+	strategyLabel   = "strategy"
+	reasonLabel     = "reason"
+	validationLabel = "validation"
+
+	resultSuccess = "success"
+	resultError   = "error"
+	resultHit     = "hit"
+	resultMiss    = "miss"
+
+	// validationParse and the other validation label values are the checks of issued certificates.
+	validationParse       = "parse"
+	validationKey         = "key"
+	validationSAN         = "san"
+	validationSubject     = "subject"
+	validationExtKeyUsage = "ext_key_usage"
+	validationCustom      = "custom"
+
+	// customSignerLabel is the signer label value used for signers requested by workloads, so that
+	// arbitrary requested signer names cannot blow up the label cardinality.
+	customSignerLabel = "custom"
+)
+
+var (
+	signerTag     = monitoring.MustCreateLabel(signerLabel)
+	resultTag     = monitoring.MustCreateLabel(resultLabel)
+	errorTag      = monitoring.MustCreateLabel(errorLabel)
+	modeTag       = monitoring.MustCreateLabel(modeLabel)
+	strategyTag   = monitoring.MustCreateLabel(strategyLabel)
+	reasonTag     = monitoring.MustCreateLabel(reasonLabel)
+	validationTag = monitoring.MustCreateLabel(validationLabel)
+
+	// signCounts is the number of signing requests handled by the RA, labeled by signer and result.
+	signCounts = monitoring.NewSum(
+		"ra_cert_sign_count",
+		"The number of certificate signing requests handled by the RA, by signer and result.",
+		monitoring.WithLabels(signerTag, resultTag),
+	)
+
+	// signLatency is the time taken by the RA to sign a certificate, labeled by signer.
+	signLatency = monitoring.NewDistribution(
+		"ra_cert_sign_duration_seconds",
+		"Time in seconds the RA takes to sign a certificate, by signer.",
+		[]float64{.01, .1, .5, 1, 3, 5, 10, 30, 60},
+		monitoring.WithLabels(signerTag),
+		monitoring.WithUnit(monitoring.Seconds),
+	)
+
+	// issuedLifetime is the lifetime of the certificates issued by the signers, labeled by signer.
+	issuedLifetime = monitoring.NewDistribution(
+		"ra_cert_issued_lifetime_seconds",
+		"Lifetime in seconds (NotAfter - NotBefore) of the certificates issued through the RA, by signer.",
+		[]float64{10, 60, 300, 900, 1800, 3600, 4 * 3600, 12 * 3600, 86400, 7 * 86400, 30 * 86400, 90 * 86400, 365 * 86400},
+		monitoring.WithLabels(signerTag),
+		monitoring.WithUnit(monitoring.Seconds),
+	)
+
+	// certChainDepths is the number of certificates in the chains returned with the issued certificates, labeled by
+	// signer.
+	certChainDepths = monitoring.NewDistribution(
+		"ra_cert_chain_depth",
+		"The number of certificates, the issued one included, in the cert chains returned by the RA, by signer.",
+		[]float64{1, 2, 3, 4, 5, 6, 8, 10},
+		monitoring.WithLabels(signerTag),
+	)
+
+	// signErrorCounts is the number of signing errors, labeled by the RA error type (e.g. CERT_GEN_ERROR).
+	signErrorCounts = monitoring.NewSum(
+		"ra_cert_sign_err_count",
+		"The number of errors occurred when signing certificates in the RA, by error type.",
+		monitoring.WithLabels(errorTag),
+	)
+
+	// signRetryCounts is the number of times signing was retried after a transient K8s API error.
+	signRetryCounts = monitoring.NewSum(
+		"ra_cert_sign_retry_count",
+		"The number of times the RA retried signing after a transient error.",
+	)
+
+	// orphanedCSRCounts is the number of K8s CSR objects that could not be deleted after signing.
+	orphanedCSRCounts = monitoring.NewSum(
+		"ra_orphaned_csr_count",
+		"The number of K8s CSR objects the RA failed to clean up after signing.",
+	)
+
+	// certCacheLookups is the number of certificate cache lookups, labeled by result (hit or miss).
+	certCacheLookups = monitoring.NewSum(
+		"ra_cert_cache_lookup_count",
+		"The number of lookups of issued certificates in the RA cache, by result (hit or miss).",
+		monitoring.WithLabels(resultTag),
+	)
+
+	// clockSkewCounts is the number of issued certificates not valid yet beyond the tolerance, labeled by signer.
+	clockSkewCounts = monitoring.NewSum(
+		"ra_cert_clock_skew_count",
+		"The number of certificates issued with a NotBefore in the future of the RA clock, by signer.",
+		monitoring.WithLabels(signerTag),
+	)
+
+	// serialCollisionCounts is the number of certificates issued with a serial number the signer issued already,
+	// labeled by signer.
+	serialCollisionCounts = monitoring.NewSum(
+		"ra_cert_serial_collision_count",
+		"The number of certificates issued with a serial number recently issued by the same signer, by signer.",
+		monitoring.WithLabels(signerTag),
+	)
+
+	// rateLimitedCounts is the number of signing requests rejected by the per-identity rate limit, labeled by
+	// signer.
+	rateLimitedCounts = monitoring.NewSum(
+		"ra_cert_sign_rate_limited_count",
+		"The number of signing requests rejected because their identity exceeded its rate limit, by signer.",
+		monitoring.WithLabels(signerTag),
+	)
+
+	// signFallbackCounts is the number of times signing fell back to a FallbackSigners signer, labeled by the
+	// fallback signer.
+	signFallbackCounts = monitoring.NewSum(
+		"ra_cert_sign_fallback_count",
+		"The number of times signing fell back to a fallback signer after the previous one was unavailable, by "+
+			"fallback signer.",
+		monitoring.WithLabels(signerTag),
+	)
+
+	// reusedKeyCounts is the number of CSRs rejected because their public key was issued to another identity.
+	reusedKeyCounts = monitoring.NewSum(
+		"ra_csr_reused_key_count",
+		"The number of CSRs rejected because their public key was recently issued to another identity.",
+	)
+
+	// sanValidationFailureCounts is the number of CSRs failing SAN validation, labeled by SAN validation mode.
+	sanValidationFailureCounts = monitoring.NewSum(
+		"ra_csr_san_validation_failure_count",
+		"The number of CSRs without SANs or with SAN identities that were not requested, by SAN validation mode "+
+			"(Warn or Enforce).",
+		monitoring.WithLabels(modeTag),
+	)
+
+	// csrWaitCounts is the number of K8s CSRs waited for with each strategy, labeled by strategy.
+	csrWaitCounts = monitoring.NewSum(
+		"ra_csr_wait_strategy_count",
+		"The number of K8s CSRs waited for by strategy (Watch or Poll). CSRs polled after their watch failed are "+
+			"counted for both strategies.",
+		monitoring.WithLabels(strategyTag),
+	)
+
+	// postSignValidationFailureCounts is the number of certificates issued by the signers that failed their
+	// validation by the RA, labeled by validation and signer.
+	postSignValidationFailureCounts = monitoring.NewSum(
+		"ra_post_sign_validation_failures_total",
+		"The number of certificates issued by the signers that failed the checks of the RA, by validation (parse, key, "+
+			"san, subject, ext_key_usage, extensions or custom) and signer.",
+		monitoring.WithLabels(validationTag, signerTag),
+	)
+
+	// auditFailureCounts is the number of issued certificates the AuditSink failed to record, labeled by signer and
+	// reason (error or timeout).
+	auditFailureCounts = monitoring.NewSum(
+		"ra_cert_audit_failure_count",
+		"The number of issued certificates the audit sink failed to record, by signer and reason (error or timeout).",
+		monitoring.WithLabels(signerTag, reasonTag),
+	)
+
+	// inflightSignCount is the number of signing requests in flight in the Kubernetes RAs.
+	inflightSignCount = monitoring.NewGauge(
+		"ra_inflight_sign_count",
+		"The number of signing requests in flight in the Kubernetes RA, which shutdown waits for.",
+	)
+
+	// outstandingCSRCount is the number of K8s CSR objects outstanding in the Kubernetes RAs.
+	outstandingCSRCount = monitoring.NewGauge(
+		"ra_outstanding_csr_count",
+		"The number of K8s CSR objects the Kubernetes RA has outstanding, which MaxConcurrentCSRs limits.",
+	)
+
+	// signerCircuitState is the state of the circuit breaker of each signer, labeled by signer.
+	signerCircuitState = monitoring.NewGauge(
+		"ra_signer_circuit_state",
+		"The state of the circuit breaker of each signer of the Kubernetes RA, by signer: 0 closed, 1 half-open, 2 open.",
+		monitoring.WithLabels(signerTag),
+	)
+
+	// signerCABundleReloadTimestamp is when the CA bundle of each signer in SignerCaCertFiles was last loaded,
+	// labeled by signer.
+	signerCABundleReloadTimestamp = monitoring.NewGauge(
+		"ra_signer_ca_bundle_last_reload_success_timestamp_seconds",
+		"The Unix time in seconds when the CA bundle of each signer with its own CA cert file was last loaded, by signer.",
+		monitoring.WithLabels(signerTag),
+		monitoring.WithUnit(monitoring.Seconds),
+	)
+
+	// rootCertExpirySeconds is the time until the soonest expiring CA root cert of each signer expires, labeled by
+	// signer.
+	rootCertExpirySeconds = monitoring.NewGauge(
+		"ra_root_cert_expiry_seconds",
+		"The number of seconds until the soonest expiring CA root certificate of each signer of the RA expires, by signer.",
+		monitoring.WithLabels(signerTag),
+		monitoring.WithUnit(monitoring.Seconds),
+	)
+)
+
+func init() {
+	monitoring.MustRegister(
+		signCounts,
+		signLatency,
+		issuedLifetime,
+		certChainDepths,
+		signErrorCounts,
+		signRetryCounts,
+		orphanedCSRCounts,
+		certCacheLookups,
+		rootCertExpirySeconds,
+		clockSkewCounts,
+		serialCollisionCounts,
+		rateLimitedCounts,
+		signFallbackCounts,
+		sanValidationFailureCounts,
+		reusedKeyCounts,
+		csrWaitCounts,
+		auditFailureCounts,
+		inflightSignCount,
+		postSignValidationFailureCounts,
+		signerCABundleReloadTimestamp,
+		outstandingCSRCount,
+		signerCircuitState,
+	)
+}
+
+// recordSign records the outcome of a signing request that started at start.
+func recordSign(signer string, start time.Time, err error) {
+	signLatency.With(signerTag.Value(signer)).Record(time.Since(start).Seconds())
+	if err == nil {
+		signCounts.With(signerTag.Value(signer), resultTag.Value(resultSuccess)).Increment()
+		return
+	}
+	signCounts.With(signerTag.Value(signer), resultTag.Value(resultError)).Increment()
+	signErrorCounts.With(errorTag.Value(errorType(err))).Increment()
+}
+
+// recordIssuedLifetime records the lifetime of the certificate of result, issued by signer.
+func recordIssuedLifetime(signer string, result *SignResult) {
+	issuedLifetime.With(signerTag.Value(signer)).Record(result.NotAfter.Sub(result.NotBefore).Seconds())
+}
+
+// recordSignerCABundleReload records that the CA bundle of signer was loaded at now.
+func recordSignerCABundleReload(signer string, now time.Time) {
+	signerCABundleReloadTimestamp.With(signerTag.Value(signer)).Record(float64(now.Unix()))
+}
+
+// recordSignerCircuitState records the state of the circuit breaker of signer.
+func recordSignerCircuitState(signer string, state circuitState) {
+	signerCircuitState.With(signerTag.Value(signer)).Record(float64(state))
+}
+
+// errorType returns the RA error type of err (e.g. CERT_GEN_ERROR), or UNKNOWN if it is not an RA error.
+func errorType(err error) string {
+	var raErr *raerror.Error
+	if errors.As(err, &raErr) {
+		return raErr.ErrorType()
+	}
+	return "UNKNOWN"
+}
+
+// hasErrorType returns whether err is an RA error of type t.
+func hasErrorType(err error, t raerror.ErrType) bool {
+	var raErr *raerror.Error
+	return errors.As(err, &raErr) && raErr.ErrType() == t
+}
+
+// inflightSigns is the number of signing requests in flight in the Kubernetes RAs.
+var inflightSigns int64
+
+// recordInflightSigns adds delta to the number of signing requests in flight, and records it.
+func recordInflightSigns(delta int64) {
+	inflightSignCount.Record(float64(atomic.AddInt64(&inflightSigns, delta)))
+}
+
+// outstandingCSRs is the number of K8s CSR objects outstanding in the Kubernetes RAs.
+var outstandingCSRs int64
+
+// recordOutstandingCSRs adds delta to the number of outstanding CSRs.
+func recordOutstandingCSRs(delta int64) {
+	outstandingCSRCount.Record(float64(atomic.AddInt64(&outstandingCSRs, delta)))
+}
+
+// recordRootCertExpiry records the time until the soonest expiring root cert in rootCertPem, the CA bundle of
+// signer, expires.
+func recordRootCertExpiry(signer string, rootCertPem []byte, now time.Time) {
+	if len(rootCertPem) == 0 {
+		return
+	}
+	rootCerts, err := util.ParsePemEncodedCertificateChain(rootCertPem)
+	if err != nil {
+		pkiRaLog.Errorf("failed to record CA root cert expiry of signer %s: %v", signer, err)
+		return
+	}
+	soonest := rootCerts[0].NotAfter
+	for _, rootCert := range rootCerts[1:] {
+		if rootCert.NotAfter.Before(soonest) {
+			soonest = rootCert.NotAfter
+		}
+	}
+	rootCertExpirySeconds.With(signerTag.Value(signer)).Record(soonest.Sub(now).Seconds())
+}
@@ -0,0 +1,245 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"crypto/x509"
+	"errors"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.opencensus.io/stats/view"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"istio.io/istio/security/pkg/pki/ca"
+)
+
+// getMetricValue returns the value of the sum or distribution count of the metric row matching all the given tags.
+func getMetricValue(t *testing.T, name string, tags map[string]string) float64 {
+	t.Helper()
+	rows, err := view.RetrieveData(name)
+	if err != nil {
+		t.Fatalf("failed to get value for metric %s: %v", name, err)
+	}
+	for _, row := range rows {
+		matched := 0
+		for _, tag := range row.Tags {
+			if v, ok := tags[tag.Key.Name()]; ok && v == tag.Value {
+				matched++
+			}
+		}
+		if matched != len(tags) {
+			continue
+		}
+		switch data := row.Data.(type) {
+		case *view.SumData:
+			return data.Value
+		case *view.DistributionData:
+			return float64(data.Count)
+		case *view.LastValueData:
+			return data.Value
+		}
+	}
+	return 0
+}
+
+func TestSignMetrics(t *testing.T) {
+	r, err := createFakeK8sRA(fake.NewSimpleClientset())
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	signer := r.raOpts.CaSigner
+	errorTags := map[string]string{signerLabel: signer, resultLabel: resultError}
+	csrErrorTags := map[string]string{errorLabel: "CSR_ERROR"}
+	signErrors := getMetricValue(t, "ra_cert_sign_count", errorTags)
+	csrErrors := getMetricValue(t, "ra_cert_sign_err_count", csrErrorTags)
+	latencies := getMetricValue(t, "ra_cert_sign_duration_seconds", map[string]string{signerLabel: signer})
+
+	// CA certificates cannot be requested, so signing fails in preSign.
+	_, err = r.Sign(createFakeCsr(t), ca.CertOpts{
+		SubjectIDs: []string{testCsrHostName},
+		TTL:        time.Minute,
+		ForCA:      true,
+	})
+	if err == nil {
+		t.Fatalf("expected signing a CA certificate to fail")
+	}
+
+	if got := getMetricValue(t, "ra_cert_sign_count", errorTags); got != signErrors+1 {
+		t.Errorf("ra_cert_sign_count: got %v, want %v", got, signErrors+1)
+	}
+	if got := getMetricValue(t, "ra_cert_sign_err_count", csrErrorTags); got != csrErrors+1 {
+		t.Errorf("ra_cert_sign_err_count: got %v, want %v", got, csrErrors+1)
+	}
+	if got := getMetricValue(t, "ra_cert_sign_duration_seconds", map[string]string{signerLabel: signer}); got != latencies+1 {
+		t.Errorf("ra_cert_sign_duration_seconds: got %v, want %v", got, latencies+1)
+	}
+}
+
+func TestIssuedLifetimeMetric(t *testing.T) {
+	r := createFakeVaultRA(t, &fakeVault{leaseDuration: 3600})
+	tags := map[string]string{signerLabel: vaultSignerLabel}
+	issued := getMetricValue(t, "ra_cert_issued_lifetime_seconds", tags)
+	if _, err := r.Sign(createFakeCsr(t), ca.CertOpts{
+		SubjectIDs: []string{testCsrHostName},
+		TTL:        10 * time.Minute,
+	}); err != nil {
+		t.Fatalf("Failed to sign through Vault: %v", err)
+	}
+	if got := getMetricValue(t, "ra_cert_issued_lifetime_seconds", tags) - issued; got != 1 {
+		t.Errorf("got %v issued lifetimes recorded, want 1", got)
+	}
+	// signing fails before a certificate is issued
+	r.raOpts.SANValidationMode = SANValidationEnforce
+	if _, err := r.Sign(createFakeCsr(t), ca.CertOpts{SubjectIDs: []string{"spiffe://other"}, TTL: time.Minute}); err == nil {
+		t.Fatalf("expected signing for another identity to fail")
+	}
+	if got := getMetricValue(t, "ra_cert_issued_lifetime_seconds", tags) - issued; got != 1 {
+		t.Errorf("got %v issued lifetimes recorded, want failed requests not to be recorded", got)
+	}
+}
+
+func TestPostSignValidationFailureMetric(t *testing.T) {
+	testCases := map[string]struct {
+		raOpts     func(raOpts *IstioRAOptions)
+		validation string
+	}{
+		"subject": {
+			raOpts:     func(raOpts *IstioRAOptions) { raOpts.DefaultOU = []string{"mesh"} },
+			validation: validationSubject,
+		},
+		"custom": {
+			raOpts: func(raOpts *IstioRAOptions) {
+				raOpts.PostSignValidators = []func(*x509.Certificate) error{
+					func(*x509.Certificate) error { return errors.New("rejected") },
+				}
+			},
+			validation: validationCustom,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			r := createFakeVaultRA(t, &fakeVault{leaseDuration: 3600})
+			tc.raOpts(r.raOpts)
+			tags := map[string]string{validationLabel: tc.validation, signerLabel: vaultSignerLabel}
+			failures := getMetricValue(t, "ra_post_sign_validation_failures_total", tags)
+			if _, err := r.Sign(createFakeCsr(t), ca.CertOpts{SubjectIDs: []string{testCsrHostName}, TTL: 10 * time.Minute}); err == nil {
+				t.Fatalf("expected the issued certificate to be rejected")
+			}
+			if got := getMetricValue(t, "ra_post_sign_validation_failures_total", tags) - failures; got != 1 {
+				t.Errorf("got %v post-sign validation failures recorded, want 1", got)
+			}
+		})
+	}
+	// CSRs rejected by the signer are not recorded
+	totalFailures := func() float64 {
+		total := 0.0
+		for _, validation := range []string{validationParse, validationKey, validationSAN, validationSubject,
+			validationExtKeyUsage, validationCustom} {
+			tags := map[string]string{validationLabel: validation, signerLabel: vaultSignerLabel}
+			total += getMetricValue(t, "ra_post_sign_validation_failures_total", tags)
+		}
+		return total
+	}
+	failures := totalFailures()
+	r := createFakeVaultRA(t, &fakeVault{leaseDuration: 3600, signStatus: http.StatusBadRequest})
+	if _, err := r.Sign(createFakeCsr(t), ca.CertOpts{SubjectIDs: []string{testCsrHostName}, TTL: 10 * time.Minute}); err == nil {
+		t.Fatalf("expected the CSR to be rejected by the signer")
+	}
+	if got := totalFailures() - failures; got != 0 {
+		t.Errorf("got %v post-sign validation failures recorded for a CSR rejected by the signer, want 0", got)
+	}
+}
+
+func TestCertChainDepthMetric(t *testing.T) {
+	rootPEM := genRootCert(t, time.Now(), time.Hour)
+	r := createFakeVaultRA(t, &fakeVault{leaseDuration: 3600, caChain: []string{string(rootPEM)}})
+	r.raOpts.MinCertChainDepth = 3
+	// the fake Vault signs with another CA than the ones of its CA chain
+	r.raOpts.CertChainMismatchPolicy = CertChainMismatchAllow
+	tags := map[string]string{signerLabel: vaultSignerLabel}
+	recorded := getMetricValue(t, "ra_cert_chain_depth", tags)
+	// a chain below the expected depth is only logged
+	result, err := r.SignWithCertChainResponse(createFakeCsr(t), ca.CertOpts{
+		SubjectIDs: []string{testCsrHostName},
+		TTL:        10 * time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("Failed to sign through Vault: %v", err)
+	}
+	if depth := certChainDepth(result.CertChainPEM); depth != 2 {
+		t.Errorf("got a cert chain of %d certificates, want the certificate and the CA chain of Vault", depth)
+	}
+	if got := getMetricValue(t, "ra_cert_chain_depth", tags) - recorded; got != 1 {
+		t.Errorf("got %v cert chain depths recorded, want 1", got)
+	}
+}
+
+func TestRootCertExpiryMetric(t *testing.T) {
+	const caSigner, otherSigner = "kubernates.io/kube-apiserver-client", "example.com/other"
+	now := time.Now()
+	soonRoot := genRootCert(t, now.Add(-time.Hour), 2*time.Hour)
+	laterRoot := genRootCert(t, now.Add(-time.Hour), 10*time.Hour)
+	otherRoot := genRootCert(t, now.Add(-time.Hour), 6*time.Hour)
+	caCertFile := filepath.Join(t.TempDir(), "root-cert.pem")
+	if err := os.WriteFile(caCertFile, append(append([]byte{}, laterRoot...), soonRoot...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	otherCaCertFile := filepath.Join(t.TempDir(), "other-root-cert.pem")
+	if err := os.WriteFile(otherCaCertFile, otherRoot, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewKubernetesRA(&IstioRAOptions{
+		ExternalCAType:    ExtCAK8s,
+		CaSigner:          caSigner,
+		CaCertFile:        caCertFile,
+		SignerCaCertFiles: map[string]string{otherSigner: otherCaCertFile},
+		K8sClient:         fake.NewSimpleClientset(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	expectExpiry := func(signer string, want time.Duration) {
+		t.Helper()
+		got := getMetricValue(t, "ra_root_cert_expiry_seconds", map[string]string{signerLabel: signer})
+		if math.Abs(got-want.Seconds()) > time.Minute.Seconds() {
+			t.Fatalf("ra_root_cert_expiry_seconds of signer %s: got %v, want about %v", signer, got, want.Seconds())
+		}
+	}
+	// The soonest expiring root of each signer is reported.
+	expectExpiry(caSigner, time.Hour)
+	expectExpiry(otherSigner, 5*time.Hour)
+
+	if err := os.WriteFile(caCertFile, laterRoot, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r.reloadCABundle()
+	expectExpiry(caSigner, 9*time.Hour)
+	expectExpiry(otherSigner, 5*time.Hour)
+
+	// Reloads of unchanged CA bundles refresh the expiry all the same.
+	rootCertExpirySeconds.With(signerTag.Value(caSigner)).Record(0)
+	rootCertExpirySeconds.With(signerTag.Value(otherSigner)).Record(0)
+	r.reloadCABundle()
+	if err := r.ReloadSignerCABundle(otherSigner); err != nil {
+		t.Fatal(err)
+	}
+	expectExpiry(caSigner, 9*time.Hour)
+	expectExpiry(otherSigner, 5*time.Hour)
+}
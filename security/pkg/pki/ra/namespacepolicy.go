@@ -0,0 +1,127 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"fmt"
+	"strings"
+
+	"istio.io/istio/pkg/spiffe"
+	raerror "istio.io/istio/security/pkg/pki/error"
+	"istio.io/istio/security/pkg/pki/util"
+)
+
+// NamespacePolicy restricts the namespaces of the requesters that may obtain certificates for the SPIFFE
+// identities of a trust domain and namespace, e.g. so that workloads of a tenant cannot obtain certificates
+// for the identities of another tenant. Patterns match exactly, or any suffix with a trailing *.
+type NamespacePolicy struct {
+	// TrustDomain is the pattern of the trust domains of the identities, e.g. cluster.local or *.
+	TrustDomain string
+	// Namespace is the pattern of the namespaces of the identities, e.g. tenant-a or tenant-a-*.
+	Namespace string
+	// AllowedRequesterNamespaces are the patterns of the namespaces of the requesters allowed to obtain
+	// certificates for the identities.
+	AllowedRequesterNamespaces []string
+	// AllowSameNamespace allows the requesters in the namespace of the identities in addition.
+	AllowSameNamespace bool
+}
+
+// matches returns whether the policy applies to the identities of namespace in trustDomain.
+func (p *NamespacePolicy) matches(trustDomain, namespace string) bool {
+	return patternMatches(p.TrustDomain, trustDomain) && patternMatches(p.Namespace, namespace)
+}
+
+// allows returns whether the policy allows requesterNamespace to obtain certificates for the identities of
+// namespace.
+func (p *NamespacePolicy) allows(namespace, requesterNamespace string) bool {
+	if p.AllowSameNamespace && requesterNamespace == namespace {
+		return true
+	}
+	for _, pattern := range p.AllowedRequesterNamespaces {
+		if patternMatches(pattern, requesterNamespace) {
+			return true
+		}
+	}
+	return false
+}
+
+// patternMatches returns whether value is pattern, or starts with pattern without its trailing *.
+func patternMatches(pattern, value string) bool {
+	if prefix := strings.TrimSuffix(pattern, "*"); prefix != pattern {
+		return strings.HasPrefix(value, prefix)
+	}
+	return value == pattern
+}
+
+// validateNamespacePolicies checks that the NamespacePolicies of raOpts have a trust domain and a namespace.
+func validateNamespacePolicies(raOpts *IstioRAOptions) error {
+	for i, policy := range raOpts.NamespacePolicies {
+		if policy.TrustDomain == "" || policy.Namespace == "" {
+			return raerror.NewError(raerror.CAInitFail, fmt.Errorf("namespace policy %d requires a trust domain and a namespace", i))
+		}
+	}
+	return nil
+}
+
+// spiffeNamespace returns the trust domain of the SPIFFE identity id, and the namespace in its /ns/<namespace>/
+// path segments, if any.
+func spiffeNamespace(id string) (trustDomain, namespace string, ok bool) {
+	segments := strings.Split(strings.TrimPrefix(id, spiffe.URIPrefix), "/")
+	for i := 1; i+1 < len(segments); i++ {
+		if segments[i] == spiffe.NamespaceSegment {
+			return segments[0], segments[i+1], true
+		}
+	}
+	return "", "", false
+}
+
+// validateRequesterNamespace checks that requesterNamespace may obtain certificates for the SPIFFE identities
+// with a namespace, as allowed by one of the NamespacePolicies matching them. Identities that no policy matches,
+// and SPIFFE identities without a namespace, are not restricted.
+func validateRequesterNamespace(raOpts *IstioRAOptions, identities []csrIdentity, requesterNamespace string) error {
+	if len(raOpts.NamespacePolicies) == 0 {
+		return nil
+	}
+	for _, id := range identities {
+		if id.idType != util.TypeURI || !strings.HasPrefix(id.value, spiffe.URIPrefix) {
+			continue
+		}
+		trustDomain, namespace, ok := spiffeNamespace(id.value)
+		if !ok {
+			continue
+		}
+		matched, allowed := false, false
+		for i := range raOpts.NamespacePolicies {
+			policy := &raOpts.NamespacePolicies[i]
+			if !policy.matches(trustDomain, namespace) {
+				continue
+			}
+			matched = true
+			if requesterNamespace != "" && policy.allows(namespace, requesterNamespace) {
+				allowed = true
+				break
+			}
+		}
+		switch {
+		case !matched:
+		case requesterNamespace == "":
+			return fmt.Errorf("the namespace of the requester is required to obtain a certificate for identity %q", id.value)
+		case !allowed:
+			return fmt.Errorf("requesters in namespace %q are not allowed to obtain a certificate for identity %q",
+				requesterNamespace, id.value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"istio.io/istio/security/pkg/pki/ca"
+	raerror "istio.io/istio/security/pkg/pki/error"
+	pkiutil "istio.io/istio/security/pkg/pki/util"
+)
+
+func TestValidateRequesterNamespace(t *testing.T) {
+	raOpts := &IstioRAOptions{NamespacePolicies: []NamespacePolicy{
+		{TrustDomain: "cluster.local", Namespace: "tenant-a-*", AllowedRequesterNamespaces: []string{"tenant-a-gateway"}, AllowSameNamespace: true},
+		{TrustDomain: "*", Namespace: "istio-system", AllowedRequesterNamespaces: []string{"istio-system"}},
+		{TrustDomain: "cluster.local", Namespace: "shared", AllowedRequesterNamespaces: []string{"tenant-*"}},
+	}}
+	testCases := map[string]struct {
+		id                 string
+		requesterNamespace string
+		expectErr          bool
+	}{
+		"same namespace": {
+			id:                 "spiffe://cluster.local/ns/tenant-a-web/sa/web",
+			requesterNamespace: "tenant-a-web",
+		},
+		"allowed requester namespace": {
+			id:                 "spiffe://cluster.local/ns/tenant-a-web/sa/web",
+			requesterNamespace: "tenant-a-gateway",
+		},
+		"namespace of another tenant": {
+			id:                 "spiffe://cluster.local/ns/tenant-a-web/sa/web",
+			requesterNamespace: "tenant-b-web",
+			expectErr:          true,
+		},
+		"other namespace matched by the same policy": {
+			id:                 "spiffe://cluster.local/ns/tenant-a-web/sa/web",
+			requesterNamespace: "tenant-a-db",
+			expectErr:          true,
+		},
+		"no requester namespace": {
+			id:        "spiffe://cluster.local/ns/tenant-a-web/sa/web",
+			expectErr: true,
+		},
+		"any trust domain": {
+			id:                 "spiffe://example.com/ns/istio-system/sa/istiod",
+			requesterNamespace: "tenant-a-web",
+			expectErr:          true,
+		},
+		"allowed requester namespace pattern": {
+			id:                 "spiffe://cluster.local/ns/shared/sa/cache",
+			requesterNamespace: "tenant-b",
+		},
+		"same namespace not allowed": {
+			id:                 "spiffe://cluster.local/ns/shared/sa/cache",
+			requesterNamespace: "shared",
+			expectErr:          true,
+		},
+		"namespace in a custom path": {
+			id:                 "spiffe://cluster.local/region/us/ns/tenant-a-web/sa/web",
+			requesterNamespace: "tenant-b-web",
+			expectErr:          true,
+		},
+		"identity matched by no policy": {
+			id:                 "spiffe://cluster.local/ns/default/sa/default",
+			requesterNamespace: "tenant-b-web",
+		},
+		"identity without namespace": {
+			id:                 "spiffe://cluster.local/tenant-a-web",
+			requesterNamespace: "tenant-b-web",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			identities := []csrIdentity{{idType: pkiutil.TypeURI, value: tc.id}}
+			err := validateRequesterNamespace(raOpts, identities, tc.requesterNamespace)
+			if tc.expectErr != (err != nil) {
+				t.Errorf("got error %v, expected an error: %v", err, tc.expectErr)
+			}
+		})
+	}
+	if err := validateRequesterNamespace(&IstioRAOptions{}, []csrIdentity{{idType: pkiutil.TypeURI, value: testCsrHostName}}, ""); err != nil {
+		t.Errorf("expected requests not to be restricted without namespace policies, got: %v", err)
+	}
+}
+
+func TestValidateNamespacePolicies(t *testing.T) {
+	for _, policy := range []NamespacePolicy{
+		{Namespace: "default", AllowedRequesterNamespaces: []string{"default"}},
+		{TrustDomain: "cluster.local", AllowedRequesterNamespaces: []string{"default"}},
+	} {
+		raOpts := &IstioRAOptions{NamespacePolicies: []NamespacePolicy{policy}}
+		var raErr *raerror.Error
+		if err := validateNamespacePolicies(raOpts); !errors.As(err, &raErr) || raErr.ErrorType() != "CA_INIT_FAIL" {
+			t.Errorf("expected a CA_INIT_FAIL error for policy %+v, got: %v", policy, err)
+		}
+	}
+}
+
+func TestPreSignRequesterNamespace(t *testing.T) {
+	csrPEM, _, err := pkiutil.GenCSR(pkiutil.CertOptions{Host: testCsrHostName, RSAKeySize: 2048})
+	if err != nil {
+		t.Fatalf("failed to create CSR: %v", err)
+	}
+	raOpts := &IstioRAOptions{NamespacePolicies: []NamespacePolicy{
+		{TrustDomain: "cluster.local", Namespace: "*", AllowSameNamespace: true},
+	}}
+	certOpts := ca.CertOpts{SubjectIDs: []string{testCsrHostName}, TTL: time.Hour, RequesterNamespace: "default"}
+	if _, err := preSign(raOpts, nil, csrPEM, certOpts); err != nil {
+		t.Errorf("unexpected error for a requester in the namespace of the identity: %v", err)
+	}
+	certOpts.RequesterNamespace = "other"
+	var raErr *raerror.Error
+	if _, err := preSign(raOpts, nil, csrPEM, certOpts); !errors.As(err, &raErr) || raErr.ErrorType() != "CSR_ERROR" {
+		t.Errorf("expected a CSR_ERROR error for a requester in another namespace, got: %v", err)
+	}
+}
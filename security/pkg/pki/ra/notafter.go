@@ -0,0 +1,42 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"fmt"
+	"time"
+
+	"istio.io/istio/security/pkg/pki/ca"
+	raerror "istio.io/istio/security/pkg/pki/error"
+)
+
+// requestedLifetime returns the lifetime requested with certOpts at now, before it is clamped: the time until its
+// NotAfter, truncated to the second so that the certificate does not outlive it, or else its TTL. The NotAfter
+// takes precedence over the TTL, and must not be in the past.
+func requestedLifetime(certOpts ca.CertOpts, now time.Time) (time.Duration, error) {
+	if certOpts.NotAfter.IsZero() {
+		return certOpts.TTL, nil
+	}
+	lifetime := certOpts.NotAfter.Sub(now).Truncate(time.Second)
+	if lifetime <= 0 {
+		return 0, raerror.NewError(raerror.CSRError, fmt.Errorf("requested NotAfter %v is in the past",
+			certOpts.NotAfter.UTC().Format(time.RFC3339)))
+	}
+	if certOpts.TTL > 0 {
+		requestLog(certOpts.RequestID).Debugf("requested TTL %v is ignored for the requested NotAfter %v", certOpts.TTL,
+			certOpts.NotAfter.UTC().Format(time.RFC3339))
+	}
+	return lifetime, nil
+}
@@ -0,0 +1,101 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"istio.io/istio/security/pkg/pki/ca"
+	raerror "istio.io/istio/security/pkg/pki/error"
+)
+
+func TestRequestedLifetime(t *testing.T) {
+	now := time.Now()
+	testCases := map[string]struct {
+		certOpts  ca.CertOpts
+		expected  time.Duration
+		expectErr bool
+	}{
+		"TTL": {
+			certOpts: ca.CertOpts{TTL: time.Hour},
+			expected: time.Hour,
+		},
+		"NotAfter": {
+			certOpts: ca.CertOpts{NotAfter: now.Add(90*time.Minute + 500*time.Millisecond)},
+			expected: 90 * time.Minute,
+		},
+		"NotAfter takes precedence over TTL": {
+			certOpts: ca.CertOpts{TTL: time.Hour, NotAfter: now.Add(30 * time.Minute)},
+			expected: 30 * time.Minute,
+		},
+		"NotAfter in the past": {
+			certOpts:  ca.CertOpts{TTL: time.Hour, NotAfter: now.Add(-time.Minute)},
+			expectErr: true,
+		},
+		"NotAfter within a second": {
+			certOpts:  ca.CertOpts{NotAfter: now.Add(500 * time.Millisecond)},
+			expectErr: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			lifetime, err := requestedLifetime(tc.certOpts, now)
+			if tc.expectErr {
+				var raErr *raerror.Error
+				if !errors.As(err, &raErr) || raErr.ErrorType() != "CSR_ERROR" {
+					t.Errorf("expected a CSR_ERROR error for a NotAfter in the past, got: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if lifetime != tc.expected {
+				t.Errorf("got lifetime %v, want %v", lifetime, tc.expected)
+			}
+		})
+	}
+}
+
+func TestPreSignNotAfter(t *testing.T) {
+	raOpts := &IstioRAOptions{DefaultCertTTL: 10 * time.Minute, MaxCertTTL: time.Hour}
+	csrPEM := createFakeCsr(t)
+	notAfter := time.Now().Add(45 * time.Minute)
+	req, err := preSign(raOpts, nil, csrPEM, ca.CertOpts{SubjectIDs: []string{testCsrHostName}, TTL: time.Hour, NotAfter: notAfter})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if req.lifetime > 45*time.Minute || req.lifetime < 44*time.Minute {
+		t.Errorf("got lifetime %v, want it to end at the requested NotAfter", req.lifetime)
+	}
+	req, err = preSign(raOpts, nil, csrPEM, ca.CertOpts{SubjectIDs: []string{testCsrHostName}, NotAfter: time.Now().Add(2 * time.Hour)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if req.lifetime != time.Hour {
+		t.Errorf("got lifetime %v, want it clamped to the max TTL", req.lifetime)
+	}
+	var raErr *raerror.Error
+	if _, err := preSign(raOpts, nil, csrPEM, ca.CertOpts{SubjectIDs: []string{testCsrHostName},
+		NotAfter: time.Now().Add(-time.Minute)}); !errors.As(err, &raErr) || raErr.ErrorType() != "CSR_ERROR" {
+		t.Errorf("expected a CSR_ERROR error for a NotAfter in the past, got: %v", err)
+	}
+
+	if certCacheKey(csrPEM, ca.CertOpts{NotAfter: notAfter}) == certCacheKey(csrPEM, ca.CertOpts{}) {
+		t.Errorf("got the same cache key for requests with and without a NotAfter")
+	}
+}
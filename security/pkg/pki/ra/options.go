@@ -0,0 +1,154 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	clientset "k8s.io/client-go/kubernetes"
+
+	raerror "istio.io/istio/security/pkg/pki/error"
+)
+
+// RAOption sets an option of the RA created by NewKubernetesRAWithOptions.
+type RAOption func(*IstioRAOptions)
+
+// NewKubernetesRAWithOptions : Create a RA that interfaces with K8S CSR CA, with the given options. Options
+// not set keep the zero value of their IstioRAOptions field. WithClientset is required, as well as
+// WithCaSigner or WithCertSignerDomain.
+func NewKubernetesRAWithOptions(opts ...RAOption) (*KubernetesRA, error) {
+	raOpts := &IstioRAOptions{ExternalCAType: ExtCAK8s}
+	for _, opt := range opts {
+		opt(raOpts)
+	}
+	if raOpts.K8sClient == nil {
+		return nil, raerror.NewError(raerror.CAInitFail, fmt.Errorf("a K8s client is required for the Kubernetes RA, set with WithClientset"))
+	}
+	return NewKubernetesRA(raOpts)
+}
+
+// WithClientset sets the K8s API client creating the CSRs.
+func WithClientset(client clientset.Interface) RAOption {
+	return func(o *IstioRAOptions) {
+		o.K8sClient = client
+	}
+}
+
+// WithCaCertFile sets the file of the CA root certs of the signers.
+func WithCaCertFile(caCertFile string) RAOption {
+	return func(o *IstioRAOptions) {
+		o.CaCertFile = caCertFile
+	}
+}
+
+// WithCaSigner sets the K8s signer of the CSRs of requests without a signer.
+func WithCaSigner(signer string) RAOption {
+	return func(o *IstioRAOptions) {
+		o.CaSigner = signer
+	}
+}
+
+// WithCertSignerDomain sets the domain of the signers requested by workloads.
+func WithCertSignerDomain(domain string) RAOption {
+	return func(o *IstioRAOptions) {
+		o.CertSignerDomain = domain
+	}
+}
+
+// WithSignerAllowList sets the signers workloads may request, see AllowedSigners.
+func WithSignerAllowList(signers ...string) RAOption {
+	return func(o *IstioRAOptions) {
+		o.AllowedSigners = signers
+	}
+}
+
+// WithDefaultCertTTL sets the lifetime of certificates requested without a TTL.
+func WithDefaultCertTTL(ttl time.Duration) RAOption {
+	return func(o *IstioRAOptions) {
+		o.DefaultCertTTL = ttl
+	}
+}
+
+// WithMaxCertTTL sets the maximum lifetime of certificates, to which longer requested TTLs are clamped.
+func WithMaxCertTTL(ttl time.Duration) RAOption {
+	return func(o *IstioRAOptions) {
+		o.MaxCertTTL = ttl
+	}
+}
+
+// WithMinCertTTL sets the minimum TTL that can be requested.
+func WithMinCertTTL(ttl time.Duration) RAOption {
+	return func(o *IstioRAOptions) {
+		o.MinCertTTL = ttl
+	}
+}
+
+// WithTrustDomain sets the trust domain of the mesh.
+func WithTrustDomain(trustDomain string) RAOption {
+	return func(o *IstioRAOptions) {
+		o.TrustDomain = trustDomain
+	}
+}
+
+// WithAutoApprove sets whether the RA approves the CSRs it creates.
+func WithAutoApprove(autoApprove bool) RAOption {
+	return func(o *IstioRAOptions) {
+		o.AutoApprove = &autoApprove
+	}
+}
+
+// WithApprovalTimeout bounds the time waiting for CSRs to be approved and issued.
+func WithApprovalTimeout(timeout time.Duration) RAOption {
+	return func(o *IstioRAOptions) {
+		o.ApprovalTimeout = timeout
+	}
+}
+
+// WithPreSignHook sets the hook authorizing requests before they are signed.
+func WithPreSignHook(hook PreSignHook) RAOption {
+	return func(o *IstioRAOptions) {
+		o.PreSignHook = hook
+	}
+}
+
+// WithPostSignValidators adds validators of the issued certificates, run after those already added.
+func WithPostSignValidators(validators ...func(leafCert *x509.Certificate) error) RAOption {
+	return func(o *IstioRAOptions) {
+		o.PostSignValidators = append(o.PostSignValidators, validators...)
+	}
+}
+
+// WithCertCache caches up to size issued certificates, so that identical requests are not signed again.
+func WithCertCache(size int) RAOption {
+	return func(o *IstioRAOptions) {
+		o.CertCacheSize = size
+	}
+}
+
+// WithCSRLabels sets the labels of the CSRs.
+func WithCSRLabels(labels map[string]string) RAOption {
+	return func(o *IstioRAOptions) {
+		o.CSRLabels = labels
+	}
+}
+
+// WithCSRAnnotations sets the annotations of the CSRs.
+func WithCSRAnnotations(annotations map[string]string) RAOption {
+	return func(o *IstioRAOptions) {
+		o.CSRAnnotations = annotations
+	}
+}
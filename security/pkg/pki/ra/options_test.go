@@ -0,0 +1,74 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+
+	raerror "istio.io/istio/security/pkg/pki/error"
+)
+
+func TestNewKubernetesRAWithOptions(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	r, err := NewKubernetesRAWithOptions(
+		WithClientset(client),
+		WithCaSigner("kubernates.io/kube-apiserver-client"),
+		WithCaCertFile("../testdata/example-ca-cert.pem"),
+		WithSignerAllowList("example.com/allowed"),
+		WithDefaultCertTTL(30*time.Minute),
+		WithMaxCertTTL(time.Hour),
+		WithAutoApprove(false),
+		WithApprovalTimeout(time.Second),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create K8s RA with options: %v", err)
+	}
+	if r.raOpts.ExternalCAType != ExtCAK8s || r.raOpts.K8sClient != client {
+		t.Errorf("expected a K8s RA using the client, got options %+v", r.raOpts)
+	}
+	if r.raOpts.CaSigner != "kubernates.io/kube-apiserver-client" || r.raOpts.CaCertFile != "../testdata/example-ca-cert.pem" {
+		t.Errorf("expected the CA signer options to be set, got %+v", r.raOpts)
+	}
+	if !reflect.DeepEqual(r.raOpts.AllowedSigners, []string{"example.com/allowed"}) {
+		t.Errorf("got allowed signers %v", r.raOpts.AllowedSigners)
+	}
+	if r.raOpts.DefaultCertTTL != 30*time.Minute || r.raOpts.MaxCertTTL != time.Hour {
+		t.Errorf("got TTLs %v and %v", r.raOpts.DefaultCertTTL, r.raOpts.MaxCertTTL)
+	}
+	if r.raOpts.AutoApprove == nil || *r.raOpts.AutoApprove || r.raOpts.ApprovalTimeout != time.Second {
+		t.Errorf("expected CSRs not to be auto approved within a second, got %+v", r.raOpts)
+	}
+}
+
+func TestNewKubernetesRAWithOptionsRequired(t *testing.T) {
+	cases := map[string][]RAOption{
+		"no clientset": {WithCaSigner("kubernates.io/kube-apiserver-client")},
+		"no signer":    {WithClientset(fake.NewSimpleClientset())},
+	}
+	for name, opts := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewKubernetesRAWithOptions(opts...)
+			var raErr *raerror.Error
+			if !errors.As(err, &raErr) || raErr.ErrorType() != "CA_INIT_FAIL" {
+				t.Errorf("expected a CA_INIT_FAIL error, got: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"hash"
+	"sync"
+)
+
+// requestHasher hashes signing requests, e.g. for certificate cache keys and CSR names. Hashers are pooled,
+// with their scratch buffer, so that hashing a request does not allocate beyond the returned hash. A hasher
+// is used by a single goroutine between getRequestHasher and putRequestHasher, and nothing it returns
+// aliases its buffers.
+type requestHasher struct {
+	h hash.Hash
+	// buf is the scratch buffer strings are copied to before being hashed.
+	buf []byte
+	num [8]byte
+	sum [sha256.Size]byte
+}
+
+// maxPooledHasherBuffer is the size beyond which scratch buffers are not kept in the pool, so that a few
+// large requests do not pin memory.
+const maxPooledHasherBuffer = 4 * 1024
+
+var requestHasherPool = sync.Pool{
+	New: func() interface{} {
+		return &requestHasher{h: sha256.New(), buf: make([]byte, 0, 256)}
+	},
+}
+
+// getRequestHasher returns a reset hasher from the pool.
+func getRequestHasher() *requestHasher {
+	rh := requestHasherPool.Get().(*requestHasher)
+	rh.h.Reset()
+	return rh
+}
+
+// putRequestHasher returns rh to the pool. rh must not be used afterwards.
+func putRequestHasher(rh *requestHasher) {
+	if cap(rh.buf) > maxPooledHasherBuffer {
+		return
+	}
+	requestHasherPool.Put(rh)
+}
+
+func (rh *requestHasher) writeBytes(b []byte) {
+	rh.h.Write(b)
+}
+
+func (rh *requestHasher) writeString(s string) {
+	rh.buf = append(rh.buf[:0], s...)
+	rh.h.Write(rh.buf)
+}
+
+func (rh *requestHasher) writeUint64(v uint64) {
+	binary.BigEndian.PutUint64(rh.num[:], v)
+	rh.h.Write(rh.num[:])
+}
+
+// sumPrefix returns the first n bytes of the hash, valid until the next use of rh.
+func (rh *requestHasher) sumPrefix(n int) []byte {
+	return rh.h.Sum(rh.sum[:0])[:n]
+}
+
+// pemBuffer holds the scratch buffers PEM blocks are decoded to and encoded in on the sign path. Buffers are
+// pooled, so that the CSRs and certificates of a sign are decoded and re-encoded without allocating beyond
+// what is returned. A buffer is used by a single goroutine between getPEMBuffer and putPEMBuffer, and
+// nothing returned by the functions using it aliases its buffers.
+type pemBuffer struct {
+	// der is the scratch buffer the last decoded block is written to.
+	der []byte
+	// b64 is the scratch buffer blocks are base64 encoded to before being written to out.
+	b64 []byte
+	// out is the buffer blocks are encoded in.
+	out bytes.Buffer
+}
+
+// maxPooledPEMBuffer is the size beyond which PEM buffers are not kept in the pool, so that a few large cert
+// chains do not pin memory.
+const maxPooledPEMBuffer = 64 * 1024
+
+var pemBufferPool = sync.Pool{
+	New: func() interface{} {
+		return &pemBuffer{der: make([]byte, 0, 2048), b64: make([]byte, 0, 2048)}
+	},
+}
+
+// getPEMBuffer returns a reset PEM buffer from the pool.
+func getPEMBuffer() *pemBuffer {
+	pb := pemBufferPool.Get().(*pemBuffer)
+	pb.out.Reset()
+	return pb
+}
+
+// putPEMBuffer returns pb to the pool. pb must not be used afterwards.
+func putPEMBuffer(pb *pemBuffer) {
+	if cap(pb.der) > maxPooledPEMBuffer || cap(pb.b64) > maxPooledPEMBuffer || pb.out.Cap() > maxPooledPEMBuffer {
+		return
+	}
+	pemBufferPool.Put(pb)
+}
+
+// pemBlock is a PEM block decoded by a pemBuffer. typ aliases the decoded data, and der the buffer, so both are
+// only valid until the next use of the buffer.
+type pemBlock struct {
+	typ     []byte
+	der     []byte
+	headers map[string]string
+}
+
+var (
+	pemBeginLine     = []byte("-----BEGIN ")
+	pemNewBeginLine  = []byte("\n-----BEGIN ")
+	pemNewEndLine    = []byte("\n-----END ")
+	pemLineDashes    = []byte("-----")
+	certificateBlock = []byte("CERTIFICATE")
+)
+
+// decode decodes the first PEM block of data as pem.Decode does, and returns it with the rest of data. ok is false
+// if data has no PEM block. Blocks are decoded into the buffer, except those with headers or otherwise unusual
+// encodings, which are left to pem.Decode.
+func (pb *pemBuffer) decode(data []byte) (block pemBlock, rest []byte, ok bool) {
+	var afterBegin []byte
+	if bytes.HasPrefix(data, pemBeginLine) {
+		afterBegin = data[len(pemBeginLine):]
+	} else if i := bytes.Index(data, pemNewBeginLine); i >= 0 {
+		afterBegin = data[i+len(pemNewBeginLine):]
+	} else {
+		return pemBlock{}, data, false
+	}
+	typeLineEnd := bytes.IndexByte(afterBegin, '\n')
+	if typeLineEnd < 0 {
+		return pb.decodeSlow(data)
+	}
+	typeLine := bytes.TrimRight(afterBegin[:typeLineEnd], " \t\r")
+	if !bytes.HasSuffix(typeLine, pemLineDashes) {
+		return pb.decodeSlow(data)
+	}
+	typ := typeLine[:len(typeLine)-len(pemLineDashes)]
+	body := afterBegin[typeLineEnd:]
+	bodyEnd := bytes.Index(body, pemNewEndLine)
+	if bodyEnd < 0 || bytes.ContainsAny(body[:bodyEnd], ": \t") {
+		return pb.decodeSlow(data)
+	}
+	trailer := body[bodyEnd+len(pemNewEndLine):]
+	if !bytes.HasPrefix(trailer, typ) || !bytes.HasPrefix(trailer[len(typ):], pemLineDashes) {
+		return pb.decodeSlow(data)
+	}
+	rest = trailer[len(typ)+len(pemLineDashes):]
+	// The trailer must end its line, as pem.Decode requires.
+	lineEnd := bytes.IndexByte(rest, '\n')
+	if lineEnd < 0 {
+		lineEnd = len(rest)
+	}
+	if len(bytes.TrimRight(rest[:lineEnd], " \t\r")) != 0 {
+		return pb.decodeSlow(data)
+	}
+	if lineEnd < len(rest) {
+		lineEnd++
+	}
+	rest = rest[lineEnd:]
+	encoded := body[:bodyEnd]
+	if n := base64.StdEncoding.DecodedLen(len(encoded)); cap(pb.der) < n {
+		pb.der = make([]byte, n)
+	}
+	// The decoder skips the line breaks, but not the other whitespace checked above.
+	n, err := base64.StdEncoding.Decode(pb.der[:cap(pb.der)], encoded)
+	if err != nil {
+		return pb.decodeSlow(data)
+	}
+	pb.der = pb.der[:n]
+	return pemBlock{typ: typ, der: pb.der}, rest, true
+}
+
+// decodeSlow decodes the first PEM block of data with pem.Decode.
+func (pb *pemBuffer) decodeSlow(data []byte) (pemBlock, []byte, bool) {
+	block, rest := pem.Decode(data)
+	if block == nil {
+		return pemBlock{}, data, false
+	}
+	return pemBlock{typ: []byte(block.Type), der: block.Bytes, headers: block.Headers}, rest, true
+}
+
+// encode appends block to the output of the buffer, encoded as by pem.Encode.
+func (pb *pemBuffer) encode(block pemBlock) {
+	if len(block.headers) > 0 {
+		_ = pem.Encode(&pb.out, &pem.Block{Type: string(block.typ), Headers: block.headers, Bytes: block.der})
+		return
+	}
+	pb.out.Write(pemBeginLine)
+	pb.out.Write(block.typ)
+	pb.out.Write(pemLineDashes)
+	pb.out.WriteByte('\n')
+	if n := base64.StdEncoding.EncodedLen(len(block.der)); cap(pb.b64) < n {
+		pb.b64 = make([]byte, n)
+	}
+	encoded := pb.b64[:base64.StdEncoding.EncodedLen(len(block.der))]
+	base64.StdEncoding.Encode(encoded, block.der)
+	for len(encoded) > 0 {
+		line := encoded
+		if len(line) > 64 {
+			line = line[:64]
+		}
+		pb.out.Write(line)
+		pb.out.WriteByte('\n')
+		encoded = encoded[len(line):]
+	}
+	pb.out.Write(pemNewEndLine[1:])
+	pb.out.Write(block.typ)
+	pb.out.Write(pemLineDashes)
+	pb.out.WriteByte('\n')
+}
+
+// parseCSRPEM parses the PEM encoded CSR csrPEM as util.ParsePemEncodedCSR does, decoding it with a pooled
+// buffer and copying out only the DER bytes retained by the returned CSR.
+func parseCSRPEM(csrPEM []byte) (*x509.CertificateRequest, error) {
+	pb := getPEMBuffer()
+	defer putPEMBuffer(pb)
+	block, _, ok := pb.decode(csrPEM)
+	if !ok {
+		return nil, fmt.Errorf("certificate signing request is not properly encoded")
+	}
+	csr, err := x509.ParseCertificateRequest(append([]byte(nil), block.der...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse X.509 certificate signing request")
+	}
+	return csr, nil
+}
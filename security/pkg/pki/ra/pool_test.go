@@ -0,0 +1,223 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"istio.io/istio/security/pkg/pki/ca"
+	pkiutil "istio.io/istio/security/pkg/pki/util"
+)
+
+// csrNameHash returns name without its random suffix.
+func csrNameHash(name string) string {
+	return name[:len(name)-csrNameSuffixLength]
+}
+
+func TestRequestHasherConcurrent(t *testing.T) {
+	csrPEM := createFakeCsr(t)
+	var opts []ca.CertOpts
+	wantKeys := map[int]string{}
+	wantNames := map[int]string{}
+	for i := 0; i < 20; i++ {
+		// Some of the requests have values larger than the pooled buffers.
+		opts = append(opts, ca.CertOpts{
+			SubjectIDs: []string{fmt.Sprintf("spiffe://cluster.local/ns/default/sa/sa-%d", i), strings.Repeat("x", i*300)},
+			TTL:        time.Duration(i) * time.Minute,
+		})
+		wantKeys[i] = certCacheKey(csrPEM, opts[i])
+		wantNames[i] = csrNameHash(DefaultCSRName(csrPEM, opts[i]))
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, len(opts)*10)
+	for n := 0; n < 10; n++ {
+		for i := range opts {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if got := certCacheKey(csrPEM, opts[i]); got != wantKeys[i] {
+					errs <- fmt.Errorf("got cache key %s for request %d, want %s", got, i, wantKeys[i])
+				}
+				if got := csrNameHash(DefaultCSRName(csrPEM, opts[i])); got != wantNames[i] {
+					errs <- fmt.Errorf("got CSR name hash %s for request %d, want %s", got, i, wantNames[i])
+				}
+			}(i)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestPEMBufferDecode(t *testing.T) {
+	certPEM := readTestData(t, "example-ca-cert.pem")
+	crlfPEM := []byte(strings.ReplaceAll(string(certPEM), "\n", "\r\n"))
+	for name, data := range map[string][]byte{
+		"certificate":            certPEM,
+		"CSR":                    createFakeCsr(t),
+		"CRLF line endings":      crlfPEM,
+		"text around the blocks": append(append([]byte("subject=foo\n"), certPEM...), []byte("trailing text\n")...),
+		"two blocks":             append(append([]byte{}, certPEM...), certPEM...),
+		"no trailing line break": []byte(strings.TrimSuffix(string(certPEM), "\n")),
+		"headers":                []byte("-----BEGIN FOO-----\nProc-Type: 4,ENCRYPTED\n\nAAAA\n-----END FOO-----\n"),
+		"spaces in the body":     []byte("-----BEGIN FOO-----\nAA AA\n-----END FOO-----\n"),
+		"mismatched trailer":     append([]byte("-----BEGIN FOO-----\nAAAA\n-----END BAR-----\n"), certPEM...),
+		"text after the trailer": append([]byte("-----BEGIN FOO-----\nAAAA\n-----END FOO----- x\n"), certPEM...),
+		"empty body":             []byte("-----BEGIN FOO-----\n-----END FOO-----\n"),
+		"invalid base64":         []byte("-----BEGIN FOO-----\nA!AA\n-----END FOO-----\n"),
+		"truncated":              certPEM[:len(certPEM)/2],
+		"no PEM block":           []byte("not PEM"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			pb := getPEMBuffer()
+			defer putPEMBuffer(pb)
+			rest, wantRest := data, data
+			for {
+				var want *pem.Block
+				want, wantRest = pem.Decode(wantRest)
+				block, gotRest, ok := pb.decode(rest)
+				if ok != (want != nil) {
+					t.Fatalf("got a block %v, want %v", ok, want != nil)
+				}
+				if !ok {
+					return
+				}
+				if string(block.typ) != want.Type || !bytes.Equal(block.der, want.Bytes) || len(block.headers) != len(want.Headers) {
+					t.Fatalf("got block %q of %d bytes, want %q of %d bytes", block.typ, len(block.der), want.Type, len(want.Bytes))
+				}
+				if !bytes.Equal(gotRest, wantRest) {
+					t.Fatalf("got rest %q, want %q", gotRest, wantRest)
+				}
+				pb.out.Reset()
+				pb.encode(block)
+				if encoded := pem.EncodeToMemory(want); !bytes.Equal(pb.out.Bytes(), encoded) {
+					t.Fatalf("got the block encoded as %q, want %q", pb.out.Bytes(), encoded)
+				}
+				rest = gotRest
+			}
+		})
+	}
+}
+
+func TestPEMBufferConcurrent(t *testing.T) {
+	var chains [][]byte
+	var want [][]byte
+	for i := 0; i < 10; i++ {
+		// Some of the chains are larger than the pooled buffers.
+		chain := bytes.Repeat(append(readTestData(t, "example-ca-cert.pem"), '\n'), 1+i*10)
+		chains = append(chains, []byte(strings.ReplaceAll(string(chain), "\n", "\r\n")))
+		want = append(want, normalizePEM(PEMNormalizeBlankLine, chains[i]))
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, len(chains)*10)
+	for n := 0; n < 10; n++ {
+		for i := range chains {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if got := normalizePEM(PEMNormalizeBlankLine, chains[i]); !bytes.Equal(got, want[i]) {
+					errs <- fmt.Errorf("got chain %d normalized differently", i)
+				}
+				if got := certChainDepth(chains[i]); got != 1+i*10 {
+					errs <- fmt.Errorf("got a depth of %d for chain %d, want %d", got, i, 1+i*10)
+				}
+			}(i)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	// The normalized chains do not alias the pooled buffers.
+	for i := range chains {
+		if normalized := normalizePEM(PEMNormalizeBlankLine, chains[i]); !bytes.Equal(normalized, want[i]) {
+			t.Errorf("got chain %d normalized differently after reusing the pooled buffers", i)
+		}
+	}
+}
+
+func BenchmarkRequestHash(b *testing.B) {
+	csrPEM := createFakeCsr(&testing.T{})
+	certOpts := ca.CertOpts{
+		SubjectIDs: []string{testCsrHostName},
+		TTL:        time.Hour,
+		DNSNames:   []string{"foo.example.com"},
+	}
+	b.Run("cache key", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			certCacheKey(csrPEM, certOpts)
+		}
+	})
+	b.Run("CSR name", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			DefaultCSRName(csrPEM, certOpts)
+		}
+	})
+}
+
+// BenchmarkSign measures the RA side of signing a CSR with a K8s signer: validating the request, then checking the
+// issued certificate and encoding it with its chain, without the round trips to the K8s API.
+func BenchmarkSign(b *testing.B) {
+	t := &testing.T{}
+	testCA := newTestCA(t, true)
+	csrPEM := createFakeCsr(t)
+	csr, err := pkiutil.ParsePemEncodedCSR(csrPEM)
+	if err != nil {
+		b.Fatal(err)
+	}
+	certDER, err := pkiutil.GenCertFromCSR(csr, testCA.intermediateCert, csr.PublicKey, testCA.intermediateKey,
+		[]string{testCsrHostName}, time.Hour, false)
+	if err != nil {
+		b.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	chainPEM := append(append([]byte{}, testCA.intermediatePEM...), testCA.rootPEM...)
+	signer := "kubernates.io/kube-apiserver-client"
+	raOpts := &IstioRAOptions{
+		ExternalCAType: ExtCAK8s,
+		DefaultCertTTL: 30 * time.Minute,
+		MaxCertTTL:     time.Hour,
+		CaSigner:       signer,
+	}
+	certOpts := withRequestID(ca.CertOpts{SubjectIDs: []string{testCsrHostName}, TTL: time.Hour})
+	certChain := func(*x509.Certificate) ([]byte, error) {
+		return chainPEM, nil
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req, err := preSign(raOpts, nil, csrPEM, certOpts)
+		if err != nil {
+			b.Fatal(err)
+		}
+		result, err := newSignResult(raOpts, req, certPEM, certChain, signer, signer, new(sync.Map))
+		if err != nil {
+			b.Fatal(err)
+		}
+		checkCertChainDepth(raOpts, signer, req.requestID, result)
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"fmt"
+	"time"
+
+	cert "k8s.io/api/certificates/v1"
+
+	raerror "istio.io/istio/security/pkg/pki/error"
+)
+
+// CertProfile constrains the certificates requested with CertOpts.Profile, e.g. to client-only usages
+// for egress gateways.
+type CertProfile struct {
+	// KeyUsages are the key usages of the certificates. Requests may restrict them further with
+	// CertOpts.KeyUsages. Defaults to the usages of requests without a profile when empty
+	KeyUsages []cert.KeyUsage
+	// MaxCertTTL is the maximum lifetime of the certificates, in addition to the MaxCertTTL of the RA
+	MaxCertTTL time.Duration
+	// AllowedSigners are the full names of the signers that may issue the certificates. A trailing * matches
+	// any suffix. All signers allowed by the RA are allowed when empty
+	AllowedSigners []string
+}
+
+// resolveProfile returns the CertProfiles entry named profileName, or nil if profileName is empty.
+func resolveProfile(raOpts *IstioRAOptions, profileName string) (*CertProfile, error) {
+	if profileName == "" {
+		return nil, nil
+	}
+	profile, ok := raOpts.CertProfiles[profileName]
+	if !ok {
+		return nil, raerror.NewError(raerror.CSRError, fmt.Errorf("unknown certificate profile %q", profileName))
+	}
+	return &profile, nil
+}
+
+// profileLifetime returns lifetime capped to the MaxCertTTL of profile, if any.
+func profileLifetime(profile *CertProfile, lifetime time.Duration) time.Duration {
+	if profile == nil || profile.MaxCertTTL <= 0 {
+		return lifetime
+	}
+	// A zero lifetime would leave the lifetime to the signer.
+	if lifetime <= 0 || lifetime > profile.MaxCertTTL {
+		return profile.MaxCertTTL
+	}
+	return lifetime
+}
+
+// checkProfileSigner checks that signerName may issue the certificates of profile, if any.
+func checkProfileSigner(profile *CertProfile, profileName, signerName string) error {
+	if profile == nil || len(profile.AllowedSigners) == 0 || signerAllowed(profile.AllowedSigners, signerName) {
+		return nil
+	}
+	return raerror.NewError(raerror.CSRError,
+		fmt.Errorf("signer %s is not allowed for certificate profile %q", signerName, profileName))
+}
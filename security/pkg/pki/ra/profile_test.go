@@ -0,0 +1,129 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	cert "k8s.io/api/certificates/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"istio.io/istio/security/pkg/pki/ca"
+	raerror "istio.io/istio/security/pkg/pki/error"
+)
+
+var egressProfile = CertProfile{
+	KeyUsages:      []cert.KeyUsage{cert.UsageDigitalSignature, cert.UsageKeyEncipherment, cert.UsageClientAuth},
+	MaxCertTTL:     10 * time.Minute,
+	AllowedSigners: []string{"example.com/egress-*"},
+}
+
+func TestProfileKeyUsages(t *testing.T) {
+	testCases := map[string]struct {
+		certOpts        ca.CertOpts
+		expected        []cert.KeyUsage
+		expectedErrType string
+	}{
+		"profile usages": {
+			certOpts: ca.CertOpts{Profile: "egress"},
+			expected: egressProfile.KeyUsages,
+		},
+		"usages among the profile usages": {
+			certOpts: ca.CertOpts{Profile: "egress", KeyUsages: []cert.KeyUsage{cert.UsageClientAuth}},
+			expected: []cert.KeyUsage{cert.UsageClientAuth},
+		},
+		"usage not in the profile": {
+			certOpts:        ca.CertOpts{Profile: "egress", KeyUsages: []cert.KeyUsage{cert.UsageServerAuth}},
+			expectedErrType: "CSR_ERROR",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			usages, err := keyUsages(tc.certOpts, &egressProfile)
+			if tc.expectedErrType != "" {
+				var raErr *raerror.Error
+				if !errors.As(err, &raErr) || raErr.ErrorType() != tc.expectedErrType {
+					t.Fatalf("expected a %s error, got: %v", tc.expectedErrType, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(usages, tc.expected) {
+				t.Errorf("got usages %v, want %v", usages, tc.expected)
+			}
+		})
+	}
+}
+
+func TestCertProfiles(t *testing.T) {
+	r, err := createFakeK8sRA(fake.NewSimpleClientset())
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	r.raOpts.CertSignerDomain = "example.com"
+	r.raOpts.CertProfiles = map[string]CertProfile{"egress": egressProfile}
+	csrPEM := createFakeCsr(t)
+
+	testCases := map[string]struct {
+		certOpts         ca.CertOpts
+		expectedLifetime time.Duration
+		expectedErrType  string
+	}{
+		"no profile": {
+			certOpts:         ca.CertOpts{TTL: time.Hour},
+			expectedLifetime: time.Hour,
+		},
+		"lifetime capped by the profile": {
+			certOpts:         ca.CertOpts{TTL: time.Hour, Profile: "egress", CertSigner: "egress-gateway"},
+			expectedLifetime: 10 * time.Minute,
+		},
+		"lifetime within the profile": {
+			certOpts:         ca.CertOpts{TTL: 5 * time.Minute, Profile: "egress", CertSigner: "egress-gateway"},
+			expectedLifetime: 5 * time.Minute,
+		},
+		"unknown profile": {
+			certOpts:        ca.CertOpts{TTL: time.Hour, Profile: "ingress"},
+			expectedErrType: "CSR_ERROR",
+		},
+		"signer not allowed for the profile": {
+			certOpts:        ca.CertOpts{TTL: time.Hour, Profile: "egress"},
+			expectedErrType: "CSR_ERROR",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			tc.certOpts.SubjectIDs = []string{testCsrHostName}
+			req, err := r.validate(nil, csrPEM, tc.certOpts)
+			if tc.expectedErrType != "" {
+				var raErr *raerror.Error
+				if !errors.As(err, &raErr) || raErr.ErrorType() != tc.expectedErrType {
+					t.Fatalf("expected a %s error, got: %v", tc.expectedErrType, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if req.lifetime != tc.expectedLifetime {
+				t.Errorf("got lifetime %v, want %v", req.lifetime, tc.expectedLifetime)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"fmt"
+	"sync"
+
+	lru "github.com/hashicorp/golang-lru"
+	"golang.org/x/time/rate"
+
+	raerror "istio.io/istio/security/pkg/pki/error"
+)
+
+// identityRateLimiter limits the rate of signing requests of each requesting identity, so that a workload
+// stuck in a rotation loop cannot starve the others. Only the most recently seen identities are tracked. It is
+// safe for concurrent use.
+type identityRateLimiter struct {
+	limit rate.Limit
+	burst int
+	mutex sync.Mutex
+	// limiters are the token buckets of the recently seen identities.
+	limiters *lru.Cache
+}
+
+// newIdentityRateLimiter returns the rate limiter of raOpts, or nil if requests are not rate limited.
+func newIdentityRateLimiter(raOpts *IstioRAOptions) (*identityRateLimiter, error) {
+	if raOpts.IdentityRateLimit <= 0 {
+		return nil, nil
+	}
+	burst := raOpts.IdentityRateBurst
+	if burst <= 0 {
+		burst = DefaultIdentityRateBurst
+	}
+	size := raOpts.IdentityRateLimiterSize
+	if size <= 0 {
+		size = DefaultIdentityRateLimiterSize
+	}
+	limiters, err := lru.New(size)
+	if err != nil {
+		return nil, err
+	}
+	return &identityRateLimiter{
+		limit:    rate.Limit(raOpts.IdentityRateLimit),
+		burst:    burst,
+		limiters: limiters,
+	}, nil
+}
+
+// allow returns whether a signing request of identity is within its rate limit, consuming a token if so.
+func (l *identityRateLimiter) allow(identity string) bool {
+	l.mutex.Lock()
+	limiter, ok := l.limiters.Get(identity)
+	if !ok {
+		limiter = rate.NewLimiter(l.limit, l.burst)
+		l.limiters.Add(identity, limiter)
+	}
+	l.mutex.Unlock()
+	return limiter.(*rate.Limiter).Allow()
+}
+
+// checkRateLimit returns a RateLimited error if the first SubjectID of a request to signer exceeds its rate
+// limit. signerLabel is the signer label value used in metrics.
+func checkRateLimit(l *identityRateLimiter, subjectIDs []string, signer, signerLabel string) error {
+	if l == nil || len(subjectIDs) == 0 {
+		return nil
+	}
+	if l.allow(subjectIDs[0]) {
+		return nil
+	}
+	rateLimitedCounts.With(signerTag.Value(signerLabel)).Increment()
+	return raerror.NewError(raerror.RateLimited, fmt.Errorf("identity %s exceeded its rate of signing requests to signer %s",
+		subjectIDs[0], signer))
+}
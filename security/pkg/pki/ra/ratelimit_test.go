@@ -0,0 +1,103 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+
+	"istio.io/istio/security/pkg/pki/ca"
+	raerror "istio.io/istio/security/pkg/pki/error"
+	pkiutil "istio.io/istio/security/pkg/pki/util"
+)
+
+func TestIdentityRateLimiter(t *testing.T) {
+	limiter, err := newIdentityRateLimiter(&IstioRAOptions{})
+	if err != nil || limiter != nil {
+		t.Fatalf("expected no rate limiter without a rate limit, got %v, %v", limiter, err)
+	}
+
+	limiter, err = newIdentityRateLimiter(&IstioRAOptions{
+		IdentityRateLimit:       0.001,
+		IdentityRateBurst:       2,
+		IdentityRateLimiterSize: 2,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !limiter.allow("a") || !limiter.allow("a") {
+		t.Fatalf("expected the burst of identity a to be allowed")
+	}
+	if limiter.allow("a") {
+		t.Errorf("expected identity a to be rate limited beyond its burst")
+	}
+	if !limiter.allow("b") {
+		t.Errorf("expected identity b not to be affected by identity a")
+	}
+	// a is evicted by b and c, and its budget is forgotten
+	limiter.allow("c")
+	if limiter.limiters.Len() != 2 {
+		t.Errorf("got %d identities tracked, want at most 2", limiter.limiters.Len())
+	}
+	if !limiter.allow("a") {
+		t.Errorf("expected evicted identity a to get a new budget")
+	}
+}
+
+func TestSignRateLimited(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	r, err := NewKubernetesRA(&IstioRAOptions{
+		ExternalCAType:    ExtCAK8s,
+		CaSigner:          "kubernates.io/kube-apiserver-client",
+		ApprovalTimeout:   100 * time.Millisecond,
+		IdentityRateLimit: 0.001,
+		IdentityRateBurst: 1,
+		K8sClient:         client,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	rateLimited := func() float64 {
+		return getMetricValue(t, "ra_cert_sign_rate_limited_count", map[string]string{"signer": r.signerMetricLabel("")})
+	}
+	before := rateLimited()
+	certOpts := ca.CertOpts{SubjectIDs: []string{testCsrHostName}, TTL: time.Hour}
+	var raErr *raerror.Error
+
+	// the CSRs are never issued by the fake client
+	if _, err := r.Sign(createFakeCsr(t), certOpts); !errors.As(err, &raErr) || raErr.ErrorType() != "CSR_PENDING" {
+		t.Fatalf("expected the first request to be signed, got: %v", err)
+	}
+	_, err = r.Sign(createFakeCsr(t), certOpts)
+	if !errors.As(err, &raErr) || raErr.ErrorType() != "RATE_LIMITED" {
+		t.Fatalf("expected a RATE_LIMITED error beyond the burst, got: %v", err)
+	}
+	if got := rateLimited() - before; got != 1 {
+		t.Errorf("got %v rate limited requests recorded, want 1", got)
+	}
+
+	const otherIdentity = "spiffe://cluster.local/ns/other/sa/other"
+	csrPEM, _, err := pkiutil.GenCSR(pkiutil.CertOptions{Host: otherIdentity, ECSigAlg: pkiutil.EcdsaSigAlg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = r.Sign(csrPEM, ca.CertOpts{SubjectIDs: []string{otherIdentity}, TTL: time.Hour})
+	if !errors.As(err, &raErr) || raErr.ErrorType() != "CSR_PENDING" {
+		t.Errorf("expected another identity not to be rate limited, got: %v", err)
+	}
+}
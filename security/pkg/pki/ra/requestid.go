@@ -0,0 +1,59 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/util/rand"
+
+	"istio.io/istio/security/pkg/pki/ca"
+	raerror "istio.io/istio/security/pkg/pki/error"
+	"istio.io/pkg/log"
+)
+
+const (
+	// requestIDAnnotation is the annotation of the K8s CSR objects with the RequestID of their signing request.
+	requestIDAnnotation = "ra.istio.io/request-id"
+	// requestIDLength is the length of the random RequestIDs of requests without one.
+	requestIDLength = 16
+)
+
+// withRequestID returns certOpts with a random RequestID if it has none.
+func withRequestID(certOpts ca.CertOpts) ca.CertOpts {
+	if certOpts.RequestID == "" {
+		certOpts.RequestID = rand.String(requestIDLength)
+	}
+	return certOpts
+}
+
+// requestLog returns the logger of the signing request requestID, which labels its lines with the ID.
+func requestLog(requestID string) *log.Scope {
+	if requestID == "" {
+		return pkiRaLog
+	}
+	return pkiRaLog.WithLabels("requestID", requestID)
+}
+
+// requestError returns err, returned for the signing request requestID, with the request ID.
+func requestError(err error, requestID string) error {
+	if err == nil || requestID == "" {
+		return err
+	}
+	if raErr, ok := err.(*raerror.Error); ok {
+		return raErr.WithRequestID(requestID)
+	}
+	return fmt.Errorf("%w (request ID %s)", err, requestID)
+}
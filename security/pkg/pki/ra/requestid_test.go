@@ -0,0 +1,112 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	cert "k8s.io/api/certificates/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	kt "k8s.io/client-go/testing"
+
+	"istio.io/istio/security/pkg/pki/ca"
+	raerror "istio.io/istio/security/pkg/pki/error"
+)
+
+func TestRequestID(t *testing.T) {
+	testCases := map[string]struct {
+		requestID string
+	}{
+		"request ID":    {requestID: "proxy-1/rotation-42"},
+		"no request ID": {},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			r, err := createFakeK8sRA(client)
+			if err != nil {
+				t.Fatalf("Failed to create Fake K8s RA: %v", err)
+			}
+			r.raOpts.ApprovalTimeout = 100 * time.Millisecond
+			_, err = r.Sign(createFakeCsr(t), ca.CertOpts{
+				SubjectIDs: []string{testCsrHostName},
+				TTL:        time.Minute,
+				RequestID:  tc.requestID,
+			})
+			var raErr *raerror.Error
+			if !errors.As(err, &raErr) || raErr.ErrorType() != "CSR_PENDING" {
+				t.Fatalf("expected the CSR to be left pending, got: %v", err)
+			}
+			requestID := raErr.RequestID()
+			if tc.requestID != "" && requestID != tc.requestID {
+				t.Errorf("got request ID %q in the error, want %q", requestID, tc.requestID)
+			}
+			if tc.requestID == "" && len(requestID) != requestIDLength {
+				t.Errorf("expected a random request ID in the error, got %q", requestID)
+			}
+			if !strings.Contains(err.Error(), requestID) {
+				t.Errorf("expected the error message %q to contain the request ID", err)
+			}
+			var created *cert.CertificateSigningRequest
+			for _, action := range client.Actions() {
+				if action.GetVerb() == "create" {
+					created = action.(kt.CreateAction).GetObject().(*cert.CertificateSigningRequest)
+				}
+			}
+			if created == nil {
+				t.Fatalf("expected a CSR to be created")
+			}
+			if got := created.Annotations[requestIDAnnotation]; got != requestID {
+				t.Errorf("got CSR request ID annotation %q, want %q", got, requestID)
+			}
+		})
+	}
+}
+
+func TestRequestIDAnnotationReserved(t *testing.T) {
+	r, err := createFakeK8sRA(fake.NewSimpleClientset())
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	err = r.Validate(createFakeCsr(t), ca.CertOpts{
+		SubjectIDs:     []string{testCsrHostName},
+		TTL:            time.Minute,
+		CSRAnnotations: map[string]string{requestIDAnnotation: "other"},
+		RequestID:      "test-request",
+	})
+	var raErr *raerror.Error
+	if !errors.As(err, &raErr) || raErr.ErrorType() != "CSR_ERROR" || raErr.RequestID() != "test-request" {
+		t.Errorf("expected the request ID annotation to be rejected for request test-request, got: %v", err)
+	}
+}
+
+func TestRequestError(t *testing.T) {
+	err := fmt.Errorf("test error")
+	if got := requestError(err, ""); got != err {
+		t.Errorf("expected errors without a request ID to be returned as is, got %v", got)
+	}
+	if got := requestError(err, "test-request"); !errors.Is(got, err) || got.Error() != "test error (request ID test-request)" {
+		t.Errorf("got error %v for request test-request", got)
+	}
+	var raErr *raerror.Error
+	if got := requestError(raerror.NewError(raerror.CSRError, err), "test-request"); !errors.As(got, &raErr) ||
+		raErr.ErrorType() != "CSR_ERROR" || raErr.RequestID() != "test-request" {
+		t.Errorf("got error %v for request test-request", got)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// isRetryableError returns whether err is a transient K8s or Vault API error worth retrying: the API
+// server throttling us, being briefly unavailable, or refusing connections.
+func isRetryableError(err error) bool {
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	var code int32
+	var status apierrors.APIStatus
+	var vaultErr *vaultError
+	switch {
+	case errors.As(err, &status):
+		code = status.Status().Code
+	case errors.As(err, &vaultErr):
+		code = int32(vaultErr.statusCode)
+	default:
+		return false
+	}
+	return code == http.StatusTooManyRequests ||
+		(code >= http.StatusInternalServerError && code <= http.StatusServiceUnavailable)
+}
+
+// signMaxAttempts returns the maximum number of attempts to sign a CSR with raOpts.
+func signMaxAttempts(raOpts *IstioRAOptions) int {
+	if raOpts.SignMaxAttempts <= 0 {
+		return DefaultSignMaxAttempts
+	}
+	return raOpts.SignMaxAttempts
+}
+
+// signWithRetry calls sign for the request requestID until it succeeds, fails with a non-retryable error,
+// runs out of attempts or ctx is done, backing off exponentially between attempts.
+func signWithRetry(ctx context.Context, raOpts *IstioRAOptions, requestID string, sign func() ([]byte, error)) ([]byte, error) {
+	maxAttempts := signMaxAttempts(raOpts)
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = DefaultSignRetryInitialInterval
+	if raOpts.SignRetryInitialInterval > 0 {
+		b.InitialInterval = raOpts.SignRetryInitialInterval
+	}
+	b.MaxInterval = DefaultSignRetryMaxInterval
+	if raOpts.SignRetryMaxInterval > 0 {
+		b.MaxInterval = raOpts.SignRetryMaxInterval
+	}
+	// The number of attempts bounds the retries, not the elapsed time.
+	b.MaxElapsedTime = 0
+	b.Reset()
+
+	for attempt := 1; ; attempt++ {
+		certChain, err := sign()
+		if err == nil || attempt >= maxAttempts || !isRetryableError(err) {
+			return certChain, err
+		}
+		wait := b.NextBackOff()
+		requestLog(requestID).Warnf("signing attempt %d/%d failed with a retryable error, retrying in %v: %v",
+			attempt, maxAttempts, wait, err)
+		signRetryCounts.Increment()
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("stopped retrying after error (%v): %w", err, ctx.Err())
+		}
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	kt "k8s.io/client-go/testing"
+
+	"istio.io/istio/security/pkg/pki/ca"
+)
+
+func TestIsRetryableError(t *testing.T) {
+	csrResource := schema.GroupResource{Group: "certificates.k8s.io", Resource: "certificatesigningrequests"}
+	testCases := map[string]struct {
+		err       error
+		retryable bool
+	}{
+		"too many requests": {
+			err:       apierrors.NewTooManyRequests("slow down", 1),
+			retryable: true,
+		},
+		"internal error": {
+			err:       fmt.Errorf("unable to submit CSR: %w", apierrors.NewInternalError(fmt.Errorf("boom"))),
+			retryable: true,
+		},
+		"service unavailable": {
+			err:       apierrors.NewServiceUnavailable("unavailable"),
+			retryable: true,
+		},
+		"connection refused": {
+			err:       fmt.Errorf("dial tcp: %w", syscall.ECONNREFUSED),
+			retryable: true,
+		},
+		"forbidden": {
+			err:       apierrors.NewForbidden(csrResource, "csr", fmt.Errorf("denied")),
+			retryable: false,
+		},
+		"gateway timeout": {
+			err:       apierrors.NewTimeoutError("timeout", 1),
+			retryable: false,
+		},
+		"vault unavailable": {
+			err:       &vaultError{statusCode: 503, errors: []string{"Vault is sealed"}},
+			retryable: true,
+		},
+		"vault bad request": {
+			err:       &vaultError{statusCode: 400, errors: []string{"invalid CSR"}},
+			retryable: false,
+		},
+		"other error": {
+			err:       fmt.Errorf("no certificate returned for the CSR"),
+			retryable: false,
+		},
+	}
+	for name, tc := range testCases {
+		if got := isRetryableError(tc.err); got != tc.retryable {
+			t.Errorf("%s: isRetryableError() = %v, want %v", name, got, tc.retryable)
+		}
+	}
+}
+
+func TestK8sSignRetry(t *testing.T) {
+	csrResource := schema.GroupResource{Group: "certificates.k8s.io", Resource: "certificatesigningrequests"}
+	testCases := map[string]struct {
+		err           error
+		maxAttempts   int
+		expectedCalls int
+	}{
+		"retryable error is retried": {
+			err: apierrors.NewTooManyRequests("slow down", 1),
+			// chiron creates the CSR once per sign attempt
+			expectedCalls: DefaultSignMaxAttempts,
+		},
+		"more attempts": {
+			err:           apierrors.NewTooManyRequests("slow down", 1),
+			maxAttempts:   5,
+			expectedCalls: 5,
+		},
+		"non-retryable error fails fast": {
+			err:           apierrors.NewForbidden(csrResource, "csr", fmt.Errorf("denied")),
+			expectedCalls: 1,
+		},
+		"retries disabled": {
+			err:         apierrors.NewTooManyRequests("slow down", 1),
+			maxAttempts: 1,
+			// chiron creates the CSR up to 3 times on its own
+			expectedCalls: 3,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			calls := 0
+			client.PrependReactor("create", "certificatesigningrequests", func(kt.Action) (bool, runtime.Object, error) {
+				calls++
+				return true, nil, tc.err
+			})
+			r, err := createFakeK8sRA(client)
+			if err != nil {
+				t.Fatalf("Failed to create Fake K8s RA: %v", err)
+			}
+			r.raOpts.SignMaxAttempts = tc.maxAttempts
+			r.raOpts.SignRetryInitialInterval = time.Millisecond
+			r.raOpts.SignRetryMaxInterval = time.Millisecond
+			_, err = r.Sign(createFakeCsr(t), ca.CertOpts{
+				SubjectIDs: []string{testCsrHostName},
+				TTL:        time.Minute,
+			})
+			if err == nil {
+				t.Fatalf("expected signing to fail")
+			}
+			if calls != tc.expectedCalls {
+				t.Errorf("expected %d CSR create calls, got %d", tc.expectedCalls, calls)
+			}
+		})
+	}
+}
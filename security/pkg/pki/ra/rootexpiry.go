@@ -0,0 +1,93 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"fmt"
+	"time"
+
+	raerror "istio.io/istio/security/pkg/pki/error"
+	"istio.io/istio/security/pkg/pki/util"
+)
+
+// RootExpiryMode is what is done when the lifetime requested for a certificate extends beyond the expiry of the
+// CA root certificates, minus the RootExpiryMargin, as the chain of the certificate is invalid once they expire.
+type RootExpiryMode string
+
+const (
+	// RootExpiryIgnore : The lifetime is not checked against the expiry of the CA root certificates
+	RootExpiryIgnore RootExpiryMode = "Ignore"
+	// RootExpiryReject : Requests for such lifetimes are rejected with a TTLError
+	RootExpiryReject RootExpiryMode = "Reject"
+	// RootExpiryClamp : The lifetime is clamped to end at the expiry of the CA root certificates minus the
+	// RootExpiryMargin. Requests are rejected with a TTLError when the clamped lifetime is below the MinCertTTL
+	RootExpiryClamp RootExpiryMode = "Clamp"
+)
+
+// validateRootExpiry checks that the RootExpiryMode of raOpts is known and its RootExpiryMargin not negative.
+func validateRootExpiry(raOpts *IstioRAOptions) error {
+	switch raOpts.RootExpiryMode {
+	case "", RootExpiryIgnore, RootExpiryReject, RootExpiryClamp:
+	default:
+		return raerror.NewError(raerror.CAInitFail, fmt.Errorf("unknown root expiry mode %q", raOpts.RootExpiryMode))
+	}
+	if raOpts.RootExpiryMargin < 0 {
+		return raerror.NewError(raerror.CAInitFail, fmt.Errorf("root expiry margin %v cannot be negative",
+			raOpts.RootExpiryMargin))
+	}
+	return nil
+}
+
+// clampToRootExpiry returns lifetime, the lifetime to request at now for the request requestID, checked against
+// the expiry of the CA root certificates of bundle, as per the RootExpiryMode of raOpts. The expiry of the last
+// of the root certificates to expire is used, as the certificate is issued by either of them during a root
+// rotation. Lifetimes left to the signer are not checked.
+func clampToRootExpiry(raOpts *IstioRAOptions, bundle *util.KeyCertBundle, lifetime time.Duration, now time.Time,
+	requestID string) (time.Duration, error) {
+	if (raOpts.RootExpiryMode != RootExpiryReject && raOpts.RootExpiryMode != RootExpiryClamp) || lifetime <= 0 || bundle == nil {
+		return lifetime, nil
+	}
+	rootCerts, err := util.ParsePemEncodedCertificateChain(bundle.GetRootCertPem())
+	if err != nil || len(rootCerts) == 0 {
+		// Signing fails on the CA bundle itself.
+		return lifetime, nil
+	}
+	last := rootCerts[0]
+	for _, rootCert := range rootCerts[1:] {
+		if rootCert.NotAfter.After(last.NotAfter) {
+			last = rootCert
+		}
+	}
+	maxLifetime := last.NotAfter.Add(-raOpts.RootExpiryMargin).Sub(now).Truncate(time.Second)
+	if lifetime <= maxLifetime {
+		return lifetime, nil
+	}
+	if maxLifetime <= 0 {
+		return lifetime, raerror.NewError(raerror.TTLError, fmt.Errorf("CA root certificate %s expires at %v, within the "+
+			"root expiry margin of %v", last.Subject, last.NotAfter.UTC().Format(time.RFC3339), raOpts.RootExpiryMargin))
+	}
+	if raOpts.RootExpiryMode == RootExpiryReject {
+		return lifetime, raerror.NewError(raerror.TTLError, fmt.Errorf("requested TTL %s extends beyond the expiry of the "+
+			"CA root certificate %s at %v minus the root expiry margin of %v", lifetime, last.Subject,
+			last.NotAfter.UTC().Format(time.RFC3339), raOpts.RootExpiryMargin))
+	}
+	if minCertTTL := raOpts.minCertTTL(); maxLifetime < minCertTTL {
+		return lifetime, raerror.NewError(raerror.TTLError, fmt.Errorf("TTL %s clamped to the expiry of the CA root "+
+			"certificate %s is less than the min allowed TTL %s", maxLifetime, last.Subject, minCertTTL))
+	}
+	requestLog(requestID).Warnf("requested TTL %s extends beyond the expiry of the CA root certificate %s at %v, clamping it to %s",
+		lifetime, last.Subject, last.NotAfter.UTC().Format(time.RFC3339), maxLifetime)
+	return maxLifetime, nil
+}
@@ -0,0 +1,159 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+
+	"istio.io/istio/security/pkg/pki/ca"
+	raerror "istio.io/istio/security/pkg/pki/error"
+	"istio.io/istio/security/pkg/pki/util"
+)
+
+func TestClampToRootExpiry(t *testing.T) {
+	now := time.Now()
+	// The root certs expire in 2 hours and in 4 hours.
+	rootCertPEM := append(genRootCert(t, now.Add(-time.Hour), 3*time.Hour), genRootCert(t, now.Add(-time.Hour), 5*time.Hour)...)
+	bundle := util.NewKeyCertBundleFromPem(nil, nil, nil, rootCertPEM)
+	testCases := map[string]struct {
+		raOpts    IstioRAOptions
+		lifetime  time.Duration
+		expected  time.Duration
+		expectErr bool
+	}{
+		"ignored by default": {
+			lifetime: 10 * time.Hour,
+			expected: 10 * time.Hour,
+		},
+		"ignore": {
+			raOpts:   IstioRAOptions{RootExpiryMode: RootExpiryIgnore},
+			lifetime: 10 * time.Hour,
+			expected: 10 * time.Hour,
+		},
+		"within the last root expiry": {
+			raOpts:   IstioRAOptions{RootExpiryMode: RootExpiryReject},
+			lifetime: 3 * time.Hour,
+			expected: 3 * time.Hour,
+		},
+		"lifetime left to the signer": {
+			raOpts:   IstioRAOptions{RootExpiryMode: RootExpiryReject},
+			lifetime: 0,
+			expected: 0,
+		},
+		"reject beyond the root expiry": {
+			raOpts:    IstioRAOptions{RootExpiryMode: RootExpiryReject},
+			lifetime:  5 * time.Hour,
+			expectErr: true,
+		},
+		"reject beyond the root expiry minus the margin": {
+			raOpts:    IstioRAOptions{RootExpiryMode: RootExpiryReject, RootExpiryMargin: 2 * time.Hour},
+			lifetime:  3 * time.Hour,
+			expectErr: true,
+		},
+		"clamp to the root expiry minus the margin": {
+			raOpts:   IstioRAOptions{RootExpiryMode: RootExpiryClamp, RootExpiryMargin: time.Hour},
+			lifetime: 5 * time.Hour,
+			expected: 3 * time.Hour,
+		},
+		"clamp below the min TTL": {
+			raOpts:    IstioRAOptions{RootExpiryMode: RootExpiryClamp, RootExpiryMargin: 4*time.Hour - 30*time.Second},
+			lifetime:  5 * time.Hour,
+			expectErr: true,
+		},
+		"root expiring within the margin": {
+			raOpts:    IstioRAOptions{RootExpiryMode: RootExpiryClamp, RootExpiryMargin: 5 * time.Hour},
+			lifetime:  time.Hour,
+			expectErr: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			lifetime, err := clampToRootExpiry(&tc.raOpts, bundle, tc.lifetime, now, "")
+			if tc.expectErr {
+				var raErr *raerror.Error
+				if !errors.As(err, &raErr) || raErr.ErrorType() != "TTL_ERROR" {
+					t.Errorf("expected a TTL_ERROR error, got: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			// The root certs are issued to the second, and the clamped lifetime truncated to it.
+			if lifetime > tc.expected || lifetime < tc.expected-2*time.Second {
+				t.Errorf("got lifetime %v, want %v", lifetime, tc.expected)
+			}
+		})
+	}
+}
+
+func TestValidateRootExpiry(t *testing.T) {
+	for _, raOpts := range []IstioRAOptions{
+		{RootExpiryMode: "Sometimes"},
+		{RootExpiryMode: RootExpiryClamp, RootExpiryMargin: -time.Hour},
+	} {
+		var raErr *raerror.Error
+		if err := validateRootExpiry(&raOpts); !errors.As(err, &raErr) || raErr.ErrorType() != "CA_INIT_FAIL" {
+			t.Errorf("expected a CA_INIT_FAIL error for %+v, got: %v", raOpts, err)
+		}
+	}
+	if err := validateRootExpiry(&IstioRAOptions{RootExpiryMode: RootExpiryReject, RootExpiryMargin: time.Hour}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestK8sValidateRootExpiry(t *testing.T) {
+	caCertFile := filepath.Join(t.TempDir(), "root-cert.pem")
+	if err := os.WriteFile(caCertFile, genRootCert(t, time.Now().Add(-time.Hour), 3*time.Hour), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	newRA := func(t *testing.T, mode RootExpiryMode) *KubernetesRA {
+		r, err := NewKubernetesRA(&IstioRAOptions{
+			ExternalCAType:   ExtCAK8s,
+			DefaultCertTTL:   time.Hour,
+			MaxCertTTL:       24 * time.Hour,
+			CaSigner:         "kubernates.io/kube-apiserver-client",
+			CaCertFile:       caCertFile,
+			K8sClient:        fake.NewSimpleClientset(),
+			RootExpiryMode:   mode,
+			RootExpiryMargin: 30 * time.Minute,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create Fake K8s RA: %v", err)
+		}
+		t.Cleanup(r.Close)
+		return r
+	}
+	csrPEM := createFakeCsr(t)
+	certOpts := ca.CertOpts{SubjectIDs: []string{testCsrHostName}, TTL: 4 * time.Hour}
+
+	var raErr *raerror.Error
+	if err := newRA(t, RootExpiryReject).Validate(csrPEM, certOpts); !errors.As(err, &raErr) || raErr.ErrorType() != "TTL_ERROR" {
+		t.Errorf("expected a TTL_ERROR error for a TTL beyond the root expiry, got: %v", err)
+	}
+	req, err := newRA(t, RootExpiryClamp).validate(nil, csrPEM, certOpts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if req.lifetime > 90*time.Minute || req.lifetime < 90*time.Minute-2*time.Second {
+		t.Errorf("got lifetime %v, want it clamped to 1h30m0s", req.lifetime)
+	}
+}
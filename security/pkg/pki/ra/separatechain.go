@@ -0,0 +1,114 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"bytes"
+	"context"
+	"encoding/pem"
+
+	"istio.io/istio/security/pkg/pki/ca"
+)
+
+// SignWithSeparateChain is similar to SignWithCertChain, but returns the leaf cert, its intermediate chain and
+// the CA root certs of the signer separately rather than concatenated.
+func (r *KubernetesRA) SignWithSeparateChain(csrPEM []byte, certOpts ca.CertOpts) (leaf, chain, roots []byte, err error) {
+	return r.SignWithSeparateChainContext(context.Background(), csrPEM, certOpts)
+}
+
+// SignWithSeparateChainContext is similar to SignWithSeparateChain, but gives up waiting for the k8s CA once
+// ctx is done.
+func (r *KubernetesRA) SignWithSeparateChainContext(ctx context.Context, csrPEM []byte,
+	certOpts ca.CertOpts) (leaf, chain, roots []byte, err error) {
+	return signWithSeparateChain(ctx, r.raOpts, csrPEM, certOpts, r.SignWithCertChainResponseContext,
+		func(result *SignResult) []byte {
+			return r.bundleForSigner(result.CertSigner).GetRootCertPem()
+		})
+}
+
+// SignWithSeparateChain is similar to SignWithCertChain, but returns the leaf cert, its intermediate chain and
+// the CA root certs separately rather than concatenated.
+func (r *CertManagerRA) SignWithSeparateChain(csrPEM []byte, certOpts ca.CertOpts) (leaf, chain, roots []byte, err error) {
+	return r.SignWithSeparateChainContext(context.Background(), csrPEM, certOpts)
+}
+
+// SignWithSeparateChainContext is similar to SignWithSeparateChain, but gives up waiting for cert-manager once
+// ctx is done.
+func (r *CertManagerRA) SignWithSeparateChainContext(ctx context.Context, csrPEM []byte,
+	certOpts ca.CertOpts) (leaf, chain, roots []byte, err error) {
+	return signWithSeparateChain(ctx, r.raOpts, csrPEM, certOpts, r.SignWithCertChainResponseContext,
+		func(*SignResult) []byte {
+			return r.GetCAKeyCertBundle().GetRootCertPem()
+		})
+}
+
+// SignWithSeparateChain is similar to SignWithCertChain, but returns the leaf cert, its intermediate chain and
+// the CA root certs separately rather than concatenated.
+func (r *VaultRA) SignWithSeparateChain(csrPEM []byte, certOpts ca.CertOpts) (leaf, chain, roots []byte, err error) {
+	return r.SignWithSeparateChainContext(context.Background(), csrPEM, certOpts)
+}
+
+// SignWithSeparateChainContext is similar to SignWithSeparateChain, but gives up waiting for Vault once ctx is
+// done.
+func (r *VaultRA) SignWithSeparateChainContext(ctx context.Context, csrPEM []byte,
+	certOpts ca.CertOpts) (leaf, chain, roots []byte, err error) {
+	return signWithSeparateChain(ctx, r.raOpts, csrPEM, certOpts, r.SignWithCertChainResponseContext,
+		func(*SignResult) []byte {
+			return r.GetCAKeyCertBundle().GetRootCertPem()
+		})
+}
+
+// signWithSeparateChain signs csrPEM with sign, and splits the cert chain of the issued certificate into the
+// leaf cert and its intermediate chain, with the root certs returned by rootsFor for the certificate removed
+// from the chain.
+func signWithSeparateChain(ctx context.Context, raOpts *IstioRAOptions, csrPEM []byte, certOpts ca.CertOpts,
+	sign signFunc, rootsFor func(*SignResult) []byte) (leaf, chain, roots []byte, err error) {
+	result, err := sign(ctx, csrPEM, certOpts)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	roots = rootsFor(result)
+	leaf, chain = splitCertChain(raOpts.PEMNormalization, result.CertChainPEM, roots)
+	return leaf, chain, roots, nil
+}
+
+// splitCertChain returns the first cert of certChainPEM, and the certs after it that are not in rootCertPEM,
+// normalized as per normalization. The chain is nil if the leaf cert was issued by a root cert directly.
+func splitCertChain(normalization PEMNormalization, certChainPEM, rootCertPEM []byte) (leaf, chain []byte) {
+	var roots [][]byte
+	for block, rest := pem.Decode(rootCertPEM); block != nil; block, rest = pem.Decode(rest) {
+		roots = append(roots, block.Bytes)
+	}
+	isRoot := func(der []byte) bool {
+		for _, root := range roots {
+			if bytes.Equal(der, root) {
+				return true
+			}
+		}
+		return false
+	}
+	for block, rest := pem.Decode(certChainPEM); block != nil; block, rest = pem.Decode(rest) {
+		switch {
+		case leaf == nil:
+			leaf = pem.EncodeToMemory(block)
+		case !isRoot(block.Bytes):
+			chain = append(chain, pem.EncodeToMemory(block)...)
+		}
+	}
+	if chain == nil {
+		return leaf, nil
+	}
+	return leaf, normalizePEM(normalization, chain)
+}
@@ -0,0 +1,131 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"bytes"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"istio.io/istio/security/pkg/pki/ca"
+	pkiutil "istio.io/istio/security/pkg/pki/util"
+)
+
+func TestSplitCertChain(t *testing.T) {
+	issuer := newTestCA(t, false)
+	subIssuer := issuer.withIntermediate(t, "sub-intermediate")
+	leafPEM := genRootCert(t, time.Now(), time.Hour)
+	concat := func(pems ...[]byte) []byte {
+		var out []byte
+		for _, p := range pems {
+			out = append(out, p...)
+		}
+		return out
+	}
+	testCases := map[string]struct {
+		normalization PEMNormalization
+		certChain     []byte
+		roots         []byte
+		expectedChain []byte
+	}{
+		"chain with root": {
+			certChain:     concat(leafPEM, issuer.intermediatePEM, issuer.rootPEM),
+			roots:         issuer.rootPEM,
+			expectedChain: issuer.intermediatePEM,
+		},
+		"chain without root": {
+			certChain:     concat(leafPEM, issuer.intermediatePEM),
+			roots:         issuer.rootPEM,
+			expectedChain: issuer.intermediatePEM,
+		},
+		"issued by root": {
+			certChain: concat(leafPEM, issuer.rootPEM),
+			roots:     issuer.rootPEM,
+		},
+		"no roots": {
+			certChain:     concat(leafPEM, issuer.intermediatePEM, issuer.rootPEM),
+			expectedChain: concat(issuer.intermediatePEM, issuer.rootPEM),
+		},
+		"blank line between intermediates": {
+			normalization: PEMNormalizeBlankLine,
+			certChain:     concat(leafPEM, subIssuer.intermediatePEM, issuer.intermediatePEM, issuer.rootPEM),
+			roots:         issuer.rootPEM,
+			expectedChain: concat(subIssuer.intermediatePEM, []byte("\n"), issuer.intermediatePEM),
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			leaf, chain := splitCertChain(tc.normalization, tc.certChain, tc.roots)
+			if !bytes.Equal(leaf, leafPEM) {
+				t.Errorf("got leaf\n%s\nwant\n%s", leaf, leafPEM)
+			}
+			if !bytes.Equal(chain, tc.expectedChain) {
+				t.Errorf("got chain\n%s\nwant\n%s", chain, tc.expectedChain)
+			}
+		})
+	}
+}
+
+func TestK8sSignWithSeparateChain(t *testing.T) {
+	issuer := newTestCA(t, false)
+	caCertFile := filepath.Join(t.TempDir(), "root-cert.pem")
+	if err := os.WriteFile(caCertFile, issuer.rootPEM, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// the signer returns the certificate issued by the intermediate, followed by the intermediate
+	client := initFakeKubeClient(func(csrPEM []byte) ([]byte, error) {
+		csr, err := pkiutil.ParsePemEncodedCSR(csrPEM)
+		if err != nil {
+			return nil, err
+		}
+		certDER, err := pkiutil.GenCertFromCSR(csr, issuer.intermediateCert, csr.PublicKey, issuer.intermediateKey,
+			[]string{testCsrHostName}, time.Hour, false)
+		if err != nil {
+			return nil, err
+		}
+		return append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), issuer.intermediatePEM...), nil
+	})
+	r, err := NewKubernetesRA(&IstioRAOptions{
+		ExternalCAType: ExtCAK8s,
+		DefaultCertTTL: 30 * time.Minute,
+		MaxCertTTL:     time.Hour,
+		CaSigner:       "kubernates.io/kube-apiserver-client",
+		CaCertFile:     caCertFile,
+		K8sClient:      client,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	leaf, chain, roots, err := r.SignWithSeparateChain(createFakeCsr(t), ca.CertOpts{
+		SubjectIDs: []string{testCsrHostName},
+		TTL:        time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("K8s CA Signing CSR failed: %v", err)
+	}
+	leafCerts, err := pkiutil.ParsePemEncodedCertificateChain(leaf)
+	if err != nil || len(leafCerts) != 1 || leafCerts[0].IsCA {
+		t.Fatalf("got leaf\n%s\nwant the issued certificate only (error: %v)", leaf, err)
+	}
+	if !bytes.Equal(chain, issuer.intermediatePEM) {
+		t.Errorf("got chain\n%s\nwant the intermediate only", chain)
+	}
+	if !bytes.Equal(roots, issuer.rootPEM) {
+		t.Errorf("got roots\n%s\nwant those of the CA cert file", roots)
+	}
+}
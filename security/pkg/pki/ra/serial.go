@@ -0,0 +1,62 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"sync"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// serialTracker remembers the serial numbers most recently issued by each signer, to detect signers reusing
+// them. It is safe for concurrent use.
+type serialTracker struct {
+	windowSize int
+	mutex      sync.Mutex
+	// serials are the recently issued serial numbers, by signer.
+	serials map[string]*lru.Cache
+}
+
+func newSerialTracker(raOpts *IstioRAOptions) *serialTracker {
+	windowSize := raOpts.SerialWindowSize
+	if windowSize <= 0 {
+		windowSize = DefaultSerialWindowSize
+	}
+	return &serialTracker{
+		windowSize: windowSize,
+		serials:    map[string]*lru.Cache{},
+	}
+}
+
+// observe records the serial number of result, issued by signer, and logs and records a collision if signer
+// issued it already within the window. signerLabel is the signer label value used in metrics.
+func (t *serialTracker) observe(signer, signerLabel string, result *SignResult) {
+	if result.SerialNumber == nil {
+		return
+	}
+	t.mutex.Lock()
+	serials, ok := t.serials[signer]
+	if !ok {
+		// lru.New only fails for a non-positive size.
+		serials, _ = lru.New(t.windowSize)
+		t.serials[signer] = serials
+	}
+	t.mutex.Unlock()
+	if found, _ := serials.ContainsOrAdd(result.SerialNumber.String(), struct{}{}); found {
+		pkiRaLog.Errorf("signer %s issued serial number %s more than once, its certificates cannot be revoked "+
+			"reliably", signer, result.SerialNumber)
+		serialCollisionCounts.With(signerTag.Value(signerLabel)).Increment()
+	}
+}
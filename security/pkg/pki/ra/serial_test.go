@@ -0,0 +1,61 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"math/big"
+	"sync"
+	"testing"
+)
+
+func TestSerialTracker(t *testing.T) {
+	const signerLabel = "serial-test"
+	collisions := func() float64 {
+		return getMetricValue(t, "ra_cert_serial_collision_count", map[string]string{"signer": signerLabel})
+	}
+	result := func(serial int64) *SignResult {
+		return &SignResult{SerialNumber: big.NewInt(serial)}
+	}
+	tracker := newSerialTracker(&IstioRAOptions{SerialWindowSize: 2})
+	tracker.observe("signer-a", signerLabel, result(1))
+	tracker.observe("signer-b", signerLabel, result(1))
+	tracker.observe("signer-a", signerLabel, result(2))
+	before := collisions()
+
+	tracker.observe("signer-a", signerLabel, result(1))
+	if got := collisions() - before; got != 1 {
+		t.Fatalf("got %v collisions recorded for a reused serial number, want 1", got)
+	}
+	// serial numbers 1 and 2 are evicted by 3 and 4
+	tracker.observe("signer-a", signerLabel, result(3))
+	tracker.observe("signer-a", signerLabel, result(4))
+	tracker.observe("signer-a", signerLabel, result(2))
+	if got := collisions() - before; got != 1 {
+		t.Errorf("got %v collisions recorded, want serial numbers out of the window to be forgotten", got)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tracker.observe("signer-c", signerLabel, result(42))
+		}()
+	}
+	wg.Wait()
+	if got := collisions() - before - 1; got != 9 {
+		t.Errorf("got %v collisions recorded, want 9 for concurrent reuses of a serial number", got)
+	}
+}
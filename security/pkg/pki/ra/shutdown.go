@@ -0,0 +1,74 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	raerror "istio.io/istio/security/pkg/pki/error"
+)
+
+// ErrShuttingDown is wrapped by the errors of the signing requests received once Shutdown was called.
+var ErrShuttingDown = errors.New("the RA is shutting down")
+
+// beginSign registers a signing request in flight, unless the RA is shutting down. endSign must be called once
+// the request completes.
+func (r *KubernetesRA) beginSign() error {
+	r.shutdownMutex.RLock()
+	defer r.shutdownMutex.RUnlock()
+	if r.shuttingDown {
+		return raerror.NewError(raerror.CANotReady, ErrShuttingDown)
+	}
+	r.inflightSigns.Add(1)
+	recordInflightSigns(1)
+	return nil
+}
+
+// endSign unregisters a signing request registered by beginSign.
+func (r *KubernetesRA) endSign() {
+	recordInflightSigns(-1)
+	r.inflightSigns.Done()
+}
+
+// isShuttingDown returns whether Shutdown was called.
+func (r *KubernetesRA) isShuttingDown() bool {
+	r.shutdownMutex.RLock()
+	defer r.shutdownMutex.RUnlock()
+	return r.shuttingDown
+}
+
+// Shutdown stops the RA accepting signing requests, which then fail with a CANotReady error wrapping
+// ErrShuttingDown, and waits for the requests in flight to complete, including the background cleanup of
+// their CSRs, before closing the RA like Close. Once ctx is done, the RA is closed without waiting further and
+// the error of ctx is returned. Shutdown may be called more than once.
+func (r *KubernetesRA) Shutdown(ctx context.Context) error {
+	r.shutdownMutex.Lock()
+	r.shuttingDown = true
+	r.shutdownMutex.Unlock()
+	defer r.Close()
+	drained := make(chan struct{})
+	go func() {
+		r.inflightSigns.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("shutting down with signing requests in flight: %w", ctx.Err())
+	}
+}
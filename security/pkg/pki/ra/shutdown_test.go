@@ -0,0 +1,127 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"istio.io/istio/security/pkg/pki/ca"
+	raerror "istio.io/istio/security/pkg/pki/error"
+)
+
+// createBlockingK8sRA returns a Kubernetes RA whose K8s CA only issues certificates once release is closed, and
+// a channel receiving a value each time a CSR is waiting to be issued.
+func createBlockingK8sRA(t *testing.T, release chan struct{}) (*KubernetesRA, *fake.Clientset, chan struct{}) {
+	t.Helper()
+	waiting := make(chan struct{}, 10)
+	var once sync.Once
+	client := initFakeKubeClient(func(csrPEM []byte) ([]byte, error) {
+		once.Do(func() { waiting <- struct{}{} })
+		<-release
+		return issueFakeCert(csrPEM)
+	})
+	r, err := createFakeK8sRA(client)
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	return r, client, waiting
+}
+
+func TestShutdownDrainsInflightSigns(t *testing.T) {
+	release := make(chan struct{})
+	r, client, waiting := createBlockingK8sRA(t, release)
+	certOpts := ca.CertOpts{SubjectIDs: []string{testCsrHostName}, TTL: time.Hour}
+	signed := make(chan error, 1)
+	go func() {
+		_, err := r.Sign(createFakeCsr(t), certOpts)
+		signed <- err
+	}()
+	<-waiting
+	if got := getMetricValue(t, "ra_inflight_sign_count", nil); got != 1 {
+		t.Errorf("ra_inflight_sign_count: got %v, want 1", got)
+	}
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- r.Shutdown(context.Background())
+	}()
+	// wait for Shutdown to stop accepting signing requests
+	for !r.isShuttingDown() {
+		time.Sleep(time.Millisecond)
+	}
+	var raErr *raerror.Error
+	if _, err := r.Sign(createFakeCsr(t), certOpts); !errors.Is(err, ErrShuttingDown) ||
+		!errors.As(err, &raErr) || raErr.ErrorType() != "CA_NOT_READY" {
+		t.Errorf("expected a CA_NOT_READY error wrapping ErrShuttingDown for a request received on shutdown, got: %v", err)
+	}
+	if err := r.Check(context.Background()); !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("expected the health check to fail on shutdown, got: %v", err)
+	}
+	select {
+	case err := <-shutdown:
+		t.Fatalf("expected Shutdown to wait for the signing request in flight, returned: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-signed; err != nil {
+		t.Errorf("expected the signing request in flight to complete, got: %v", err)
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("unexpected Shutdown error: %v", err)
+	}
+	csrs, err := client.CertificatesV1().CertificateSigningRequests().List(context.Background(), metav1.ListOptions{})
+	if err != nil || len(csrs.Items) != 0 {
+		t.Errorf("expected the CSR to be cleaned up before Shutdown returned, got %d CSRs: %v", len(csrs.Items), err)
+	}
+	if got := getMetricValue(t, "ra_inflight_sign_count", nil); got != 0 {
+		t.Errorf("ra_inflight_sign_count: got %v, want 0", got)
+	}
+	select {
+	case <-r.stopCh:
+	default:
+		t.Errorf("expected Shutdown to close the RA")
+	}
+	if err := r.Shutdown(context.Background()); err != nil {
+		t.Errorf("unexpected error shutting down again: %v", err)
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	r, _, waiting := createBlockingK8sRA(t, release)
+	go func() {
+		_, _ = r.Sign(createFakeCsr(t), ca.CertOpts{SubjectIDs: []string{testCsrHostName}, TTL: time.Hour})
+	}()
+	<-waiting
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := r.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected Shutdown to give up on the signing request in flight, got: %v", err)
+	}
+	select {
+	case <-r.stopCh:
+	default:
+		t.Errorf("expected Shutdown to close the RA once its context is done")
+	}
+}
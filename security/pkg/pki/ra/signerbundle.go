@@ -0,0 +1,106 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	raerror "istio.io/istio/security/pkg/pki/error"
+	"istio.io/istio/security/pkg/pki/util"
+)
+
+// watchSignerCaCertFile starts watching the SignerCaCertFiles entry caCertFile of signerName as watchCaCertFile
+// does CaCertFile, reloading the CA bundle of signerName only.
+func (r *KubernetesRA) watchSignerCaCertFile(signerName, caCertFile string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(watchDir(caCertFile)); err != nil {
+		_ = watcher.Close()
+		return err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.signerCaCertWatchers = append(r.signerCaCertWatchers, watcher)
+	go r.handleSignerCaCertFileWatch(signerName, caCertFile, watcher)
+	return nil
+}
+
+func (r *KubernetesRA) handleSignerCaCertFileWatch(signerName, caCertFile string, watcher *fsnotify.Watcher) {
+	for {
+		select {
+		case <-r.stopCh:
+			return
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			// Chmod events do not change the content of the file.
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			if err := r.ReloadSignerCABundle(signerName); err != nil {
+				pkiRaLog.Errorf("failed to reload CA cert file %s of signer %s, keeping its previous CA bundle: %v",
+					caCertFile, signerName, err)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			pkiRaLog.Errorf("error watching CA cert file %s of signer %s: %v", caCertFile, signerName, err)
+		}
+	}
+}
+
+// ReloadSignerCABundle swaps in the CA root certs in the SignerCaCertFiles entry of signerName if they have
+// changed, independently of CaCertFile and of the other signers. A file that cannot be read or parsed is rejected
+// with an error, keeping the previous bundle of the signer in place. It is safe to call concurrently with signing
+// and reloads.
+func (r *KubernetesRA) ReloadSignerCABundle(signerName string) error {
+	caCertFile, ok := r.raOpts.SignerCaCertFiles[signerName]
+	if !ok {
+		return raerror.NewError(raerror.CAInitFail, fmt.Errorf("no CA cert file is configured for signer %s", signerName))
+	}
+	r.signerReloadMutex.Lock()
+	defer r.signerReloadMutex.Unlock()
+	signerBundle, err := loadCABundle(caCertFile)
+	if err != nil {
+		return raerror.NewError(raerror.CAInitFail, fmt.Errorf("failed to reload CA cert file %s of signer %s: %v",
+			caCertFile, signerName, err))
+	}
+	now := time.Now()
+	recordRootCertExpiry(signerName, signerBundle.GetRootCertPem(), now)
+	r.mutex.Lock()
+	if bytes.Equal(signerBundle.GetRootCertPem(), r.signerBundles[signerName].GetRootCertPem()) {
+		r.mutex.Unlock()
+		return nil
+	}
+	r.signerBundles[signerName] = signerBundle
+	r.mutex.Unlock()
+	recordSignerCABundleReload(signerName, now)
+	pkiRaLog.Infof("reloaded CA cert file %s of signer %s", caCertFile, signerName)
+	return nil
+}
+
+// GetSignerCAKeyCertBundle returns the current CA bundle of signerName: that of its SignerCaCertFiles entry, or
+// else the one of GetCAKeyCertBundle.
+func (r *KubernetesRA) GetSignerCAKeyCertBundle(signerName string) *util.KeyCertBundle {
+	return r.bundleForSigner(signerName)
+}
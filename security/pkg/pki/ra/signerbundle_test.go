@@ -0,0 +1,107 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+
+	"istio.io/istio/pkg/test/util/retry"
+	raerror "istio.io/istio/security/pkg/pki/error"
+)
+
+func TestWatchSignerCaCertFiles(t *testing.T) {
+	root1 := readTestData(t, "spiffe-root-cert-1.pem")
+	root2 := readTestData(t, "spiffe-root-cert-2.pem")
+	const signerA, signerB = "example.com/signer-a", "example.com/signer-b"
+	caCertFileA := filepath.Join(t.TempDir(), "root-cert.pem")
+	caCertFileB := filepath.Join(t.TempDir(), "root-cert.pem")
+	for _, caCertFile := range []string{caCertFileA, caCertFileB} {
+		if err := os.WriteFile(caCertFile, root1, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := NewKubernetesRA(&IstioRAOptions{
+		ExternalCAType:    ExtCAK8s,
+		DefaultCertTTL:    30 * time.Minute,
+		MaxCertTTL:        time.Hour,
+		CaSigner:          "kubernates.io/kube-apiserver-client",
+		SignerCaCertFiles: map[string]string{signerA: caCertFileA, signerB: caCertFileB},
+		WatchCaCertFile:   true,
+		K8sClient:         fake.NewSimpleClientset(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	defer r.Close()
+	reloadTimestamp := func(signer string) float64 {
+		return getMetricValue(t, "ra_signer_ca_bundle_last_reload_success_timestamp_seconds", map[string]string{signerLabel: signer})
+	}
+	if reloadTimestamp(signerA) == 0 || reloadTimestamp(signerB) == 0 {
+		t.Fatalf("expected the initial load of the signer CA bundles to be recorded")
+	}
+	initialB := reloadTimestamp(signerB)
+	defaultRoot := r.GetCAKeyCertBundle().GetRootCertPem()
+	// the reload timestamps are in seconds
+	time.Sleep(time.Second)
+
+	// A malformed file must not replace the bundle of its signer, nor prevent the other signers from reloading.
+	if err := os.WriteFile(caCertFileB, []byte("not a certificate"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(caCertFileA, root2, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	retry.UntilSuccessOrFail(t, func() error {
+		if got := r.GetSignerCAKeyCertBundle(signerA).GetRootCertPem(); !bytes.Equal(got, root2) {
+			return fmt.Errorf("CA bundle of %s was not reloaded", signerA)
+		}
+		return nil
+	}, retry.Timeout(5*time.Second))
+	if got := r.GetSignerCAKeyCertBundle(signerB).GetRootCertPem(); !bytes.Equal(got, root1) {
+		t.Errorf("malformed CA cert file replaced the CA bundle of %s", signerB)
+	}
+	if got := reloadTimestamp(signerB); got != initialB {
+		t.Errorf("got reload timestamp %v for %s, want it unchanged at %v", got, signerB, initialB)
+	}
+	if reloadTimestamp(signerA) <= initialB {
+		t.Errorf("expected the reload of %s to be recorded", signerA)
+	}
+	if got := r.GetCAKeyCertBundle().GetRootCertPem(); !bytes.Equal(got, defaultRoot) {
+		t.Errorf("reloading a signer CA bundle changed the default CA bundle")
+	}
+
+	if err := os.WriteFile(caCertFileB, root2, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	retry.UntilSuccessOrFail(t, func() error {
+		if got := r.GetSignerCAKeyCertBundle(signerB).GetRootCertPem(); !bytes.Equal(got, root2) {
+			return fmt.Errorf("CA bundle of %s was not reloaded", signerB)
+		}
+		return nil
+	}, retry.Timeout(5*time.Second))
+
+	var raErr *raerror.Error
+	if err := r.ReloadSignerCABundle("example.com/unknown"); !errors.As(err, &raErr) || raErr.ErrorType() != "CA_INIT_FAIL" {
+		t.Errorf("expected a CA_INIT_FAIL error for a signer without CA cert file, got: %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+
+	raerror "istio.io/istio/security/pkg/pki/error"
+	"istio.io/istio/security/pkg/pki/util"
+)
+
+// checkSignerDomain logs the subjects of the CA root certs in rootCertPEM along with the signers of raOpts, so
+// that a CaCertFile not matching them is obvious, and checks on a best effort basis that the CertSignerDomain
+// is consistent with the CA root certs: one of their subject, DNS or URI SAN or name constraint domains must be
+// the CertSignerDomain, one of its parent domains or one of its subdomains. A mismatch is logged, or is a
+// CAInitFail error with StrictSignerDomainCheck. Nothing is checked without CertSignerDomain or CA root certs.
+func checkSignerDomain(raOpts *IstioRAOptions, rootCertPEM []byte) error {
+	if len(rootCertPEM) == 0 {
+		return nil
+	}
+	rootCerts, err := util.ParsePemEncodedCertificateChain(rootCertPEM)
+	if err != nil {
+		return raerror.NewError(raerror.CAInitFail, fmt.Errorf("failed to parse the CA root certs: %v", err))
+	}
+	subjects := make([]string, 0, len(rootCerts))
+	for _, rootCert := range rootCerts {
+		subjects = append(subjects, fmt.Sprintf("%q", rootCert.Subject.String()))
+	}
+	pkiRaLog.Infof("Kubernetes RA signing with CA signer %q and signer domain %q, CA root certs: %s",
+		raOpts.CaSigner, raOpts.CertSignerDomain, strings.Join(subjects, ", "))
+	if raOpts.CertSignerDomain == "" {
+		return nil
+	}
+	for _, rootCert := range rootCerts {
+		for _, name := range caCertNames(rootCert) {
+			if domainsMatch(raOpts.CertSignerDomain, name) {
+				return nil
+			}
+		}
+	}
+	err = fmt.Errorf("signer domain %s matches none of the names of CA root certs %s, the certificates issued by its "+
+		"signers may not chain to them", raOpts.CertSignerDomain, strings.Join(subjects, ", "))
+	if raOpts.StrictSignerDomainCheck {
+		return raerror.NewError(raerror.CAInitFail, err)
+	}
+	pkiRaLog.Warnf("%v", err)
+	return nil
+}
+
+// caCertNames returns the names of cert that a signer domain may be checked against.
+func caCertNames(cert *x509.Certificate) []string {
+	names := []string{cert.Subject.CommonName}
+	names = append(names, cert.Subject.Organization...)
+	names = append(names, cert.Subject.OrganizationalUnit...)
+	names = append(names, cert.DNSNames...)
+	for _, uri := range cert.URIs {
+		names = append(names, uri.Host)
+	}
+	names = append(names, cert.PermittedDNSDomains...)
+	return append(names, cert.PermittedURIDomains...)
+}
+
+// domainsMatch returns whether domain is name, or a parent domain or subdomain of it, ignoring case. The
+// leading dot of the name constraints of subdomains is ignored.
+func domainsMatch(domain, name string) bool {
+	domain = strings.ToLower(domain)
+	name = strings.ToLower(strings.TrimPrefix(name, "."))
+	if name == "" {
+		return false
+	}
+	return domain == name || strings.HasSuffix(domain, "."+name) || strings.HasSuffix(name, "."+domain)
+}
@@ -0,0 +1,124 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+
+	raerror "istio.io/istio/security/pkg/pki/error"
+)
+
+func TestCheckSignerDomain(t *testing.T) {
+	// the root cert has the organization istio.io and the DNS SAN test-ca
+	rootPEM := genRootCert(t, time.Now(), time.Hour)
+	testCases := map[string]struct {
+		domain      string
+		rootPEM     []byte
+		mismatching bool
+	}{
+		"organization": {
+			domain:  "istio.io",
+			rootPEM: rootPEM,
+		},
+		"subdomain of organization": {
+			domain:  "signers.Istio.io",
+			rootPEM: rootPEM,
+		},
+		"DNS SAN": {
+			domain:  "test-ca",
+			rootPEM: rootPEM,
+		},
+		"mismatching": {
+			domain:      "example.com",
+			rootPEM:     rootPEM,
+			mismatching: true,
+		},
+		"suffix but not subdomain": {
+			domain:      "notistio.io",
+			rootPEM:     rootPEM,
+			mismatching: true,
+		},
+		"no signer domain": {
+			rootPEM: rootPEM,
+		},
+		"no CA root certs": {
+			domain: "example.com",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if err := checkSignerDomain(&IstioRAOptions{CertSignerDomain: tc.domain}, tc.rootPEM); err != nil {
+				t.Errorf("expected a mismatch to only be logged without strict check, got: %v", err)
+			}
+			err := checkSignerDomain(&IstioRAOptions{CertSignerDomain: tc.domain, StrictSignerDomainCheck: true}, tc.rootPEM)
+			var raErr *raerror.Error
+			if tc.mismatching {
+				if !errors.As(err, &raErr) || raErr.ErrorType() != "CA_INIT_FAIL" {
+					t.Errorf("expected a CA_INIT_FAIL error with strict check, got: %v", err)
+				}
+			} else if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestNewKubernetesRAStrictSignerDomainCheck(t *testing.T) {
+	caCertFile := filepath.Join(t.TempDir(), "root-cert.pem")
+	if err := os.WriteFile(caCertFile, genRootCert(t, time.Now(), time.Hour), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	raOpts := func(domain string) *IstioRAOptions {
+		return &IstioRAOptions{
+			ExternalCAType:          ExtCAK8s,
+			CertSignerDomain:        domain,
+			CaCertFile:              caCertFile,
+			StrictSignerDomainCheck: true,
+			K8sClient:               fake.NewSimpleClientset(),
+		}
+	}
+	if _, err := NewKubernetesRA(raOpts("istio.io")); err != nil {
+		t.Errorf("unexpected error for a signer domain matching the CA root cert: %v", err)
+	}
+	if _, err := NewKubernetesRA(raOpts("example.com")); err == nil {
+		t.Errorf("expected an error for a signer domain not matching the CA root cert")
+	}
+}
+
+func TestNewKubernetesRAFromSecretStrictSignerDomainCheck(t *testing.T) {
+	rootPEM := genRootCert(t, time.Now(), time.Hour)
+	raOpts := func(domain string) *IstioRAOptions {
+		return &IstioRAOptions{
+			ExternalCAType:          ExtCAK8s,
+			CertSignerDomain:        domain,
+			StrictSignerDomainCheck: true,
+			K8sClient:               caCertSecretClient(t, rootPEM),
+		}
+	}
+	r, err := NewKubernetesRAFromSecret(raOpts("istio.io"), "istio-system", "istio-ca", "root-cert.pem")
+	if err != nil {
+		t.Fatalf("unexpected error for a signer domain matching the CA root cert in the Secret: %v", err)
+	}
+	r.Close()
+	if _, err := NewKubernetesRAFromSecret(raOpts("example.com"), "istio-system", "istio-ca", "root-cert.pem"); err == nil {
+		t.Errorf("expected an error for a signer domain not matching the CA root cert in the Secret")
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"istio.io/istio/security/pkg/k8s/chiron"
+	"istio.io/istio/security/pkg/pki/ca"
+	"istio.io/istio/security/pkg/pki/util"
+)
+
+const (
+	// signerProbeHost is the identity of the throwaway CSRs of the signer probe.
+	signerProbeHost = "istio-ra-signer-probe"
+	// signerProbeAnnotation marks the throwaway CSRs of the signer probe, for the approvers and signers to tell
+	// them apart.
+	signerProbeAnnotation = "ra.istio.io/signer-probe"
+	// signerProbeLifetime is the lifetime requested for the certificates of the signer probe.
+	signerProbeLifetime = 10 * time.Minute
+)
+
+var (
+	// ErrSignerUnserved is returned by Check when the signer probe found that no controller issues, denies or
+	// fails the CSRs of CaSigner.
+	ErrSignerUnserved = errors.New("signer appears unserved")
+
+	// errSignerProbePending is returned by Check until the first signer probe completes.
+	errSignerProbePending = errors.New("the signer probe has not completed yet")
+
+	// signerProbeRetryInterval is how often the signer probe is run again until CaSigner is found served.
+	signerProbeRetryInterval = time.Minute
+)
+
+// runSignerProbe probes that CaSigner is served, and then again every signerProbeRetryInterval until it is or the
+// RA is closed or shutting down, recording the result of each probe for Check. Each probe is registered as a
+// signing request in flight, so that Shutdown waits for the cleanup of its CSR.
+func (r *KubernetesRA) runSignerProbe() {
+	for {
+		if r.beginSign() != nil {
+			return
+		}
+		err := r.probeSigner()
+		r.endSign()
+		r.mutex.Lock()
+		r.signerProbeErr = err
+		r.mutex.Unlock()
+		if err == nil {
+			return
+		}
+		pkiRaLog.Warnf("%v, probing it again in %v", err, signerProbeRetryInterval)
+		select {
+		case <-r.stopCh:
+			return
+		case <-time.After(signerProbeRetryInterval):
+		}
+	}
+}
+
+// probeSigner signs a throwaway CSR with CaSigner, cleaned up right after, and returns an error wrapping
+// ErrSignerUnserved if no certificate is issued for it within the SignerProbeTimeout. A CSR denied by its approver
+// or failed by the signer is answered, which is enough for the signer to be served.
+func (r *KubernetesRA) probeSigner() error {
+	timeout := r.raOpts.SignerProbeTimeout
+	if timeout <= 0 {
+		timeout = DefaultSignerProbeTimeout
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-r.stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	csrPEM, _, err := util.GenCSR(util.CertOptions{Host: signerProbeHost, ECSigAlg: util.EcdsaSigAlg})
+	if err != nil {
+		return fmt.Errorf("failed to generate the CSR of the signer probe: %v", err)
+	}
+	client, err := r.clientForSigner(r.raOpts.CaSigner)
+	if err != nil {
+		return err
+	}
+	usages, err := keyUsages(ca.CertOpts{}, nil)
+	if err != nil {
+		return err
+	}
+	certOpts := withRequestID(ca.CertOpts{CSRAnnotations: map[string]string{signerProbeAnnotation: "true"}})
+	signOpts := r.chironSignOptions(csrPEM, certOpts, r.raOpts.CaSigner)
+	signOpts.ApprovalTimeout = timeout
+	signOpts.SkipCleanUp = false
+	_, _, err = chiron.SignCSRK8sWithContext(ctx, client, csrPEM, r.raOpts.CaSigner, nil, usages, "", "",
+		r.shouldApprove(r.raOpts.CaSigner, certOpts.RequestID), false, signerProbeLifetime, signOpts)
+	switch {
+	case err == nil:
+		pkiRaLog.Infof("signer probe: signer %s issued the throwaway CSR", r.raOpts.CaSigner)
+		return nil
+	case errors.Is(err, chiron.ErrCSRDenied) || errors.Is(err, chiron.ErrCSRFailed):
+		pkiRaLog.Infof("signer probe: signer %s answered the throwaway CSR: %v", r.raOpts.CaSigner, err)
+		return nil
+	case errors.Is(err, chiron.ErrCSRNotIssued):
+		return fmt.Errorf("%w: signer %s issued no certificate for the throwaway CSR within %v, check that a "+
+			"controller serves it", ErrSignerUnserved, r.raOpts.CaSigner, timeout)
+	}
+	return fmt.Errorf("signer probe of signer %s failed: %v", r.raOpts.CaSigner, err)
+}
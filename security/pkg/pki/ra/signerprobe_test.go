@@ -0,0 +1,108 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"istio.io/istio/pkg/test/util/retry"
+)
+
+func TestSignerProbe(t *testing.T) {
+	newRA := func(t *testing.T, client clientset.Interface, timeout time.Duration) *KubernetesRA {
+		caCertFile := filepath.Join(t.TempDir(), "root-cert.pem")
+		if err := os.WriteFile(caCertFile, genRootCert(t, time.Now(), time.Hour), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		r, err := NewKubernetesRA(&IstioRAOptions{
+			ExternalCAType:     ExtCAK8s,
+			DefaultCertTTL:     30 * time.Minute,
+			MaxCertTTL:         time.Hour,
+			CaSigner:           "kubernates.io/kube-apiserver-client",
+			CaCertFile:         caCertFile,
+			ProbeSigner:        true,
+			SignerProbeTimeout: timeout,
+			K8sClient:          client,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create Fake K8s RA: %v", err)
+		}
+		t.Cleanup(r.Close)
+		return r
+	}
+
+	t.Run("served", func(t *testing.T) {
+		client := initFakeKubeClient(issueFakeCert)
+		r := newRA(t, client, 5*time.Second)
+		retry.UntilSuccessOrFail(t, func() error {
+			return r.Check(context.Background())
+		}, retry.Timeout(10*time.Second))
+		retry.UntilSuccessOrFail(t, func() error {
+			csrs, err := client.CertificatesV1().CertificateSigningRequests().List(context.Background(), metav1.ListOptions{})
+			if err != nil {
+				return err
+			}
+			if len(csrs.Items) != 0 {
+				return fmt.Errorf("got %d CSRs left after the signer probe", len(csrs.Items))
+			}
+			return nil
+		}, retry.Timeout(5*time.Second))
+	})
+
+	t.Run("unserved", func(t *testing.T) {
+		r := newRA(t, fake.NewSimpleClientset(), 100*time.Millisecond)
+		if err := r.Check(context.Background()); err == nil {
+			t.Fatalf("expected Check to fail before the signer probe completes")
+		}
+		retry.UntilSuccessOrFail(t, func() error {
+			if err := r.Check(context.Background()); !errors.Is(err, ErrSignerUnserved) {
+				return fmt.Errorf("got %v, want %v", err, ErrSignerUnserved)
+			}
+			return nil
+		}, retry.Timeout(5*time.Second))
+	})
+}
+
+func TestSignerProbeShutdown(t *testing.T) {
+	client := initFakeKubeClient(issueFakeCert)
+	r, err := NewKubernetesRA(&IstioRAOptions{
+		ExternalCAType: ExtCAK8s,
+		CaSigner:       "kubernates.io/kube-apiserver-client",
+		CaCertFile:     "../testdata/example-ca-cert.pem",
+		ProbeSigner:    true,
+		K8sClient:      client,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	if err := r.Shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected Shutdown error: %v", err)
+	}
+	csrs, err := client.CertificatesV1().CertificateSigningRequests().List(context.Background(), metav1.ListOptions{})
+	if err != nil || len(csrs.Items) != 0 {
+		t.Errorf("expected the CSR of the signer probe to be cleaned up before Shutdown returned, got %d CSRs: %v",
+			len(csrs.Items), err)
+	}
+}
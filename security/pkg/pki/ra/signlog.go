@@ -0,0 +1,86 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"istio.io/istio/security/pkg/pki/ca"
+	"istio.io/pkg/log"
+)
+
+// subjectIDsHashLength is the length of the hex encoded hash of the SubjectIDs logged at default verbosity.
+const subjectIDsHashLength = 16
+
+// signLogger logs the entry and exit of a signing request with consistent fields: signer, subjectIDs, requestID
+// and ttl, and on exit result and error-code. The SubjectIDs are hashed and the CSR is not logged unless the pkira
+// scope logs at debug level, which also logs the error messages, as they may quote the SubjectIDs.
+type signLogger struct {
+	scope *log.Scope
+	debug bool
+	start time.Time
+}
+
+// startSignLog logs the start of signing csrPEM with certOpts by signer, the signer label of the metrics, and
+// returns the signLogger to log its end with.
+func startSignLog(signer string, csrPEM []byte, certOpts ca.CertOpts) *signLogger {
+	scope := requestLog(certOpts.RequestID)
+	l := &signLogger{debug: scope.DebugEnabled(), start: time.Now()}
+	var subjectIDs string
+	if l.debug {
+		subjectIDs = strings.Join(certOpts.SubjectIDs, ",")
+	} else {
+		subjectIDs = hashSubjectIDs(certOpts.SubjectIDs)
+	}
+	l.scope = scope.WithLabels("signer", signer, "subjectIDs", subjectIDs, "ttl", certOpts.TTL.String())
+	l.scope.Info("signing request started")
+	if l.debug {
+		l.scope.Debugf("signing CSR:\n%s", csrPEM)
+	}
+	return l
+}
+
+// end logs the end of the signing request with its outcome err.
+func (l *signLogger) end(err error) {
+	if err == nil {
+		l.scope.WithLabels("result", resultSuccess, "error-code", "", "duration", time.Since(l.start).String()).
+			Info("signing request completed")
+		return
+	}
+	scope := l.scope.WithLabels("result", resultError, "error-code", errorType(err), "duration", time.Since(l.start).String())
+	if l.debug {
+		scope.Infof("signing request failed: %v", err)
+		return
+	}
+	scope.Info("signing request failed")
+}
+
+// hashSubjectIDs returns a short hex encoded SHA-256 of subjectIDs, to correlate the log lines of the requests
+// for the same identities without logging them.
+func hashSubjectIDs(subjectIDs []string) string {
+	h := sha256.New()
+	// Every SubjectID is length-prefixed, so that different lists cannot hash alike.
+	var length [8]byte
+	for _, subjectID := range subjectIDs {
+		binary.BigEndian.PutUint64(length[:], uint64(len(subjectID)))
+		h.Write(length[:])
+		h.Write([]byte(subjectID))
+	}
+	return hex.EncodeToString(h.Sum(nil))[:subjectIDsHashLength]
+}
@@ -0,0 +1,112 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"istio.io/istio/security/pkg/pki/ca"
+	raerror "istio.io/istio/security/pkg/pki/error"
+	"istio.io/pkg/log"
+)
+
+func TestHashSubjectIDs(t *testing.T) {
+	hash := hashSubjectIDs([]string{"spiffe://cluster.local/ns/default/sa/default"})
+	if len(hash) != subjectIDsHashLength {
+		t.Errorf("got hash %q of length %d, want %d", hash, len(hash), subjectIDsHashLength)
+	}
+	if got := hashSubjectIDs([]string{"spiffe://cluster.local/ns/default/sa/default"}); got != hash {
+		t.Errorf("got hash %q, want the same hash %q", got, hash)
+	}
+	if hashSubjectIDs([]string{"ab", "c"}) == hashSubjectIDs([]string{"a", "bc"}) {
+		t.Errorf("different SubjectIDs hash alike")
+	}
+	if strings.Contains(hash, "spiffe") {
+		t.Errorf("hash %q contains the SubjectIDs", hash)
+	}
+}
+
+func TestSignLog(t *testing.T) {
+	const subjectID = "spiffe://cluster.local/ns/default/sa/signlog"
+	csrPEM := []byte("-----BEGIN CERTIFICATE REQUEST-----\nc2lnbmxvZw==\n-----END CERTIFICATE REQUEST-----\n")
+	certOpts := ca.CertOpts{SubjectIDs: []string{subjectID}, TTL: time.Hour, RequestID: "signlog-request"}
+
+	// signLogs returns the log output of a signing request failing with err, with the pkira scope at level.
+	signLogs := func(t *testing.T, level log.Level, err error) string {
+		out := filepath.Join(t.TempDir(), "out.log")
+		opts := log.DefaultOptions()
+		opts.OutputPaths = []string{out}
+		if err := log.Configure(opts); err != nil {
+			t.Fatal(err)
+		}
+		prevLevel := pkiRaLog.GetOutputLevel()
+		pkiRaLog.SetOutputLevel(level)
+		t.Cleanup(func() {
+			pkiRaLog.SetOutputLevel(prevLevel)
+			_ = log.Configure(log.DefaultOptions())
+		})
+		startSignLog("istio.io/signer", csrPEM, certOpts).end(err)
+		_ = log.Sync()
+		logs, readErr := os.ReadFile(out)
+		if readErr != nil {
+			t.Fatal(readErr)
+		}
+		return string(logs)
+	}
+
+	t.Run("success", func(t *testing.T) {
+		logs := signLogs(t, log.InfoLevel, nil)
+		for _, want := range []string{
+			"signing request started", "signing request completed", "signer=istio.io/signer",
+			"subjectIDs=" + hashSubjectIDs(certOpts.SubjectIDs), "requestID=signlog-request", "ttl=1h0m0s",
+			"result=" + resultSuccess, "error-code=",
+		} {
+			if !strings.Contains(logs, want) {
+				t.Errorf("logs %q do not contain %q", logs, want)
+			}
+		}
+		for _, unwanted := range []string{subjectID, "c2lnbmxvZw=="} {
+			if strings.Contains(logs, unwanted) {
+				t.Errorf("logs %q contain %q at info level", logs, unwanted)
+			}
+		}
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		logs := signLogs(t, log.InfoLevel, raerror.NewError(raerror.CSRError, errors.New("bad SAN "+subjectID)))
+		for _, want := range []string{"signing request failed", "result=" + resultError, "error-code=CSR_ERROR"} {
+			if !strings.Contains(logs, want) {
+				t.Errorf("logs %q do not contain %q", logs, want)
+			}
+		}
+		if strings.Contains(logs, subjectID) {
+			t.Errorf("logs %q contain the SubjectIDs at info level", logs)
+		}
+	})
+
+	t.Run("debug", func(t *testing.T) {
+		logs := signLogs(t, log.DebugLevel, raerror.NewError(raerror.CSRError, errors.New("bad SAN")))
+		for _, want := range []string{"subjectIDs=" + subjectID, "c2lnbmxvZw==", "bad SAN"} {
+			if !strings.Contains(logs, want) {
+				t.Errorf("logs %q do not contain %q at debug level", logs, want)
+			}
+		}
+	})
+}
@@ -0,0 +1,83 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"context"
+
+	"istio.io/istio/security/pkg/pki/ca"
+)
+
+// signFunc signs csrPEM with certOpts, like SignWithCertChainResponseContext.
+type signFunc func(ctx context.Context, csrPEM []byte, certOpts ca.CertOpts) (*SignResult, error)
+
+// SignStream signs the requests received on requests one at a time and in order, and returns the channel
+// the response of each request is sent on.
+func (r *KubernetesRA) SignStream(ctx context.Context, requests <-chan SignRequest) <-chan SignResponse {
+	return signStream(ctx, requests, r.SignWithCertChainResponseContext)
+}
+
+// SignStream signs the requests received on requests one at a time and in order, and returns the channel
+// the response of each request is sent on.
+func (r *CertManagerRA) SignStream(ctx context.Context, requests <-chan SignRequest) <-chan SignResponse {
+	return signStream(ctx, requests, r.SignWithCertChainResponseContext)
+}
+
+// SignStream signs the requests received on requests one at a time and in order, and returns the channel
+// the response of each request is sent on.
+func (r *VaultRA) SignStream(ctx context.Context, requests <-chan SignRequest) <-chan SignResponse {
+	return signStream(ctx, requests, r.SignWithCertChainResponseContext)
+}
+
+// signStream signs the requests received on requests with sign, one at a time. The next request is only read
+// once the response of the previous one has been received, so a caller not keeping up with the responses
+// holds off further requests. The responses channel is closed once requests is closed or ctx is done, and
+// the response being signed when ctx is done is dropped.
+func signStream(ctx context.Context, requests <-chan SignRequest, sign signFunc) <-chan SignResponse {
+	responses := make(chan SignResponse)
+	go func() {
+		defer close(responses)
+		for {
+			var req SignRequest
+			select {
+			case next, ok := <-requests:
+				if !ok {
+					return
+				}
+				req = next
+			case <-ctx.Done():
+				return
+			}
+			resp := signRequest(ctx, &req, sign)
+			select {
+			case responses <- resp:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return responses
+}
+
+// signRequest signs req with sign, within the Timeout of req if any.
+func signRequest(ctx context.Context, req *SignRequest, sign signFunc) SignResponse {
+	if req.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, req.Timeout)
+		defer cancel()
+	}
+	result, err := sign(ctx, req.CSRPEM, req.CertOpts)
+	return SignResponse{Result: result, Err: err}
+}
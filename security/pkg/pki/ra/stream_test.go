@@ -0,0 +1,84 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"istio.io/istio/security/pkg/pki/ca"
+	raerror "istio.io/istio/security/pkg/pki/error"
+)
+
+func TestSignStream(t *testing.T) {
+	var ra RegistrationAuthority = createFakeVaultRA(t, &fakeVault{leaseDuration: 3600})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	requests := make(chan SignRequest)
+	responses := ra.SignStream(ctx, requests)
+
+	certOpts := ca.CertOpts{SubjectIDs: []string{testCsrHostName}, TTL: 10 * time.Minute}
+	requests <- SignRequest{CSRPEM: createFakeCsr(t), CertOpts: certOpts}
+	// the next request is not read until the response of the previous one is received
+	select {
+	case requests <- SignRequest{CSRPEM: []byte("invalid"), CertOpts: certOpts}:
+		t.Fatalf("expected the stream to wait for the response to be received")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if resp := <-responses; resp.Err != nil || resp.Result == nil {
+		t.Fatalf("Failed to sign through the stream: %v", resp.Err)
+	}
+
+	requests <- SignRequest{CSRPEM: []byte("invalid"), CertOpts: certOpts}
+	resp := <-responses
+	var raErr *raerror.Error
+	if !errors.As(resp.Err, &raErr) || raErr.ErrorType() != "CSR_ERROR" {
+		t.Fatalf("expected a CSR_ERROR error for the invalid CSR, got: %v", resp.Err)
+	}
+
+	requests <- SignRequest{CSRPEM: createFakeCsr(t), CertOpts: certOpts}
+	if resp := <-responses; resp.Err != nil {
+		t.Fatalf("expected the stream to go on after a failed request, got: %v", resp.Err)
+	}
+
+	cancel()
+	select {
+	case _, ok := <-responses:
+		if ok {
+			t.Fatalf("expected no response after the stream context is done")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the responses to be closed once the stream context is done")
+	}
+}
+
+func TestSignStreamClosed(t *testing.T) {
+	r := createFakeVaultRA(t, &fakeVault{leaseDuration: 3600})
+	requests := make(chan SignRequest, 1)
+	responses := r.SignStream(context.Background(), requests)
+	requests <- SignRequest{CSRPEM: createFakeCsr(t), CertOpts: ca.CertOpts{
+		SubjectIDs: []string{testCsrHostName},
+		TTL:        10 * time.Minute,
+	}}
+	close(requests)
+	if resp := <-responses; resp.Err != nil {
+		t.Fatalf("Failed to sign through the stream: %v", resp.Err)
+	}
+	if _, ok := <-responses; ok {
+		t.Fatalf("expected the responses to be closed once the requests are")
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"fmt"
+
+	raerror "istio.io/istio/security/pkg/pki/error"
+)
+
+// DuplicateSubjectIDMode is what is done when the SubjectIDs of a signing request list an identity more than
+// once. The duplicates are always removed before the SubjectIDs are validated.
+type DuplicateSubjectIDMode string
+
+const (
+	// DuplicateSubjectIDIgnore : Duplicate SubjectIDs are removed silently
+	DuplicateSubjectIDIgnore DuplicateSubjectIDMode = "Ignore"
+	// DuplicateSubjectIDWarn : Duplicate SubjectIDs are removed and logged
+	DuplicateSubjectIDWarn DuplicateSubjectIDMode = "Warn"
+)
+
+// validateDuplicateSubjectIDMode checks that the DuplicateSubjectIDMode of raOpts is known.
+func validateDuplicateSubjectIDMode(raOpts *IstioRAOptions) error {
+	switch raOpts.DuplicateSubjectIDMode {
+	case "", DuplicateSubjectIDIgnore, DuplicateSubjectIDWarn:
+		return nil
+	}
+	return raerror.NewError(raerror.CAInitFail, fmt.Errorf("unknown duplicate subject ID mode %q", raOpts.DuplicateSubjectIDMode))
+}
+
+// dedupeSubjectIDs returns subjectIDs of the request requestID without the identities listed again, in the order
+// they are first listed, logging the duplicates with DuplicateSubjectIDWarn. Identities are compared exactly,
+// identities differing only by case are distinct. subjectIDs is returned as is if it has no duplicates.
+func dedupeSubjectIDs(raOpts *IstioRAOptions, subjectIDs []string, requestID string) []string {
+	if len(subjectIDs) < 2 {
+		return subjectIDs
+	}
+	seen := make(map[string]struct{}, len(subjectIDs))
+	var deduped, duplicates []string
+	for i, subjectID := range subjectIDs {
+		if _, ok := seen[subjectID]; ok {
+			if duplicates == nil {
+				deduped = append([]string{}, subjectIDs[:i]...)
+			}
+			duplicates = append(duplicates, subjectID)
+			continue
+		}
+		seen[subjectID] = struct{}{}
+		if duplicates != nil {
+			deduped = append(deduped, subjectID)
+		}
+	}
+	if duplicates == nil {
+		return subjectIDs
+	}
+	if raOpts.DuplicateSubjectIDMode == DuplicateSubjectIDWarn {
+		requestLog(requestID).Warnf("removed duplicate subject IDs %v from the requested subject IDs %v", duplicates, subjectIDs)
+	}
+	return deduped
+}
@@ -0,0 +1,130 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	kt "k8s.io/client-go/testing"
+
+	"istio.io/istio/security/pkg/pki/ca"
+	pkiutil "istio.io/istio/security/pkg/pki/util"
+)
+
+func TestDedupeSubjectIDs(t *testing.T) {
+	const (
+		a      = "spiffe://cluster.local/ns/default/sa/a"
+		b      = "spiffe://cluster.local/ns/default/sa/b"
+		mixedA = "spiffe://cluster.local/ns/default/sa/A"
+	)
+	testCases := map[string]struct {
+		subjectIDs []string
+		expected   []string
+	}{
+		"no subject IDs": {},
+		"single": {
+			subjectIDs: []string{a},
+			expected:   []string{a},
+		},
+		"no duplicates": {
+			subjectIDs: []string{b, a},
+			expected:   []string{b, a},
+		},
+		"duplicates": {
+			subjectIDs: []string{b, a, b, a, b},
+			expected:   []string{b, a},
+		},
+		"mixed case": {
+			subjectIDs: []string{a, mixedA, a},
+			expected:   []string{a, mixedA},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			for _, mode := range []DuplicateSubjectIDMode{"", DuplicateSubjectIDIgnore, DuplicateSubjectIDWarn} {
+				subjectIDs := append([]string(nil), tc.subjectIDs...)
+				got := dedupeSubjectIDs(&IstioRAOptions{DuplicateSubjectIDMode: mode}, subjectIDs, "test")
+				if !reflect.DeepEqual(got, tc.expected) {
+					t.Errorf("mode %q: got %v, want %v", mode, got, tc.expected)
+				}
+				if !reflect.DeepEqual(subjectIDs, tc.subjectIDs) {
+					t.Errorf("mode %q: the requested subject IDs were modified to %v", mode, subjectIDs)
+				}
+			}
+		})
+	}
+
+	if err := validateRAOptions(&IstioRAOptions{DuplicateSubjectIDMode: "Reject"}); err == nil {
+		t.Errorf("expected an error for an unknown duplicate subject ID mode")
+	}
+}
+
+func TestPreSignDuplicateSubjectIDs(t *testing.T) {
+	raOpts := &IstioRAOptions{SANValidationMode: SANValidationEnforce, DuplicateSubjectIDMode: DuplicateSubjectIDWarn}
+	subjectIDs := []string{testCsrHostName, testCsrHostName}
+	if _, err := preSign(raOpts, nil, createFakeCsr(t), ca.CertOpts{SubjectIDs: subjectIDs, TTL: time.Hour}); err != nil {
+		t.Fatalf("unexpected error for duplicate subject IDs: %v", err)
+	}
+	if len(subjectIDs) != 2 {
+		t.Errorf("the subject IDs of the caller were modified")
+	}
+}
+
+func TestK8sSignDuplicateSubjectIDs(t *testing.T) {
+	client := initFakeKubeClient(issueFakeCert)
+	creates := 0
+	client.PrependReactor("create", "certificatesigningrequests", func(kt.Action) (bool, runtime.Object, error) {
+		creates++
+		return false, nil, nil
+	})
+	r, err := createFakeK8sRA(client)
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	r.raOpts.DuplicateSubjectIDMode = DuplicateSubjectIDWarn
+	if r.certCache, err = newCertCache(&IstioRAOptions{CertCacheSize: 10}); err != nil {
+		t.Fatal(err)
+	}
+	csrPEM := createFakeCsr(t)
+	certPEM, err := r.Sign(csrPEM, ca.CertOpts{SubjectIDs: []string{testCsrHostName, testCsrHostName}, TTL: time.Hour})
+	if err != nil {
+		t.Fatalf("K8s CA Signing CSR failed: %v", err)
+	}
+	deduped := ca.CertOpts{SubjectIDs: []string{testCsrHostName}, TTL: time.Hour}
+	if r.certCache.get(certCacheKey(csrPEM, deduped), time.Now()) == nil {
+		t.Errorf("expected the certificate to be cached under the key of the deduplicated subject IDs")
+	}
+	if _, err := r.Sign(csrPEM, deduped); err != nil {
+		t.Fatalf("K8s CA Signing CSR failed: %v", err)
+	}
+	if creates != 1 {
+		t.Errorf("expected the request without duplicates to get the cached certificate, got %d CSRs", creates)
+	}
+	leafCert, err := pkiutil.ParsePemEncodedCertificate(certPEM)
+	if err != nil {
+		t.Fatalf("failed to parse the certificate: %v", err)
+	}
+	var sans []string
+	sans = append(sans, leafCert.DNSNames...)
+	for _, u := range leafCert.URIs {
+		sans = append(sans, u.String())
+	}
+	if !reflect.DeepEqual(sans, []string{testCsrHostName}) {
+		t.Errorf("got SANs %v, want %v once", sans, testCsrHostName)
+	}
+}
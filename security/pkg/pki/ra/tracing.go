@@ -0,0 +1,73 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+
+	"go.opencensus.io/trace"
+)
+
+const (
+	signSpanName           = "ra.Sign"
+	kubernetesSignSpanName = "ra.KubernetesSign"
+
+	signerAttribute         = "signer"
+	csrSizeAttribute        = "csr_size"
+	csrFingerprintAttribute = "csr_fingerprint"
+	retryCountAttribute     = "retry_count"
+	requestIDAttribute      = "request_id"
+	outcomeAttribute        = "outcome"
+)
+
+// startSignSpan starts the span of signing csrPEM for certSigner with the request ID requestID, as a child of
+// the span in ctx if any. The CSR itself is only recorded by its fingerprint.
+func startSignSpan(ctx context.Context, csrPEM []byte, certSigner, requestID string) (context.Context, *trace.Span) {
+	ctx, span := trace.StartSpan(ctx, signSpanName)
+	span.AddAttributes(
+		trace.StringAttribute(signerAttribute, certSigner),
+		trace.StringAttribute(requestIDAttribute, requestID),
+		trace.Int64Attribute(csrSizeAttribute, int64(len(csrPEM))),
+		trace.StringAttribute(csrFingerprintAttribute, csrFingerprint(csrPEM)),
+	)
+	return ctx, span
+}
+
+// csrFingerprint returns the hex encoded SHA-256 of csrPEM.
+func csrFingerprint(csrPEM []byte) string {
+	sum := sha256.Sum256(csrPEM)
+	return hex.EncodeToString(sum[:])
+}
+
+// endSpan records the outcome err of the operation traced by span, and ends it.
+func endSpan(span *trace.Span, err error) {
+	if err == nil {
+		span.AddAttributes(trace.StringAttribute(outcomeAttribute, resultSuccess))
+		span.End()
+		return
+	}
+	span.AddAttributes(trace.StringAttribute(outcomeAttribute, errorType(err)))
+	code := int32(trace.StatusCodeUnknown)
+	if errors.Is(err, context.Canceled) {
+		code = trace.StatusCodeCancelled
+	} else if errors.Is(err, context.DeadlineExceeded) {
+		code = trace.StatusCodeDeadlineExceeded
+	}
+	span.SetStatus(trace.Status{Code: code, Message: err.Error()})
+	span.End()
+}
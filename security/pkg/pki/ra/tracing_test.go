@@ -0,0 +1,124 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opencensus.io/trace"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"istio.io/istio/security/pkg/pki/ca"
+)
+
+// spanRecorder is a trace exporter that keeps the exported spans.
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []*trace.SpanData
+}
+
+func (s *spanRecorder) ExportSpan(span *trace.SpanData) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.spans = append(s.spans, span)
+}
+
+func (s *spanRecorder) get(name string) *trace.SpanData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, span := range s.spans {
+		if span.Name == name {
+			return span
+		}
+	}
+	return nil
+}
+
+func TestSignTracing(t *testing.T) {
+	recorder := &spanRecorder{}
+	trace.RegisterExporter(recorder)
+	defer trace.UnregisterExporter(recorder)
+
+	// the CSR never gets a certificate issued
+	r, err := createFakeK8sRA(fake.NewSimpleClientset())
+	if err != nil {
+		t.Fatalf("Failed to create Fake K8s RA: %v", err)
+	}
+	r.raOpts.ApprovalTimeout = 100 * time.Millisecond
+	ctx, parent := trace.StartSpan(context.Background(), "caller", trace.WithSampler(trace.AlwaysSample()))
+	csrPEM := createFakeCsr(t)
+	if _, err := r.SignContext(ctx, csrPEM, ca.CertOpts{
+		SubjectIDs: []string{testCsrHostName},
+		TTL:        60 * time.Second,
+	}); err == nil {
+		t.Fatalf("expected signing to fail")
+	}
+	parent.End()
+
+	signSpan := recorder.get(signSpanName)
+	if signSpan == nil {
+		t.Fatalf("no %s span was exported", signSpanName)
+	}
+	if signSpan.ParentSpanID != parent.SpanContext().SpanID {
+		t.Errorf("expected the %s span to be a child of the caller span", signSpanName)
+	}
+	for attr, want := range map[string]interface{}{
+		csrSizeAttribute:        int64(len(csrPEM)),
+		csrFingerprintAttribute: csrFingerprint(csrPEM),
+		outcomeAttribute:        "CSR_PENDING",
+	} {
+		if got := signSpan.Attributes[attr]; got != want {
+			t.Errorf("got %s span attribute %s %v, want %v", signSpanName, attr, got, want)
+		}
+	}
+	for _, value := range signSpan.Attributes {
+		if strings.Contains(fmt.Sprint(value), "CERTIFICATE REQUEST") {
+			t.Errorf("the CSR must not be recorded in span attributes")
+		}
+	}
+
+	kubernetesSignSpan := recorder.get(kubernetesSignSpanName)
+	if kubernetesSignSpan == nil {
+		t.Fatalf("no %s span was exported", kubernetesSignSpanName)
+	}
+	if kubernetesSignSpan.ParentSpanID != signSpan.SpanID {
+		t.Errorf("expected the %s span to be a child of the %s span", kubernetesSignSpanName, signSpanName)
+	}
+	if got := kubernetesSignSpan.Attributes[signerAttribute]; got != r.raOpts.CaSigner {
+		t.Errorf("got %s span signer %v, want %v", kubernetesSignSpanName, got, r.raOpts.CaSigner)
+	}
+	if got := kubernetesSignSpan.Attributes[retryCountAttribute]; got != int64(0) {
+		t.Errorf("got %s span retry count %v, want 0", kubernetesSignSpanName, got)
+	}
+
+	for _, name := range []string{"chiron.SubmitCSR", "chiron.ApproveCSR", "chiron.WaitForCertificate"} {
+		span := recorder.get(name)
+		if span == nil {
+			t.Errorf("no %s span was exported", name)
+			continue
+		}
+		if span.ParentSpanID != kubernetesSignSpan.SpanID {
+			t.Errorf("expected the %s span to be a child of the %s span", name, kubernetesSignSpanName)
+		}
+	}
+	if span := recorder.get("chiron.WaitForCertificate"); span != nil && span.Code == trace.StatusCodeOK {
+		t.Errorf("expected the chiron.WaitForCertificate span to record the failure")
+	}
+}
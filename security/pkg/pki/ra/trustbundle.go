@@ -0,0 +1,163 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"strings"
+
+	"istio.io/istio/security/pkg/pki/util"
+)
+
+const (
+	// trustBundleFormatJWKS is the value of the format query parameter requesting the trust bundle as a JWKS.
+	trustBundleFormatJWKS = "jwks"
+	// contentTypeJWKS is the media type of the trust bundle as a JWKS.
+	contentTypeJWKS = "application/jwk-set+json"
+	// contentTypePEM is the media type of the trust bundle as PEM.
+	contentTypePEM = "application/x-pem-file"
+)
+
+// trustBundleJWKS is the trust bundle as a JWKS, in the format of SPIFFE trust bundles.
+type trustBundleJWKS struct {
+	Keys []trustBundleJWK `json:"keys"`
+}
+
+// trustBundleJWK is a CA root certificate of the trust bundle as a JWK, with its public key only.
+type trustBundleJWK struct {
+	Use string `json:"use"`
+	Kty string `json:"kty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	// X5c is the DER encoded certificate, base64 encoded.
+	X5c []string `json:"x5c"`
+}
+
+// NewTrustBundleHandler returns a read-only http.Handler serving the CA root certs of the bundle returned by
+// getBundle on each request, so that reloads of the bundle are served right away. The root certs are served as
+// PEM, or as a JWKS when the format query parameter is "jwks" or the request accepts application/jwk-set+json.
+// Only the root certs are served, re-encoded from their parsed form, never the key of the bundle. The ETag is
+// derived from the root certs, so that clients revalidating with If-None-Match only download changed bundles.
+func NewTrustBundleHandler(getBundle func() *util.KeyCertBundle) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var rootCertPEM []byte
+		if bundle := getBundle(); bundle != nil {
+			rootCertPEM = bundle.GetRootCertPem()
+		}
+		rootCerts, err := util.ParsePemEncodedCertificateChain(rootCertPEM)
+		if len(rootCertPEM) == 0 || err != nil {
+			http.Error(w, "no CA root certificate is loaded", http.StatusServiceUnavailable)
+			return
+		}
+		jwks := req.URL.Query().Get("format") == trustBundleFormatJWKS || strings.Contains(req.Header.Get("Accept"), contentTypeJWKS)
+		body, contentType, err := encodeTrustBundle(rootCerts, jwks)
+		if err != nil {
+			http.Error(w, "failed to encode the CA root certificates", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("ETag", trustBundleETag(rootCerts, jwks))
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Vary", "Accept")
+		if match := req.Header.Get("If-None-Match"); match != "" && match == w.Header().Get("ETag") {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		if req.Method == http.MethodHead {
+			return
+		}
+		_, _ = w.Write(body)
+	})
+}
+
+// trustBundleETag returns the ETag of rootCerts served as a JWKS or as PEM.
+func trustBundleETag(rootCerts []*x509.Certificate, jwks bool) string {
+	h := sha256.New()
+	for _, rootCert := range rootCerts {
+		h.Write(rootCert.Raw)
+	}
+	if jwks {
+		h.Write([]byte(trustBundleFormatJWKS))
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// encodeTrustBundle returns rootCerts encoded as a JWKS or as PEM, and its media type.
+func encodeTrustBundle(rootCerts []*x509.Certificate, jwks bool) ([]byte, string, error) {
+	if !jwks {
+		var body []byte
+		for _, rootCert := range rootCerts {
+			body = append(body, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: rootCert.Raw})...)
+		}
+		return body, contentTypePEM, nil
+	}
+	doc := trustBundleJWKS{Keys: []trustBundleJWK{}}
+	for _, rootCert := range rootCerts {
+		key := trustBundleJWK{Use: "x509-svid", X5c: []string{base64.StdEncoding.EncodeToString(rootCert.Raw)}}
+		switch pub := rootCert.PublicKey.(type) {
+		case *ecdsa.PublicKey:
+			size := (pub.Curve.Params().BitSize + 7) / 8
+			key.Kty = "EC"
+			key.Crv = pub.Curve.Params().Name
+			key.X = base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, size)))
+			key.Y = base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, size)))
+		case *rsa.PublicKey:
+			key.Kty = "RSA"
+			key.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			key.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		default:
+			// Keys of other types are only served with their certificate.
+			continue
+		}
+		doc.Keys = append(doc.Keys, key)
+	}
+	body, err := json.Marshal(doc)
+	return body, contentTypeJWKS, err
+}
+
+// TrustBundleHandler returns a read-only http.Handler serving the current CA root certs of the RA, as PEM or
+// as a JWKS, for external verifiers. The CA root certs of the SignerCaCertFiles are not served.
+func (r *KubernetesRA) TrustBundleHandler() http.Handler {
+	return NewTrustBundleHandler(r.GetCAKeyCertBundle)
+}
+
+// TrustBundleHandler returns a read-only http.Handler serving the current CA root certs of the RA, as PEM or
+// as a JWKS, for external verifiers.
+func (r *CertManagerRA) TrustBundleHandler() http.Handler {
+	return NewTrustBundleHandler(r.GetCAKeyCertBundle)
+}
+
+// TrustBundleHandler returns a read-only http.Handler serving the current CA root certs of the RA, as PEM or
+// as a JWKS, for external verifiers.
+func (r *VaultRA) TrustBundleHandler() http.Handler {
+	return NewTrustBundleHandler(r.GetCAKeyCertBundle)
+}
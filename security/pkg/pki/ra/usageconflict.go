@@ -0,0 +1,85 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+
+	cert "k8s.io/api/certificates/v1"
+
+	raerror "istio.io/istio/security/pkg/pki/error"
+)
+
+// CSRUsageConflictMode is what is done when the extended key usage extension of a CSR conflicts with the key
+// usages requested by the RA.
+type CSRUsageConflictMode string
+
+const (
+	// CSRUsageConflictIgnore : Conflicts are not detected, and left to the signer
+	CSRUsageConflictIgnore CSRUsageConflictMode = "Ignore"
+	// CSRUsageConflictStrict : CSRs requesting extended key usages other than those of the key usages requested
+	// by the RA are rejected with a CSRError naming them, instead of by the signer
+	CSRUsageConflictStrict CSRUsageConflictMode = "Strict"
+	// CSRUsageConflictBestEffort : The key usages of the RA prevail: the conflicting extended key usages are
+	// logged and the CSR is sent to the signer as is, as it cannot be signed again without the key of the
+	// workload. Certificates issued with a conflicting extended key usage are rejected with a CertGenError
+	CSRUsageConflictBestEffort CSRUsageConflictMode = "BestEffort"
+)
+
+// validateCSRUsageConflictMode checks that the CSRUsageConflictMode of raOpts is known.
+func validateCSRUsageConflictMode(raOpts *IstioRAOptions) error {
+	switch raOpts.CSRUsageConflictMode {
+	case "", CSRUsageConflictIgnore, CSRUsageConflictStrict, CSRUsageConflictBestEffort:
+		return nil
+	}
+	return raerror.NewError(raerror.CAInitFail, fmt.Errorf("unknown CSR usage conflict mode %q", raOpts.CSRUsageConflictMode))
+}
+
+// checksCSRUsageConflicts returns whether the CSRUsageConflictMode of raOpts detects conflicts.
+func checksCSRUsageConflicts(raOpts *IstioRAOptions) bool {
+	return raOpts.CSRUsageConflictMode == CSRUsageConflictStrict || raOpts.CSRUsageConflictMode == CSRUsageConflictBestEffort
+}
+
+// reconcileCSRUsageConflicts checks the extended key usages requested by the extension of csr against
+// requestedUsages, the key usages requested by the RA, as per the CSRUsageConflictMode of raOpts. With
+// CSRUsageConflictBestEffort, it returns the conflicting ones, which the certificate must not have.
+func reconcileCSRUsageConflicts(raOpts *IstioRAOptions, csr *x509.CertificateRequest, requestedUsages []cert.KeyUsage,
+	requestID string) ([]asn1.ObjectIdentifier, error) {
+	if !checksCSRUsageConflicts(raOpts) || containsKeyUsage(requestedUsages, cert.UsageAny) {
+		return nil, nil
+	}
+	oids, err := csrExtKeyUsageOIDs(csr)
+	if err != nil {
+		return nil, err
+	}
+	var conflicting []asn1.ObjectIdentifier
+	for _, oid := range oids {
+		if !containsExtKeyUsageOID(requestedUsages, oid) {
+			conflicting = append(conflicting, oid)
+		}
+	}
+	if len(conflicting) == 0 {
+		return nil, nil
+	}
+	if raOpts.CSRUsageConflictMode == CSRUsageConflictStrict {
+		return nil, fmt.Errorf("extended key usages %v requested by the CSR conflict with the key usages %v requested by the RA",
+			conflicting, requestedUsages)
+	}
+	requestLog(requestID).Warnf("ignoring extended key usages %v requested by the CSR, which conflict with the key usages %v requested by the RA",
+		conflicting, requestedUsages)
+	return conflicting, nil
+}
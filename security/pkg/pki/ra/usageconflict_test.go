@@ -0,0 +1,156 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	cert "k8s.io/api/certificates/v1"
+
+	"istio.io/istio/security/pkg/pki/ca"
+	raerror "istio.io/istio/security/pkg/pki/error"
+	"istio.io/istio/security/pkg/pki/util"
+)
+
+func TestReconcileCSRUsageConflicts(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	serverAuth := extKeyUsageOIDs[x509.ExtKeyUsageServerAuth]
+	codeSigning := extKeyUsageOIDs[x509.ExtKeyUsageCodeSigning]
+	unknown := asn1.ObjectIdentifier{1, 2, 3, 4}
+	testCases := map[string]struct {
+		mode        CSRUsageConflictMode
+		usages      []cert.KeyUsage
+		oids        []asn1.ObjectIdentifier
+		conflicting []asn1.ObjectIdentifier
+		expectErr   bool
+	}{
+		"ignored": {
+			oids: []asn1.ObjectIdentifier{codeSigning, unknown},
+		},
+		"explicitly ignored": {
+			mode: CSRUsageConflictIgnore,
+			oids: []asn1.ObjectIdentifier{codeSigning, unknown},
+		},
+		"no extension": {
+			mode: CSRUsageConflictStrict,
+		},
+		"no conflict": {
+			mode: CSRUsageConflictStrict,
+			oids: []asn1.ObjectIdentifier{serverAuth},
+		},
+		"any usage requested": {
+			mode:   CSRUsageConflictStrict,
+			usages: []cert.KeyUsage{cert.UsageDigitalSignature, cert.UsageAny},
+			oids:   []asn1.ObjectIdentifier{codeSigning, unknown},
+		},
+		"strict": {
+			mode:      CSRUsageConflictStrict,
+			oids:      []asn1.ObjectIdentifier{serverAuth, codeSigning},
+			expectErr: true,
+		},
+		"best effort": {
+			mode:        CSRUsageConflictBestEffort,
+			oids:        []asn1.ObjectIdentifier{serverAuth, codeSigning, unknown},
+			conflicting: []asn1.ObjectIdentifier{codeSigning, unknown},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			csr, err := util.ParsePemEncodedCSR(csrWithExtKeyUsages(t, key, tc.oids...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			usages := tc.usages
+			if usages == nil {
+				usages = defaultKeyUsages
+			}
+			conflicting, err := reconcileCSRUsageConflicts(&IstioRAOptions{CSRUsageConflictMode: tc.mode}, csr, usages, "test")
+			if tc.expectErr {
+				if err == nil || !strings.Contains(err.Error(), codeSigning.String()) {
+					t.Errorf("expected an error naming the conflicting code signing, got: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(conflicting, tc.conflicting) {
+				t.Errorf("got conflicting extended key usages %v, want %v", conflicting, tc.conflicting)
+			}
+		})
+	}
+
+	if err := validateRAOptions(&IstioRAOptions{CSRUsageConflictMode: "Reconcile"}); err == nil {
+		t.Errorf("expected an error for an unknown CSR usage conflict mode")
+	}
+}
+
+func TestPreSignCSRUsageConflicts(t *testing.T) {
+	issuer := newTestCA(t, false)
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	csrPEM := csrWithExtKeyUsages(t, key, extKeyUsageOIDs[x509.ExtKeyUsageServerAuth], extKeyUsageOIDs[x509.ExtKeyUsageCodeSigning])
+	certOpts := ca.CertOpts{SubjectIDs: []string{testCsrHostName}, TTL: time.Hour}
+
+	var raErr *raerror.Error
+	if _, err := preSign(&IstioRAOptions{}, nil, csrPEM, certOpts); err != nil {
+		t.Fatalf("unexpected error for a CSR requesting code signing without conflict detection: %v", err)
+	}
+	if _, err := preSign(&IstioRAOptions{CSRUsageConflictMode: CSRUsageConflictStrict}, nil, csrPEM,
+		certOpts); !errors.As(err, &raErr) || raErr.ErrorType() != "CSR_ERROR" {
+		t.Fatalf("expected a CSR_ERROR error for a CSR requesting code signing, got: %v", err)
+	}
+
+	raOpts := &IstioRAOptions{CSRUsageConflictMode: CSRUsageConflictBestEffort}
+	req, err := preSign(raOpts, nil, csrPEM, certOpts)
+	if err != nil {
+		t.Fatalf("expected the key usages of the RA to prevail, got: %v", err)
+	}
+	uri, _ := url.Parse(testCsrHostName)
+	issue := func(usages ...x509.ExtKeyUsage) []byte {
+		certDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			NotBefore:    time.Now(),
+			NotAfter:     time.Now().Add(time.Hour),
+			URIs:         []*url.URL{uri},
+			ExtKeyUsage:  usages,
+		}, issuer.intermediateCert, key.Public(), issuer.intermediateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	}
+	noChain := func(*x509.Certificate) ([]byte, error) { return nil, nil }
+	if _, err := newSignResult(raOpts, req, issue(x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageCodeSigning), noChain,
+		"test", "test", new(sync.Map)); !errors.As(err, &raErr) || raErr.ErrorType() != "CERT_GEN_ERROR" {
+		t.Errorf("expected a CERT_GEN_ERROR error for a certificate issued with the conflicting code signing, got: %v", err)
+	}
+	if _, err := newSignResult(raOpts, req, issue(x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth), noChain,
+		"test", "test", new(sync.Map)); err != nil {
+		t.Errorf("unexpected error for a certificate issued with the key usages of the RA: %v", err)
+	}
+}
@@ -0,0 +1,270 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+
+	cert "k8s.io/api/certificates/v1"
+
+	"istio.io/istio/security/pkg/pki/ca"
+	raerror "istio.io/istio/security/pkg/pki/error"
+)
+
+// defaultKeyUsages are requested for certificates when CertOpts does not specify any key usages.
+var defaultKeyUsages = []cert.KeyUsage{
+	cert.UsageDigitalSignature,
+	cert.UsageKeyEncipherment,
+	cert.UsageServerAuth,
+	cert.UsageClientAuth,
+}
+
+// validKeyUsages are the key usages accepted by the K8s v1 CSR API.
+var validKeyUsages = map[cert.KeyUsage]struct{}{
+	cert.UsageSigning:           {},
+	cert.UsageDigitalSignature:  {},
+	cert.UsageContentCommitment: {},
+	cert.UsageKeyEncipherment:   {},
+	cert.UsageKeyAgreement:      {},
+	cert.UsageDataEncipherment:  {},
+	cert.UsageCertSign:          {},
+	cert.UsageCRLSign:           {},
+	cert.UsageEncipherOnly:      {},
+	cert.UsageDecipherOnly:      {},
+	cert.UsageAny:               {},
+	cert.UsageServerAuth:        {},
+	cert.UsageClientAuth:        {},
+	cert.UsageCodeSigning:       {},
+	cert.UsageEmailProtection:   {},
+	cert.UsageSMIME:             {},
+	cert.UsageIPsecEndSystem:    {},
+	cert.UsageIPsecTunnel:       {},
+	cert.UsageIPsecUser:         {},
+	cert.UsageTimestamping:      {},
+	cert.UsageOCSPSigning:       {},
+	cert.UsageMicrosoftSGC:      {},
+	cert.UsageNetscapeSGC:       {},
+}
+
+// extKeyUsages are the extended key usages of the K8s v1 CSR API, with the extended key usage they set in
+// issued certificates.
+var extKeyUsages = map[cert.KeyUsage]x509.ExtKeyUsage{
+	cert.UsageAny:             x509.ExtKeyUsageAny,
+	cert.UsageServerAuth:      x509.ExtKeyUsageServerAuth,
+	cert.UsageClientAuth:      x509.ExtKeyUsageClientAuth,
+	cert.UsageCodeSigning:     x509.ExtKeyUsageCodeSigning,
+	cert.UsageEmailProtection: x509.ExtKeyUsageEmailProtection,
+	cert.UsageSMIME:           x509.ExtKeyUsageEmailProtection,
+	cert.UsageIPsecEndSystem:  x509.ExtKeyUsageIPSECEndSystem,
+	cert.UsageIPsecTunnel:     x509.ExtKeyUsageIPSECTunnel,
+	cert.UsageIPsecUser:       x509.ExtKeyUsageIPSECUser,
+	cert.UsageTimestamping:    x509.ExtKeyUsageTimeStamping,
+	cert.UsageOCSPSigning:     x509.ExtKeyUsageOCSPSigning,
+	cert.UsageMicrosoftSGC:    x509.ExtKeyUsageMicrosoftServerGatedCrypto,
+	cert.UsageNetscapeSGC:     x509.ExtKeyUsageNetscapeServerGatedCrypto,
+}
+
+// CSRExtKeyUsageMode is what is done with the extended key usages requested by the extended key usage extension
+// of a CSR that are not in the AllowedEKUs.
+type CSRExtKeyUsageMode string
+
+const (
+	// CSRExtKeyUsageReject : CSRs requesting extended key usages that are not allowed are rejected with a CSRError
+	CSRExtKeyUsageReject CSRExtKeyUsageMode = "Reject"
+	// CSRExtKeyUsageStrip : The extended key usages that are not allowed are stripped from the request. As the CSR
+	// cannot be signed again without the key of the workload, it is sent to the signer as is, and certificates
+	// issued with a stripped extended key usage that the RA did not request are rejected with a CertGenError
+	CSRExtKeyUsageStrip CSRExtKeyUsageMode = "Strip"
+)
+
+// oidExtensionExtKeyUsage is the extended key usage extension of RFC 5280.
+var oidExtensionExtKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37}
+
+// extKeyUsageOIDs are the object identifiers of the extended key usages of extKeyUsages.
+var extKeyUsageOIDs = map[x509.ExtKeyUsage]asn1.ObjectIdentifier{
+	x509.ExtKeyUsageAny:                        {2, 5, 29, 37, 0},
+	x509.ExtKeyUsageServerAuth:                 {1, 3, 6, 1, 5, 5, 7, 3, 1},
+	x509.ExtKeyUsageClientAuth:                 {1, 3, 6, 1, 5, 5, 7, 3, 2},
+	x509.ExtKeyUsageCodeSigning:                {1, 3, 6, 1, 5, 5, 7, 3, 3},
+	x509.ExtKeyUsageEmailProtection:            {1, 3, 6, 1, 5, 5, 7, 3, 4},
+	x509.ExtKeyUsageIPSECEndSystem:             {1, 3, 6, 1, 5, 5, 7, 3, 5},
+	x509.ExtKeyUsageIPSECTunnel:                {1, 3, 6, 1, 5, 5, 7, 3, 6},
+	x509.ExtKeyUsageIPSECUser:                  {1, 3, 6, 1, 5, 5, 7, 3, 7},
+	x509.ExtKeyUsageTimeStamping:               {1, 3, 6, 1, 5, 5, 7, 3, 8},
+	x509.ExtKeyUsageOCSPSigning:                {1, 3, 6, 1, 5, 5, 7, 3, 9},
+	x509.ExtKeyUsageMicrosoftServerGatedCrypto: {1, 3, 6, 1, 4, 1, 311, 10, 3, 3},
+	x509.ExtKeyUsageNetscapeServerGatedCrypto:  {2, 16, 840, 1, 113730, 4, 1},
+}
+
+// validateAllowedEKUs checks that the AllowedEKUs of raOpts are extended key usages, and that its
+// CSRExtKeyUsageMode is known.
+func validateAllowedEKUs(raOpts *IstioRAOptions) error {
+	for _, usage := range raOpts.AllowedEKUs {
+		if _, ok := extKeyUsages[usage]; !ok {
+			return raerror.NewError(raerror.CAInitFail, fmt.Errorf("allowed CSR extended key usage %q is not an extended key usage", usage))
+		}
+	}
+	switch raOpts.CSRExtKeyUsageMode {
+	case "", CSRExtKeyUsageReject, CSRExtKeyUsageStrip:
+		return nil
+	}
+	return raerror.NewError(raerror.CAInitFail, fmt.Errorf("unknown CSR extended key usage mode %q", raOpts.CSRExtKeyUsageMode))
+}
+
+// csrExtKeyUsageOIDs returns the extended key usages requested by the extended key usage extension of csr, if any.
+func csrExtKeyUsageOIDs(csr *x509.CertificateRequest) ([]asn1.ObjectIdentifier, error) {
+	var oids []asn1.ObjectIdentifier
+	for _, ext := range csr.Extensions {
+		if !ext.Id.Equal(oidExtensionExtKeyUsage) {
+			continue
+		}
+		var extOIDs []asn1.ObjectIdentifier
+		if rest, err := asn1.Unmarshal(ext.Value, &extOIDs); err != nil || len(rest) > 0 {
+			return nil, fmt.Errorf("invalid extended key usage extension in CSR")
+		}
+		oids = append(oids, extOIDs...)
+	}
+	return oids, nil
+}
+
+// validateCSRExtKeyUsages checks the extended key usages requested by the extension of csr against the
+// AllowedEKUs of raOpts, if any. With CSRExtKeyUsageStrip, it returns those that are not allowed, other than the
+// ones of the key usages requested by the RA, which the certificate must not have.
+func validateCSRExtKeyUsages(raOpts *IstioRAOptions, csr *x509.CertificateRequest,
+	requestedUsages []cert.KeyUsage) ([]asn1.ObjectIdentifier, error) {
+	if len(raOpts.AllowedEKUs) == 0 {
+		return nil, nil
+	}
+	oids, err := csrExtKeyUsageOIDs(csr)
+	if err != nil {
+		return nil, err
+	}
+	var stripped []asn1.ObjectIdentifier
+	for _, oid := range oids {
+		if containsExtKeyUsageOID(raOpts.AllowedEKUs, oid) {
+			continue
+		}
+		if raOpts.CSRExtKeyUsageMode != CSRExtKeyUsageStrip {
+			return nil, fmt.Errorf("extended key usage %v requested by the CSR is not allowed", oid)
+		}
+		if !containsExtKeyUsageOID(requestedUsages, oid) {
+			stripped = append(stripped, oid)
+		}
+	}
+	return stripped, nil
+}
+
+// containsExtKeyUsageOID returns whether oid is the extended key usage of one of usages.
+func containsExtKeyUsageOID(usages []cert.KeyUsage, oid asn1.ObjectIdentifier) bool {
+	for _, usage := range usages {
+		if extKeyUsage, ok := extKeyUsages[usage]; ok && extKeyUsageOIDs[extKeyUsage].Equal(oid) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkStrippedExtKeyUsages returns an error if leafCert has one of the stripped extended key usages.
+func checkStrippedExtKeyUsages(leafCert *x509.Certificate, stripped []asn1.ObjectIdentifier) error {
+	if len(stripped) == 0 {
+		return nil
+	}
+	certOIDs := append([]asn1.ObjectIdentifier{}, leafCert.UnknownExtKeyUsage...)
+	for _, extKeyUsage := range leafCert.ExtKeyUsage {
+		certOIDs = append(certOIDs, extKeyUsageOIDs[extKeyUsage])
+	}
+	for _, oid := range stripped {
+		for _, certOID := range certOIDs {
+			if certOID.Equal(oid) {
+				return fmt.Errorf("the issued certificate has extended key usage %v, which was stripped from the CSR", oid)
+			}
+		}
+	}
+	return nil
+}
+
+// requestedExtKeyUsages returns the extended key usages requested with certOpts other than those of
+// defaultKeyUsages, which must be in the AllowedExtendedKeyUsages of raOpts.
+func requestedExtKeyUsages(raOpts *IstioRAOptions, certOpts ca.CertOpts) ([]x509.ExtKeyUsage, error) {
+	var requested []x509.ExtKeyUsage
+	for _, usage := range certOpts.KeyUsages {
+		extKeyUsage, ok := extKeyUsages[usage]
+		if !ok || containsKeyUsage(defaultKeyUsages, usage) {
+			continue
+		}
+		if !containsKeyUsage(raOpts.AllowedExtendedKeyUsages, usage) {
+			return nil, raerror.NewError(raerror.CSRError, fmt.Errorf("extended key usage %q is not allowed", usage))
+		}
+		requested = append(requested, extKeyUsage)
+	}
+	return requested, nil
+}
+
+// checkExtKeyUsages returns an error if leafCert does not have all the requested extended key usages.
+func checkExtKeyUsages(leafCert *x509.Certificate, requested []x509.ExtKeyUsage) error {
+	for _, extKeyUsage := range requested {
+		found := false
+		for _, certUsage := range leafCert.ExtKeyUsage {
+			if certUsage == extKeyUsage || certUsage == x509.ExtKeyUsageAny {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("the issued certificate does not have the requested extended key usage %v", extKeyUsage)
+		}
+	}
+	return nil
+}
+
+// keyUsages returns the key usages to request for a certificate with the given cert opts and profile, if any.
+// Requested usages must be among those of the profile. CA certificates always get the cert sign usage.
+func keyUsages(certOpts ca.CertOpts, profile *CertProfile) ([]cert.KeyUsage, error) {
+	usages := defaultKeyUsages
+	if profile != nil && len(profile.KeyUsages) > 0 {
+		usages = profile.KeyUsages
+	}
+	if len(certOpts.KeyUsages) > 0 {
+		if profile != nil && len(profile.KeyUsages) > 0 {
+			for _, usage := range certOpts.KeyUsages {
+				if !containsKeyUsage(profile.KeyUsages, usage) {
+					return nil, raerror.NewError(raerror.CSRError,
+						fmt.Errorf("key usage %q is not allowed for certificate profile %q", usage, certOpts.Profile))
+				}
+			}
+		}
+		usages = certOpts.KeyUsages
+	}
+	for _, usage := range usages {
+		if _, ok := validKeyUsages[usage]; !ok {
+			return nil, raerror.NewError(raerror.CertGenError, fmt.Errorf("invalid key usage %q", usage))
+		}
+	}
+	if certOpts.ForCA && !containsKeyUsage(usages, cert.UsageCertSign) {
+		usages = append(append([]cert.KeyUsage{}, usages...), cert.UsageCertSign)
+	}
+	return usages, nil
+}
+
+func containsKeyUsage(usages []cert.KeyUsage, usage cert.KeyUsage) bool {
+	for _, u := range usages {
+		if u == usage {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,279 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	cert "k8s.io/api/certificates/v1"
+
+	"istio.io/istio/security/pkg/pki/ca"
+	raerror "istio.io/istio/security/pkg/pki/error"
+	"istio.io/istio/security/pkg/pki/util"
+)
+
+func TestKeyUsages(t *testing.T) {
+	testCases := map[string]struct {
+		certOpts  ca.CertOpts
+		expected  []cert.KeyUsage
+		expectErr bool
+	}{
+		"default usages": {
+			certOpts: ca.CertOpts{},
+			expected: defaultKeyUsages,
+		},
+		"client only usages": {
+			certOpts: ca.CertOpts{KeyUsages: []cert.KeyUsage{cert.UsageDigitalSignature, cert.UsageClientAuth}},
+			expected: []cert.KeyUsage{cert.UsageDigitalSignature, cert.UsageClientAuth},
+		},
+		"CA certificate gets cert sign": {
+			certOpts: ca.CertOpts{ForCA: true, KeyUsages: []cert.KeyUsage{cert.UsageDigitalSignature}},
+			expected: []cert.KeyUsage{cert.UsageDigitalSignature, cert.UsageCertSign},
+		},
+		"CA certificate with cert sign": {
+			certOpts: ca.CertOpts{ForCA: true, KeyUsages: []cert.KeyUsage{cert.UsageCertSign}},
+			expected: []cert.KeyUsage{cert.UsageCertSign},
+		},
+		"unknown usage": {
+			certOpts:  ca.CertOpts{KeyUsages: []cert.KeyUsage{cert.UsageClientAuth, "make coffee"}},
+			expectErr: true,
+		},
+	}
+	for name, tc := range testCases {
+		usages, err := keyUsages(tc.certOpts, nil)
+		if tc.expectErr {
+			if err == nil {
+				t.Errorf("%s: expected an error", name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(usages, tc.expected) {
+			t.Errorf("%s: got usages %v, want %v", name, usages, tc.expected)
+		}
+	}
+	if len(defaultKeyUsages) != 4 {
+		t.Errorf("default usages were modified: %v", defaultKeyUsages)
+	}
+}
+
+func TestRequestedExtKeyUsages(t *testing.T) {
+	testCases := map[cert.KeyUsage]x509.ExtKeyUsage{
+		cert.UsageAny:             x509.ExtKeyUsageAny,
+		cert.UsageCodeSigning:     x509.ExtKeyUsageCodeSigning,
+		cert.UsageEmailProtection: x509.ExtKeyUsageEmailProtection,
+		cert.UsageSMIME:           x509.ExtKeyUsageEmailProtection,
+		cert.UsageIPsecEndSystem:  x509.ExtKeyUsageIPSECEndSystem,
+		cert.UsageIPsecTunnel:     x509.ExtKeyUsageIPSECTunnel,
+		cert.UsageIPsecUser:       x509.ExtKeyUsageIPSECUser,
+		cert.UsageTimestamping:    x509.ExtKeyUsageTimeStamping,
+		cert.UsageOCSPSigning:     x509.ExtKeyUsageOCSPSigning,
+		cert.UsageMicrosoftSGC:    x509.ExtKeyUsageMicrosoftServerGatedCrypto,
+		cert.UsageNetscapeSGC:     x509.ExtKeyUsageNetscapeServerGatedCrypto,
+	}
+	for usage, expected := range testCases {
+		t.Run(string(usage), func(t *testing.T) {
+			certOpts := ca.CertOpts{KeyUsages: []cert.KeyUsage{cert.UsageDigitalSignature, cert.UsageClientAuth, usage}}
+			if _, err := keyUsages(certOpts, nil); err != nil {
+				t.Fatalf("expected key usage %q to be valid, got: %v", usage, err)
+			}
+			_, err := requestedExtKeyUsages(&IstioRAOptions{}, certOpts)
+			var raErr *raerror.Error
+			if !errors.As(err, &raErr) || raErr.ErrorType() != "CSR_ERROR" {
+				t.Errorf("expected a CSR_ERROR error for a key usage not allowed, got: %v", err)
+			}
+			requested, err := requestedExtKeyUsages(&IstioRAOptions{AllowedExtendedKeyUsages: []cert.KeyUsage{usage}}, certOpts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(requested, []x509.ExtKeyUsage{expected}) {
+				t.Errorf("got extended key usages %v, want %v", requested, expected)
+			}
+		})
+	}
+	for _, usage := range defaultKeyUsages {
+		requested, err := requestedExtKeyUsages(&IstioRAOptions{}, ca.CertOpts{KeyUsages: []cert.KeyUsage{usage}})
+		if err != nil || len(requested) > 0 {
+			t.Errorf("expected default key usage %q to be allowed without being checked, got %v, %v", usage, requested, err)
+		}
+	}
+}
+
+func TestCheckExtKeyUsages(t *testing.T) {
+	leafCert := &x509.Certificate{ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageCodeSigning}}
+	if err := checkExtKeyUsages(leafCert, []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := checkExtKeyUsages(leafCert, []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping}); err == nil {
+		t.Errorf("expected an error for a dropped extended key usage")
+	}
+	anyCert := &x509.Certificate{ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}
+	if err := checkExtKeyUsages(anyCert, []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping}); err != nil {
+		t.Errorf("unexpected error for a certificate with any extended key usage: %v", err)
+	}
+}
+
+// csrWithExtKeyUsages returns a PEM encoded CSR for testCsrHostName, with key, requesting oids in its extended
+// key usage extension.
+func csrWithExtKeyUsages(t *testing.T, key crypto.Signer, oids ...asn1.ObjectIdentifier) []byte {
+	t.Helper()
+	uri, err := url.Parse(testCsrHostName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.CertificateRequest{URIs: []*url.URL{uri}}
+	if len(oids) > 0 {
+		value, err := asn1.Marshal(oids)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template.ExtraExtensions = []pkix.Extension{{Id: oidExtensionExtKeyUsage, Value: value}}
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: createTestCSR(t, key, template).Raw})
+}
+
+func TestValidateCSRExtKeyUsages(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	serverAuth := extKeyUsageOIDs[x509.ExtKeyUsageServerAuth]
+	codeSigning := extKeyUsageOIDs[x509.ExtKeyUsageCodeSigning]
+	unknown := asn1.ObjectIdentifier{1, 2, 3, 4}
+	testCases := map[string]struct {
+		allowed   []cert.KeyUsage
+		mode      CSRExtKeyUsageMode
+		oids      []asn1.ObjectIdentifier
+		stripped  []asn1.ObjectIdentifier
+		expectErr bool
+	}{
+		"not restricted": {
+			oids: []asn1.ObjectIdentifier{codeSigning, unknown},
+		},
+		"no extension": {
+			allowed: []cert.KeyUsage{cert.UsageClientAuth},
+		},
+		"allowed": {
+			allowed: []cert.KeyUsage{cert.UsageServerAuth, cert.UsageCodeSigning},
+			oids:    []asn1.ObjectIdentifier{serverAuth, codeSigning},
+		},
+		"rejected": {
+			allowed:   []cert.KeyUsage{cert.UsageServerAuth},
+			oids:      []asn1.ObjectIdentifier{serverAuth, codeSigning},
+			expectErr: true,
+		},
+		"unknown rejected": {
+			allowed:   []cert.KeyUsage{cert.UsageServerAuth},
+			oids:      []asn1.ObjectIdentifier{unknown},
+			expectErr: true,
+		},
+		"stripped": {
+			allowed:  []cert.KeyUsage{cert.UsageTimestamping},
+			mode:     CSRExtKeyUsageStrip,
+			oids:     []asn1.ObjectIdentifier{serverAuth, codeSigning, unknown},
+			stripped: []asn1.ObjectIdentifier{codeSigning, unknown},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			raOpts := &IstioRAOptions{AllowedEKUs: tc.allowed, CSRExtKeyUsageMode: tc.mode}
+			csr, err := util.ParsePemEncodedCSR(csrWithExtKeyUsages(t, key, tc.oids...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// server auth is requested by the default key usages
+			stripped, err := validateCSRExtKeyUsages(raOpts, csr, defaultKeyUsages)
+			if tc.expectErr {
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(stripped, tc.stripped) {
+				t.Errorf("got stripped extended key usages %v, want %v", stripped, tc.stripped)
+			}
+		})
+	}
+
+	for name, raOpts := range map[string]*IstioRAOptions{
+		"not an extended key usage": {AllowedEKUs: []cert.KeyUsage{cert.UsageCertSign}},
+		"unknown mode":              {AllowedEKUs: []cert.KeyUsage{cert.UsageClientAuth}, CSRExtKeyUsageMode: "Drop"},
+	} {
+		if err := validateRAOptions(raOpts); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestPreSignCSRExtKeyUsages(t *testing.T) {
+	issuer := newTestCA(t, false)
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	csrPEM := csrWithExtKeyUsages(t, key, extKeyUsageOIDs[x509.ExtKeyUsageServerAuth], extKeyUsageOIDs[x509.ExtKeyUsageCodeSigning])
+	certOpts := ca.CertOpts{SubjectIDs: []string{testCsrHostName}, TTL: time.Hour}
+	raOpts := &IstioRAOptions{AllowedEKUs: []cert.KeyUsage{cert.UsageClientAuth}}
+
+	var raErr *raerror.Error
+	if _, err := preSign(raOpts, nil, csrPEM, certOpts); !errors.As(err, &raErr) || raErr.ErrorType() != "CSR_ERROR" {
+		t.Fatalf("expected a CSR_ERROR error for a CSR requesting code signing, got: %v", err)
+	}
+
+	raOpts.CSRExtKeyUsageMode = CSRExtKeyUsageStrip
+	req, err := preSign(raOpts, nil, csrPEM, certOpts)
+	if err != nil {
+		t.Fatalf("expected code signing to be stripped from the request, got: %v", err)
+	}
+	uri, _ := url.Parse(testCsrHostName)
+	issue := func(usages ...x509.ExtKeyUsage) []byte {
+		certDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			NotBefore:    time.Now(),
+			NotAfter:     time.Now().Add(time.Hour),
+			URIs:         []*url.URL{uri},
+			ExtKeyUsage:  usages,
+		}, issuer.intermediateCert, key.Public(), issuer.intermediateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	}
+	noChain := func(*x509.Certificate) ([]byte, error) { return nil, nil }
+	// the signer honors the extended key usages of the CSR
+	if _, err := newSignResult(raOpts, req, issue(x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageCodeSigning), noChain,
+		"test", "test", new(sync.Map)); !errors.As(err, &raErr) || raErr.ErrorType() != "CERT_GEN_ERROR" {
+		t.Errorf("expected a CERT_GEN_ERROR error for a certificate issued with the stripped code signing, got: %v", err)
+	}
+	// the signer issues the requested key usages, server auth was not stripped as the RA requests it
+	if _, err := newSignResult(raOpts, req, issue(x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth), noChain,
+		"test", "test", new(sync.Map)); err != nil {
+		t.Errorf("unexpected error for a certificate issued without code signing: %v", err)
+	}
+}
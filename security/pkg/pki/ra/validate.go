@@ -0,0 +1,488 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	"istio.io/istio/pkg/spiffe"
+	raerror "istio.io/istio/security/pkg/pki/error"
+	"istio.io/istio/security/pkg/pki/util"
+)
+
+// defaultKeyAlgorithms are the public key algorithms allowed in CSRs when AllowedKeyAlgorithms is not set.
+var defaultKeyAlgorithms = []x509.PublicKeyAlgorithm{x509.RSA, x509.ECDSA}
+
+// validateCSRKey checks that the public key in csr uses an allowed algorithm and is not weaker than
+// the configured minimum size.
+func validateCSRKey(raOpts *IstioRAOptions, csr *x509.CertificateRequest) error {
+	allowed := raOpts.AllowedKeyAlgorithms
+	if len(allowed) == 0 {
+		allowed = defaultKeyAlgorithms
+	}
+	algorithmAllowed := false
+	for _, algorithm := range allowed {
+		if csr.PublicKeyAlgorithm == algorithm {
+			algorithmAllowed = true
+			break
+		}
+	}
+	if !algorithmAllowed {
+		return fmt.Errorf("public key algorithm %v is not allowed", csr.PublicKeyAlgorithm)
+	}
+
+	switch key := csr.PublicKey.(type) {
+	case *rsa.PublicKey:
+		minSize := raOpts.MinRSAKeySize
+		if minSize <= 0 {
+			minSize = DefaultMinRSAKeySize
+		}
+		if size := key.N.BitLen(); size < minSize {
+			return fmt.Errorf("RSA key size %d is less than the minimum allowed size %d", size, minSize)
+		}
+	case *ecdsa.PublicKey:
+		minSize := raOpts.MinECKeySize
+		if minSize <= 0 {
+			minSize = DefaultMinECKeySize
+		}
+		if size := key.Curve.Params().BitSize; size < minSize {
+			return fmt.Errorf("ECDSA curve %s is less than the minimum allowed size %d", key.Curve.Params().Name, minSize)
+		}
+	}
+	return nil
+}
+
+// csrIdentity is a SAN identity in a CSR.
+type csrIdentity struct {
+	idType util.IdentityType
+	// value is the identity, IP addresses are in their string form.
+	value string
+}
+
+// csrIdentities returns the URI, DNS and IP SAN identities in csr.
+func csrIdentities(csr *x509.CertificateRequest) ([]csrIdentity, error) {
+	return sanIdentities(csr.Extensions)
+}
+
+// errNoSANExtension is the error of sanIdentities for extensions without a SAN extension.
+var errNoSANExtension = errors.New("the SAN extension does not exist")
+
+// sanIdentities returns the URI, DNS and IP identities in the SAN extension of exts.
+func sanIdentities(exts []pkix.Extension) ([]csrIdentity, error) {
+	sanExt := util.ExtractSANExtension(exts)
+	if sanExt == nil {
+		return nil, errNoSANExtension
+	}
+	ids, err := util.ExtractIDsFromSAN(sanExt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract identities from SAN extension (error %v)", err)
+	}
+	identities := make([]csrIdentity, 0, len(ids))
+	for _, id := range ids {
+		value := string(id.Value)
+		if id.Type == util.TypeIP {
+			value = net.IP(id.Value).String()
+		}
+		identities = append(identities, csrIdentity{idType: id.Type, value: value})
+	}
+	return identities, nil
+}
+
+// matches returns whether value is the identity id. DNS names are compared case-insensitively.
+func (id csrIdentity) matches(value string) bool {
+	return id.value == value || (id.idType == util.TypeDNS && strings.EqualFold(id.value, value))
+}
+
+// validateCSRIdentities checks that every CSR identity is one of subjectIDs, regardless of order.
+// DNS names are compared case-insensitively.
+func validateCSRIdentities(identities []csrIdentity, subjectIDs []string) error {
+	for _, id := range identities {
+		matched := false
+		for _, subjectID := range subjectIDs {
+			if id.matches(subjectID) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("SAN identity %q is not one of the requested subject IDs", id.value)
+		}
+	}
+	return nil
+}
+
+// SANValidationMode is how strictly the SAN identities of CSRs are checked against the requested SubjectIDs
+// and DNSNames.
+type SANValidationMode string
+
+const (
+	// SANValidationOff : CSRs are signed without checking their SAN identities
+	SANValidationOff SANValidationMode = "Off"
+	// SANValidationWarn : CSRs without a SAN extension, or with SAN identities that were not requested, are
+	// logged and counted, but signed, e.g. while migrating deployments with such CSRs
+	SANValidationWarn SANValidationMode = "Warn"
+	// SANValidationEnforce : CSRs without a SAN extension, or with SAN identities that were not requested, are
+	// rejected
+	SANValidationEnforce SANValidationMode = "Enforce"
+)
+
+// validateSANValidationMode checks that the SANValidationMode of raOpts is known.
+func validateSANValidationMode(raOpts *IstioRAOptions) error {
+	switch raOpts.SANValidationMode {
+	case "", SANValidationOff, SANValidationWarn, SANValidationEnforce:
+		return nil
+	}
+	return raerror.NewError(raerror.CAInitFail, fmt.Errorf("unknown SAN validation mode %q", raOpts.SANValidationMode))
+}
+
+// sanValidationMode returns the SANValidationMode, or its default.
+func (o *IstioRAOptions) sanValidationMode() SANValidationMode {
+	if o.SANValidationMode == "" {
+		return SANValidationEnforce
+	}
+	return o.SANValidationMode
+}
+
+// validateCSRSANs returns the SAN identities of csr of the request requestID, after checking that they are
+// requested subjectIDs, dnsNames or ipAddresses as configured by the SANValidationMode, that the requested
+// dnsNames are in csr, and that the requested ipAddresses are exactly its IP SANs. A malformed SAN extension is
+// always rejected.
+func validateCSRSANs(raOpts *IstioRAOptions, csr *x509.CertificateRequest, subjectIDs, dnsNames []string,
+	ipAddresses []net.IP, requestID string) ([]csrIdentity, error) {
+	identities, err := csrIdentities(csr)
+	if err != nil && !errors.Is(err, errNoSANExtension) {
+		return nil, err
+	}
+	if mode := raOpts.sanValidationMode(); mode != SANValidationOff {
+		if err == nil {
+			requested := append(append([]string{}, subjectIDs...), dnsNames...)
+			for _, ip := range ipAddresses {
+				requested = append(requested, ip.String())
+			}
+			err = validateCSRIdentities(identities, requested)
+		}
+		if err != nil {
+			sanValidationFailureCounts.With(modeTag.Value(string(mode))).Increment()
+			if mode == SANValidationEnforce {
+				return nil, err
+			}
+			requestLog(requestID).Warnf("signing a CSR that fails SAN validation, which SAN validation mode %s "+
+				"rejects: %v", SANValidationEnforce, err)
+		}
+	}
+	// The signers cannot add requested DNS names that are not in the CSR.
+	if err := validateCSRDNSNames(identities, dnsNames); err != nil {
+		return nil, err
+	}
+	if err := validateCSRIPAddresses(identities, ipAddresses); err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
+
+// validateDNSNames checks that every requested DNS name is a valid DNS name allowed by AllowedDNSNames, and
+// only a wildcard if AllowWildcardDNSNames is set.
+func validateDNSNames(raOpts *IstioRAOptions, dnsNames []string) error {
+	for _, dnsName := range dnsNames {
+		name := strings.ToLower(dnsName)
+		wildcard := strings.HasPrefix(name, "*.")
+		if wildcard && !raOpts.AllowWildcardDNSNames {
+			return fmt.Errorf("wildcard DNS name %q is not allowed", dnsName)
+		}
+		if errs := validation.IsDNS1123Subdomain(strings.TrimPrefix(name, "*.")); len(errs) > 0 {
+			return fmt.Errorf("invalid DNS name %q: %s", dnsName, strings.Join(errs, ", "))
+		}
+		allowed := false
+		for _, allowedName := range raOpts.AllowedDNSNames {
+			allowedName = strings.ToLower(allowedName)
+			if name == allowedName {
+				allowed = true
+				break
+			}
+			// *.<domain> allows the names directly in <domain>, but not the wildcard itself unless listed.
+			if domain := strings.TrimPrefix(allowedName, "*"); domain != allowedName && !wildcard &&
+				strings.HasSuffix(name, domain) && !strings.Contains(strings.TrimSuffix(name, domain), ".") {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("DNS name %q is not allowed", dnsName)
+		}
+	}
+	return nil
+}
+
+// validateCSRDNSNames checks that every requested DNS name is a DNS SAN of the CSR, as no signer can add SANs
+// to a CSR.
+func validateCSRDNSNames(identities []csrIdentity, dnsNames []string) error {
+	for _, dnsName := range dnsNames {
+		found := false
+		for _, id := range identities {
+			if id.idType == util.TypeDNS && id.matches(dnsName) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("requested DNS name %q is not in the CSR, the signer cannot add it", dnsName)
+		}
+	}
+	return nil
+}
+
+// validateIssuedCert checks that cert was issued for csr: it must have the public key of csr, and the
+// same SAN identities as csr, regardless of order. It has no SAN extension only if csr has no SAN identities.
+func validateIssuedCert(csr *x509.CertificateRequest, identities []csrIdentity, cert *x509.Certificate) error {
+	if err := validateIssuedKey(csr, cert); err != nil {
+		return err
+	}
+	return validateIssuedSANs(identities, cert)
+}
+
+// validateIssuedKey checks that cert has the public key of csr.
+func validateIssuedKey(csr *x509.CertificateRequest, cert *x509.Certificate) error {
+	csrKey, err := x509.MarshalPKIXPublicKey(csr.PublicKey)
+	if err != nil {
+		return fmt.Errorf("failed to marshal the public key of the CSR: %v", err)
+	}
+	certKey, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	if err != nil {
+		return fmt.Errorf("failed to marshal the public key of the issued certificate: %v", err)
+	}
+	if !bytes.Equal(csrKey, certKey) {
+		return fmt.Errorf("the public key of the issued certificate does not match the CSR")
+	}
+	return nil
+}
+
+// validateIssuedSANs checks that cert has the SAN identities of a CSR, regardless of order. It has no SAN
+// extension only if there are no identities.
+func validateIssuedSANs(identities []csrIdentity, cert *x509.Certificate) error {
+	certIdentities, err := sanIdentities(cert.Extensions)
+	if err != nil && !(errors.Is(err, errNoSANExtension) && len(identities) == 0) {
+		return fmt.Errorf("invalid issued certificate: %v", err)
+	}
+	contains := func(identities []csrIdentity, id csrIdentity) bool {
+		for _, other := range identities {
+			if other.idType == id.idType && other.matches(id.value) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, id := range certIdentities {
+		if !contains(identities, id) {
+			return fmt.Errorf("SAN identity %q of the issued certificate is not in the CSR", id.value)
+		}
+	}
+	for _, id := range identities {
+		if !contains(certIdentities, id) {
+			return fmt.Errorf("SAN identity %q of the CSR is missing from the issued certificate", id.value)
+		}
+	}
+	return nil
+}
+
+// validateSubject checks that subject has exactly the DefaultOrganization and DefaultOU of raOpts, if any,
+// regardless of order. Empty values are ignored.
+func validateSubject(raOpts *IstioRAOptions, subject pkix.Name) error {
+	if len(raOpts.DefaultOrganization) > 0 && !sameValues(subject.Organization, raOpts.DefaultOrganization) {
+		return fmt.Errorf("subject organization %q does not match the required organization %q",
+			subject.Organization, raOpts.DefaultOrganization)
+	}
+	if len(raOpts.DefaultOU) > 0 && !sameValues(subject.OrganizationalUnit, raOpts.DefaultOU) {
+		return fmt.Errorf("subject organizational unit %q does not match the required organizational unit %q",
+			subject.OrganizationalUnit, raOpts.DefaultOU)
+	}
+	return nil
+}
+
+// sameValues returns whether a and b have the same non-empty values, regardless of order and duplicates.
+func sameValues(a, b []string) bool {
+	set := func(values []string) map[string]bool {
+		s := map[string]bool{}
+		for _, v := range values {
+			if v != "" {
+				s[v] = true
+			}
+		}
+		return s
+	}
+	setA, setB := set(a), set(b)
+	if len(setA) != len(setB) {
+		return false
+	}
+	for v := range setA {
+		if !setB[v] {
+			return false
+		}
+	}
+	return true
+}
+
+// validateCAIdentities checks that every identity of a CA certificate request is one of the
+// CASigningIdentities.
+func validateCAIdentities(raOpts *IstioRAOptions, identities []csrIdentity) error {
+	for _, id := range identities {
+		allowed := false
+		for _, caID := range raOpts.CASigningIdentities {
+			if id.matches(caID) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("identity %q is not allowed to obtain CA certificates", id.value)
+		}
+	}
+	return nil
+}
+
+// signerAllowed returns whether signerName matches one of the allowed signer names, where a trailing *
+// matches any suffix.
+func signerAllowed(allowed []string, signerName string) bool {
+	for _, pattern := range allowed {
+		if patternMatches(pattern, signerName) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateSignerName checks that signerName is a K8s signer name, a fully qualified domain name followed by a path
+// of non-empty segments such as example.com/istio-ingress, so that invalid names are rejected with a descriptive
+// error before creating CSRs instead of by the K8s API server.
+func validateSignerName(signerName string) error {
+	parts := strings.SplitN(signerName, "/", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return fmt.Errorf("signer name %q is not of the form domain/path, e.g. example.com/signer-name", signerName)
+	}
+	if errs := validation.IsFullyQualifiedDomainName(field.NewPath("domain"), parts[0]); len(errs) > 0 {
+		return fmt.Errorf("signer name %q does not start with a fully qualified domain name: %v", signerName, errs.ToAggregate())
+	}
+	for _, segment := range strings.Split(parts[1], "/") {
+		switch {
+		case segment == "":
+			return fmt.Errorf("signer name %q has an empty path segment", signerName)
+		case segment == "." || segment == "..":
+			return fmt.Errorf("signer name %q has a relative path segment %q", signerName, segment)
+		case strings.ContainsAny(segment, "% \t\r\n"):
+			return fmt.Errorf("signer name %q has a path segment %q with whitespace or a %% character", signerName, segment)
+		}
+	}
+	return nil
+}
+
+// validateTrustDomains checks that every SPIFFE identity belongs to one of the TrustedDomains.
+// All trust domains are allowed when TrustedDomains is empty.
+func validateTrustDomains(raOpts *IstioRAOptions, identities []csrIdentity) error {
+	if len(raOpts.TrustedDomains) == 0 {
+		return nil
+	}
+	for _, id := range identities {
+		if id.idType != util.TypeURI || !strings.HasPrefix(id.value, spiffe.URIPrefix) {
+			continue
+		}
+		trustDomain := strings.SplitN(strings.TrimPrefix(id.value, spiffe.URIPrefix), "/", 2)[0]
+		trusted := false
+		for _, td := range raOpts.TrustedDomains {
+			if trustDomain == td {
+				trusted = true
+				break
+			}
+		}
+		if !trusted {
+			return fmt.Errorf("trust domain %q of identity %q is not trusted", trustDomain, id.value)
+		}
+	}
+	return nil
+}
+
+// SubjectIDFormat is a format of the SubjectIDs of signing requests.
+type SubjectIDFormat string
+
+const (
+	// SubjectIDFormatSpiffe : SPIFFE IDs, e.g. spiffe://cluster.local/ns/default/sa/default
+	SubjectIDFormatSpiffe SubjectIDFormat = "spiffe"
+	// SubjectIDFormatDNS : DNS names, or wildcard DNS names
+	SubjectIDFormatDNS SubjectIDFormat = "dns"
+	// SubjectIDFormatIP : IPv4 or IPv6 addresses
+	SubjectIDFormatIP SubjectIDFormat = "ip"
+	// SubjectIDFormatAny : Any non-empty SubjectID, for deployments using custom identity formats
+	SubjectIDFormatAny SubjectIDFormat = "any"
+)
+
+// defaultSubjectIDFormats are the formats of SubjectIDs allowed when SubjectIDFormats is not set.
+var defaultSubjectIDFormats = []SubjectIDFormat{SubjectIDFormatSpiffe, SubjectIDFormatDNS, SubjectIDFormatIP}
+
+// validateSubjectIDs checks that there is at least one SubjectID, and that each is in one of the allowed
+// SubjectIDFormats.
+func validateSubjectIDs(raOpts *IstioRAOptions, subjectIDs []string) error {
+	if len(subjectIDs) == 0 {
+		return fmt.Errorf("no subject IDs are requested")
+	}
+	formats := raOpts.SubjectIDFormats
+	if len(formats) == 0 {
+		formats = defaultSubjectIDFormats
+	}
+	for _, subjectID := range subjectIDs {
+		if subjectID == "" {
+			return fmt.Errorf("empty subject ID")
+		}
+		valid := false
+		for _, format := range formats {
+			if subjectIDHasFormat(subjectID, format) {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return fmt.Errorf("subject ID %q is not in any of the allowed formats %v", subjectID, formats)
+		}
+	}
+	return nil
+}
+
+// subjectIDHasFormat returns whether subjectID is in format.
+func subjectIDHasFormat(subjectID string, format SubjectIDFormat) bool {
+	switch format {
+	case SubjectIDFormatSpiffe:
+		if !strings.HasPrefix(subjectID, spiffe.URIPrefix) {
+			return false
+		}
+		u, err := url.Parse(subjectID)
+		return err == nil && u.Host != "" && u.Port() == "" && u.User == nil && u.RawQuery == "" && u.Fragment == ""
+	case SubjectIDFormatDNS:
+		return len(validation.IsDNS1123Subdomain(strings.TrimPrefix(strings.ToLower(subjectID), "*."))) == 0
+	case SubjectIDFormatIP:
+		return net.ParseIP(subjectID) != nil
+	case SubjectIDFormatAny:
+		return true
+	}
+	return false
+}
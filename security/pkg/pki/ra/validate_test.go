@@ -0,0 +1,642 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"istio.io/istio/security/pkg/pki/ca"
+	raerror "istio.io/istio/security/pkg/pki/error"
+	"istio.io/istio/security/pkg/pki/util"
+)
+
+func createTestCSR(t *testing.T, key crypto.Signer, template *x509.CertificateRequest) *x509.CertificateRequest {
+	t.Helper()
+	if template == nil {
+		template = &x509.CertificateRequest{}
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		t.Fatalf("failed to create CSR: %v", err)
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		t.Fatalf("failed to parse CSR: %v", err)
+	}
+	return csr
+}
+
+func TestValidateCSRKey(t *testing.T) {
+	rsa1024, _ := rsa.GenerateKey(rand.Reader, 1024)
+	rsa2048, _ := rsa.GenerateKey(rand.Reader, 2048)
+	p224, _ := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, ed, _ := ed25519.GenerateKey(rand.Reader)
+
+	testCases := map[string]struct {
+		raOpts    IstioRAOptions
+		key       crypto.Signer
+		expectErr bool
+	}{
+		"RSA 2048": {
+			key: rsa2048,
+		},
+		"RSA 1024 is too weak": {
+			key:       rsa1024,
+			expectErr: true,
+		},
+		"RSA 2048 below the configured minimum": {
+			raOpts:    IstioRAOptions{MinRSAKeySize: 3072},
+			key:       rsa2048,
+			expectErr: true,
+		},
+		"ECDSA P-256": {
+			key: p256,
+		},
+		"ECDSA P-224 is too weak": {
+			key:       p224,
+			expectErr: true,
+		},
+		"ECDSA P-224 with a lower minimum": {
+			raOpts: IstioRAOptions{MinECKeySize: 224},
+			key:    p224,
+		},
+		"Ed25519 is not allowed by default": {
+			key:       ed,
+			expectErr: true,
+		},
+		"Ed25519 explicitly allowed": {
+			raOpts: IstioRAOptions{AllowedKeyAlgorithms: []x509.PublicKeyAlgorithm{x509.Ed25519}},
+			key:    ed,
+		},
+		"RSA not in the allowed algorithms": {
+			raOpts:    IstioRAOptions{AllowedKeyAlgorithms: []x509.PublicKeyAlgorithm{x509.ECDSA}},
+			key:       rsa2048,
+			expectErr: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := validateCSRKey(&tc.raOpts, createTestCSR(t, tc.key, nil))
+			if tc.expectErr && err == nil {
+				t.Errorf("expected an error")
+			} else if !tc.expectErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateCSRIdentities(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	spiffeID := "spiffe://cluster.local/ns/default/sa/bookinfo-productpage"
+	spiffeURI, _ := url.Parse(spiffeID)
+	csr := createTestCSR(t, key, &x509.CertificateRequest{
+		URIs:        []*url.URL{spiffeURI},
+		DNSNames:    []string{"productpage.default.svc"},
+		IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+	})
+	noSANCsr := createTestCSR(t, key, nil)
+
+	testCases := map[string]struct {
+		csr        *x509.CertificateRequest
+		subjectIDs []string
+		expectErr  bool
+	}{
+		"exact match": {
+			csr:        csr,
+			subjectIDs: []string{spiffeID, "productpage.default.svc", "10.0.0.1"},
+		},
+		"order insensitive superset": {
+			csr:        csr,
+			subjectIDs: []string{"10.0.0.1", "other", "productpage.default.svc", spiffeID},
+		},
+		"DNS names are case insensitive": {
+			csr:        csr,
+			subjectIDs: []string{spiffeID, "ProductPage.Default.svc", "10.0.0.1"},
+		},
+		"URI identity not requested": {
+			csr:        csr,
+			subjectIDs: []string{"spiffe://cluster.local/ns/default/sa/other", "productpage.default.svc", "10.0.0.1"},
+			expectErr:  true,
+		},
+		"DNS identity not requested": {
+			csr:        csr,
+			subjectIDs: []string{spiffeID, "10.0.0.1"},
+			expectErr:  true,
+		},
+		"IP identity not requested": {
+			csr:        csr,
+			subjectIDs: []string{spiffeID, "productpage.default.svc"},
+			expectErr:  true,
+		},
+		"no SAN extension": {
+			csr:        noSANCsr,
+			subjectIDs: []string{spiffeID},
+			expectErr:  true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			identities, err := csrIdentities(tc.csr)
+			if err == nil {
+				err = validateCSRIdentities(identities, tc.subjectIDs)
+			}
+			if tc.expectErr && err == nil {
+				t.Errorf("expected an error")
+			} else if !tc.expectErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateCSRSANs(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	spiffeID, _ := url.Parse(testCsrHostName)
+	withSANs := createTestCSR(t, key, &x509.CertificateRequest{URIs: []*url.URL{spiffeID}})
+	withoutSANs := createTestCSR(t, key, nil)
+
+	testCases := map[string]struct {
+		mode       SANValidationMode
+		csr        *x509.CertificateRequest
+		subjectIDs []string
+		dnsNames   []string
+		expectErr  bool
+		// failureMode is the mode label of the failure recorded, if any.
+		failureMode SANValidationMode
+	}{
+		"requested SANs": {
+			mode:       SANValidationEnforce,
+			csr:        withSANs,
+			subjectIDs: []string{testCsrHostName},
+		},
+		"SAN not requested with Enforce": {
+			mode:        SANValidationEnforce,
+			csr:         withSANs,
+			subjectIDs:  []string{"spiffe://cluster.local/ns/default/sa/other"},
+			expectErr:   true,
+			failureMode: SANValidationEnforce,
+		},
+		"SAN not requested with Warn": {
+			mode:        SANValidationWarn,
+			csr:         withSANs,
+			subjectIDs:  []string{"spiffe://cluster.local/ns/default/sa/other"},
+			failureMode: SANValidationWarn,
+		},
+		"SAN not requested with Off": {
+			mode:       SANValidationOff,
+			csr:        withSANs,
+			subjectIDs: []string{"spiffe://cluster.local/ns/default/sa/other"},
+		},
+		"no SANs with Enforce": {
+			mode:        SANValidationEnforce,
+			csr:         withoutSANs,
+			subjectIDs:  []string{testCsrHostName},
+			expectErr:   true,
+			failureMode: SANValidationEnforce,
+		},
+		"no SANs with Warn": {
+			mode:        SANValidationWarn,
+			csr:         withoutSANs,
+			subjectIDs:  []string{testCsrHostName},
+			failureMode: SANValidationWarn,
+		},
+		"no SANs with the default mode": {
+			csr:         withoutSANs,
+			subjectIDs:  []string{testCsrHostName},
+			expectErr:   true,
+			failureMode: SANValidationEnforce,
+		},
+		"SAN not requested with the default mode": {
+			csr:         withSANs,
+			subjectIDs:  []string{"spiffe://cluster.local/ns/default/sa/other"},
+			expectErr:   true,
+			failureMode: SANValidationEnforce,
+		},
+		"requested DNS name not in the CSR with Off": {
+			mode:       SANValidationOff,
+			csr:        withoutSANs,
+			subjectIDs: []string{testCsrHostName},
+			dnsNames:   []string{"foo.example.com"},
+			expectErr:  true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			failures := map[SANValidationMode]float64{}
+			for _, mode := range []SANValidationMode{SANValidationWarn, SANValidationEnforce} {
+				failures[mode] = getMetricValue(t, "ra_csr_san_validation_failure_count", map[string]string{modeLabel: string(mode)})
+			}
+			identities, err := validateCSRSANs(&IstioRAOptions{SANValidationMode: tc.mode}, tc.csr, tc.subjectIDs,
+				tc.dnsNames, nil, "test-request")
+			if tc.expectErr != (err != nil) {
+				t.Fatalf("got error %v, expected an error: %v", err, tc.expectErr)
+			}
+			if err == nil && len(identities) != len(tc.csr.URIs) {
+				t.Errorf("got identities %v, want the SANs of the CSR", identities)
+			}
+			for mode, before := range failures {
+				want := before
+				if mode == tc.failureMode {
+					want++
+				}
+				if got := getMetricValue(t, "ra_csr_san_validation_failure_count", map[string]string{modeLabel: string(mode)}); got != want {
+					t.Errorf("got %v SAN validation failures with mode %s, want %v", got, mode, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPreSignSANValidationDefault(t *testing.T) {
+	csrPEM := createFakeCsr(t)
+	certOpts := ca.CertOpts{SubjectIDs: []string{"spiffe://cluster.local/ns/default/sa/other"}, TTL: time.Hour}
+	var raErr *raerror.Error
+	if _, err := preSign(&IstioRAOptions{}, nil, csrPEM, certOpts); !errors.As(err, &raErr) || raErr.ErrorType() != "CSR_ERROR" {
+		t.Errorf("expected a CSR_ERROR error for a SAN that was not requested without a SAN validation mode, got: %v", err)
+	}
+	if _, err := preSign(&IstioRAOptions{SANValidationMode: SANValidationWarn}, nil, csrPEM, certOpts); err != nil {
+		t.Errorf("expected the CSR to be signed with %s, got: %v", SANValidationWarn, err)
+	}
+}
+
+func TestValidateSANValidationMode(t *testing.T) {
+	for _, mode := range []SANValidationMode{"", SANValidationOff, SANValidationWarn, SANValidationEnforce} {
+		if err := validateSANValidationMode(&IstioRAOptions{SANValidationMode: mode}); err != nil {
+			t.Errorf("unexpected error for mode %q: %v", mode, err)
+		}
+	}
+	if err := validateSANValidationMode(&IstioRAOptions{SANValidationMode: "enforce"}); err == nil {
+		t.Errorf("expected an error for an unknown mode")
+	}
+}
+
+func TestValidateSignerName(t *testing.T) {
+	testCases := map[string]bool{
+		"kubernetes.io/kube-apiserver-client": true,
+		"example.com/istio/ingress":           true,
+		"example.com./istio":                  true,
+		"example.com":                         false,
+		"example.com/":                        false,
+		"/istio":                              false,
+		"example/istio":                       false,
+		"Example.com/istio":                   false,
+		"example_domain.com/istio":            false,
+		"example.com//istio":                  false,
+		"example.com/istio/":                  false,
+		"example.com/../istio":                false,
+		"example.com/istio ingress":           false,
+		"example.com/istio%2Fingress":         false,
+	}
+	for signerName, valid := range testCases {
+		if err := validateSignerName(signerName); (err == nil) != valid {
+			t.Errorf("validateSignerName(%q): got error %v, want valid %v", signerName, err, valid)
+		}
+	}
+}
+
+func TestValidateTrustDomains(t *testing.T) {
+	identities := func(ids ...string) []csrIdentity {
+		out := []csrIdentity{}
+		for _, id := range ids {
+			out = append(out, csrIdentity{idType: util.TypeURI, value: id})
+		}
+		return out
+	}
+	testCases := map[string]struct {
+		trustedDomains []string
+		identities     []csrIdentity
+		expectErr      bool
+	}{
+		"no trusted domains configured": {
+			identities: identities("spiffe://other-mesh/ns/default/sa/default"),
+		},
+		"trusted domain": {
+			trustedDomains: []string{"cluster.local", "mesh.example.com"},
+			identities:     identities("spiffe://mesh.example.com/ns/default/sa/default"),
+		},
+		"untrusted domain": {
+			trustedDomains: []string{"cluster.local"},
+			identities:     identities("spiffe://cluster.local/ns/default/sa/default", "spiffe://other-mesh/ns/default/sa/default"),
+			expectErr:      true,
+		},
+		"untrusted domain with a non-workload path": {
+			trustedDomains: []string{"cluster.local"},
+			identities:     identities("spiffe://other-mesh/custom/path"),
+			expectErr:      true,
+		},
+		"non SPIFFE identities are ignored": {
+			trustedDomains: []string{"cluster.local"},
+			identities: append(identities("https://other-mesh/ns/default/sa/default"),
+				csrIdentity{idType: util.TypeDNS, value: "other-mesh"}),
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := validateTrustDomains(&IstioRAOptions{TrustedDomains: tc.trustedDomains}, tc.identities)
+			if tc.expectErr && err == nil {
+				t.Errorf("expected an error")
+			} else if !tc.expectErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateDNSNames(t *testing.T) {
+	testCases := map[string]struct {
+		allowedDNSNames []string
+		allowWildcard   bool
+		dnsNames        []string
+		expectErr       bool
+	}{
+		"no DNS names requested": {},
+		"no DNS names allowed": {
+			dnsNames:  []string{"foo.example.com"},
+			expectErr: true,
+		},
+		"allowed name": {
+			allowedDNSNames: []string{"foo.example.com"},
+			dnsNames:        []string{"Foo.Example.com"},
+		},
+		"name in an allowed domain": {
+			allowedDNSNames: []string{"*.example.com"},
+			dnsNames:        []string{"foo.example.com", "bar.example.com"},
+		},
+		"name in a subdomain of an allowed domain": {
+			allowedDNSNames: []string{"*.example.com"},
+			dnsNames:        []string{"foo.bar.example.com"},
+			expectErr:       true,
+		},
+		"allowed domain itself": {
+			allowedDNSNames: []string{"*.example.com"},
+			dnsNames:        []string{"example.com"},
+			expectErr:       true,
+		},
+		"name not allowed": {
+			allowedDNSNames: []string{"foo.example.com"},
+			dnsNames:        []string{"foo.example.com", "bar.example.com"},
+			expectErr:       true,
+		},
+		"wildcard not permitted": {
+			allowedDNSNames: []string{"*.example.com"},
+			dnsNames:        []string{"*.example.com"},
+			expectErr:       true,
+		},
+		"wildcard permitted": {
+			allowedDNSNames: []string{"*.example.com"},
+			allowWildcard:   true,
+			dnsNames:        []string{"*.example.com"},
+		},
+		"wildcard permitted but not allowed": {
+			allowedDNSNames: []string{"foo.example.com"},
+			allowWildcard:   true,
+			dnsNames:        []string{"*.example.com"},
+			expectErr:       true,
+		},
+		"invalid name": {
+			allowedDNSNames: []string{"foo_bar.example.com"},
+			dnsNames:        []string{"foo_bar.example.com"},
+			expectErr:       true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := validateDNSNames(&IstioRAOptions{
+				AllowedDNSNames:       tc.allowedDNSNames,
+				AllowWildcardDNSNames: tc.allowWildcard,
+			}, tc.dnsNames)
+			if tc.expectErr && err == nil {
+				t.Errorf("expected an error")
+			} else if !tc.expectErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateSubjectIDs(t *testing.T) {
+	testCases := map[string]struct {
+		formats    []SubjectIDFormat
+		subjectIDs []string
+		expectErr  bool
+	}{
+		"no subject IDs": {
+			expectErr: true,
+		},
+		"empty subject ID": {
+			subjectIDs: []string{""},
+			expectErr:  true,
+		},
+		"default formats": {
+			subjectIDs: []string{"spiffe://cluster.local/ns/default/sa/default", "foo.example.com", "10.0.0.1", "::1"},
+		},
+		"malformed SPIFFE ID": {
+			subjectIDs: []string{"spiffe:///ns/default/sa/default"},
+			expectErr:  true,
+		},
+		"SPIFFE ID with a query": {
+			subjectIDs: []string{"spiffe://cluster.local/ns/default/sa/default?foo=bar"},
+			expectErr:  true,
+		},
+		"malformed DNS name": {
+			subjectIDs: []string{"foo_bar.example.com"},
+			expectErr:  true,
+		},
+		"format not allowed": {
+			formats:    []SubjectIDFormat{SubjectIDFormatSpiffe},
+			subjectIDs: []string{"spiffe://cluster.local/ns/default/sa/default", "10.0.0.1"},
+			expectErr:  true,
+		},
+		"custom format": {
+			formats:    []SubjectIDFormat{SubjectIDFormatAny},
+			subjectIDs: []string{"urn:example:workload:foo"},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := validateSubjectIDs(&IstioRAOptions{SubjectIDFormats: tc.formats}, tc.subjectIDs)
+			if tc.expectErr && err == nil {
+				t.Errorf("expected an error")
+			} else if !tc.expectErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateSubject(t *testing.T) {
+	raOpts := &IstioRAOptions{DefaultOrganization: []string{"Example", "Istio"}, DefaultOU: []string{"Mesh"}}
+	testCases := map[string]struct {
+		raOpts  *IstioRAOptions
+		subject pkix.Name
+		wantErr string
+	}{
+		"nothing required": {
+			raOpts:  &IstioRAOptions{},
+			subject: pkix.Name{Organization: []string{"Other"}},
+		},
+		"required values in another order": {
+			raOpts:  raOpts,
+			subject: pkix.Name{Organization: []string{"Istio", "", "Example"}, OrganizationalUnit: []string{"Mesh"}},
+		},
+		"missing organization": {
+			raOpts:  raOpts,
+			subject: pkix.Name{Organization: []string{"Istio"}, OrganizationalUnit: []string{"Mesh"}},
+			wantErr: "subject organization",
+		},
+		"other organization": {
+			raOpts:  raOpts,
+			subject: pkix.Name{Organization: []string{"Example", "Istio", "Other"}, OrganizationalUnit: []string{"Mesh"}},
+			wantErr: "subject organization",
+		},
+		"missing organizational unit": {
+			raOpts:  raOpts,
+			subject: pkix.Name{Organization: []string{"Example", "Istio"}, OrganizationalUnit: []string{""}},
+			wantErr: "subject organizational unit",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := validateSubject(tc.raOpts, tc.subject)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("expected an error containing %q, got: %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestSignerAllowed(t *testing.T) {
+	allowed := []string{"example.com/istio-*", "example.com/exact", "other.com/*"}
+	testCases := map[string]bool{
+		"example.com/istio-":        true,
+		"example.com/istio-ingress": true,
+		"example.com/exact":         true,
+		"other.com/anything":        true,
+		"example.com/exact-not":     false,
+		"example.com/istio":         false,
+		"example.com/other":         false,
+		"evil.com/istio-ingress":    false,
+		"other.com":                 false,
+	}
+	for signerName, expected := range testCases {
+		t.Run(signerName, func(t *testing.T) {
+			if got := signerAllowed(allowed, signerName); got != expected {
+				t.Errorf("signerAllowed(%q): got %v, want %v", signerName, got, expected)
+			}
+		})
+	}
+	if signerAllowed(nil, "example.com/exact") {
+		t.Errorf("expected no signer to match an empty list")
+	}
+}
+
+func TestValidateIssuedCert(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	spiffeURI, _ := url.Parse("spiffe://cluster.local/ns/default/sa/bookinfo-productpage")
+	otherURI, _ := url.Parse("spiffe://cluster.local/ns/default/sa/other")
+	csr := createTestCSR(t, key, &x509.CertificateRequest{
+		URIs:     []*url.URL{spiffeURI},
+		DNSNames: []string{"productpage.default.svc"},
+	})
+	identities, err := csrIdentities(csr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issue := func(key *ecdsa.PrivateKey, template *x509.Certificate) *x509.Certificate {
+		template.SerialNumber = big.NewInt(1)
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		if err != nil {
+			t.Fatalf("failed to create certificate: %v", err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatalf("failed to parse certificate: %v", err)
+		}
+		return cert
+	}
+
+	testCases := map[string]struct {
+		cert      *x509.Certificate
+		expectErr bool
+	}{
+		"matching certificate": {
+			cert: issue(key, &x509.Certificate{URIs: []*url.URL{spiffeURI}, DNSNames: []string{"productpage.default.svc"}}),
+		},
+		"DNS names are case insensitive": {
+			cert: issue(key, &x509.Certificate{URIs: []*url.URL{spiffeURI}, DNSNames: []string{"ProductPage.Default.svc"}}),
+		},
+		"different public key": {
+			cert:      issue(otherKey, &x509.Certificate{URIs: []*url.URL{spiffeURI}, DNSNames: []string{"productpage.default.svc"}}),
+			expectErr: true,
+		},
+		"extra identity": {
+			cert: issue(key, &x509.Certificate{
+				URIs:     []*url.URL{spiffeURI, otherURI},
+				DNSNames: []string{"productpage.default.svc"},
+			}),
+			expectErr: true,
+		},
+		"missing identity": {
+			cert:      issue(key, &x509.Certificate{URIs: []*url.URL{spiffeURI}}),
+			expectErr: true,
+		},
+		"no SAN extension": {
+			cert:      issue(key, &x509.Certificate{}),
+			expectErr: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := validateIssuedCert(csr, identities, tc.cert)
+			if tc.expectErr && err == nil {
+				t.Errorf("expected an error")
+			} else if !tc.expectErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+
+	// The certificate of a CSR without SANs, signed with SAN validation off or in warn mode.
+	noSANs := createTestCSR(t, key, nil)
+	if err := validateIssuedCert(noSANs, nil, issue(key, &x509.Certificate{})); err != nil {
+		t.Errorf("unexpected error for a CSR without SANs: %v", err)
+	}
+	if err := validateIssuedCert(noSANs, nil, issue(key, &x509.Certificate{URIs: []*url.URL{spiffeURI}})); err == nil {
+		t.Errorf("expected an error for an identity added to a CSR without SANs")
+	}
+}
@@ -0,0 +1,529 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"istio.io/istio/security/pkg/pki/ca"
+	raerror "istio.io/istio/security/pkg/pki/error"
+	"istio.io/istio/security/pkg/pki/util"
+)
+
+const (
+	// vaultSignerLabel is the signer label value used in metrics for certificates signed by Vault.
+	vaultSignerLabel = "vault"
+
+	defaultVaultPKIMount                = "pki"
+	defaultVaultAuthMount               = "kubernetes"
+	defaultVaultServiceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+)
+
+// VaultRAOptions : Options of the ExtCAVault RA signing with a role of the PKI secrets engine of Vault, logging
+// in with the Kubernetes auth method of Vault
+type VaultRAOptions struct {
+	// Address : URL of the Vault server, e.g. https://vault.vault:8200
+	Address string
+	// Namespace : Vault Enterprise namespace of the PKI and auth mounts, if any
+	Namespace string
+	// PKIMount : Mount path of the PKI secrets engine. Defaults to pki
+	PKIMount string
+	// Role : PKI role signing the CSRs. It must allow the SANs of the CSRs
+	Role string
+	// AuthMount : Mount path of the Kubernetes auth method. Defaults to kubernetes
+	AuthMount string
+	// AuthRole : Role of the Kubernetes auth method to log in with
+	AuthRole string
+	// ServiceAccountTokenFile : Service account token to log in with. Defaults to the token of the pod
+	ServiceAccountTokenFile string
+	// HTTPClient : Client of the Vault API, e.g. trusting the CA of Vault. Defaults to http.DefaultClient
+	HTTPClient *http.Client
+}
+
+// vaultError is an error response of the Vault API.
+type vaultError struct {
+	statusCode int
+	errors     []string
+}
+
+func (e *vaultError) Error() string {
+	return fmt.Sprintf("Vault API error (HTTP %d): %s", e.statusCode, strings.Join(e.errors, "; "))
+}
+
+// vaultToken is a Vault client token obtained by logging in.
+type vaultToken struct {
+	token     string
+	renewable bool
+	// renewAt is when the token is renewed, or logged in again, before it expires. Zero if it does not expire.
+	renewAt time.Time
+	expiry  time.Time
+}
+
+// vaultAuthResponse is the response of the Vault login and token renewal APIs.
+type vaultAuthResponse struct {
+	Auth struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int64  `json:"lease_duration"`
+		Renewable     bool   `json:"renewable"`
+	} `json:"auth"`
+}
+
+// vaultSignResponse is the response of the Vault PKI sign API.
+type vaultSignResponse struct {
+	Data struct {
+		Certificate string   `json:"certificate"`
+		IssuingCA   string   `json:"issuing_ca"`
+		CAChain     []string `json:"ca_chain"`
+	} `json:"data"`
+}
+
+// VaultRA integrated with an external CA using the PKI secrets engine of Vault
+type VaultRA struct {
+	raOpts        *IstioRAOptions
+	vaultOpts     VaultRAOptions
+	client        *http.Client
+	keyCertBundle *util.KeyCertBundle
+	// tokenMutex protects token.
+	tokenMutex sync.Mutex
+	token      *vaultToken
+	// certCache caches the issued certificates when CertCacheSize is set.
+	certCache *certCache
+	// serials detects the serial numbers reused by the signers.
+	serials *serialTracker
+	// keyReuse detects the CSR keys reused between identities when RejectReusedKeys is set.
+	keyReuse *keyReuseTracker
+	// issuances remembers the certificates recently issued to each identity when RecentIssuancesPerIdentity is set.
+	issuances *issuanceIndex
+	// inflight deduplicates concurrent identical signing requests.
+	inflight inflightGroup
+	// missingCertChainSigners are the signers for which a missing cert chain has been logged.
+	missingCertChainSigners sync.Map
+}
+
+// NewVaultRA : Create a RA that signs certificates with the Vault PKI role of raOpts.Vault
+func NewVaultRA(raOpts *IstioRAOptions) (*VaultRA, error) {
+	vaultOpts := raOpts.Vault
+	if vaultOpts.Address == "" {
+		return nil, raerror.NewError(raerror.CAInitFail, fmt.Errorf("a Vault address is required for the Vault RA"))
+	}
+	if vaultOpts.Role == "" {
+		return nil, raerror.NewError(raerror.CAInitFail, fmt.Errorf("a Vault PKI role is required for the Vault RA"))
+	}
+	if vaultOpts.AuthRole == "" {
+		return nil, raerror.NewError(raerror.CAInitFail, fmt.Errorf("a Vault Kubernetes auth role is required for the Vault RA"))
+	}
+	if err := validateRAOptions(raOpts); err != nil {
+		return nil, err
+	}
+	warnUnsupportedNotBeforeBackdate(raOpts, "Vault")
+	vaultOpts.Address = strings.TrimSuffix(vaultOpts.Address, "/")
+	if vaultOpts.PKIMount == "" {
+		vaultOpts.PKIMount = defaultVaultPKIMount
+	}
+	if vaultOpts.AuthMount == "" {
+		vaultOpts.AuthMount = defaultVaultAuthMount
+	}
+	if vaultOpts.ServiceAccountTokenFile == "" {
+		vaultOpts.ServiceAccountTokenFile = defaultVaultServiceAccountTokenFile
+	}
+	client := vaultOpts.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	keyCertBundle := util.NewKeyCertBundleFromPem(nil, nil, nil, nil)
+	if raOpts.CaCertFile != "" {
+		var err error
+		if keyCertBundle, err = loadCABundle(raOpts.CaCertFile); err != nil {
+			return nil, raerror.NewError(raerror.CAInitFail, fmt.Errorf("error processing Certificate Bundle for Vault RA: %v", err))
+		}
+	}
+	istioRA := &VaultRA{
+		raOpts:        raOpts,
+		vaultOpts:     vaultOpts,
+		client:        client,
+		keyCertBundle: keyCertBundle,
+		serials:       newSerialTracker(raOpts),
+	}
+	recordRootCertExpiry(vaultSignerLabel, keyCertBundle.GetRootCertPem(), time.Now())
+	if raOpts.CertCacheSize > 0 {
+		certCache, err := newCertCache(raOpts)
+		if err != nil {
+			return nil, raerror.NewError(raerror.CAInitFail, fmt.Errorf("error creating the certificate cache: %v", err))
+		}
+		istioRA.certCache = certCache
+	}
+	keyReuse, err := newKeyReuseTracker(raOpts)
+	if err != nil {
+		return nil, raerror.NewError(raerror.CAInitFail, fmt.Errorf("error creating the key reuse tracker: %v", err))
+	}
+	istioRA.keyReuse = keyReuse
+	issuances, err := newIssuanceIndex(raOpts)
+	if err != nil {
+		return nil, raerror.NewError(raerror.CAInitFail, fmt.Errorf("error creating the issuance index: %v", err))
+	}
+	istioRA.issuances = issuances
+	return istioRA, nil
+}
+
+// Sign takes a PEM-encoded CSR and cert opts, and returns a certificate signed by the Vault PKI role.
+func (r *VaultRA) Sign(csrPEM []byte, certOpts ca.CertOpts) ([]byte, error) {
+	return r.SignContext(context.Background(), csrPEM, certOpts)
+}
+
+// SignContext is similar to Sign, but gives up waiting for Vault once ctx is done.
+func (r *VaultRA) SignContext(ctx context.Context, csrPEM []byte, certOpts ca.CertOpts) ([]byte, error) {
+	result, err := r.SignWithCertChainResponseContext(ctx, csrPEM, certOpts)
+	if err != nil {
+		return nil, err
+	}
+	return result.CertPEM, nil
+}
+
+// SignWithCertChain is similar to Sign but returns the leaf cert and the entire cert chain.
+func (r *VaultRA) SignWithCertChain(csrPEM []byte, certOpts ca.CertOpts) ([]byte, error) {
+	return r.SignWithCertChainContext(context.Background(), csrPEM, certOpts)
+}
+
+// SignWithCertChainContext is similar to SignContext but returns the leaf cert and the entire cert chain.
+func (r *VaultRA) SignWithCertChainContext(ctx context.Context, csrPEM []byte, certOpts ca.CertOpts) ([]byte, error) {
+	result, err := r.SignWithCertChainResponseContext(ctx, csrPEM, certOpts)
+	if err != nil {
+		return nil, err
+	}
+	return result.CertChainPEM, nil
+}
+
+// SignWithCertChainResponse is similar to SignWithCertChain, but also returns the parsed details of
+// the issued certificate.
+func (r *VaultRA) SignWithCertChainResponse(csrPEM []byte, certOpts ca.CertOpts) (*SignResult, error) {
+	return r.SignWithCertChainResponseContext(context.Background(), csrPEM, certOpts)
+}
+
+// SignWithCertChainResponseContext is similar to SignWithCertChainResponse, but gives up waiting for
+// Vault once ctx is done.
+func (r *VaultRA) SignWithCertChainResponseContext(ctx context.Context, csrPEM []byte,
+	certOpts ca.CertOpts) (*SignResult, error) {
+	return r.SignParsed(ctx, nil, csrPEM, certOpts)
+}
+
+// SignParsed is similar to SignWithCertChainResponseContext, but takes the CSR raw already parsed as csr, so
+// that it is not parsed again. A nil csr is parsed from raw.
+func (r *VaultRA) SignParsed(ctx context.Context, csr *x509.CertificateRequest, raw []byte,
+	certOpts ca.CertOpts) (result *SignResult, err error) {
+	start := time.Now()
+	certOpts = withRequestID(certOpts)
+	ctx, span := startSignSpan(ctx, raw, certOpts.CertSigner, certOpts.RequestID)
+	signLog := startSignLog(vaultSignerLabel, raw, certOpts)
+	defer func() {
+		recordSign(vaultSignerLabel, start, err)
+		endSpan(span, err)
+		signLog.end(err)
+	}()
+	result, err = r.sign(ctx, csr, raw, certOpts)
+	return result, requestError(err, certOpts.RequestID)
+}
+
+// validate runs the checks of signing csrPEM, parsed as csr if not nil, with certOpts, and returns the
+// validated request.
+func (r *VaultRA) validate(csr *x509.CertificateRequest, csrPEM []byte,
+	certOpts ca.CertOpts) (*validatedRequest, error) {
+	req, err := preSign(r.raOpts, csr, csrPEM, certOpts)
+	if err != nil {
+		return nil, err
+	}
+	if certOpts.CertSigner != "" {
+		return nil, raerror.NewError(raerror.CertGenError,
+			fmt.Errorf("signer %s is not supported by the Vault RA", certOpts.CertSigner))
+	}
+	if certOpts.ForCA {
+		return nil, raerror.NewError(raerror.CertGenError, fmt.Errorf("CA certificates are not supported by the Vault RA"))
+	}
+	if len(certOpts.KeyUsages) > 0 {
+		return nil, raerror.NewError(raerror.CertGenError,
+			fmt.Errorf("key usages cannot be requested from the Vault RA, they are set by the Vault PKI role"))
+	}
+	if err := checkProfileSigner(req.profile, certOpts.Profile, vaultSignerLabel); err != nil {
+		return nil, err
+	}
+	if req.lifetime, err = clampToRootExpiry(r.raOpts, r.keyCertBundle, req.lifetime, time.Now(), certOpts.RequestID); err != nil {
+		return nil, err
+	}
+	// The sign endpoint cannot request them, they are added by the PKI mount configuration if at all.
+	if err := rejectUnsupportedExtensions(certOpts, "Vault"); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// Validate checks whether csrPEM and certOpts would be accepted for signing, without calling Vault.
+// It returns the same errors as Sign for requests that are not.
+func (r *VaultRA) Validate(csrPEM []byte, certOpts ca.CertOpts) error {
+	_, err := r.validate(nil, csrPEM, certOpts)
+	return requestError(err, certOpts.RequestID)
+}
+
+// sign validates and authorizes csrPEM, parsed as csr if not nil, and has it signed by the Vault PKI role unless a
+// certificate is cached for it. Concurrent identical requests share a single signing.
+func (r *VaultRA) sign(ctx context.Context, csr *x509.CertificateRequest, csrPEM []byte,
+	certOpts ca.CertOpts) (*SignResult, error) {
+	req, err := r.validate(csr, csrPEM, certOpts)
+	if err != nil {
+		return nil, err
+	}
+	// The identities listed again are dropped from the rest of the request, e.g. its cache and rate limit keys.
+	certOpts.SubjectIDs = req.subjectIDs
+	if err := runPreSignHook(ctx, r.raOpts, csrPEM, certOpts, req); err != nil {
+		return nil, err
+	}
+	if err := r.keyReuse.check(req, certOpts.SubjectIDs); err != nil {
+		return nil, err
+	}
+	key := certCacheKey(csrPEM, certOpts)
+	if r.certCache != nil {
+		if result := r.certCache.get(key, time.Now()); result != nil {
+			return result, nil
+		}
+	}
+	return signDeduplicated(ctx, &r.inflight, inflightKey(vaultSignerLabel, key, certOpts), req.requestID, signTimeout(r.raOpts),
+		nil, func(ctx context.Context) (*SignResult, error) {
+			return r.signUncached(ctx, csrPEM, certOpts, req, key)
+		})
+}
+
+// signUncached has the validated csrPEM signed by the Vault PKI role, and caches the certificate under key.
+func (r *VaultRA) signUncached(ctx context.Context, csrPEM []byte, certOpts ca.CertOpts, req *validatedRequest,
+	key string) (*SignResult, error) {
+	result, err := signCheckingClockSkew(r.raOpts, vaultSignerLabel, req.requestID, func() (*SignResult, error) {
+		certPEM, chainPEM, err := r.vaultSign(ctx, csrPEM, req.lifetime, req.requestID)
+		if err != nil {
+			return nil, err
+		}
+		return newSignResult(r.raOpts, req, certPEM, func(*x509.Certificate) ([]byte, error) {
+			if len(chainPEM) > 0 {
+				return chainPEM, nil
+			}
+			return r.GetCAKeyCertBundle().GetCertChainPem(), nil
+		}, vaultSignerLabel, vaultSignerLabel, &r.missingCertChainSigners)
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.serials.observe(vaultSignerLabel, vaultSignerLabel, result)
+	r.keyReuse.observe(req, certOpts.SubjectIDs)
+	recordIssuedLifetime(vaultSignerLabel, result)
+	checkCertChainDepth(r.raOpts, vaultSignerLabel, req.requestID, result)
+	if err := recordIssuance(r.raOpts, vaultSignerLabel, certOpts, result); err != nil {
+		return nil, err
+	}
+	r.issuances.observe(certOpts.SubjectIDs, result)
+	if r.certCache != nil {
+		r.certCache.add(key, result, time.Now())
+	}
+	return result, nil
+}
+
+// vaultSign has csrPEM signed by the Vault PKI role for the request requestID, and returns the issued
+// certificate and its CA chain.
+func (r *VaultRA) vaultSign(ctx context.Context, csrPEM []byte, lifetime time.Duration,
+	requestID string) ([]byte, []byte, error) {
+	var chainPEM []byte
+	certPEM, err := signWithRetry(ctx, r.raOpts, requestID, func() ([]byte, error) {
+		var certPEM []byte
+		var err error
+		certPEM, chainPEM, err = r.requestCertificate(ctx, csrPEM, lifetime, requestID)
+		return certPEM, err
+	})
+	if err != nil {
+		var vaultErr *vaultError
+		switch {
+		case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+			return nil, nil, raerror.NewError(raerror.RequestCanceled, err)
+		case errors.As(err, &vaultErr) && vaultErr.statusCode == http.StatusBadRequest:
+			return nil, nil, raerror.NewError(raerror.CSRError, err)
+		}
+		return nil, nil, raerror.NewError(raerror.CertGenError, err)
+	}
+	return certPEM, chainPEM, nil
+}
+
+// requestCertificate calls the Vault PKI sign API for csrPEM, logging in again once if the client token has
+// been revoked.
+func (r *VaultRA) requestCertificate(ctx context.Context, csrPEM []byte, lifetime time.Duration,
+	requestID string) ([]byte, []byte, error) {
+	body := map[string]string{
+		"csr":    string(csrPEM),
+		"format": "pem",
+	}
+	// A zero lifetime leaves the lifetime to the Vault PKI role.
+	if lifetime > 0 {
+		body["ttl"] = fmt.Sprintf("%ds", int64(lifetime/time.Second))
+	}
+	path := fmt.Sprintf("/v1/%s/sign/%s", r.vaultOpts.PKIMount, r.vaultOpts.Role)
+	var resp vaultSignResponse
+	for attempt := 0; ; attempt++ {
+		token, err := r.clientToken(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = r.call(ctx, path, token, body, &resp)
+		var vaultErr *vaultError
+		if attempt == 0 && errors.As(err, &vaultErr) && vaultErr.statusCode == http.StatusForbidden {
+			requestLog(requestID).Warnf("Vault denied signing with the current client token, logging in again: %v", err)
+			r.invalidateToken(token)
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		break
+	}
+	if resp.Data.Certificate == "" {
+		return nil, nil, fmt.Errorf("vault returned no certificate")
+	}
+	certPEM := []byte(strings.TrimSpace(resp.Data.Certificate) + "\n")
+	caChain := resp.Data.CAChain
+	if len(caChain) == 0 && resp.Data.IssuingCA != "" {
+		caChain = []string{resp.Data.IssuingCA}
+	}
+	var chainPEM []byte
+	for _, caCert := range caChain {
+		chainPEM = append(chainPEM, strings.TrimSpace(caCert)+"\n"...)
+	}
+	return certPEM, chainPEM, nil
+}
+
+// clientToken returns the Vault client token to call the PKI sign API with. The token is renewed, or
+// obtained by logging in again, once two thirds of its lease have elapsed.
+func (r *VaultRA) clientToken(ctx context.Context) (string, error) {
+	r.tokenMutex.Lock()
+	defer r.tokenMutex.Unlock()
+	now := time.Now()
+	if r.token != nil && (r.token.renewAt.IsZero() || now.Before(r.token.renewAt)) {
+		return r.token.token, nil
+	}
+	if r.token != nil && r.token.renewable && now.Before(r.token.expiry) {
+		token, err := r.authenticate(ctx, "/v1/auth/token/renew-self", r.token.token, nil)
+		if err == nil {
+			r.token = token
+			return token.token, nil
+		}
+		pkiRaLog.Warnf("failed to renew the Vault client token, logging in again: %v", err)
+	}
+	jwt, err := os.ReadFile(r.vaultOpts.ServiceAccountTokenFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the service account token to log in to Vault: %v", err)
+	}
+	token, err := r.authenticate(ctx, fmt.Sprintf("/v1/auth/%s/login", r.vaultOpts.AuthMount), "", map[string]string{
+		"role": r.vaultOpts.AuthRole,
+		"jwt":  strings.TrimSpace(string(jwt)),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to log in to Vault: %w", err)
+	}
+	r.token = token
+	return token.token, nil
+}
+
+// authenticate calls the Vault auth API path, and returns the client token it issues.
+func (r *VaultRA) authenticate(ctx context.Context, path, token string, body interface{}) (*vaultToken, error) {
+	var resp vaultAuthResponse
+	if err := r.call(ctx, path, token, body, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Auth.ClientToken == "" {
+		return nil, fmt.Errorf("vault returned no client token")
+	}
+	issued := &vaultToken{token: resp.Auth.ClientToken, renewable: resp.Auth.Renewable}
+	if lease := time.Duration(resp.Auth.LeaseDuration) * time.Second; lease > 0 {
+		now := time.Now()
+		issued.renewAt = now.Add(lease * 2 / 3)
+		issued.expiry = now.Add(lease)
+	}
+	return issued, nil
+}
+
+// invalidateToken drops token, unless another client token has replaced it already.
+func (r *VaultRA) invalidateToken(token string) {
+	r.tokenMutex.Lock()
+	defer r.tokenMutex.Unlock()
+	if r.token != nil && r.token.token == token {
+		r.token = nil
+	}
+}
+
+// call POSTs body to the Vault API path with the client token, if any, and decodes the response into out.
+func (r *VaultRA) call(ctx context.Context, path, token string, body interface{}, out interface{}) error {
+	var reqBody io.Reader = http.NoBody
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.vaultOpts.Address+path, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if r.vaultOpts.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", r.vaultOpts.Namespace)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var errResp struct {
+			Errors []string `json:"errors"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&errResp)
+		return &vaultError{statusCode: resp.StatusCode, errors: errResp.Errors}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode the Vault API response: %v", err)
+	}
+	return nil
+}
+
+// GetCAKeyCertBundle returns the KeyCertBundle for the CA.
+func (r *VaultRA) GetCAKeyCertBundle() *util.KeyCertBundle {
+	return r.keyCertBundle
+}
+
+// Capabilities reports the optional features supported by the Vault RA.
+func (r *VaultRA) Capabilities() RACapabilities {
+	return RACapabilities{
+		ForCA:             false,
+		CustomSigners:     false,
+		CustomKeyUsages:   false,
+		NotBeforeBackdate: false,
+	}
+}
@@ -0,0 +1,309 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ra
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"istio.io/istio/security/pkg/pki/ca"
+	raerror "istio.io/istio/security/pkg/pki/error"
+	pkiutil "istio.io/istio/security/pkg/pki/util"
+)
+
+const testVaultJWT = "test-service-account-token"
+
+// fakeVault emulates the Kubernetes auth method and the PKI secrets engine of Vault.
+type fakeVault struct {
+	mu sync.Mutex
+	// leaseDuration is the lease of the issued client tokens, in seconds.
+	leaseDuration int64
+	// signStatus is the status of the next sign responses, if set.
+	signStatus int
+	caChain    []string
+	logins     int
+	renewals   int
+	tokens     map[string]bool
+	signBodies []map[string]string
+}
+
+func (v *fakeVault) issueToken(w http.ResponseWriter) {
+	token := fmt.Sprintf("token-%d", len(v.tokens))
+	v.tokens[token] = true
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"auth": map[string]interface{}{"client_token": token, "lease_duration": v.leaseDuration, "renewable": true},
+	})
+}
+
+func (v *fakeVault) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	var body map[string]string
+	_ = json.NewDecoder(req.Body).Decode(&body)
+	switch req.URL.Path {
+	case "/v1/auth/kubernetes/login":
+		if body["role"] != "istiod" || body["jwt"] != testVaultJWT {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		v.logins++
+		v.issueToken(w)
+	case "/v1/auth/token/renew-self":
+		if !v.tokens[req.Header.Get("X-Vault-Token")] {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		v.renewals++
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"auth": map[string]interface{}{
+				"client_token": req.Header.Get("X-Vault-Token"), "lease_duration": v.leaseDuration, "renewable": true,
+			},
+		})
+	case "/v1/pki/sign/istio":
+		if !v.tokens[req.Header.Get("X-Vault-Token")] {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		v.signBodies = append(v.signBodies, body)
+		if v.signStatus != 0 {
+			w.WriteHeader(v.signStatus)
+			_, _ = w.Write([]byte(`{"errors":["test error"]}`))
+			return
+		}
+		certPEM, err := issueFakeCert([]byte(body["csr"]))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{"certificate": string(certPEM), "ca_chain": v.caChain},
+		})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func createFakeVaultRA(t *testing.T, vault *fakeVault) *VaultRA {
+	t.Helper()
+	vault.tokens = map[string]bool{}
+	server := httptest.NewServer(vault)
+	t.Cleanup(server.Close)
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte(testVaultJWT+"\n"), 0o600); err != nil {
+		t.Fatalf("failed to write the service account token: %v", err)
+	}
+	r, err := NewVaultRA(&IstioRAOptions{
+		ExternalCAType:           ExtCAVault,
+		DefaultCertTTL:           30 * time.Minute,
+		MaxCertTTL:               time.Hour,
+		SignMaxAttempts:          1,
+		SignRetryInitialInterval: time.Millisecond,
+		Vault: VaultRAOptions{
+			Address:                 server.URL + "/",
+			Role:                    "istio",
+			AuthRole:                "istiod",
+			ServiceAccountTokenFile: tokenFile,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create Fake Vault RA: %v", err)
+	}
+	return r
+}
+
+func TestNewVaultRA(t *testing.T) {
+	testCases := map[string]VaultRAOptions{
+		"no address":   {Role: "istio", AuthRole: "istiod"},
+		"no role":      {Address: "https://vault:8200", AuthRole: "istiod"},
+		"no auth role": {Address: "https://vault:8200", Role: "istio"},
+	}
+	for name, vaultOpts := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewVaultRA(&IstioRAOptions{Vault: vaultOpts})
+			var raErr *raerror.Error
+			if !errors.As(err, &raErr) || raErr.ErrorType() != "CA_INIT_FAIL" {
+				t.Errorf("expected a CA_INIT_FAIL error, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestVaultSign(t *testing.T) {
+	intermediatePEM := genRootCert(t, time.Now(), time.Hour)
+	rootPEM := genRootCert(t, time.Now(), 2*time.Hour)
+	vault := &fakeVault{leaseDuration: 3600, caChain: []string{string(intermediatePEM), string(rootPEM)}}
+	r := createFakeVaultRA(t, vault)
+	// the fake Vault signs with another CA than the ones of its CA chain
+	r.raOpts.CertChainMismatchPolicy = CertChainMismatchAllow
+	var ra RegistrationAuthority = r
+	for i := 0; i < 2; i++ {
+		result, err := ra.SignWithCertChainResponse(createFakeCsr(t), ca.CertOpts{
+			SubjectIDs: []string{testCsrHostName},
+			TTL:        10 * time.Minute,
+		})
+		if err != nil {
+			t.Fatalf("Failed to sign through Vault: %v", err)
+		}
+		want := append(append(append([]byte{}, result.CertPEM...), intermediatePEM...), rootPEM...)
+		if !bytes.Equal(result.CertChainPEM, want) {
+			t.Errorf("expected the cert chain to be the certificate followed by the CA chain of Vault")
+		}
+		if result.CertSigner != vaultSignerLabel {
+			t.Errorf("got signer %q, want %q", result.CertSigner, vaultSignerLabel)
+		}
+		leafCert, err := pkiutil.ParsePemEncodedCertificate(result.CertPEM)
+		if err != nil {
+			t.Fatalf("failed to parse the issued certificate: %v", err)
+		}
+		if result.SerialNumberDecimal != leafCert.SerialNumber.Text(10) ||
+			result.SerialNumberHex != strings.ToUpper(leafCert.SerialNumber.Text(16)) {
+			t.Errorf("got serial number %s (%s), want %v", result.SerialNumberDecimal, result.SerialNumberHex, leafCert.SerialNumber)
+		}
+	}
+	if vault.logins != 1 {
+		t.Errorf("got %d logins, want the client token to be reused", vault.logins)
+	}
+	if got := vault.signBodies[0]; got["ttl"] != "600s" || got["format"] != "pem" {
+		t.Errorf("got sign request %v, want a ttl of 600s and the pem format", got)
+	}
+}
+
+func TestVaultSignParsed(t *testing.T) {
+	r := createFakeVaultRA(t, &fakeVault{leaseDuration: 3600})
+	csrPEM := createFakeCsr(t)
+	csr, err := pkiutil.ParsePemEncodedCSR(csrPEM)
+	if err != nil {
+		t.Fatalf("failed to parse CSR: %v", err)
+	}
+	result, err := r.SignParsed(context.Background(), csr, csrPEM, ca.CertOpts{
+		SubjectIDs: []string{testCsrHostName},
+		TTL:        10 * time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("Failed to sign the parsed CSR through Vault: %v", err)
+	}
+	if !bytes.Equal(result.CertChainPEM, result.CertPEM) {
+		t.Errorf("expected the certificate to be returned without a chain")
+	}
+}
+
+func TestVaultSignRequiredSubject(t *testing.T) {
+	r := createFakeVaultRA(t, &fakeVault{leaseDuration: 3600})
+	r.raOpts.DefaultOU = []string{"mesh"}
+	// the Vault PKI role sets the subject, that of the CSR is not checked
+	_, err := r.Sign(createFakeCsr(t), ca.CertOpts{SubjectIDs: []string{testCsrHostName}, TTL: 10 * time.Minute})
+	var raErr *raerror.Error
+	if !errors.As(err, &raErr) || raErr.ErrorType() != "CERT_GEN_ERROR" || !strings.Contains(err.Error(), "organizational unit") {
+		t.Errorf("expected a CERT_GEN_ERROR error for a certificate issued without the required OU, got: %v", err)
+	}
+}
+
+func TestVaultSignTokenRenewal(t *testing.T) {
+	vault := &fakeVault{leaseDuration: 1}
+	r := createFakeVaultRA(t, vault)
+	certOpts := ca.CertOpts{SubjectIDs: []string{testCsrHostName}, TTL: 10 * time.Minute}
+	if _, err := r.Sign(createFakeCsr(t), certOpts); err != nil {
+		t.Fatalf("Failed to sign through Vault: %v", err)
+	}
+	// generated before sleeping, so that the token has not expired yet once it is
+	csrPEM := createFakeCsr(t)
+	// two thirds of the lease have elapsed, but the token has not expired yet
+	time.Sleep(700 * time.Millisecond)
+	if _, err := r.Sign(csrPEM, certOpts); err != nil {
+		t.Fatalf("Failed to sign through Vault: %v", err)
+	}
+	if vault.logins != 1 || vault.renewals != 1 {
+		t.Errorf("got %d logins and %d renewals, want the client token to be renewed", vault.logins, vault.renewals)
+	}
+}
+
+func TestVaultSignTokenRevoked(t *testing.T) {
+	vault := &fakeVault{leaseDuration: 3600}
+	r := createFakeVaultRA(t, vault)
+	certOpts := ca.CertOpts{SubjectIDs: []string{testCsrHostName}, TTL: 10 * time.Minute}
+	if _, err := r.Sign(createFakeCsr(t), certOpts); err != nil {
+		t.Fatalf("Failed to sign through Vault: %v", err)
+	}
+	vault.mu.Lock()
+	vault.tokens = map[string]bool{"revoked": false}
+	vault.mu.Unlock()
+	if _, err := r.Sign(createFakeCsr(t), certOpts); err != nil {
+		t.Fatalf("Failed to sign through Vault after the client token was revoked: %v", err)
+	}
+	if vault.logins != 2 {
+		t.Errorf("got %d logins, want to log in again after the client token was revoked", vault.logins)
+	}
+}
+
+func TestVaultSignFailure(t *testing.T) {
+	testCases := map[string]struct {
+		signStatus      int
+		certOpts        ca.CertOpts
+		expectedErrType string
+	}{
+		"rejected by the role": {
+			signStatus:      http.StatusBadRequest,
+			expectedErrType: "CSR_ERROR",
+		},
+		"vault sealed": {
+			signStatus:      http.StatusServiceUnavailable,
+			expectedErrType: "CERT_GEN_ERROR",
+		},
+		"custom signer": {
+			certOpts:        ca.CertOpts{CertSigner: "custom"},
+			expectedErrType: "CERT_GEN_ERROR",
+		},
+		"CA certificate": {
+			certOpts:        ca.CertOpts{ForCA: true},
+			expectedErrType: "CSR_ERROR",
+		},
+		"invalid issuing certificate URL": {
+			certOpts:        ca.CertOpts{IssuingCertificateURLs: []string{"ca.crt"}},
+			expectedErrType: "CSR_ERROR",
+		},
+		"must-staple": {
+			certOpts:        ca.CertOpts{MustStaple: true},
+			expectedErrType: "CSR_ERROR",
+		},
+		"issuing certificate URLs": {
+			certOpts:        ca.CertOpts{IssuingCertificateURLs: []string{"http://ca.example.com/ca.crt"}},
+			expectedErrType: "CSR_ERROR",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			r := createFakeVaultRA(t, &fakeVault{leaseDuration: 3600, signStatus: tc.signStatus})
+			tc.certOpts.SubjectIDs = []string{testCsrHostName}
+			tc.certOpts.TTL = 60 * time.Second
+			_, err := r.Sign(createFakeCsr(t), tc.certOpts)
+			var raErr *raerror.Error
+			if !errors.As(err, &raErr) || raErr.ErrorType() != tc.expectedErrType {
+				t.Errorf("expected a %s error, got: %v", tc.expectedErrType, err)
+			}
+		})
+	}
+}